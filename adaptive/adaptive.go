@@ -0,0 +1,141 @@
+package adaptive
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// levelDifficulties are the default Rasch difficulties (in logits) of questions
+// that carry a CEFR level but no explicit difficulty
+var levelDifficulties = map[string]float64{
+	"A1": -2.5,
+	"A2": -1.5,
+	"B1": -0.5,
+	"B2": 0.5,
+	"C1": 1.5,
+	"C2": 2.5,
+}
+
+// Ability grid used for the EAP estimate, with a standard normal prior
+const (
+	gridMin    = -4.0
+	gridMax    = 4.0
+	gridPoints = 81
+)
+
+// selectionPool is the number of most informative questions the next question is
+// drawn from, so that candidates of similar ability don't all see the same items
+const selectionPool = 3
+
+// Config holds the stopping rule of an adaptive test
+type Config struct {
+	MinQuestions int     // Never stop before this many answers
+	MaxQuestions int     // Always stop after this many answers
+	TargetSE     float64 // Stop once the standard error of the ability estimate is at or below this value
+}
+
+// Response is a single graded answer used to estimate ability
+type Response struct {
+	Difficulty float64
+	Correct    bool
+}
+
+// Difficulty returns the Rasch difficulty of a question: the explicit difficulty
+// if set, otherwise the default for its CEFR level, otherwise 0
+func Difficulty(q models.Question) float64 {
+	if q.Difficulty != nil {
+		return *q.Difficulty
+	}
+	return levelDifficulties[q.Level]
+}
+
+// Probability returns the Rasch probability of a correct answer
+func Probability(ability, difficulty float64) float64 {
+	return 1.0 / (1.0 + math.Exp(difficulty-ability))
+}
+
+// Estimate returns the expected a posteriori ability estimate and its standard error
+// With no responses it returns the prior (0 with standard error 1)
+func Estimate(responses []Response) (ability, se float64) {
+	logPosterior := make([]float64, gridPoints)
+	maxLog := math.Inf(-1)
+	for i := range logPosterior {
+		theta := gridTheta(i)
+		l := -theta * theta / 2
+		for _, r := range responses {
+			p := Probability(theta, r.Difficulty)
+			if r.Correct {
+				l += math.Log(p)
+			} else {
+				l += math.Log(1 - p)
+			}
+		}
+		logPosterior[i] = l
+		if l > maxLog {
+			maxLog = l
+		}
+	}
+
+	var sumW, sumWT, sumWT2 float64
+	for i, l := range logPosterior {
+		theta := gridTheta(i)
+		w := math.Exp(l - maxLog)
+		sumW += w
+		sumWT += w * theta
+		sumWT2 += w * theta * theta
+	}
+
+	ability = sumWT / sumW
+	variance := sumWT2/sumW - ability*ability
+	if variance < 0 {
+		variance = 0
+	}
+	return ability, math.Sqrt(variance)
+}
+
+func gridTheta(i int) float64 {
+	return gridMin + float64(i)*(gridMax-gridMin)/float64(gridPoints-1)
+}
+
+// ShouldStop reports whether the stopping rule is met after answered responses
+func (c Config) ShouldStop(answered int, se float64) bool {
+	if answered >= c.MaxQuestions {
+		return true
+	}
+	return answered >= c.MinQuestions && se <= c.TargetSE
+}
+
+// SelectNext picks the next question from the bank that has not been used yet,
+// preferring the items with the highest Fisher information at the current ability
+// The random source decides between equally preferred items, pass a seeded one to make the choice reproducible
+// Returns nil if every question has been used
+func SelectNext(ability float64, bank []models.Question, used map[primitive.ObjectID]bool, r *rand.Rand) *models.Question {
+	var candidates []models.Question
+	for _, q := range bank {
+		if !used[q.ID] {
+			candidates = append(candidates, q)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	info := func(q models.Question) float64 {
+		p := Probability(ability, Difficulty(q))
+		return p * (1 - p)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return info(candidates[i]) > info(candidates[j])
+	})
+
+	n := selectionPool
+	if len(candidates) < n {
+		n = len(candidates)
+	}
+	next := candidates[r.Intn(n)]
+	return &next
+}
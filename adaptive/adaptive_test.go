@@ -0,0 +1,158 @@
+package adaptive
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDifficulty(t *testing.T) {
+	explicit := 1.2
+	tests := []struct {
+		name     string
+		question models.Question
+		want     float64
+	}{
+		{"explicit difficulty", models.Question{Level: "A1", Difficulty: &explicit}, 1.2},
+		{"level default", models.Question{Level: "B2"}, 0.5},
+		{"no level", models.Question{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Difficulty(tt.question); got != tt.want {
+				t.Errorf("Difficulty() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		name        string
+		responses   []Response
+		wantMin     float64 // Range the ability estimate must fall in
+		wantMax     float64
+		wantSEBelow float64 // The standard error must be below this
+	}{
+		{"prior", nil, -0.01, 0.01, 1.01},
+		{"one correct answer moves up", []Response{{0, true}}, 0.1, 1, 1},
+		{"one wrong answer moves down", []Response{{0, false}}, -1, -0.1, 1},
+		{"symmetric responses stay at 0", []Response{{-1, true}, {1, false}}, -0.01, 0.01, 1},
+		{"all correct on hard items", []Response{{2, true}, {2, true}, {2, true}, {2, true}}, 1, 4, 1},
+		{"all wrong on easy items", []Response{{-2, false}, {-2, false}, {-2, false}, {-2, false}}, -4, -1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ability, se := Estimate(tt.responses)
+			if ability < tt.wantMin || ability > tt.wantMax {
+				t.Errorf("ability = %v, want between %v and %v", ability, tt.wantMin, tt.wantMax)
+			}
+			if se <= 0 || se >= tt.wantSEBelow {
+				t.Errorf("standard error = %v, want between 0 and %v", se, tt.wantSEBelow)
+			}
+		})
+	}
+}
+
+func TestEstimateNarrowsWithMoreAnswers(t *testing.T) {
+	var responses []Response
+	_, previousSE := Estimate(nil)
+	for i := 0; i < 10; i++ {
+		responses = append(responses, Response{Difficulty: 0, Correct: i%2 == 0})
+		_, se := Estimate(responses)
+		if se >= previousSE {
+			t.Fatalf("standard error after %d answers = %v, not below %v", i+1, se, previousSE)
+		}
+		previousSE = se
+	}
+}
+
+func TestShouldStop(t *testing.T) {
+	c := Config{MinQuestions: 5, MaxQuestions: 10, TargetSE: 0.4}
+	tests := []struct {
+		answered int
+		se       float64
+		want     bool
+	}{
+		{0, 1, false},
+		{4, 0.3, false}, // Precise enough, but too few answers
+		{5, 0.3, true},
+		{5, 0.4, true},
+		{7, 0.5, false},
+		{10, 0.9, true},
+	}
+	for _, tt := range tests {
+		if got := c.ShouldStop(tt.answered, tt.se); got != tt.want {
+			t.Errorf("ShouldStop(%d, %v) = %v, want %v", tt.answered, tt.se, got, tt.want)
+		}
+	}
+}
+
+func TestSelectNext(t *testing.T) {
+	// One question per level, A1 (-2.5 logits) to C2 (2.5 logits)
+	var bank []models.Question
+	for _, level := range models.CEFRLevels {
+		bank = append(bank, models.Question{ID: primitive.NewObjectID(), Level: level})
+	}
+	usedUpTo := func(n int) map[primitive.ObjectID]bool {
+		used := make(map[primitive.ObjectID]bool)
+		for _, q := range bank[:n] {
+			used[q.ID] = true
+		}
+		return used
+	}
+
+	tests := []struct {
+		name       string
+		ability    float64
+		used       map[primitive.ObjectID]bool
+		wantLevels []string // Levels the question may be drawn from, none for no question
+	}{
+		{"low ability", -2.5, nil, []string{"A1", "A2", "B1"}},
+		{"middle ability", 0.2, nil, []string{"B1", "B2", "C1"}},
+		{"high ability", 2.5, nil, []string{"C2", "C1", "B2"}},
+		{"used questions are skipped", -2.5, usedUpTo(2), []string{"B1", "B2", "C1"}},
+		{"fewer candidates than the pool", 0, usedUpTo(5), []string{"C2"}},
+		{"bank exhausted", 0, usedUpTo(6), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 50; i++ {
+				next := SelectNext(tt.ability, bank, tt.used, rand.New(rand.NewSource(int64(i))))
+				if len(tt.wantLevels) == 0 {
+					if next != nil {
+						t.Fatalf("SelectNext() = %s question, want nil", next.Level)
+					}
+					return
+				}
+				if next == nil {
+					t.Fatal("SelectNext() = nil, want a question")
+				}
+				if tt.used[next.ID] || !containsLevel(tt.wantLevels, next.Level) {
+					t.Fatalf("SelectNext() = %s question, want one of %v", next.Level, tt.wantLevels)
+				}
+			}
+		})
+	}
+}
+
+func containsLevel(levels []string, level string) bool {
+	for _, l := range levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+func TestProbability(t *testing.T) {
+	if got := Probability(1, 1); math.Abs(got-0.5) > 1e-12 {
+		t.Errorf("Probability(1, 1) = %v, want 0.5", got)
+	}
+	if Probability(2, 0) <= Probability(0, 0) {
+		t.Error("Probability() does not grow with ability")
+	}
+}
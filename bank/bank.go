@@ -0,0 +1,136 @@
+package bank
+
+import (
+	"errors"
+
+	"github.com/andru_bot/tg-bot/database"
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrReplaced is returned when changing a question that was superseded by an edited version
+var ErrReplaced = errors.New("question was replaced by an edited version")
+
+// Service manages the question bank without breaking past sessions
+// A question that appears in any session is never changed or removed: editing it
+// stores a new version that takes its place in new tests, deleting it retires it
+type Service struct {
+	questions database.QuestionRepository
+	sessions  database.SessionRepository
+}
+
+func NewService(questions database.QuestionRepository, sessions database.SessionRepository) *Service {
+	return &Service{questions: questions, sessions: sessions}
+}
+
+// List returns the current questions of the bank (active and deactivated), without replaced versions
+func (s *Service) List() ([]models.Question, error) {
+	all, err := s.questions.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var questions []models.Question
+	for _, q := range all {
+		if q.ReplacedBy == nil {
+			questions = append(questions, q)
+		}
+	}
+	return questions, nil
+}
+
+// Get returns a question by ID
+func (s *Service) Get(questionID primitive.ObjectID) (*models.Question, error) {
+	return s.questions.GetByID(questionID)
+}
+
+// Usage returns the number of sessions that contain the question
+func (s *Service) Usage(questionID primitive.ObjectID) (int, error) {
+	return s.sessions.CountByQuestionID(questionID)
+}
+
+// Add validates and stores a new active question, assigning its ID
+func (s *Service) Add(q *models.Question) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	q.ID = primitive.NewObjectID()
+	q.Retired = false
+	q.ReplacedBy = nil
+	return s.questions.Create(q)
+}
+
+// Edit applies change to the question and stores the result
+// Unused questions are updated in place; a question used by a session is retired and
+// the edited copy is stored under a new ID. Returns the stored question
+func (s *Service) Edit(questionID primitive.ObjectID, change func(*models.Question)) (*models.Question, error) {
+	original, err := s.questions.GetByID(questionID)
+	if err != nil {
+		return nil, err
+	}
+	if original.ReplacedBy != nil {
+		return nil, ErrReplaced
+	}
+
+	edited := *original
+	change(&edited)
+	edited.ID = original.ID
+	if err := edited.Validate(); err != nil {
+		return nil, err
+	}
+
+	used, err := s.sessions.CountByQuestionID(questionID)
+	if err != nil {
+		return nil, err
+	}
+	if used == 0 {
+		if err := s.questions.Update(&edited); err != nil {
+			return nil, err
+		}
+		return &edited, nil
+	}
+
+	// Copy on write: past sessions keep pointing at the original
+	edited.ID = primitive.NewObjectID()
+	if err := s.questions.Create(&edited); err != nil {
+		return nil, err
+	}
+	original.Retired = true
+	original.ReplacedBy = &edited.ID
+	if err := s.questions.Update(original); err != nil {
+		return nil, err
+	}
+	return &edited, nil
+}
+
+// SetActive deactivates a question (no longer used in new tests) or activates it again
+func (s *Service) SetActive(questionID primitive.ObjectID, active bool) error {
+	q, err := s.questions.GetByID(questionID)
+	if err != nil {
+		return err
+	}
+	if q.ReplacedBy != nil {
+		return ErrReplaced
+	}
+	q.Retired = !active
+	return s.questions.Update(q)
+}
+
+// Delete removes an unused question
+// A question used by a session is retired instead; retired reports which of the two happened
+func (s *Service) Delete(questionID primitive.ObjectID) (retired bool, err error) {
+	used, err := s.sessions.CountByQuestionID(questionID)
+	if err != nil {
+		return false, err
+	}
+	if used == 0 {
+		return false, s.questions.Delete(questionID)
+	}
+
+	q, err := s.questions.GetByID(questionID)
+	if err != nil {
+		return false, err
+	}
+	q.Retired = true
+	return true, s.questions.Update(q)
+}
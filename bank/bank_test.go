@@ -0,0 +1,119 @@
+package bank
+
+import (
+	"testing"
+
+	"github.com/andru_bot/tg-bot/database"
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newQuestion returns a valid question
+func newQuestion(text string) *models.Question {
+	return &models.Question{Text: text, Options: []string{"go", "goes", "going"}, CorrectAnswerID: 2, Score: 1}
+}
+
+// newTestService returns a service on the memory backend with one stored question, used by a session if used is set
+func newTestService(t *testing.T, used bool) (*Service, *database.Repositories, *models.Question) {
+	t.Helper()
+	repos := database.NewMemoryRepositories()
+	s := NewService(repos.Questions, repos.Sessions)
+	q := newQuestion("She ___ to school.")
+	if err := s.Add(q); err != nil {
+		t.Fatalf("Add() error: %v", err)
+	}
+	if used {
+		session := &models.Session{UserID: primitive.NewObjectID(), QuestionIDs: []primitive.ObjectID{q.ID}}
+		if err := repos.Sessions.Create(session); err != nil {
+			t.Fatalf("Create() session error: %v", err)
+		}
+	}
+	return s, repos, q
+}
+
+func TestEdit(t *testing.T) {
+	for _, used := range []bool{false, true} {
+		s, repos, q := newTestService(t, used)
+		edited, err := s.Edit(q.ID, func(q *models.Question) { q.Text = "He ___ to work." })
+		if err != nil {
+			t.Fatalf("Edit() error: %v", err)
+		}
+		if edited.Text != "He ___ to work." {
+			t.Errorf("Edit() text = %q", edited.Text)
+		}
+
+		original, err := repos.Questions.GetByID(q.ID)
+		if err != nil {
+			t.Fatalf("GetByID() error: %v", err)
+		}
+		if !used {
+			// Updated in place
+			if edited.ID != q.ID || original.Text != "He ___ to work." || original.Retired {
+				t.Errorf("unused question: edited %v, stored %+v, want updated in place", edited.ID, original)
+			}
+			continue
+		}
+		// Copy on write, the original stays as the session saw it
+		if edited.ID == q.ID {
+			t.Fatal("Edit() of a used question kept its ID")
+		}
+		if original.Text != "She ___ to school." || !original.Retired || original.ReplacedBy == nil || *original.ReplacedBy != edited.ID {
+			t.Errorf("used question after Edit() = %+v, want retired and replaced by %v", original, edited.ID)
+		}
+		if _, err := s.Edit(q.ID, func(*models.Question) {}); err != ErrReplaced {
+			t.Errorf("Edit() of a replaced question error = %v, want ErrReplaced", err)
+		}
+		if err := s.SetActive(q.ID, true); err != ErrReplaced {
+			t.Errorf("SetActive() of a replaced question error = %v, want ErrReplaced", err)
+		}
+		list, err := s.List()
+		if err != nil {
+			t.Fatalf("List() error: %v", err)
+		}
+		if len(list) != 1 || list[0].ID != edited.ID {
+			t.Errorf("List() = %v, want only the edited version", list)
+		}
+	}
+}
+
+func TestEditRejectsInvalid(t *testing.T) {
+	s, repos, q := newTestService(t, false)
+	if _, err := s.Edit(q.ID, func(q *models.Question) { q.CorrectAnswerID = 9 }); err == nil {
+		t.Fatal("Edit() to an invalid question succeeded")
+	}
+	stored, err := repos.Questions.GetByID(q.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error: %v", err)
+	}
+	if stored.CorrectAnswerID != 2 {
+		t.Errorf("stored correct answer = %d after a rejected edit, want 2", stored.CorrectAnswerID)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	for _, used := range []bool{false, true} {
+		s, repos, q := newTestService(t, used)
+		retired, err := s.Delete(q.ID)
+		if err != nil {
+			t.Fatalf("Delete() error: %v", err)
+		}
+		if retired != used {
+			t.Errorf("Delete() retired = %v, want %v", retired, used)
+		}
+
+		stored, err := repos.Questions.GetByID(q.ID)
+		switch {
+		case !used && err == nil:
+			t.Error("unused question still stored after Delete()")
+		case used && (err != nil || !stored.Retired):
+			t.Errorf("used question after Delete() = %+v, %v, want kept and retired", stored, err)
+		}
+		active, err := repos.Questions.GetActive()
+		if err != nil {
+			t.Fatalf("GetActive() error: %v", err)
+		}
+		if len(active) != 0 {
+			t.Errorf("GetActive() = %d questions after Delete(), want 0", len(active))
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package bank
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Change is a question of an imported file that differs from the bank
+type Change struct {
+	Current models.Question // Question in the bank
+	New     models.Question // Question from the file
+}
+
+// Changes is the difference between an imported question file and the bank
+// Questions are matched by their Key: the external ID, or the text for questions without one
+type Changes struct {
+	Added      []models.Question
+	Changed    []Change
+	Removed    []models.Question // Active bank questions missing from the file, retired when applied
+	Unchanged  int
+	Duplicates []models.Question // Repeated questions of the file, ignored
+}
+
+// Empty reports whether applying the changes would leave the bank as it is
+func (c *Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Changed) == 0 && len(c.Removed) == 0
+}
+
+// sameContent reports whether two questions would be shown, graded and drawn the same way
+func sameContent(a, b *models.Question) bool {
+	return a.Text == b.Text &&
+		a.TextHTML == b.TextHTML &&
+		slices.Equal(a.Options, b.Options) &&
+		slices.Equal(a.Matches, b.Matches) &&
+		a.CorrectAnswerID == b.CorrectAnswerID &&
+		slices.Equal(a.CorrectIDs(), b.CorrectIDs()) &&
+		a.QuestionType() == b.QuestionType() &&
+		slices.Equal(a.AcceptedAnswers, b.AcceptedAnswers) &&
+		a.Audio == b.Audio &&
+		a.Image == b.Image &&
+		reflect.DeepEqual(a.Passage, b.Passage) &&
+		a.Tolerance == b.Tolerance &&
+		a.Score == b.Score &&
+		a.Level == b.Level &&
+		reflect.DeepEqual(a.Difficulty, b.Difficulty) &&
+		slices.Equal(a.Tags, b.Tags)
+}
+
+// Diff compares a complete question file with the bank
+// A deactivated question that appears in the file again is reported as changed, since applying reactivates it
+func (s *Service) Diff(incoming []models.Question) (*Changes, error) {
+	current, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]models.Question, len(current))
+	byText := make(map[string]models.Question) // Questions keyed by their text, without an explicit ID
+	for _, q := range current {
+		byKey[q.Key()] = q
+		if textKey := models.TextKey(q.Text); q.Key() == textKey {
+			byText[textKey] = q
+		}
+	}
+
+	changes := &Changes{}
+	seen := make(map[string]bool, len(incoming))
+	matched := make(map[primitive.ObjectID]bool, len(incoming))
+	for _, q := range incoming {
+		key := q.Key()
+		if seen[key] {
+			changes.Duplicates = append(changes.Duplicates, q)
+			continue
+		}
+		seen[key] = true
+
+		existing, ok := byKey[key]
+		if !ok {
+			// The file gave the question an ID since the last import
+			existing, ok = byText[models.TextKey(q.Text)]
+			ok = ok && !matched[existing.ID]
+		}
+		if ok {
+			matched[existing.ID] = true
+		}
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, q)
+		case existing.Retired || existing.Key() != key || !sameContent(&existing, &q):
+			changes.Changed = append(changes.Changed, Change{Current: existing, New: q})
+		default:
+			changes.Unchanged++
+		}
+	}
+
+	for _, q := range current {
+		if !q.Retired && !matched[q.ID] {
+			changes.Removed = append(changes.Removed, q)
+		}
+	}
+	return changes, nil
+}
+
+// Apply makes the bank match the file the changes were computed from
+// Changed questions go through Edit, so versions used by past sessions are kept; removed questions are
+// retired rather than deleted, so a question that comes back in a later file is activated again
+func (s *Service) Apply(changes *Changes) error {
+	for i := range changes.Added {
+		changes.Added[i].ExternalID = changes.Added[i].Key()
+		if err := s.Add(&changes.Added[i]); err != nil {
+			return fmt.Errorf("adding %q: %w", changes.Added[i].Text, err)
+		}
+	}
+
+	for _, change := range changes.Changed {
+		imported := change.New
+		if !sameContent(&change.Current, &imported) {
+			_, err := s.Edit(change.Current.ID, func(q *models.Question) {
+				q.ExternalID = imported.Key()
+				q.Text = imported.Text
+				q.TextHTML = imported.TextHTML
+				q.Options = imported.Options
+				q.CorrectAnswerID = imported.CorrectAnswerID
+				q.CorrectAnswerIDs = imported.CorrectAnswerIDs
+				q.Matches = imported.Matches
+				q.Type = imported.Type
+				q.AcceptedAnswers = imported.AcceptedAnswers
+				q.Tolerance = imported.Tolerance
+				q.Audio = imported.Audio
+				q.Image = imported.Image
+				q.Passage = imported.Passage
+				q.Score = imported.Score
+				q.Level = imported.Level
+				q.Difficulty = imported.Difficulty
+				q.Tags = imported.Tags
+				q.Retired = false
+			})
+			if err != nil {
+				return fmt.Errorf("updating %q: %w", change.Current.Text, err)
+			}
+			continue
+		}
+		// Same content: store the key and activate in place, past sessions are not affected
+		current := change.Current
+		current.ExternalID = imported.Key()
+		current.Retired = false
+		if err := s.questions.Update(&current); err != nil {
+			return fmt.Errorf("updating %q: %w", change.Current.Text, err)
+		}
+	}
+
+	for _, q := range changes.Removed {
+		if err := s.SetActive(q.ID, false); err != nil {
+			return fmt.Errorf("retiring %q: %w", q.Text, err)
+		}
+	}
+	return nil
+}
+
+// Sync makes the bank match a complete question file and returns what changed
+// Running it again with the same file changes nothing
+func (s *Service) Sync(incoming []models.Question) (*Changes, error) {
+	changes, err := s.Diff(incoming)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Apply(changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
@@ -0,0 +1,178 @@
+package bank
+
+import (
+	"testing"
+
+	"github.com/andru_bot/tg-bot/models"
+)
+
+// texts returns the text of every question
+func texts(questions []models.Question) []string {
+	var result []string
+	for _, q := range questions {
+		result = append(result, q.Text)
+	}
+	return result
+}
+
+func TestDiff(t *testing.T) {
+	s, _, used := newTestService(t, true) // "She ___ to school.", used by a session
+	for _, text := range []string{"They ___ at home.", "I ___ a student."} {
+		if err := s.Add(newQuestion(text)); err != nil {
+			t.Fatalf("Add() error: %v", err)
+		}
+	}
+	retired := newQuestion("We ___ friends.")
+	if err := s.Add(retired); err != nil {
+		t.Fatalf("Add() error: %v", err)
+	}
+	if err := s.SetActive(retired.ID, false); err != nil {
+		t.Fatalf("SetActive() error: %v", err)
+	}
+
+	changedScore := newQuestion("They  ___ at home. ") // Same question, other spacing
+	changedScore.Score = 3
+	incoming := []models.Question{
+		*newQuestion("She ___ to school."),
+		*changedScore,
+		*newQuestion("We ___ friends."),
+		*newQuestion("He ___ a doctor."),
+		*newQuestion("He ___ a doctor."),
+	}
+
+	changes, err := s.Diff(incoming)
+	if err != nil {
+		t.Fatalf("Diff() error: %v", err)
+	}
+	if got := texts(changes.Added); len(got) != 1 || got[0] != "He ___ a doctor." {
+		t.Errorf("Added = %q, want the new question", got)
+	}
+	if len(changes.Changed) != 2 || changes.Changed[0].New.Score != 3 || changes.Changed[1].Current.ID != retired.ID {
+		t.Errorf("Changed = %+v, want the new score and the reactivated question", changes.Changed)
+	}
+	if got := texts(changes.Removed); len(got) != 1 || got[0] != "I ___ a student." {
+		t.Errorf("Removed = %q, want the question missing from the file", got)
+	}
+	if changes.Unchanged != 1 || len(changes.Duplicates) != 1 {
+		t.Errorf("Unchanged = %d, Duplicates = %d, want 1 and 1", changes.Unchanged, len(changes.Duplicates))
+	}
+	if changes.Empty() {
+		t.Error("Empty() = true")
+	}
+
+	if err := s.Apply(changes); err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+	after, err := s.Diff(incoming)
+	if err != nil {
+		t.Fatalf("Diff() error: %v", err)
+	}
+	if !after.Empty() || after.Unchanged != 4 {
+		t.Errorf("Diff() after Apply() = %+v, want 4 unchanged questions", after)
+	}
+
+	// The used question is kept for its session, even though the file still matches it
+	if stored, err := s.Get(used.ID); err != nil || stored.Retired {
+		t.Errorf("used question after Apply() = %+v, %v, want active", stored, err)
+	}
+}
+
+func TestApplyKeepsUsedQuestions(t *testing.T) {
+	s, repos, used := newTestService(t, true)
+
+	changed := newQuestion("She ___ to school.")
+	changed.CorrectAnswerID = 3
+	changes, err := s.Diff([]models.Question{*changed})
+	if err != nil {
+		t.Fatalf("Diff() error: %v", err)
+	}
+	if err := s.Apply(changes); err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+
+	original, err := repos.Questions.GetByID(used.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error: %v", err)
+	}
+	if original.CorrectAnswerID != 2 || original.ReplacedBy == nil {
+		t.Fatalf("used question after Apply() = %+v, want unchanged and replaced", original)
+	}
+	active, err := repos.Questions.GetActive()
+	if err != nil {
+		t.Fatalf("GetActive() error: %v", err)
+	}
+	if len(active) != 1 || active[0].ID != *original.ReplacedBy || active[0].CorrectAnswerID != 3 {
+		t.Errorf("GetActive() = %+v, want only the edited copy", active)
+	}
+
+	// Removing it from the file retires the copy, the original stays for its session
+	changes, err = s.Diff(nil)
+	if err != nil {
+		t.Fatalf("Diff() error: %v", err)
+	}
+	if err := s.Apply(changes); err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+	if active, _ := repos.Questions.GetActive(); len(active) != 0 {
+		t.Errorf("GetActive() = %d questions after removing all, want 0", len(active))
+	}
+	if _, err := repos.Questions.GetByID(used.ID); err != nil {
+		t.Errorf("used question gone after removing all: %v", err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	s, _, _ := newTestService(t, false) // "She ___ to school." without ID, as added by an admin
+
+	withID := func(id, text string) models.Question {
+		q := newQuestion(text)
+		q.ExternalID = id
+		return *q
+	}
+	file := []models.Question{
+		withID("q1", "She ___ to school."), // Adopts the existing question
+		withID("q2", "They ___ at home."),
+	}
+
+	changes, err := s.Sync(file)
+	if err != nil {
+		t.Fatalf("Sync() error: %v", err)
+	}
+	if len(changes.Added) != 1 || len(changes.Changed) != 1 || len(changes.Removed) != 0 {
+		t.Errorf("first Sync() = %+v, want q2 added and q1 matched by text", changes)
+	}
+	if changes, err := s.Sync(file); err != nil || !changes.Empty() {
+		t.Errorf("second Sync() = %+v, %v, want no changes", changes, err)
+	}
+
+	// A reworded question keeps its ID, a question left out is retired and comes back when listed again
+	reworded := []models.Question{withID("q1", "She ___ to the school.")}
+	changes, err = s.Sync(reworded)
+	if err != nil {
+		t.Fatalf("Sync() error: %v", err)
+	}
+	if len(changes.Added) != 0 || len(changes.Changed) != 1 || len(changes.Removed) != 1 {
+		t.Errorf("Sync() of the reworded file = %+v, want q1 changed and q2 removed", changes)
+	}
+	changes, err = s.Sync(file)
+	if err != nil {
+		t.Fatalf("Sync() error: %v", err)
+	}
+	if len(changes.Added) != 0 || len(changes.Changed) != 2 {
+		t.Errorf("Sync() of the first file again = %+v, want both changed back", changes)
+	}
+
+	questions, err := s.List()
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	var active []string
+	for _, q := range questions {
+		if !q.Retired {
+			active = append(active, q.Key())
+		}
+	}
+	if len(questions) != 2 || len(active) != 2 {
+		t.Errorf("bank after Sync() = %+v, want q1 and q2 active", questions)
+	}
+}
@@ -0,0 +1,190 @@
+package blueprint
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/andru_bot/tg-bot/models"
+)
+
+// PoolError reports a blueprint rule whose pool has too few questions
+type PoolError struct {
+	Rule      models.BlueprintRule
+	Matching  int  // Questions of the pool
+	Available int  // Questions of the pool not already drawn for other rules
+	Grouped   bool // Enough questions are available, but only by splitting a reading passage
+}
+
+func (e *PoolError) Error() string {
+	if e.Grouped {
+		return fmt.Sprintf("blueprint rule %q needs %d questions, its pool has %d but they can't be drawn without splitting a reading passage",
+			e.Rule.String(), e.Rule.Count, e.Available)
+	}
+	if e.Available < e.Matching {
+		return fmt.Sprintf("blueprint rule %q needs %d questions, its pool has %d of which %d are left after the other rules",
+			e.Rule.String(), e.Rule.Count, e.Matching, e.Available)
+	}
+	return fmt.Sprintf("blueprint rule %q needs %d questions, its pool has only %d", e.Rule.String(), e.Rule.Count, e.Matching)
+}
+
+// Assemble draws the questions of a blueprint at random from pool
+// No question is drawn twice. The questions of a reading passage are drawn as one unit: all of
+// them count toward the rule, so a passage is only in a rule's pool if each of its questions is.
+// Rules with the smallest pool are filled first, and each rule prefers questions the rules still
+// to fill can't use, so overlapping pools are not exhausted by accident. The result lists the
+// questions rule by rule in blueprint order, each rule's questions in pool order with the
+// questions of a passage together. A *PoolError is returned for the first rule that can't be filled.
+func Assemble(rules []models.BlueprintRule, pool []models.Question, r *rand.Rand) ([]models.Question, error) {
+	units := passageUnits(pool)
+	unitOf := make([]int, len(pool)) // Unit of each pool index
+	for u, unit := range units {
+		for _, j := range unit {
+			unitOf[j] = u
+		}
+	}
+
+	// matches[i] lists the pool indexes of rule i, unitMatches[i] the units all of whose questions match it
+	matches := make([][]int, len(rules))
+	unitMatches := make([][]int, len(rules))
+	for i := range rules {
+		for j := range pool {
+			if rules[i].Matches(&pool[j]) {
+				matches[i] = append(matches[i], j)
+			}
+		}
+		for u, unit := range units {
+			if matchesUnit(rules[i], pool, unit) {
+				unitMatches[i] = append(unitMatches[i], u)
+			}
+		}
+	}
+
+	order := make([]int, len(rules))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return len(matches[order[a]]) < len(matches[order[b]])
+	})
+
+	used := make(map[int]bool) // Units already drawn
+	drawn := make([][]int, len(rules))
+	for n, i := range order {
+		var candidates []int
+		for _, u := range unitMatches[i] {
+			if !used[u] {
+				candidates = append(candidates, u)
+			}
+		}
+		available := 0
+		for _, j := range matches[i] {
+			if !used[unitOf[j]] {
+				available++
+			}
+		}
+		if available < rules[i].Count {
+			return nil, &PoolError{Rule: rules[i], Matching: len(matches[i]), Available: available}
+		}
+
+		// Shuffle, then move the units wanted by fewer of the remaining rules to the front
+		r.Shuffle(len(candidates), func(a, b int) {
+			candidates[a], candidates[b] = candidates[b], candidates[a]
+		})
+		demand := make(map[int]int, len(candidates))
+		for _, k := range order[n+1:] {
+			for _, u := range candidates {
+				if matchesUnit(rules[k], pool, units[u]) {
+					demand[u]++
+				}
+			}
+		}
+		sort.SliceStable(candidates, func(a, b int) bool {
+			return demand[candidates[a]] < demand[candidates[b]]
+		})
+
+		picked := pickUnits(units, candidates, rules[i].Count)
+		if picked == nil {
+			return nil, &PoolError{Rule: rules[i], Matching: len(matches[i]), Available: available, Grouped: true}
+		}
+		sort.Slice(picked, func(a, b int) bool {
+			return units[picked[a]][0] < units[picked[b]][0]
+		})
+		for _, u := range picked {
+			drawn[i] = append(drawn[i], units[u]...)
+			used[u] = true
+		}
+	}
+
+	questions := make([]models.Question, 0, models.BlueprintSize(rules))
+	for i := range rules {
+		for _, j := range drawn[i] {
+			questions = append(questions, pool[j])
+		}
+	}
+	return questions, nil
+}
+
+// passageUnits splits the pool indexes into the units drawn together: the questions of each
+// reading passage, and every other question on its own; units are in pool order of their first question
+func passageUnits(pool []models.Question) [][]int {
+	var units [][]int
+	passages := make(map[string]int)
+	for j := range pool {
+		key := pool[j].PassageKey()
+		if u, ok := passages[key]; ok && key != "" {
+			units[u] = append(units[u], j)
+			continue
+		}
+		if key != "" {
+			passages[key] = len(units)
+		}
+		units = append(units, []int{j})
+	}
+	return units
+}
+
+// matchesUnit reports whether every question of the unit is in the rule's pool
+func matchesUnit(rule models.BlueprintRule, pool []models.Question, unit []int) bool {
+	for _, j := range unit {
+		if !rule.Matches(&pool[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// pickUnits returns candidates whose questions add up to exactly count, preferring units earlier in
+// candidates; nil if no combination fits
+func pickUnits(units [][]int, candidates []int, count int) []int {
+	// fits[k][s] reports whether candidates[k:] hold units adding up to s questions
+	fits := make([][]bool, len(candidates)+1)
+	for k := range fits {
+		fits[k] = make([]bool, count+1)
+	}
+	fits[len(candidates)][0] = true
+	for k := len(candidates) - 1; k >= 0; k-- {
+		size := len(units[candidates[k]])
+		for s := 0; s <= count; s++ {
+			fits[k][s] = fits[k+1][s] || (size <= s && fits[k+1][s-size])
+		}
+	}
+	if !fits[0][count] {
+		return nil
+	}
+
+	picked := []int{}
+	for k, rest := 0, count; rest > 0; k++ {
+		if size := len(units[candidates[k]]); size <= rest && fits[k+1][rest-size] {
+			picked = append(picked, candidates[k])
+			rest -= size
+		}
+	}
+	return picked
+}
+
+// Check reports whether the blueprint can be assembled from pool, see Assemble
+func Check(rules []models.BlueprintRule, pool []models.Question) error {
+	_, err := Assemble(rules, pool, rand.New(rand.NewSource(0)))
+	return err
+}
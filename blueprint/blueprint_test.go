@@ -0,0 +1,204 @@
+package blueprint
+
+import (
+	"errors"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newQuestion returns a question of the level with the tags
+func newQuestion(level string, tags ...string) models.Question {
+	return models.Question{ID: primitive.NewObjectID(), Level: level, Tags: tags}
+}
+
+// checkForm checks that questions fill every rule in blueprint order with distinct questions of its pool
+func checkForm(t *testing.T, rules []models.BlueprintRule, questions []models.Question) {
+	t.Helper()
+	if len(questions) != models.BlueprintSize(rules) {
+		t.Fatalf("Assemble() drew %d questions, want %d", len(questions), models.BlueprintSize(rules))
+	}
+	seen := make(map[primitive.ObjectID]bool)
+	next := 0
+	for _, rule := range rules {
+		for i := 0; i < rule.Count; i++ {
+			q := questions[next]
+			next++
+			if !rule.Matches(&q) {
+				t.Errorf("question %d (%s %v) drawn for rule %q is not in its pool", next-1, q.Level, q.Tags, rule.String())
+			}
+			if seen[q.ID] {
+				t.Errorf("question %d drawn twice", next-1)
+			}
+			seen[q.ID] = true
+		}
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	var pool []models.Question
+	for _, level := range []string{"A1", "A2", "B1"} {
+		for i := 0; i < 4; i++ {
+			pool = append(pool, newQuestion(level, "grammar"), newQuestion(level, "vocabulary"))
+		}
+	}
+	rules := []models.BlueprintRule{
+		{Count: 3, Level: "A1"},
+		{Count: 2, Level: "B1", Tags: []string{"vocabulary"}},
+		{Count: 4, Tags: []string{"grammar"}},
+	}
+
+	forms := make(map[string]bool)
+	for seed := int64(0); seed < 20; seed++ {
+		questions, err := Assemble(rules, pool, rand.New(rand.NewSource(seed)))
+		if err != nil {
+			t.Fatalf("Assemble() error: %v", err)
+		}
+		checkForm(t, rules, questions)
+
+		again, err := Assemble(rules, pool, rand.New(rand.NewSource(seed)))
+		if err != nil || !slices.EqualFunc(again, questions, func(a, b models.Question) bool { return a.ID == b.ID }) {
+			t.Fatalf("Assemble() not reproducible for seed %d", seed)
+		}
+		var ids []byte
+		for _, q := range questions {
+			ids = append(ids, q.ID[:]...)
+		}
+		forms[string(ids)] = true
+	}
+	if len(forms) < 10 {
+		t.Errorf("20 seeds assembled only %d different forms", len(forms))
+	}
+}
+
+func TestAssembleOverlappingPools(t *testing.T) {
+	// The A1 rule needs every A1 question, including the only A1 grammar question. The grammar
+	// rule is filled first (smaller pool) and must leave that question alone
+	a1Grammar := newQuestion("A1", "grammar")
+	pool := []models.Question{a1Grammar, newQuestion("A1"), newQuestion("A1"), newQuestion("B1", "grammar")}
+	rules := []models.BlueprintRule{
+		{Count: 3, Level: "A1"},
+		{Count: 1, Tags: []string{"grammar"}},
+	}
+
+	for seed := int64(0); seed < 20; seed++ {
+		questions, err := Assemble(rules, pool, rand.New(rand.NewSource(seed)))
+		if err != nil {
+			t.Fatalf("Assemble() with seed %d error: %v", seed, err)
+		}
+		checkForm(t, rules, questions)
+	}
+}
+
+func TestAssemblePoolErrors(t *testing.T) {
+	pool := []models.Question{newQuestion("A1", "grammar"), newQuestion("A1", "grammar"), newQuestion("B1")}
+
+	tests := []struct {
+		name          string
+		rules         []models.BlueprintRule
+		wantMatching  int
+		wantAvailable int
+	}{
+		{"pool too small", []models.BlueprintRule{{Count: 3, Level: "A1"}}, 2, 2},
+		{"empty pool", []models.BlueprintRule{{Count: 1, Level: "C2"}}, 0, 0},
+		{"pool taken by other rules", []models.BlueprintRule{{Count: 2, Tags: []string{"grammar"}}, {Count: 1, Level: "A1"}}, 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Assemble(tt.rules, pool, rand.New(rand.NewSource(1)))
+			var poolErr *PoolError
+			if !errors.As(err, &poolErr) {
+				t.Fatalf("Assemble() error = %v, want a *PoolError", err)
+			}
+			if poolErr.Matching != tt.wantMatching || poolErr.Available != tt.wantAvailable {
+				t.Errorf("PoolError = %d matching, %d available, want %d and %d",
+					poolErr.Matching, poolErr.Available, tt.wantMatching, tt.wantAvailable)
+			}
+			if err := Check(tt.rules, pool); err == nil {
+				t.Error("Check() = nil for a blueprint Assemble can't fill")
+			}
+		})
+	}
+
+	if err := Check([]models.BlueprintRule{{Count: 2, Level: "A1"}, {Count: 1}}, pool); err != nil {
+		t.Errorf("Check() error = %v for a blueprint that fits", err)
+	}
+}
+
+// inPassage returns the questions put into one reading passage
+func inPassage(key string, questions ...models.Question) []models.Question {
+	passage := &models.Passage{Key: key, Text: "text"}
+	for i := range questions {
+		questions[i].Passage = passage
+	}
+	return questions
+}
+
+func TestAssemblePassages(t *testing.T) {
+	var pool []models.Question
+	pool = append(pool, inPassage("p1", newQuestion("B1", "reading"), newQuestion("B1", "reading"))...)
+	pool = append(pool, newQuestion("B1", "reading"), newQuestion("A1", "grammar"))
+	pool = append(pool, inPassage("p2", newQuestion("B1", "reading"), newQuestion("B1", "reading"), newQuestion("B1", "reading"))...)
+	pool = append(pool, newQuestion("A1", "grammar"))
+	// Only part of p3 is B1, so p3 is in no B1 pool
+	pool = append(pool, inPassage("p3", newQuestion("B1", "reading"), newQuestion("A2", "reading"))...)
+	rules := []models.BlueprintRule{
+		{Count: 3, Level: "B1"},
+		{Count: 2, Level: "A1"},
+	}
+
+	passages := make(map[string]bool)
+	for seed := int64(0); seed < 30; seed++ {
+		questions, err := Assemble(rules, pool, rand.New(rand.NewSource(seed)))
+		if err != nil {
+			t.Fatalf("Assemble() with seed %d error: %v", seed, err)
+		}
+		checkForm(t, rules, questions)
+
+		// A passage is drawn with all of its questions, one after another
+		count := make(map[string]int)
+		for i, q := range questions {
+			key := q.PassageKey()
+			if key == "" {
+				continue
+			}
+			if count[key] > 0 && questions[i-1].PassageKey() != key {
+				t.Errorf("seed %d: questions of passage %s apart", seed, key)
+			}
+			count[key]++
+		}
+		if count["p3"] > 0 || (count["p1"] != 0 && count["p1"] != 2) || (count["p2"] != 0 && count["p2"] != 3) {
+			t.Errorf("seed %d: drew %v questions per passage, want whole passages of the pool", seed, count)
+		}
+		for key := range count {
+			passages[key] = true
+		}
+	}
+	if !passages["p1"] || !passages["p2"] {
+		t.Errorf("30 seeds drew only passages %v, want both p1 and p2", passages)
+	}
+}
+
+func TestAssembleSplitPassage(t *testing.T) {
+	// Four B1 questions, but two of them can only be drawn by splitting the passage of three
+	pool := append(inPassage("p1", newQuestion("B1"), newQuestion("B1"), newQuestion("B1")), newQuestion("B1"))
+	rules := []models.BlueprintRule{{Count: 2, Level: "B1"}}
+
+	_, err := Assemble(rules, pool, rand.New(rand.NewSource(1)))
+	var poolErr *PoolError
+	if !errors.As(err, &poolErr) || !poolErr.Grouped || poolErr.Available != 4 {
+		t.Fatalf("Assemble() error = %v, want a *PoolError for a split passage", err)
+	}
+	if err := Check(rules, pool); err == nil {
+		t.Error("Check() = nil for a blueprint that needs a passage split")
+	}
+	if err := Check([]models.BlueprintRule{{Count: 3, Level: "B1"}}, pool); err != nil {
+		t.Errorf("Check() error = %v for a blueprint filled by the whole passage", err)
+	}
+	if err := Check([]models.BlueprintRule{{Count: 4, Level: "B1"}}, pool); err != nil {
+		t.Errorf("Check() error = %v for a blueprint taking everything", err)
+	}
+}
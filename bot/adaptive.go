@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"log"
+
+	"github.com/andru_bot/tg-bot/adaptive"
+	"github.com/andru_bot/tg-bot/models"
+	"github.com/andru_bot/tg-bot/shuffle"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// prepareNextQuestion makes sure session.QuestionIDs contains the question at session.CurrentIdx
+// Linear sessions get all their questions up front; adaptive sessions get the next
+// question selected from the test's questions of the bank unless the stopping rule is met;
+// after a question of a reading passage, the passage's other questions are selected first
+// Returns false when there are no more questions and the test should be finished
+func (h *BotHandler) prepareNextQuestion(session *ActiveSession) bool {
+	if session.CurrentIdx < len(session.QuestionIDs) {
+		return true
+	}
+	if session.Mode != models.ModeAdaptive {
+		return false
+	}
+
+	if session.CurrentIdx >= session.TotalQuestions || h.adaptiveConfig.ShouldStop(session.CurrentIdx, session.AbilitySE) {
+		return false
+	}
+
+	bank, err := h.questionRepo.GetActive()
+	if err != nil {
+		log.Printf("Error getting questions for adaptive selection: %v", err)
+		return false
+	}
+	bank = session.Test.SelectQuestions(bank)
+
+	used := make(map[primitive.ObjectID]bool, len(session.QuestionIDs))
+	for _, id := range session.QuestionIDs {
+		used[id] = true
+	}
+
+	// Seeded by session and position so the selection can be reproduced
+	r := shuffle.Rand(session.Seed, session.CurrentIdx)
+	var next *models.Question
+	if n := len(session.Questions); n > 0 && session.Questions[n-1].Passage != nil {
+		var passage []models.Question
+		for i := range bank {
+			if models.SamePassage(&bank[i], &session.Questions[n-1]) {
+				passage = append(passage, bank[i])
+			}
+		}
+		next = adaptive.SelectNext(session.Ability, passage, used, r)
+	}
+	if next == nil {
+		next = adaptive.SelectNext(session.Ability, bank, used, r)
+	}
+	if next == nil {
+		// Question bank exhausted
+		return false
+	}
+
+	order := optionOrder(&session.Test, next, session.Seed, session.CurrentIdx)
+
+	snapshot := next.Snapshot()
+	err = h.sessionRepo.AppendQuestion(session.SessionID, snapshot, order)
+	if err != nil {
+		log.Printf("Error appending question to session: %v", err)
+		return false
+	}
+	session.QuestionIDs = append(session.QuestionIDs, next.ID)
+	session.Questions = append(session.Questions, snapshot)
+	if order != nil {
+		if session.OptionOrders == nil {
+			session.OptionOrders = make(map[string][]int)
+		}
+		session.OptionOrders[next.ID.Hex()] = order
+	}
+
+	return true
+}
+
+// updateAbility re-estimates the candidate's ability from all answers of the session and stores it
+func (h *BotHandler) updateAbility(session *ActiveSession) {
+	answers, err := h.answerRepo.GetBySession(session.SessionID)
+	if err != nil {
+		log.Printf("Error getting answers for ability estimate: %v", err)
+		return
+	}
+
+	questions := h.getSessionQuestions(session.QuestionIDs, session.Questions)
+	session.Ability, session.AbilitySE = adaptive.Estimate(abilityResponses(answers, questions))
+
+	err = h.sessionRepo.UpdateAbility(session.SessionID, session.Ability, session.AbilitySE)
+	if err != nil {
+		log.Printf("Error updating ability estimate: %v", err)
+	}
+}
+
+// abilityResponses returns the Rasch responses of the answers to the given questions
+// Timed out answers are left out: like the termination policies, the estimate treats a
+// timeout as no evidence about the candidate's ability rather than as a wrong answer
+func abilityResponses(answers []models.Answer, questions []models.Question) []adaptive.Response {
+	difficulties := make(map[primitive.ObjectID]float64)
+	for _, q := range questions {
+		difficulties[q.ID] = adaptive.Difficulty(q)
+	}
+
+	responses := make([]adaptive.Response, 0, len(answers))
+	for _, a := range answers {
+		difficulty, ok := difficulties[a.QuestionID]
+		if !ok || a.TimedOut {
+			continue
+		}
+		responses = append(responses, adaptive.Response{Difficulty: difficulty, Correct: a.IsCorrect})
+	}
+	return responses
+}
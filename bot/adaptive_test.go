@@ -0,0 +1,49 @@
+package bot
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/andru_bot/tg-bot/adaptive"
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAbilityResponses(t *testing.T) {
+	easy, hard := -1.0, 2.0
+	q1 := models.Question{ID: primitive.NewObjectID(), Difficulty: &easy}
+	q2 := models.Question{ID: primitive.NewObjectID(), Difficulty: &hard}
+	q3 := models.Question{ID: primitive.NewObjectID(), Level: "B1"}
+	questions := []models.Question{q1, q2, q3}
+
+	tests := []struct {
+		name    string
+		answers []models.Answer
+		want    []adaptive.Response
+	}{
+		{"no answers", nil, []adaptive.Response{}},
+		{"correct and wrong", []models.Answer{{QuestionID: q1.ID, IsCorrect: true}, {QuestionID: q2.ID}},
+			[]adaptive.Response{{Difficulty: easy, Correct: true}, {Difficulty: hard}}},
+		{"level difficulty", []models.Answer{{QuestionID: q3.ID}},
+			[]adaptive.Response{{Difficulty: adaptive.Difficulty(q3)}}},
+		{"timeouts left out", []models.Answer{{QuestionID: q1.ID, TimedOut: true}, {QuestionID: q2.ID, IsCorrect: true}},
+			[]adaptive.Response{{Difficulty: hard, Correct: true}}},
+		{"unknown question left out", []models.Answer{{QuestionID: primitive.NewObjectID()}}, []adaptive.Response{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := abilityResponses(tt.answers, questions); !slices.Equal(got, tt.want) {
+				t.Errorf("abilityResponses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A timeout leaves the estimate where it was, a wrong answer lowers it
+	answered := []models.Answer{{QuestionID: q1.ID, IsCorrect: true}}
+	before, _ := adaptive.Estimate(abilityResponses(answered, questions))
+	afterTimeout, _ := adaptive.Estimate(abilityResponses(append(answered, models.Answer{QuestionID: q2.ID, TimedOut: true}), questions))
+	afterWrong, _ := adaptive.Estimate(abilityResponses(append(answered, models.Answer{QuestionID: q2.ID}), questions))
+	if afterTimeout != before || afterWrong >= before {
+		t.Errorf("ability %v, after a timeout %v, after a wrong answer %v", before, afterTimeout, afterWrong)
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/andru_bot/tg-bot/database"
 	"github.com/andru_bot/tg-bot/excel"
 	"github.com/andru_bot/tg-bot/models"
+	"github.com/andru_bot/tg-bot/placement"
+	"github.com/andru_bot/tg-bot/scoring"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -39,7 +42,49 @@ func (h *BotHandler) getAdminTelegramIDs() []int64 {
 	return adminIDs
 }
 
-func (h *BotHandler) sendAdminNotification(userTelegramID int64, sessionID primitive.ObjectID, correctAnswers, incorrectAnswers, totalQuestions int, answers []models.Answer, questions []models.Question) {
+// notifyAdmins runs an admin notification in the background so that building and
+// uploading the report does not hold up the user's worker
+// At most WORKER_COUNT notifications run at the same time
+func (h *BotHandler) notifyAdmins(send func()) {
+	h.notifications.Add(1)
+	go func() {
+		defer h.notifications.Done()
+		h.notificationSlots <- struct{}{}
+		defer func() { <-h.notificationSlots }()
+		send()
+	}()
+}
+
+// getReportSession loads the session for admin reports
+// Falls back to a bare session so that reports are still sent if the lookup fails
+func (h *BotHandler) getReportSession(sessionID primitive.ObjectID) *models.Session {
+	session, err := h.sessionRepo.GetByID(sessionID)
+	if err != nil {
+		log.Printf("Error getting session for admin notification: %v", err)
+		return &models.Session{ID: sessionID}
+	}
+	return session
+}
+
+// formatResultDetails returns the admin message lines for the CEFR placement,
+// the adaptive ability estimate and the shuffle seed, empty if none is available
+func formatResultDetails(session *models.Session, placementResult placement.Result) string {
+	var details string
+	if placementResult.Level != "" {
+		details += fmt.Sprintf("\n🎓 Level: %s\n📈 By level: %s", placementResult.Level, placementResult.Breakdown())
+	}
+	if session.Ability != nil && session.AbilitySE != nil {
+		details += fmt.Sprintf("\n🧠 Ability: %.2f ± %.2f logits", *session.Ability, *session.AbilitySE)
+	}
+	if session.Seed != 0 {
+		details += fmt.Sprintf("\n🎲 Seed: %d", session.Seed)
+	}
+	return details
+}
+
+// sendAdminNotification sends the outcome of a finished session with the results file to all admins
+// Questions from skipFrom onwards that were not answered are marked as skipped in the file
+func (h *BotHandler) sendAdminNotification(userTelegramID int64, sessionID primitive.ObjectID, status, reason string, scoreResult scoring.Result, answers []models.Answer, questions []models.Question, placementResult placement.Result, skipFrom int) {
 	adminIDs := h.getAdminTelegramIDs()
 	if len(adminIDs) == 0 {
 		log.Printf("No admin IDs configured, skipping admin notification")
@@ -62,27 +107,48 @@ func (h *BotHandler) sendAdminNotification(userTelegramID int64, sessionID primi
 		}
 	}
 
+	session := h.getReportSession(sessionID)
+	test := h.sessionTest(session)
+
 	// Create admin message
 	adminMessage := fmt.Sprintf(
-		"📊 Test Completed\n\n"+
+		"📊 %s\n\n"+
 			"👤 User: %s\n"+
+			"📚 Test: %s\n"+
+			"🏁 Outcome: %s\n"+
 			"✅ Correct Answers: %d\n"+
+			"%s"+
 			"❌ Incorrect Answers: %d\n"+
-			"📝 Total Questions: %d",
+			"📝 Total Questions: %d\n"+
+			"🎯 Score: %s",
+		notificationTitle(status),
 		userLink,
-		correctAnswers,
-		incorrectAnswers,
-		totalQuestions,
+		tgbotapi.EscapeText(tgbotapi.ModeMarkdown, test.Name),
+		tgbotapi.EscapeText(tgbotapi.ModeMarkdown, formatOutcome(status, reason)),
+		scoreResult.Correct,
+		formatPartial(scoreResult),
+		scoreResult.Incorrect,
+		len(questions),
+		scoreResult.Summary(),
 	)
+	if verdict := scoreResult.Verdict(); verdict != "" {
+		adminMessage += "\n🏆 " + verdict
+	}
+	adminMessage += formatResultDetails(session, placementResult)
 
-	// Create Excel file
-	excelPath, err := excel.CreateResultsExcel(sessionID, answers, questions)
+	// Create Excel file with skipped questions
+	excelPath, err := excel.CreateResultsExcelWithSkipped(session, answers, questions, scoreResult, placementResult, skipFrom)
 	if err != nil {
 		log.Printf("Error creating Excel file: %v", err)
 		return
 	}
 	defer os.Remove(excelPath) // Clean up temp file
 
+	caption := fmt.Sprintf("%s results for user %s", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, test.Name), userLink)
+	if status != models.StatusCompleted {
+		caption += fmt.Sprintf(" (%s)", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, formatOutcome(status, reason)))
+	}
+
 	// Send message and Excel file to all admins
 	for _, adminID := range adminIDs {
 		// Send message to admin
@@ -95,7 +161,7 @@ func (h *BotHandler) sendAdminNotification(userTelegramID int64, sessionID primi
 
 		// Send Excel file to admin
 		doc := tgbotapi.NewDocument(adminID, tgbotapi.FilePath(excelPath))
-		doc.Caption = fmt.Sprintf("Test results for user %s", userLink)
+		doc.Caption = caption
 		doc.ParseMode = "Markdown"
 		_, err = h.bot.Send(doc)
 		if err != nil {
@@ -104,68 +170,55 @@ func (h *BotHandler) sendAdminNotification(userTelegramID int64, sessionID primi
 	}
 }
 
-func (h *BotHandler) sendAdminNotificationWithSkipped(userTelegramID int64, sessionID primitive.ObjectID, correctAnswers, incorrectAnswers, totalQuestions int, answers []models.Answer, questions []models.Question, currentIdx int, maxConsecutiveErrors int) {
-	adminIDs := h.getAdminTelegramIDs()
-	if len(adminIDs) == 0 {
-		log.Printf("No admin IDs configured, skipping admin notification")
-		return
+// notificationTitle returns the headline of the admin notification for a terminal status
+func notificationTitle(status string) string {
+	switch status {
+	case models.StatusFailedConsecutiveErrors, models.StatusFailed:
+		return "Test Failed"
+	case models.StatusCancelledByUser:
+		return "Test Finished Early"
+	case models.StatusExpired:
+		return "Test Expired"
+	case models.StatusCancelledByAdmin:
+		return "Test Cancelled"
+	default:
+		return "Test Completed"
 	}
+}
 
-	// Get user info by telegram ID
-	user, err := h.userRepo.GetByTelegramID(userTelegramID)
-	var userLink string
-	if err != nil || user == nil {
-		// Fallback: create user link with just ID
-		userLink = fmt.Sprintf("[User %d](tg://user?id=%d)", userTelegramID, userTelegramID)
-		log.Printf("Error getting user for admin notification: %v", err)
-	} else {
-		// Create user link
-		if user.Username != "" {
-			userLink = fmt.Sprintf("[@%s](https://t.me/%s)", user.Username, user.Username)
-		} else {
-			userLink = fmt.Sprintf("[User %d](tg://user?id=%d)", userTelegramID, userTelegramID)
-		}
+// cancelUserTest finishes the running test of the user with the given Telegram ID as cancelled by an admin
+func (h *BotHandler) cancelUserTest(chatID int64, adminID int64, arg string) {
+	targetID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		h.sendMessage(chatID, "Usage: /cancel_test &lt;telegram id&gt;")
+		return
 	}
 
-	// Create admin message
-	adminMessage := fmt.Sprintf(
-		"📊 Test Failed (%d Consecutive Errors)\n\n"+
-			"👤 User: %s\n"+
-			"✅ Correct Answers: %d\n"+
-			"❌ Incorrect Answers: %d\n"+
-			"📝 Total Questions: %d",
-		maxConsecutiveErrors,
-		userLink,
-		correctAnswers,
-		incorrectAnswers,
-		totalQuestions,
-	)
-
-	// Create Excel file with skipped questions
-	excelPath, err := excel.CreateResultsExcelWithSkipped(sessionID, answers, questions, currentIdx)
+	user, err := h.userRepo.GetByTelegramID(targetID)
+	if err == database.ErrNotFound {
+		h.sendMessage(chatID, fmt.Sprintf("User %d not found.", targetID))
+		return
+	}
 	if err != nil {
-		log.Printf("Error creating Excel file: %v", err)
+		log.Printf("Error getting user %d: %v", targetID, err)
+		h.sendMessage(chatID, "Error cancelling test. Please try again later.")
 		return
 	}
-	defer os.Remove(excelPath) // Clean up temp file
 
-	// Send message and Excel file to all admins
-	for _, adminID := range adminIDs {
-		// Send message to admin
-		msg := tgbotapi.NewMessage(adminID, adminMessage)
-		msg.ParseMode = "Markdown"
-		_, err = h.bot.Send(msg)
+	// The test belongs to the target user's worker; queue from a goroutine so this worker
+	// never waits on a full queue of its own
+	go h.dispatcher.Submit(targetID, func() {
+		dbSession, err := h.sessionRepo.GetActiveByUserID(user.ID)
 		if err != nil {
-			log.Printf("Error sending admin notification to %d: %v", adminID, err)
+			log.Printf("Error getting active session of user %d: %v", targetID, err)
+			h.sendMessage(chatID, "Error cancelling test. Please try again later.")
+			return
 		}
-
-		// Send Excel file to admin
-		doc := tgbotapi.NewDocument(adminID, tgbotapi.FilePath(excelPath))
-		doc.Caption = fmt.Sprintf("Test results for user %s (failed due to %d consecutive errors)", userLink, maxConsecutiveErrors)
-		doc.ParseMode = "Markdown"
-		_, err = h.bot.Send(doc)
-		if err != nil {
-			log.Printf("Error sending Excel file to admin %d: %v", adminID, err)
+		if dbSession == nil {
+			h.sendMessage(chatID, fmt.Sprintf("User %d has no running test.", targetID))
+			return
 		}
-	}
+		h.terminateSession(targetID, dbSession, models.StatusCancelledByAdmin, fmt.Sprintf("requested by admin %d", adminID))
+		h.sendMessage(chatID, fmt.Sprintf("🛑 Test of user %d cancelled.", targetID))
+	})
 }
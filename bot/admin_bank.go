@@ -0,0 +1,766 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andru_bot/tg-bot/bank"
+	"github.com/andru_bot/tg-bot/database"
+	"github.com/andru_bot/tg-bot/models"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Admin buttons carry "adm:<action>[:<argument>]"; every press is checked against the admin list
+const (
+	adminCallbackPrefix = "adm:"
+	questionsPerPage    = 10
+)
+
+// AdminCommands are shown in the command menu of admins only
+var AdminCommands = []tgbotapi.BotCommand{
+	{Command: "questions", Description: "Manage the question bank"},
+	{Command: "add_question", Description: "Add a question"},
+	{Command: "cancel", Description: "Cancel the current admin action"},
+	{Command: "cancel_test", Description: "Cancel a user's running test"},
+}
+
+// Steps of the add and edit conversations, named after the field they ask for
+const (
+	stepType      = "type"
+	stepText      = "text"
+	stepOptions   = "options" // Answer options, one per line
+	stepPairs     = "pairs"   // Options of a match question with their matches, one pair per line
+	stepCorrect   = "correct"
+	stepAccepted  = "accepted"  // Accepted answers of a typed-answer question
+	stepTolerance = "tolerance" // Typos allowed in a typed answer
+	stepScore     = "score"
+	stepLevel     = "level"
+	stepTags      = "tags"
+	stepField     = "field"   // Edit: waiting for the field to change
+	stepConfirm   = "confirm" // Waiting for Save or Cancel
+)
+
+// addSteps returns the order in which a new question of the draft's type is entered
+func addSteps(q *models.Question) []string {
+	switch q.QuestionType() {
+	case models.QuestionTypeText:
+		return []string{stepType, stepText, stepAccepted, stepTolerance, stepScore, stepLevel, stepTags, stepConfirm}
+	case models.QuestionTypeOrder:
+		return []string{stepType, stepText, stepOptions, stepScore, stepLevel, stepTags, stepConfirm}
+	case models.QuestionTypeMatch:
+		return []string{stepType, stepText, stepPairs, stepScore, stepLevel, stepTags, stepConfirm}
+	}
+	return []string{stepType, stepText, stepOptions, stepCorrect, stepScore, stepLevel, stepTags, stepConfirm}
+}
+
+// optionTypes are the question types answered by choosing options
+var optionTypes = []string{models.QuestionTypeChoice, models.QuestionTypeMultiple}
+
+// editFields are the fields offered when editing, with their button labels and the question types
+// they apply to (nil for every type)
+var editFields = []struct {
+	step, label   string
+	questionTypes []string
+}{
+	{stepText, "Text", nil},
+	{stepOptions, "Answers", optionTypes},
+	{stepCorrect, "Correct answer", optionTypes},
+	{stepOptions, "Words", []string{models.QuestionTypeOrder}},
+	{stepPairs, "Pairs", []string{models.QuestionTypeMatch}},
+	{stepAccepted, "Accepted answers", []string{models.QuestionTypeText}},
+	{stepTolerance, "Typos allowed", []string{models.QuestionTypeText}},
+	{stepScore, "Score", nil},
+	{stepLevel, "Level", nil},
+	{stepTags, "Tags", nil},
+}
+
+// adminConversation is the state of an admin adding or editing a question, or confirming an import
+type adminConversation struct {
+	editing    bool               // false when adding a new question
+	questionID primitive.ObjectID // Question being edited
+	draft      models.Question
+	step       string
+	changes    *bank.Changes // Uploaded question file waiting for confirmation
+}
+
+// SetAdminCommands shows the user commands plus AdminCommands in the command menu of every admin
+func (h *BotHandler) SetAdminCommands(userCommands []tgbotapi.BotCommand) {
+	commands := append(append([]tgbotapi.BotCommand{}, userCommands...), AdminCommands...)
+	for _, adminID := range h.getAdminTelegramIDs() {
+		cmdConfig := tgbotapi.NewSetMyCommandsWithScope(tgbotapi.NewBotCommandScopeChat(adminID), commands...)
+		_, err := h.bot.Request(cmdConfig)
+		if err != nil {
+			log.Printf("Warning: Failed to set admin commands for %d: %v", adminID, err)
+		}
+	}
+}
+
+func (h *BotHandler) isAdmin(userID int64) bool {
+	for _, adminID := range h.getAdminTelegramIDs() {
+		if adminID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *BotHandler) getConversation(userID int64) *adminConversation {
+	h.conversationsMu.Lock()
+	defer h.conversationsMu.Unlock()
+	return h.conversations[userID]
+}
+
+func (h *BotHandler) setConversation(userID int64, conv *adminConversation) {
+	h.conversationsMu.Lock()
+	defer h.conversationsMu.Unlock()
+	if conv == nil {
+		delete(h.conversations, userID)
+		return
+	}
+	h.conversations[userID] = conv
+}
+
+// handleAdminCommand handles the admin commands, returns false if command is not one of them
+// Non-admins get the same answer as for an unknown command
+func (h *BotHandler) handleAdminCommand(msg *tgbotapi.Message) bool {
+	switch msg.Command() {
+	case "questions", "add_question", "cancel", "cancel_test":
+	default:
+		return false
+	}
+	if !h.isAdmin(msg.From.ID) {
+		return false
+	}
+
+	switch msg.Command() {
+	case "questions":
+		page, _ := strconv.Atoi(strings.TrimSpace(msg.CommandArguments()))
+		h.sendQuestionList(msg.Chat.ID, page-1)
+	case "add_question":
+		conv := &adminConversation{draft: models.Question{Score: 1}, step: stepType}
+		h.setConversation(msg.From.ID, conv)
+		h.sendMessage(msg.Chat.ID, "➕ New question. Send /cancel at any time to stop.")
+		h.askStep(msg.Chat.ID, conv)
+	case "cancel":
+		if h.getConversation(msg.From.ID) == nil {
+			h.sendMessage(msg.Chat.ID, "Nothing to cancel.")
+			return true
+		}
+		h.setConversation(msg.From.ID, nil)
+		h.sendMessage(msg.Chat.ID, "Cancelled.")
+	case "cancel_test":
+		h.cancelUserTest(msg.Chat.ID, msg.From.ID, strings.TrimSpace(msg.CommandArguments()))
+	}
+	return true
+}
+
+// handleAdminInput treats a text message as the answer to the current step of the admin's conversation
+// Returns false if the user has no conversation in progress
+func (h *BotHandler) handleAdminInput(msg *tgbotapi.Message) bool {
+	conv := h.getConversation(msg.From.ID)
+	if conv == nil || !h.isAdmin(msg.From.ID) {
+		return false
+	}
+	h.applyAdminInput(msg.Chat.ID, msg.From.ID, conv, msg.Text)
+	return true
+}
+
+func (h *BotHandler) handleAdminCallback(query *tgbotapi.CallbackQuery) {
+	if !h.isAdmin(query.From.ID) {
+		h.answerCallback(query.ID, "This action is only available to admins.")
+		return
+	}
+	h.answerCallback(query.ID, "")
+
+	chatID := query.Message.Chat.ID
+	userID := query.From.ID
+	action, arg, _ := strings.Cut(strings.TrimPrefix(query.Data, adminCallbackPrefix), ":")
+
+	// Actions on the conversation in progress
+	switch action {
+	case "f", "m", "i", "ok", "no":
+		conv := h.getConversation(userID)
+		if conv == nil {
+			h.sendMessage(chatID, "This action has expired. Use /questions to start again.")
+			return
+		}
+		switch action {
+		case "f":
+			if conv.step != stepField {
+				return
+			}
+			conv.step = arg
+			h.askStep(chatID, conv)
+		case "m":
+			if !conv.editing || conv.step != stepConfirm {
+				return
+			}
+			conv.step = stepField
+			h.askStep(chatID, conv)
+		case "i":
+			h.applyAdminInput(chatID, userID, conv, arg)
+		case "ok":
+			if conv.step == stepConfirm {
+				h.saveConversation(chatID, userID, conv)
+			}
+		case "no":
+			h.setConversation(userID, nil)
+			h.sendMessage(chatID, "Cancelled.")
+		}
+		return
+	case "l":
+		page, _ := strconv.Atoi(arg)
+		h.sendQuestionList(chatID, page)
+		return
+	}
+
+	// Actions on a question
+	questionID, err := primitive.ObjectIDFromHex(arg)
+	if err != nil {
+		h.sendMessage(chatID, "Invalid question.")
+		return
+	}
+
+	switch action {
+	case "v":
+		h.sendQuestionView(chatID, questionID, "")
+	case "e":
+		question, err := h.bank.Get(questionID)
+		if err != nil {
+			h.sendBankError(chatID, err)
+			return
+		}
+		conv := &adminConversation{editing: true, questionID: questionID, draft: *question, step: stepField}
+		h.setConversation(userID, conv)
+		h.askStep(chatID, conv)
+	case "d", "a":
+		if err := h.bank.SetActive(questionID, action == "a"); err != nil {
+			h.sendBankError(chatID, err)
+			return
+		}
+		status := "✅ Question activated."
+		if action == "d" {
+			status = "🚫 Question deactivated, new tests will not use it."
+		}
+		h.sendQuestionView(chatID, questionID, status)
+	case "x":
+		h.sendMessageWithInlineKeyboard(chatID, "Delete this question? Questions that were already used in a test are deactivated instead.",
+			tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🗑 Delete", adminCallbackPrefix+"X:"+arg),
+				tgbotapi.NewInlineKeyboardButtonData("Keep", adminCallbackPrefix+"v:"+arg),
+			)))
+	case "X":
+		retired, err := h.bank.Delete(questionID)
+		if err != nil {
+			h.sendBankError(chatID, err)
+			return
+		}
+		if retired {
+			h.sendQuestionView(chatID, questionID, "🚫 The question is used by past tests, so it was deactivated instead of deleted.")
+			return
+		}
+		h.sendMessage(chatID, "🗑 Question deleted.")
+		h.sendQuestionList(chatID, 0)
+	default:
+		h.sendMessage(chatID, "Unknown action.")
+	}
+}
+
+// applyAdminInput stores the value for the current step and moves the conversation on
+func (h *BotHandler) applyAdminInput(chatID int64, userID int64, conv *adminConversation, input string) {
+	value := strings.TrimSpace(input)
+	q := &conv.draft
+
+	switch conv.step {
+	case stepType:
+		questionType, ok := models.NormalizeQuestionType(value)
+		if !ok {
+			h.sendMessage(chatID, "Please choose the question type with the buttons above.")
+			return
+		}
+		q.Type = questionType
+	case stepText:
+		if value == "" {
+			h.sendMessage(chatID, "The question text must not be empty.")
+			return
+		}
+		q.Text = value
+		q.TextHTML = "" // Formatted copy of the old text
+	case stepOptions:
+		var options []string
+		for _, line := range strings.Split(input, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				options = append(options, line)
+			}
+		}
+		if q.QuestionType() == models.QuestionTypeOrder && len(options) == 1 {
+			options = strings.Fields(options[0]) // A sentence on one line is split into words
+		}
+		if len(options) < models.MinOptions || len(options) > models.MaxOptions {
+			h.sendMessage(chatID, fmt.Sprintf("Please send %d to %d answers, one per line.", models.MinOptions, models.MaxOptions))
+			return
+		}
+		q.Options = options
+		if q.CorrectAnswerID > len(options) {
+			q.CorrectAnswerID = 0 // Has to be chosen again
+		}
+		if slices.ContainsFunc(q.CorrectAnswerIDs, func(id int) bool { return id > len(options) }) {
+			q.CorrectAnswerIDs = nil
+		}
+	case stepCorrect:
+		var ids []int
+		for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+			id, err := strconv.Atoi(field)
+			if err != nil || id < 1 || id > q.GetAnswerCount() || slices.Contains(ids, id) {
+				ids = nil
+				break
+			}
+			ids = append(ids, id)
+		}
+		if q.IsMultiple() && len(ids) == 0 {
+			h.sendMessage(chatID, fmt.Sprintf("Please send the numbers of the correct answers (1 to %d), separated by commas.", q.GetAnswerCount()))
+			return
+		}
+		if !q.IsMultiple() && len(ids) != 1 {
+			h.sendMessage(chatID, fmt.Sprintf("Please choose a number between 1 and %d.", q.GetAnswerCount()))
+			return
+		}
+		if q.IsMultiple() {
+			slices.Sort(ids)
+			q.CorrectAnswerIDs = ids
+		} else {
+			q.CorrectAnswerID = ids[0]
+		}
+	case stepPairs:
+		var options, matches []string
+		for _, line := range strings.Split(input, "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			option, match, _ := strings.Cut(line, "=")
+			options = append(options, strings.TrimSpace(option))
+			matches = append(matches, strings.TrimSpace(match))
+		}
+		if len(options) < models.MinOptions || len(options) > models.MaxOptions ||
+			slices.Contains(options, "") || slices.Contains(matches, "") {
+			h.sendMessage(chatID, fmt.Sprintf("Please send %d to %d pairs, one per line, as <code>word = meaning</code>.", models.MinOptions, models.MaxOptions))
+			return
+		}
+		q.Options = options
+		q.Matches = matches
+	case stepAccepted:
+		answers := models.ParseAcceptedAnswers(input)
+		if len(answers) == 0 {
+			h.sendMessage(chatID, "Please send at least one accepted answer.")
+			return
+		}
+		q.AcceptedAnswers = answers
+	case stepTolerance:
+		tolerance, err := strconv.Atoi(value)
+		if err != nil || tolerance < 0 || tolerance > models.MaxTolerance {
+			h.sendMessage(chatID, fmt.Sprintf("Please send a number between 0 and %d.", models.MaxTolerance))
+			return
+		}
+		q.Tolerance = tolerance
+	case stepScore:
+		score, err := strconv.Atoi(value)
+		if err != nil || score < 0 {
+			h.sendMessage(chatID, "Please send a whole number of points (0 or more).")
+			return
+		}
+		q.Score = score
+	case stepLevel:
+		if value == "-" {
+			q.Level = ""
+		} else if level, ok := models.NormalizeLevel(value); ok {
+			q.Level = level
+		} else {
+			h.sendMessage(chatID, fmt.Sprintf("Please choose one of %s, or - for no level.", strings.Join(models.CEFRLevels, ", ")))
+			return
+		}
+	case stepTags:
+		if value == "-" {
+			value = ""
+		}
+		q.Tags = models.ParseTags(value)
+	default:
+		h.sendMessage(chatID, "Please use the buttons above, or /cancel.")
+		return
+	}
+
+	conv.step = h.nextStep(conv)
+	h.askStep(chatID, conv)
+}
+
+// nextStep returns the step after the current one
+// Editing goes straight to confirmation unless the correct answer has to be chosen again
+func (h *BotHandler) nextStep(conv *adminConversation) string {
+	steps := addSteps(&conv.draft)
+	if slices.Contains(steps, stepCorrect) && !hasCorrectAnswer(&conv.draft) {
+		if conv.editing || conv.step == stepCorrect || indexOf(steps, conv.step) > indexOf(steps, stepCorrect) {
+			return stepCorrect
+		}
+	}
+	if conv.editing {
+		return stepConfirm
+	}
+	return steps[indexOf(steps, conv.step)+1]
+}
+
+// askStep sends the prompt of the conversation's current step
+func (h *BotHandler) askStep(chatID int64, conv *adminConversation) {
+	q := &conv.draft
+	input := func(label, value string) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(label, adminCallbackPrefix+"i:"+value)
+	}
+
+	switch conv.step {
+	case stepField:
+		var buttons []tgbotapi.InlineKeyboardButton
+		for _, f := range editFields {
+			if f.questionTypes == nil || slices.Contains(f.questionTypes, q.QuestionType()) {
+				buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(f.label, adminCallbackPrefix+"f:"+f.step))
+			}
+		}
+		var rows [][]tgbotapi.InlineKeyboardButton
+		for i := 0; i < len(buttons); i += 2 {
+			rows = append(rows, buttons[i:min(i+2, len(buttons))])
+		}
+		h.sendMessageWithInlineKeyboard(chatID, "✏️ What do you want to change?", tgbotapi.NewInlineKeyboardMarkup(rows...))
+	case stepType:
+		h.sendMessageWithInlineKeyboard(chatID, "What kind of question is it?",
+			tgbotapi.NewInlineKeyboardMarkup(
+				tgbotapi.NewInlineKeyboardRow(input("🔘 One correct answer", models.QuestionTypeChoice)),
+				tgbotapi.NewInlineKeyboardRow(input("☑️ Select all that apply", models.QuestionTypeMultiple)),
+				tgbotapi.NewInlineKeyboardRow(input("🧩 Put words in order", models.QuestionTypeOrder)),
+				tgbotapi.NewInlineKeyboardRow(input("🔗 Match pairs", models.QuestionTypeMatch)),
+				tgbotapi.NewInlineKeyboardRow(input("✍️ Typed answer", models.QuestionTypeText)),
+			))
+	case stepText:
+		h.sendMessage(chatID, "Send the question text. HTML formatting such as &lt;b&gt;bold&lt;/b&gt; is allowed.")
+	case stepOptions:
+		if q.QuestionType() == models.QuestionTypeOrder {
+			h.sendMessage(chatID, fmt.Sprintf("Send the sentence in the correct order. It is split into words at spaces; "+
+				"to keep phrases together, send one word or phrase per line (%d to %d).", models.MinOptions, models.MaxOptions))
+			return
+		}
+		h.sendMessage(chatID, fmt.Sprintf("Send the answers, one per line (%d to %d).", models.MinOptions, models.MaxOptions))
+	case stepPairs:
+		h.sendMessage(chatID, fmt.Sprintf("Send the pairs to match, one per line as <code>word = meaning</code> (%d to %d). "+
+			"Candidates see the meanings shuffled.", models.MinOptions, models.MaxOptions))
+	case stepCorrect:
+		if q.IsMultiple() {
+			h.sendMessage(chatID, "Which answers are correct? Send their numbers separated by commas, e.g. <code>1, 3</code>.\n\n"+formatAnswers(q))
+			return
+		}
+		var rows [][]tgbotapi.InlineKeyboardButton
+		for i := 1; i <= q.GetAnswerCount(); i++ {
+			if (i-1)%5 == 0 {
+				rows = append(rows, nil)
+			}
+			rows[len(rows)-1] = append(rows[len(rows)-1], input(strconv.Itoa(i), strconv.Itoa(i)))
+		}
+		h.sendMessageWithInlineKeyboard(chatID, "Which answer is correct?\n\n"+formatAnswers(q), tgbotapi.NewInlineKeyboardMarkup(rows...))
+	case stepAccepted:
+		h.sendMessage(chatID, "Send the accepted answers, one per line or separated by |. "+
+			"Case, extra spaces, punctuation around words and contractions (don't = do not) are ignored when grading.")
+	case stepTolerance:
+		var row []tgbotapi.InlineKeyboardButton
+		for i := 0; i <= models.MaxTolerance; i++ {
+			row = append(row, input(strconv.Itoa(i), strconv.Itoa(i)))
+		}
+		h.sendMessageWithInlineKeyboard(chatID, "How many typos (wrong, missing, extra or swapped letters) are allowed?", tgbotapi.NewInlineKeyboardMarkup(row))
+	case stepScore:
+		h.sendMessageWithInlineKeyboard(chatID, "How many points is a correct answer worth? Send a number or use the button.",
+			tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(input("1 point", "1"))))
+	case stepLevel:
+		var row []tgbotapi.InlineKeyboardButton
+		for _, level := range models.CEFRLevels {
+			row = append(row, input(level, level))
+		}
+		h.sendMessageWithInlineKeyboard(chatID, "Choose the CEFR level.",
+			tgbotapi.NewInlineKeyboardMarkup(row, tgbotapi.NewInlineKeyboardRow(input("No level", "-"))))
+	case stepTags:
+		h.sendMessageWithInlineKeyboard(chatID, "Send the tags separated by commas, e.g. <code>grammar, tenses</code>.",
+			tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(input("No tags", "-"))))
+	case stepConfirm:
+		row := tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💾 Save", adminCallbackPrefix+"ok"),
+			tgbotapi.NewInlineKeyboardButtonData("✖️ Cancel", adminCallbackPrefix+"no"),
+		)
+		if conv.editing {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData("✏️ Change more", adminCallbackPrefix+"m"))
+		}
+		h.sendMessageWithInlineKeyboard(chatID, "Please check the question:\n\n"+formatQuestion(q), tgbotapi.NewInlineKeyboardMarkup(row))
+	}
+}
+
+// saveConversation stores the draft of a confirmed conversation
+func (h *BotHandler) saveConversation(chatID int64, userID int64, conv *adminConversation) {
+	if conv.changes != nil {
+		h.applyImport(chatID, userID, conv.changes)
+		return
+	}
+
+	var saved *models.Question
+	var err error
+	if conv.editing {
+		draft := conv.draft
+		saved, err = h.bank.Edit(conv.questionID, func(q *models.Question) {
+			*q = draft
+		})
+	} else {
+		saved = &conv.draft
+		err = h.bank.Add(saved)
+	}
+	if err != nil {
+		h.sendBankError(chatID, err)
+		return
+	}
+	h.setConversation(userID, nil)
+
+	status := "✅ Question added."
+	if conv.editing && saved.ID != conv.questionID {
+		status = "✅ Question saved as a new version. Past tests keep the old version."
+	} else if conv.editing {
+		status = "✅ Question saved."
+	}
+	h.sendQuestionView(chatID, saved.ID, status)
+}
+
+// sendQuestionList sends one page of the question bank with a button per question
+func (h *BotHandler) sendQuestionList(chatID int64, page int) {
+	questions, err := h.bank.List()
+	if err != nil {
+		h.sendBankError(chatID, err)
+		return
+	}
+	if len(questions) == 0 {
+		h.sendMessage(chatID, "The question bank is empty. Use /add_question to add one.")
+		return
+	}
+
+	pages := (len(questions) + questionsPerPage - 1) / questionsPerPage
+	page = max(0, min(page, pages-1))
+	start := page * questionsPerPage
+	end := min(start+questionsPerPage, len(questions))
+
+	text := fmt.Sprintf("📚 <b>Question bank</b>: %d questions (page %d/%d)\n", len(questions), page+1, pages)
+	var buttons []tgbotapi.InlineKeyboardButton
+	for i := start; i < end; i++ {
+		q := questions[i]
+		text += fmt.Sprintf("\n<b>%d.</b> %s%s", i+1, formatLevelTag(q.Level), html.EscapeString(truncate(plainText(q.Text), 60)))
+		if q.Retired {
+			text += " <i>(inactive)</i>"
+		}
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(i+1), adminCallbackPrefix+"v:"+q.ID.Hex()))
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := 0; i < len(buttons); i += 5 {
+		rows = append(rows, buttons[i:min(i+5, len(buttons))])
+	}
+	var nav []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("◀️ Previous", adminCallbackPrefix+"l:"+strconv.Itoa(page-1)))
+	}
+	if page < pages-1 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("Next ▶️", adminCallbackPrefix+"l:"+strconv.Itoa(page+1)))
+	}
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+
+	h.sendMessageWithInlineKeyboard(chatID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+// sendQuestionView sends a question with its usage and the admin actions, status is shown above it if set
+func (h *BotHandler) sendQuestionView(chatID int64, questionID primitive.ObjectID, status string) {
+	q, err := h.bank.Get(questionID)
+	if err != nil {
+		h.sendBankError(chatID, err)
+		return
+	}
+	used, err := h.bank.Usage(questionID)
+	if err != nil {
+		log.Printf("Error counting question usage: %v", err)
+	}
+
+	text := formatQuestion(q)
+	text += fmt.Sprintf("\n\n🆔 <code>%s</code>", q.ID.Hex())
+	if q.ExternalID != "" {
+		text += fmt.Sprintf("\n🔑 <code>%s</code>", html.EscapeString(q.ExternalID))
+	}
+	text += fmt.Sprintf("\n📝 Used in %d test(s)", used)
+	switch {
+	case q.ReplacedBy != nil:
+		text += "\n♻️ Replaced by an edited version"
+	case q.Retired:
+		text += "\n🚫 Inactive"
+	default:
+		text += "\n✅ Active"
+	}
+	if status != "" {
+		text = status + "\n\n" + text
+	}
+
+	hexID := q.ID.Hex()
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if q.ReplacedBy != nil {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➡️ Current version", adminCallbackPrefix+"v:"+q.ReplacedBy.Hex()),
+		))
+	} else {
+		toggle := tgbotapi.NewInlineKeyboardButtonData("🚫 Deactivate", adminCallbackPrefix+"d:"+hexID)
+		if q.Retired {
+			toggle = tgbotapi.NewInlineKeyboardButtonData("✅ Activate", adminCallbackPrefix+"a:"+hexID)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✏️ Edit", adminCallbackPrefix+"e:"+hexID),
+			toggle,
+			tgbotapi.NewInlineKeyboardButtonData("🗑 Delete", adminCallbackPrefix+"x:"+hexID),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("📚 All questions", adminCallbackPrefix+"l:0"),
+	))
+
+	h.sendMessageWithInlineKeyboard(chatID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func (h *BotHandler) sendBankError(chatID int64, err error) {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		h.sendMessage(chatID, "This question no longer exists.")
+	case errors.Is(err, bank.ErrReplaced):
+		h.sendMessage(chatID, "This question was replaced by an edited version. Please change the current version instead.")
+	default:
+		log.Printf("Question bank error: %v", err)
+		h.sendMessage(chatID, "❌ "+html.EscapeString(err.Error()))
+	}
+}
+
+// formatQuestion renders a question for admins, marking the correct answer
+func formatQuestion(q *models.Question) string {
+	text := fmt.Sprintf("%s%s\n\n%s\n\n⭐ Score: %d", formatLevelTag(q.Level), q.Text, formatAnswers(q), q.Score)
+	if q.Audio != "" {
+		text += fmt.Sprintf("\n🎧 Audio: <code>%s</code>", html.EscapeString(q.Audio))
+	}
+	if q.Image != "" {
+		text += fmt.Sprintf("\n🖼 Image: <code>%s</code>", html.EscapeString(q.Image))
+	}
+	if q.Passage != nil {
+		text += fmt.Sprintf("\n📖 Passage: %s <code>%s</code>", html.EscapeString(q.Passage.Title), html.EscapeString(q.Passage.Key))
+	}
+	if q.Difficulty != nil {
+		text += fmt.Sprintf("\n📈 Difficulty: %.2f", *q.Difficulty)
+	}
+	if len(q.Tags) > 0 {
+		text += "\n🏷 Tags: " + html.EscapeString(strings.Join(q.Tags, ", "))
+	}
+	return text
+}
+
+func formatAnswers(q *models.Question) string {
+	var lines []string
+	if q.IsTextAnswer() {
+		lines = append(lines, "✍️ Typed answer, accepted:")
+		for _, answer := range q.AcceptedAnswers {
+			lines = append(lines, "✅ "+html.EscapeString(answer))
+		}
+		if q.Tolerance > 0 {
+			lines = append(lines, fmt.Sprintf("🔤 Typos allowed: %d", q.Tolerance))
+		}
+		return strings.Join(lines, "\n")
+	}
+	switch q.QuestionType() {
+	case models.QuestionTypeOrder:
+		words := make([]string, len(q.Options))
+		for i, word := range q.Options {
+			words[i] = "[" + html.EscapeString(word) + "]"
+		}
+		return "🧩 Put in order:\n✅ " + strings.Join(words, " ")
+	case models.QuestionTypeMatch:
+		lines = append(lines, "🔗 Match pairs:")
+		for i, option := range q.Options {
+			lines = append(lines, fmt.Sprintf("✅ %d. %s → %s", i+1, html.EscapeString(option), html.EscapeString(q.GetMatch(i+1))))
+		}
+		return strings.Join(lines, "\n")
+	case models.QuestionTypeMultiple:
+		lines = append(lines, "☑️ Select all that apply:")
+	}
+	for i := 1; i <= q.GetAnswerCount(); i++ {
+		mark := "▫️"
+		if q.IsCorrectOption(i) {
+			mark = "✅"
+		}
+		lines = append(lines, fmt.Sprintf("%s %d. %s", mark, i, html.EscapeString(q.GetAnswer(i))))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatLevelTag(level string) string {
+	if level == "" {
+		return ""
+	}
+	return "[" + level + "] "
+}
+
+// hasCorrectAnswer reports whether the correct options of a draft are chosen and exist
+func hasCorrectAnswer(q *models.Question) bool {
+	ids := q.CorrectIDs()
+	if len(ids) == 0 {
+		return false
+	}
+	for _, id := range ids {
+		if id < 1 || id > q.GetAnswerCount() {
+			return false
+		}
+	}
+	return true
+}
+
+// plainText strips HTML tags and line breaks for one-line previews
+func plainText(s string) string {
+	return strings.ReplaceAll(html.UnescapeString(stripTags(s)), "\n", " ")
+}
+
+// stripTags removes the HTML tags of a message, keeping line breaks
+func stripTags(s string) string {
+	var b strings.Builder
+	inTag := false
+	for _, r := range s {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>':
+			inTag = false
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+func indexOf(steps []string, step string) int {
+	for i, s := range steps {
+		if s == step {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,275 @@
+package bot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/andru_bot/tg-bot/bank"
+	"github.com/andru_bot/tg-bot/config"
+	"github.com/andru_bot/tg-bot/csv"
+	"github.com/andru_bot/tg-bot/excel"
+	"github.com/andru_bot/tg-bot/json"
+	"github.com/andru_bot/tg-bot/models"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxImportFileSize = 5 << 20 // Bytes
+	maxReportItems    = 10      // Per section of the import report message; the attached report lists everything
+)
+
+// questionReaders parse an uploaded question file by extension
+var questionReaders = map[string]func(io.Reader, ...models.QuestionCheck) ([]models.Question, error){
+	".json": json.ReadQuestions,
+	".csv":  csv.ReadQuestions,
+	".xlsx": excel.ReadQuestions,
+}
+
+// handleAdminDocument treats a file sent by an admin as a new version of the whole question bank
+// The admin gets a validation report and the changes to the bank; nothing is stored until confirmed
+// Returns false if the message has no document or the sender is not an admin
+func (h *BotHandler) handleAdminDocument(msg *tgbotapi.Message) bool {
+	if msg.Document == nil || !h.isAdmin(msg.From.ID) {
+		return false
+	}
+
+	doc := msg.Document
+	readQuestions, ok := questionReaders[strings.ToLower(filepath.Ext(doc.FileName))]
+	if !ok {
+		h.sendMessage(msg.Chat.ID, "To import questions, send a .json, .csv or .xlsx file.")
+		return true
+	}
+	if doc.FileSize > maxImportFileSize {
+		h.sendMessage(msg.Chat.ID, fmt.Sprintf("The file is too large (at most %d MB).", maxImportFileSize>>20))
+		return true
+	}
+
+	data, err := h.downloadFile(doc.FileID)
+	if err != nil {
+		log.Printf("Error downloading question file: %v", err)
+		h.sendMessage(msg.Chat.ID, "Error downloading the file. Please try again.")
+		return true
+	}
+
+	// Media files are not part of the upload, they have to be in the media directory already
+	questions, err := readQuestions(bytes.NewReader(data), h.mediaDir.Check)
+	var rowErrors models.RowErrors
+	if err != nil && !errors.As(err, &rowErrors) {
+		h.sendMessage(msg.Chat.ID, "❌ Could not read the file: "+html.EscapeString(err.Error()))
+		return true
+	}
+
+	changes, err := h.bank.Diff(questions)
+	if err != nil {
+		log.Printf("Error comparing question file with the bank: %v", err)
+		h.sendMessage(msg.Chat.ID, "Error loading the question bank. Please try again later.")
+		return true
+	}
+
+	report := formatImportReport(doc.FileName, len(questions), rowErrors, changes, maxReportItems)
+	if len(rowErrors) > maxReportItems || len(changes.Added) > maxReportItems ||
+		len(changes.Changed) > maxReportItems || len(changes.Removed) > maxReportItems ||
+		len(changes.Duplicates) > maxReportItems {
+		h.sendImportReportFile(msg.Chat.ID, doc.FileName, questions, rowErrors, changes)
+	}
+
+	switch {
+	case len(rowErrors) > 0:
+		h.setConversation(msg.From.ID, nil)
+		h.sendMessage(msg.Chat.ID, report+"\n\n❌ Please fix the invalid rows and send the file again. The bank was not changed.")
+	case changes.Empty():
+		h.setConversation(msg.From.ID, nil)
+		h.sendMessage(msg.Chat.ID, report+"\n\n✅ The bank already matches this file.")
+	default:
+		h.setConversation(msg.From.ID, &adminConversation{changes: changes, step: stepConfirm})
+		h.sendMessageWithInlineKeyboard(msg.Chat.ID, report+"\n\nApply these changes to the question bank?",
+			tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("✅ Apply", adminCallbackPrefix+"ok"),
+				tgbotapi.NewInlineKeyboardButtonData("✖️ Cancel", adminCallbackPrefix+"no"),
+			)))
+	}
+	return true
+}
+
+// applyImport stores the changes of a confirmed import
+func (h *BotHandler) applyImport(chatID int64, userID int64, changes *bank.Changes) {
+	h.setConversation(userID, nil)
+
+	err := h.bank.Apply(changes)
+	if err != nil {
+		log.Printf("Error applying question import: %v", err)
+		h.sendMessage(chatID, "❌ The import stopped with an error, some changes may have been applied: "+
+			html.EscapeString(err.Error())+"\nSend the file again to see what is left.")
+		return
+	}
+
+	h.sendMessage(chatID, fmt.Sprintf("✅ Question bank updated: %d added, %d changed, %d removed.",
+		len(changes.Added), len(changes.Changed), len(changes.Removed)))
+}
+
+// downloadFile fetches a file sent to the bot
+func (h *BotHandler) downloadFile(fileID string) ([]byte, error) {
+	file, err := h.bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf(config.GetTelegramFileEndpoint(), h.bot.Token, file.FilePath)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.bot.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImportFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImportFileSize {
+		return nil, fmt.Errorf("file is larger than %d bytes", maxImportFileSize)
+	}
+	return data, nil
+}
+
+// sendImportReportFile sends the complete import report as a text file
+func (h *BotHandler) sendImportReportFile(chatID int64, fileName string, questions []models.Question, rowErrors models.RowErrors, changes *bank.Changes) {
+	report := html.UnescapeString(stripTags(formatImportReport(fileName, len(questions), rowErrors, changes, 0)))
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
+		Name:  strings.TrimSuffix(fileName, filepath.Ext(fileName)) + "_report.txt",
+		Bytes: []byte(report),
+	})
+	_, err := h.bot.Send(doc)
+	if err != nil {
+		log.Printf("Error sending import report: %v", err)
+	}
+}
+
+// formatImportReport describes the invalid rows of an uploaded file and its changes to the bank
+// Each section lists at most limit items, 0 lists all
+func formatImportReport(fileName string, valid int, rowErrors models.RowErrors, changes *bank.Changes, limit int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📄 <b>%s</b>: %d valid questions, %d invalid\n", html.EscapeString(fileName), valid, len(rowErrors))
+
+	section := func(title string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n<b>%s (%d)</b>\n", title, len(items))
+		shown := items
+		if limit > 0 && len(items) > limit {
+			shown = items[:limit]
+		}
+		for _, item := range shown {
+			b.WriteString("• " + item + "\n")
+		}
+		if len(shown) < len(items) {
+			fmt.Fprintf(&b, "… and %d more (see the attached report)\n", len(items)-len(shown))
+		}
+	}
+	preview := func(q *models.Question) string {
+		return html.EscapeString(truncate(plainText(q.Text), 60))
+	}
+
+	var items []string
+	for _, rowErr := range rowErrors {
+		items = append(items, fmt.Sprintf("Row %d: %s", rowErr.Row, html.EscapeString(rowErr.Message)))
+	}
+	section("❌ Invalid rows", items)
+
+	items = nil
+	for i := range changes.Added {
+		items = append(items, preview(&changes.Added[i]))
+	}
+	section("➕ Added", items)
+
+	items = nil
+	for i := range changes.Changed {
+		change := &changes.Changed[i]
+		item := preview(&change.Current)
+		if changed := changedFields(&change.Current, &change.New); changed != "" {
+			item += " <i>(" + changed + ")</i>"
+		}
+		items = append(items, item)
+	}
+	section("✏️ Changed", items)
+
+	items = nil
+	for i := range changes.Removed {
+		items = append(items, preview(&changes.Removed[i]))
+	}
+	section("➖ Removed", items)
+
+	items = nil
+	for i := range changes.Duplicates {
+		items = append(items, preview(&changes.Duplicates[i]))
+	}
+	section("⚠️ Repeated in the file, ignored", items)
+
+	fmt.Fprintf(&b, "\nUnchanged: %d", changes.Unchanged)
+	return b.String()
+}
+
+// changedFields lists what an import changes about a question
+func changedFields(current, imported *models.Question) string {
+	var fields []string
+	if current.Key() != imported.Key() {
+		fields = append(fields, "ID")
+	}
+	if current.Text != imported.Text || current.TextHTML != imported.TextHTML {
+		fields = append(fields, "text")
+	}
+	if !slices.Equal(current.Options, imported.Options) {
+		fields = append(fields, "answers")
+	}
+	if !slices.Equal(current.Matches, imported.Matches) {
+		fields = append(fields, "matches")
+	}
+	if current.Audio != imported.Audio || current.Image != imported.Image {
+		fields = append(fields, "media")
+	}
+	if !reflect.DeepEqual(current.Passage, imported.Passage) {
+		fields = append(fields, "passage")
+	}
+	if current.CorrectAnswerID != imported.CorrectAnswerID || !slices.Equal(current.CorrectIDs(), imported.CorrectIDs()) {
+		fields = append(fields, "correct answer")
+	}
+	if current.QuestionType() != imported.QuestionType() {
+		fields = append(fields, "type")
+	}
+	if !slices.Equal(current.AcceptedAnswers, imported.AcceptedAnswers) || current.Tolerance != imported.Tolerance {
+		fields = append(fields, "accepted answers")
+	}
+	if current.Score != imported.Score {
+		fields = append(fields, "score")
+	}
+	if current.Level != imported.Level {
+		fields = append(fields, "level")
+	}
+	if (current.Difficulty == nil) != (imported.Difficulty == nil) ||
+		(current.Difficulty != nil && *current.Difficulty != *imported.Difficulty) {
+		fields = append(fields, "difficulty")
+	}
+	if !slices.Equal(current.Tags, imported.Tags) {
+		fields = append(fields, "tags")
+	}
+	if current.Retired {
+		fields = append(fields, "activated")
+	}
+	return strings.Join(fields, ", ")
+}
@@ -0,0 +1,65 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andru_bot/tg-bot/database"
+	"github.com/andru_bot/tg-bot/models"
+	"github.com/andru_bot/tg-bot/placement"
+	"github.com/andru_bot/tg-bot/scoring"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestAdminNotificationEscapesOutcome(t *testing.T) {
+	const telegramID, adminID = 42, 7
+	t.Setenv("ADMIN_TELEGRAM_ID", "7")
+
+	var mu sync.Mutex
+	var texts []string // Message texts and document captions sent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sendMessage") || strings.HasSuffix(r.URL.Path, "/sendDocument") {
+			r.ParseMultipartForm(1 << 20)
+			mu.Lock()
+			texts = append(texts, r.FormValue("text")+r.FormValue("caption"))
+			mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true,"result":{"message_id":5,"chat":{"id":7}}}`))
+	}))
+	t.Cleanup(srv.Close)
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("token", srv.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatalf("NewBotAPIWithAPIEndpoint() error: %v", err)
+	}
+
+	repos := database.NewMemoryRepositories()
+	h := NewBotHandler(bot, repos, t.TempDir()+"/results.csv")
+	user, err := repos.Users.FindOrCreate(telegramID, "alice", "Alice", "")
+	if err != nil {
+		t.Fatalf("FindOrCreate() error: %v", err)
+	}
+	session := &models.Session{UserID: user.ID}
+	if err := repos.Sessions.Create(session); err != nil {
+		t.Fatalf("Create() session error: %v", err)
+	}
+
+	// Level names and unknown statuses come from configuration and may hold Markdown characters
+	h.sendAdminNotification(telegramID, session.ID, "failed_early", "3 of 4 answers at B1_plus incorrect",
+		scoring.Result{}, nil, nil, placement.Result{}, 0)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := tgbotapi.EscapeText(tgbotapi.ModeMarkdown, "failed_early (3 of 4 answers at B1_plus incorrect)")
+	if len(texts) != 2 {
+		t.Fatalf("sent %d requests to admin %d, want the message and the results file", len(texts), adminID)
+	}
+	for _, text := range texts {
+		if !strings.Contains(text, want) {
+			t.Errorf("admin notification %q does not contain the escaped outcome %q", text, want)
+		}
+	}
+}
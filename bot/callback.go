@@ -0,0 +1,153 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Answer buttons carry "a:<session id>:<question index>:<position>:<signature>"; the buttons of
+// multiple questions carry "m" (toggle an option) or "s" (submit) and a selection instead of the position,
+// the buttons of order and match questions "b" (pick or undo) or "f" (submit) and the sequence built so far
+// as one digit per pick (the displayed position minus 1)
+// The signature is a truncated HMAC-SHA256 of the preceding fields, which keeps
+// the payload under Telegram's 64-byte callback data limit (at most about 60 bytes)
+const (
+	answerPayloadKind      = "a"
+	togglePayloadKind      = "m"
+	submitPayloadKind      = "s"
+	buildPayloadKind       = "b"
+	finishPayloadKind      = "f"
+	answerSignatureLength  = 16 // base64url characters, 96 bits
+	callbackKeyDerivedFrom = "tg-english-bot callback key:"
+)
+
+var errInvalidPayload = errors.New("invalid callback payload")
+
+// answerPayload identifies the button the user pressed
+type answerPayload struct {
+	Kind        string // answerPayloadKind (the default), togglePayloadKind, submitPayloadKind, buildPayloadKind or finishPayloadKind
+	SessionID   primitive.ObjectID
+	QuestionIdx int   // Index of the question in the session when the button was sent
+	Position    int   // answerPayloadKind: displayed position of the option, starting at 1
+	Selection   int   // togglePayloadKind and submitPayloadKind: selected displayed positions, bit i-1 for position i
+	Sequence    []int // buildPayloadKind and finishPayloadKind: displayed positions picked so far, starting at 1
+}
+
+// selectedPositions returns the displayed positions of a selection in ascending order
+func selectedPositions(selection int) []int {
+	var positions []int
+	for position := 1; selection>>(position-1) > 0; position++ {
+		if selection&(1<<(position-1)) != 0 {
+			positions = append(positions, position)
+		}
+	}
+	return positions
+}
+
+// deriveCallbackKey returns the HMAC key for callback payloads
+// CALLBACK_SECRET is used when set, otherwise the key is derived from the bot token
+func deriveCallbackKey(secret, botToken string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+	sum := sha256.Sum256([]byte(callbackKeyDerivedFrom + botToken))
+	return sum[:]
+}
+
+func (h *BotHandler) signCallback(body string) string {
+	mac := hmac.New(sha256.New, h.callbackKey)
+	mac.Write([]byte(body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:answerSignatureLength]
+}
+
+// encodeAnswerPayload builds the signed callback data of an answer button
+func (h *BotHandler) encodeAnswerPayload(p answerPayload) string {
+	kind, value := answerPayloadKind, strconv.Itoa(p.Position)
+	switch p.Kind {
+	case togglePayloadKind, submitPayloadKind:
+		kind, value = p.Kind, strconv.Itoa(p.Selection)
+	case buildPayloadKind, finishPayloadKind:
+		kind, value = p.Kind, encodeSequence(p.Sequence)
+	}
+	body := fmt.Sprintf("%s:%s:%d:%s", kind, p.SessionID.Hex(), p.QuestionIdx, value)
+	return body + ":" + h.signCallback(body)
+}
+
+// encodeSequence writes displayed positions 1 to 10 as the digits 0 to 9
+func encodeSequence(sequence []int) string {
+	digits := make([]byte, len(sequence))
+	for i, position := range sequence {
+		digits[i] = byte('0' + position - 1)
+	}
+	return string(digits)
+}
+
+// decodeSequence parses the digits written by encodeSequence
+func decodeSequence(s string) ([]int, error) {
+	sequence := make([]int, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return nil, errInvalidPayload
+		}
+		sequence[i] = int(s[i]-'0') + 1
+	}
+	return sequence, nil
+}
+
+// decodeAnswerPayload verifies and parses the callback data of an answer button
+// Payloads with a bad signature or format (including bare positions sent by older versions) are rejected
+func (h *BotHandler) decodeAnswerPayload(data string) (answerPayload, error) {
+	i := strings.LastIndexByte(data, ':')
+	if i < 0 {
+		return answerPayload{}, errInvalidPayload
+	}
+	body, signature := data[:i], data[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(h.signCallback(body))) {
+		return answerPayload{}, errInvalidPayload
+	}
+
+	parts := strings.Split(body, ":")
+	if len(parts) != 4 {
+		return answerPayload{}, errInvalidPayload
+	}
+	kind := parts[0]
+	switch kind {
+	case answerPayloadKind, togglePayloadKind, submitPayloadKind, buildPayloadKind, finishPayloadKind:
+	default:
+		return answerPayload{}, errInvalidPayload
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return answerPayload{}, errInvalidPayload
+	}
+	questionIdx, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return answerPayload{}, errInvalidPayload
+	}
+
+	p := answerPayload{Kind: kind, SessionID: sessionID, QuestionIdx: questionIdx}
+	if kind == buildPayloadKind || kind == finishPayloadKind {
+		if p.Sequence, err = decodeSequence(parts[3]); err != nil {
+			return answerPayload{}, err
+		}
+		return p, nil
+	}
+	value, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return answerPayload{}, errInvalidPayload
+	}
+	if kind == answerPayloadKind {
+		p.Position = value
+	} else {
+		p.Selection = value
+	}
+	return p, nil
+}
@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAnswerPayloadRoundTrip(t *testing.T) {
+	h := &BotHandler{callbackKey: deriveCallbackKey("", "token")}
+	sessionID := primitive.NewObjectID()
+
+	tests := []struct {
+		name    string
+		payload answerPayload
+		want    answerPayload // Zero to expect the payload itself
+	}{
+		{name: "answer", payload: answerPayload{Kind: answerPayloadKind, SessionID: sessionID, QuestionIdx: 3, Position: 2}},
+		{name: "kind defaults to answer", payload: answerPayload{SessionID: sessionID, QuestionIdx: 0, Position: 1},
+			want: answerPayload{Kind: answerPayloadKind, SessionID: sessionID, QuestionIdx: 0, Position: 1}},
+		{name: "toggle", payload: answerPayload{Kind: togglePayloadKind, SessionID: sessionID, QuestionIdx: 7, Selection: 0b1010}},
+		{name: "submit", payload: answerPayload{Kind: submitPayloadKind, SessionID: sessionID, QuestionIdx: 7, Selection: 0b0101}},
+		{name: "build", payload: answerPayload{Kind: buildPayloadKind, SessionID: sessionID, QuestionIdx: 12, Sequence: []int{3, 1, 10}}},
+		{name: "build from scratch", payload: answerPayload{Kind: buildPayloadKind, SessionID: sessionID, QuestionIdx: 12, Sequence: []int{}}},
+		{name: "finish", payload: answerPayload{Kind: finishPayloadKind, SessionID: sessionID, QuestionIdx: 99, Sequence: []int{2, 4, 1, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want.Kind == "" {
+				want = tt.payload
+			}
+			data := h.encodeAnswerPayload(tt.payload)
+			if len(data) > 64 {
+				t.Errorf("callback data %q is %d bytes, Telegram allows 64", data, len(data))
+			}
+			got, err := h.decodeAnswerPayload(data)
+			if err != nil {
+				t.Fatalf("decodeAnswerPayload(%q) error: %v", data, err)
+			}
+			if got.Kind != want.Kind || got.SessionID != want.SessionID || got.QuestionIdx != want.QuestionIdx ||
+				got.Position != want.Position || got.Selection != want.Selection || !slices.Equal(got.Sequence, want.Sequence) {
+				t.Errorf("decodeAnswerPayload(%q) = %+v, want %+v", data, got, want)
+			}
+		})
+	}
+}
+
+func TestAnswerPayloadTampering(t *testing.T) {
+	h := &BotHandler{callbackKey: deriveCallbackKey("", "token")}
+	other := &BotHandler{callbackKey: deriveCallbackKey("secret", "token")}
+	valid := h.encodeAnswerPayload(answerPayload{Kind: answerPayloadKind, SessionID: primitive.NewObjectID(), QuestionIdx: 1, Position: 2})
+	body, signature := valid[:strings.LastIndexByte(valid, ':')], valid[strings.LastIndexByte(valid, ':')+1:]
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"other position", strings.Replace(body, ":1:2", ":1:3", 1) + ":" + signature},
+		{"other question", strings.Replace(body, ":1:2", ":0:2", 1) + ":" + signature},
+		{"other kind", "m" + body[1:] + ":" + signature},
+		{"bad signature", body + ":" + strings.Repeat("A", answerSignatureLength)},
+		{"short signature", body + ":" + signature[:answerSignatureLength-1]},
+		{"signed with another key", other.encodeAnswerPayload(answerPayload{Kind: answerPayloadKind, SessionID: primitive.NewObjectID(), QuestionIdx: 1, Position: 2})},
+		{"no signature", body},
+		{"missing field", "a:1:2:" + h.signCallback("a:1:2")},
+		{"unknown kind", "x:" + body[2:] + ":" + h.signCallback("x:"+body[2:])},
+		{"bad sequence", "b:" + body[2:len(body)-1] + "x:" + h.signCallback("b:"+body[2:len(body)-1]+"x")},
+		{"bare position of older versions", "2"},
+		{"empty", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := h.decodeAnswerPayload(tt.data); err != errInvalidPayload {
+				t.Errorf("decodeAnswerPayload(%q) = %+v, %v, want errInvalidPayload", tt.data, got, err)
+			}
+		})
+	}
+}
+
+func TestDeriveCallbackKey(t *testing.T) {
+	if got := string(deriveCallbackKey("secret", "token")); got != "secret" {
+		t.Errorf("deriveCallbackKey() = %q, want the secret", got)
+	}
+	if slices.Equal(deriveCallbackKey("", "token"), deriveCallbackKey("", "other token")) {
+		t.Error("deriveCallbackKey() gives the same key for different bot tokens")
+	}
+}
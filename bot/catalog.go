@@ -0,0 +1,96 @@
+package bot
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	"github.com/andru_bot/tg-bot/models"
+	"github.com/andru_bot/tg-bot/scoring"
+	"github.com/andru_bot/tg-bot/termination"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Catalog buttons carry "test:<test id>"
+const catalogCallbackPrefix = "test:"
+
+// showCatalog lets the user pick one of the tests of the catalog
+func (h *BotHandler) showCatalog(chatID int64, tests []models.Test) {
+	text := "📚 <b>Choose a test</b>\n"
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for i, test := range tests {
+		text += fmt.Sprintf("\n<b>%d. %s</b>", i+1, html.EscapeString(test.Name))
+		if test.Description != "" {
+			text += "\n" + html.EscapeString(test.Description)
+		}
+		text += "\n"
+		keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d. %s", i+1, test.Name), catalogCallbackPrefix+test.ID.Hex()),
+		))
+	}
+
+	h.sendMessageWithInlineKeyboard(chatID, text, tgbotapi.NewInlineKeyboardMarkup(keyboard...))
+}
+
+// handleCatalogCallback starts the test the user picked from the catalog
+func (h *BotHandler) handleCatalogCallback(query *tgbotapi.CallbackQuery) {
+	chatID := query.Message.Chat.ID
+
+	testID, err := primitive.ObjectIDFromHex(strings.TrimPrefix(query.Data, catalogCallbackPrefix))
+	if err != nil {
+		h.answerCallback(query.ID, "This test is no longer available.")
+		return
+	}
+	test, err := h.testRepo.GetByID(testID)
+	if err != nil || test.Retired {
+		if err != nil {
+			log.Printf("Error getting test %s: %v", testID.Hex(), err)
+		}
+		h.answerCallback(query.ID, "This test is no longer available.")
+		return
+	}
+	h.answerCallback(query.ID, "")
+
+	user, err := h.userRepo.FindOrCreate(query.From.ID, query.From.UserName, query.From.FirstName, query.From.LastName)
+	if err != nil {
+		log.Printf("Error finding/creating user: %v", err)
+		h.sendMessage(chatID, "Error starting test. Please try again later.")
+		return
+	}
+
+	if h.resumeTest(chatID, query.From.ID, user) {
+		return
+	}
+	h.startTest(chatID, query.From.ID, user, test)
+}
+
+// sessionTest returns the settings a session runs with: the snapshot taken when it started,
+// or the default test for sessions started before the test catalog existed
+func (h *BotHandler) sessionTest(dbSession *models.Session) models.Test {
+	if dbSession.Test != nil {
+		return *dbSession.Test
+	}
+	return h.defaultTest
+}
+
+// testPolicies returns the termination policies of a test
+// Policies are validated when tests.json is loaded; one that no longer parses falls back to the default test's
+func (h *BotHandler) testPolicies(test *models.Test) termination.Policies {
+	policies, err := termination.Parse(test.TerminationPolicy)
+	if err != nil {
+		log.Printf("Error parsing termination policy of test %s: %v, using the default", test.Key, err)
+		policies, _ = termination.Parse(h.defaultTest.TerminationPolicy)
+	}
+	return policies
+}
+
+// testScoring returns the scoring scheme of a test
+func testScoring(test *models.Test) scoring.Config {
+	return scoring.Config{
+		NegativeMarking: test.NegativeMarking,
+		PassThreshold:   test.PassThreshold,
+		PartialCredit:   test.PartialCredit,
+	}
+}
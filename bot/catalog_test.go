@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCatalog(t *testing.T) {
+	const telegramID = 42
+	h, repos := newTestHandler(t)
+	b1 := &models.Question{ID: primitive.NewObjectID(), Text: "If I ___ you, I would go.", Options: []string{"am", "were", "be"}, CorrectAnswerID: 2, Score: 2, Level: "B1"}
+	if err := repos.Questions.Create(b1); err != nil {
+		t.Fatalf("Create() question error: %v", err)
+	}
+	tests := []*models.Test{
+		{ID: primitive.NewObjectID(), Key: "b1", Name: "B1 grammar", Position: 0, Mode: models.ModeLinear, Levels: []string{"B1"}, TerminationPolicy: "none"},
+		{ID: primitive.NewObjectID(), Key: "quick", Name: "Quick check", Position: 1, Mode: models.ModeLinear, QuestionCount: 2, TerminationPolicy: "none"},
+		{ID: primitive.NewObjectID(), Key: "old", Name: "Old test", Position: 2, Mode: models.ModeLinear, TerminationPolicy: "none", Retired: true},
+	}
+	for _, test := range tests {
+		if err := repos.Tests.Create(test); err != nil {
+			t.Fatalf("Create() test error: %v", err)
+		}
+	}
+
+	// Several tests: the user picks one instead of starting right away
+	handle(h, commandUpdate(telegramID, "/start_test"))
+	if h.getActiveSession(telegramID) != nil {
+		t.Fatal("/start_test started a test although the catalog has several")
+	}
+
+	// A retired test can no longer be started from an old catalog message
+	handle(h, callbackUpdate(telegramID, catalogCallbackPrefix+tests[2].ID.Hex()))
+	if h.getActiveSession(telegramID) != nil {
+		t.Fatal("retired test was started")
+	}
+
+	for _, tt := range []struct {
+		test      *models.Test
+		wantCount int
+	}{
+		{tests[0], 1},
+		{tests[1], 2},
+	} {
+		handle(h, callbackUpdate(telegramID, catalogCallbackPrefix+tt.test.ID.Hex()))
+		session := h.getActiveSession(telegramID)
+		if session == nil {
+			t.Fatalf("choosing %s started no test", tt.test.Key)
+		}
+		if session.Test.Key != tt.test.Key || len(session.QuestionIDs) != tt.wantCount {
+			t.Errorf("session of %s = test %s with %d questions, want %d", tt.test.Key, session.Test.Key, len(session.QuestionIDs), tt.wantCount)
+		}
+		if tt.test.Key == "b1" && session.QuestionIDs[0] != b1.ID {
+			t.Errorf("B1 test asked %v, want the B1 question", session.QuestionIDs)
+		}
+
+		stored, err := repos.Sessions.GetByID(session.SessionID)
+		if err != nil {
+			t.Fatalf("GetByID() error: %v", err)
+		}
+		if stored.TestID != tt.test.ID || stored.Test == nil || stored.Test.Name != tt.test.Name {
+			t.Errorf("stored session test = %v %+v, want a snapshot of %s", stored.TestID, stored.Test, tt.test.Key)
+		}
+		handle(h, commandUpdate(telegramID, "/finish_test"))
+	}
+	h.notifications.Wait()
+}
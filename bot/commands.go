@@ -2,8 +2,14 @@ package bot
 
 import (
 	"fmt"
+	"html"
 	"log"
+	"time"
 
+	"github.com/andru_bot/tg-bot/blueprint"
+	"github.com/andru_bot/tg-bot/models"
+	"github.com/andru_bot/tg-bot/placement"
+	"github.com/andru_bot/tg-bot/shuffle"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -21,6 +27,9 @@ func (h *BotHandler) handleCommand(msg *tgbotapi.Message) {
 	case "result":
 		h.handleResult(msg)
 	default:
+		if h.handleAdminCommand(msg) {
+			return
+		}
 		h.sendMessageWithMenu(msg.Chat.ID, "Unknown command. Use /help to see available commands.")
 	}
 }
@@ -49,7 +58,7 @@ func (h *BotHandler) handleStart(msg *tgbotapi.Message) {
 		"Use the menu buttons below or commands to interact with the bot.\n\n" +
 		"Use 'Start Test' to begin the test.\n\n" +
 		"The bot will ask you questions one by one. " +
-		"Select your answer using the buttons. " +
+		"Select your answer using the buttons, or type it when asked. " +
 		"Your answers are kept secret until the end of the test."
 
 	h.sendMessageWithMenu(msg.Chat.ID, text)
@@ -60,9 +69,17 @@ func (h *BotHandler) handleHelp(msg *tgbotapi.Message) {
 		"Available commands:\n" +
 		"📚 Start Test - Start a new test\n" +
 		"✅ Finish Test - Finish current test session\n" +
-		"📊 My Results - Show results of your last completed test\n" +
+		"📊 My Results - Show your last result of each test\n" +
 		"ℹ️ Help - Show this help message\n\n" +
 		"You can use menu buttons or commands: /start_test, /finish_test, /result"
+	if h.isAdmin(msg.From.ID) {
+		text += "\n\nAdmin commands:\n" +
+			"/questions - Browse, edit, deactivate and delete questions\n" +
+			"/add_question - Add a question\n" +
+			"/cancel - Cancel adding or editing a question\n" +
+			"/cancel_test &lt;telegram id&gt; - Cancel a user's running test\n" +
+			"Send a .json, .csv or .xlsx file to replace the question bank (you confirm the changes first)"
+	}
 
 	h.sendMessageWithMenu(msg.Chat.ID, text)
 }
@@ -83,117 +100,190 @@ func (h *BotHandler) handleTestMe(msg *tgbotapi.Message) {
 		return
 	}
 
-	// Check if user already has an active session in memory
-	if _, exists := h.activeSessions[userID]; exists {
-		h.sendMessage(msg.Chat.ID, "You already have an active test session. Please complete it first.")
+	if h.resumeTest(msg.Chat.ID, userID, user) {
+		return
+	}
+
+	tests, err := h.testRepo.GetActive()
+	if err != nil {
+		log.Printf("Error getting tests: %v", err)
+		h.sendMessage(msg.Chat.ID, "Error loading tests. Please try again later.")
 		return
 	}
 
+	switch len(tests) {
+	case 0:
+		// Catalog not synced, e.g. the bot was started without main
+		h.startTest(msg.Chat.ID, userID, user, &h.defaultTest)
+	case 1:
+		h.startTest(msg.Chat.ID, userID, user, &tests[0])
+	default:
+		h.showCatalog(msg.Chat.ID, tests)
+	}
+}
+
+// resumeTest continues the user's test in progress
+// Returns false if the user has none and may start a new test
+func (h *BotHandler) resumeTest(chatID int64, userID int64, user *models.User) bool {
+	// Check if user already has an active session in memory
+	if h.getActiveSession(userID) != nil {
+		h.sendMessage(chatID, "You already have an active test session. Please complete it first.")
+		return true
+	}
+
 	// Check for existing active session in database
-	dbSession, err := h.sessionRepo.GetActiveByUserID(user.ID)
+	resumed, err := h.loadStoredSession(userID, user)
 	if err != nil {
 		log.Printf("Error checking for existing session: %v", err)
-		h.sendMessage(msg.Chat.ID, "Error starting test. Please try again later.")
-		return
+		h.sendMessage(chatID, "Error starting test. Please try again later.")
+		return true
+	}
+	if resumed == nil {
+		return false
 	}
 
 	// If there's an active session in DB, resume it
-	if dbSession != nil {
-		h.activeSessions[userID] = &ActiveSession{
-			SessionID:         dbSession.ID,
-			UserID:            dbSession.UserID,
-			QuestionIDs:       dbSession.QuestionIDs,
-			CurrentIdx:        dbSession.CurrentIdx,
-			Score:             dbSession.TotalScore,
-			ConsecutiveErrors: 0, // Will be recalculated from answers when callback is processed
-		}
-		h.sendMessage(msg.Chat.ID, "Resuming your test...")
-		h.sendNextQuestion(msg.Chat.ID, userID)
-		return
+	h.touchSession(resumed)
+	// The last answer before the restart may already have ended the test
+	if h.checkTermination(chatID, userID, resumed) {
+		return true
+	}
+	h.sendMessage(chatID, "Resuming your test...")
+	h.sendNextQuestion(chatID, userID)
+	return true
+}
+
+// optionOrder returns the displayed option order of the question at position questionIdx,
+// nil to show the options in canonical order
+// Order and match questions are always scrambled, since their canonical order is the key
+func optionOrder(test *models.Test, question *models.Question, seed int64, questionIdx int) []int {
+	if question.IsSequence() {
+		return shuffle.Scramble(seed, questionIdx, question.GetAnswerCount())
+	}
+	if test.ShuffleOptions {
+		return shuffle.Options(seed, questionIdx, question.GetAnswerCount())
 	}
+	return nil
+}
 
-	// Get all questions
-	questions, err := h.questionRepo.GetAll()
+// startTest starts a new session of the test with the questions of the bank it selects
+func (h *BotHandler) startTest(chatID int64, userID int64, user *models.User, test *models.Test) {
+	// Get all questions of the test
+	bank, err := h.questionRepo.GetActive()
 	if err != nil {
 		log.Printf("Error getting questions: %v", err)
-		h.sendMessage(msg.Chat.ID, "Error loading questions. Please try again later.")
+		h.sendMessage(chatID, "Error loading questions. Please try again later.")
 		return
 	}
+	questions := test.SelectQuestions(bank)
 
 	if len(questions) == 0 {
-		h.sendMessage(msg.Chat.ID, "No questions available. Please contact administrator.")
+		h.sendMessage(chatID, "No questions available. Please contact administrator.")
 		return
 	}
 
-	// Create question IDs list
-	questionIDs := make([]primitive.ObjectID, len(questions))
-	for i, q := range questions {
-		questionIDs[i] = q.ID
+	// Keep the settings as they are now, so later changes of the catalog do not change this test
+	snapshot := *test
+	session := &models.Session{
+		UserID: user.ID,
+		TestID: test.ID,
+		Test:   &snapshot,
+		ChatID: chatID,
+		Mode:   test.Mode,
+		Seed:   shuffle.NewSeed(),
+	}
+	if test.TimeLimit > 0 {
+		expiresAt := time.Now().Add(test.TimeLimit)
+		session.ExpiresAt = &expiresAt
+	}
+	if test.Mode == models.ModeAdaptive {
+		// Questions are selected one at a time as the candidate answers
+		session.QuestionIDs = []primitive.ObjectID{}
+		session.TotalQuestions = min(h.adaptiveConfig.MaxQuestions, len(questions))
+		if test.QuestionCount > 0 {
+			session.TotalQuestions = min(session.TotalQuestions, test.QuestionCount)
+		}
+	} else {
+		if len(test.Blueprint) > 0 {
+			// Draw a parallel form from the pools of the blueprint (steps -1 and -2 are taken by shuffle)
+			questions, err = blueprint.Assemble(test.Blueprint, questions, shuffle.Rand(session.Seed, -3))
+			if err != nil {
+				log.Printf("Error assembling test %s: %v", test.Key, err)
+				h.sendMessage(chatID, "This test can't be started right now. Please contact administrator.")
+				return
+			}
+		}
+
+		// Create question IDs list
+		questionIDs := make([]primitive.ObjectID, len(questions))
+		for i, q := range questions {
+			questionIDs[i] = q.ID
+		}
+		// The questions of a reading passage are drawn and shuffled as a group, and asked one after another
+		groups := models.PassageGroups(questions)
+		if test.QuestionCount > 0 {
+			questionIDs = shuffle.Draw(session.Seed, questionIDs, test.QuestionCount, groups)
+		}
+		if test.ShuffleQuestions {
+			questionIDs = shuffle.Questions(session.Seed, questionIDs, groups)
+		} else {
+			questionIDs = shuffle.Group(questionIDs, groups)
+		}
+		// Keep the questions as they are now, so later edits of the bank do not change this test
+		session.Questions = make([]models.Question, len(questionIDs))
+		for i, id := range questionIDs {
+			question, _ := models.FindQuestion(questions, id)
+			session.Questions[i] = question.Snapshot()
+		}
+		for i := range session.Questions {
+			order := optionOrder(test, &session.Questions[i], session.Seed, i)
+			if order == nil {
+				continue
+			}
+			if session.OptionOrders == nil {
+				session.OptionOrders = make(map[string][]int, len(questionIDs))
+			}
+			session.OptionOrders[session.Questions[i].ID.Hex()] = order
+		}
+		session.QuestionIDs = questionIDs
+		session.TotalQuestions = len(questionIDs)
 	}
 
 	// Create new session in database
-	session, err := h.sessionRepo.Create(user.ID, questionIDs)
+	err = h.sessionRepo.Create(session)
 	if err != nil {
 		log.Printf("Error creating session: %v", err)
-		h.sendMessage(msg.Chat.ID, "Error starting test. Please try again later.")
+		h.sendMessage(chatID, "Error starting test. Please try again later.")
 		return
 	}
 
 	// Create active session in memory
-	h.activeSessions[userID] = &ActiveSession{
-		SessionID:         session.ID,
-		UserID:            user.ID,
-		QuestionIDs:       questionIDs,
-		CurrentIdx:        0,
-		Score:             0,
-		ConsecutiveErrors: 0,
-	}
+	h.activateSession(userID, h.activeSessionFromDB(session))
 
 	// Remove menu keyboard during test
-	h.removeMenu(msg.Chat.ID)
+	h.removeMenu(chatID)
 
 	// Send first question
-	h.sendNextQuestion(msg.Chat.ID, userID)
+	h.sendNextQuestion(chatID, userID)
 }
 
 func (h *BotHandler) handleFinishTest(msg *tgbotapi.Message) {
 	userID := msg.From.ID
 
-	// Check if user has an active session in memory
-	session, exists := h.activeSessions[userID]
-	if !exists {
-		// Try to load from database
-		user, err := h.userRepo.FindOrCreate(
-			int64(userID),
-			msg.From.UserName,
-			msg.From.FirstName,
-			msg.From.LastName,
-		)
-		if err != nil {
-			log.Printf("Error finding/creating user: %v", err)
-			h.sendMessage(msg.Chat.ID, "Error processing request. Please try again later.")
-			return
-		}
-
-		dbSession, err := h.sessionRepo.GetActiveByUserID(user.ID)
-		if err != nil || dbSession == nil {
-			h.sendMessage(msg.Chat.ID, "You don't have an active test session.")
-			return
-		}
-
-		// Load session into memory
-		h.activeSessions[userID] = &ActiveSession{
-			SessionID:   dbSession.ID,
-			UserID:      dbSession.UserID,
-			QuestionIDs: dbSession.QuestionIDs,
-			CurrentIdx:  dbSession.CurrentIdx,
-			Score:       dbSession.TotalScore,
-		}
-		session = h.activeSessions[userID]
+	// Load the session from the database if it is not in memory (e.g. after a restart)
+	session, err := h.resumeSession(msg.From)
+	if err != nil {
+		log.Printf("Error loading active session: %v", err)
+		h.sendMessage(msg.Chat.ID, "Error processing request. Please try again later.")
+		return
+	}
+	if session == nil {
+		h.sendMessage(msg.Chat.ID, "You don't have an active test session.")
+		return
 	}
 
 	// Finish the test manually (hide detailed results)
-	h.finishTest(msg.Chat.ID, userID, session, false)
+	h.finishTest(msg.Chat.ID, userID, session, models.StatusCancelledByUser, "ended by the candidate")
 }
 
 func (h *BotHandler) handleResult(msg *tgbotapi.Message) {
@@ -212,59 +302,87 @@ func (h *BotHandler) handleResult(msg *tgbotapi.Message) {
 		return
 	}
 
-	// Get last completed session
-	session, err := h.sessionRepo.GetLastCompletedByUserID(user.ID)
+	// Finished sessions of every test, whatever their outcome, latest first
+	sessions, err := h.sessionRepo.GetFinishedByUserID(user.ID)
 	if err != nil {
-		log.Printf("Error getting last session: %v", err)
+		log.Printf("Error getting finished sessions: %v", err)
 		h.sendMessage(msg.Chat.ID, "Error retrieving results. Please try again later.")
 		return
 	}
 
-	if session == nil {
+	if len(sessions) == 0 {
 		h.sendMessage(msg.Chat.ID, "You haven't completed any test yet. Use /start_test to begin.")
 		return
 	}
 
+	// The last result of each test; sessions started before the catalog existed count as one test
+	shown := make(map[primitive.ObjectID]bool)
+	for i := range sessions {
+		session := &sessions[i]
+		if shown[session.TestID] {
+			continue
+		}
+		shown[session.TestID] = true
+
+		resultText, err := h.formatSessionResult(session)
+		if err != nil {
+			log.Printf("Error getting answers: %v", err)
+			h.sendMessage(msg.Chat.ID, "Error retrieving answers. Please try again later.")
+			return
+		}
+		h.sendMessageWithMenu(msg.Chat.ID, resultText)
+	}
+}
+
+// formatSessionResult describes the result of a finished session for the candidate
+func (h *BotHandler) formatSessionResult(session *models.Session) (string, error) {
 	// Get all answers for this session
 	answers, err := h.answerRepo.GetBySession(session.ID)
 	if err != nil {
-		log.Printf("Error getting answers: %v", err)
-		h.sendMessage(msg.Chat.ID, "Error retrieving answers. Please try again later.")
-		return
-	}
-
-	// Calculate statistics
-	totalQuestions := session.TotalQuestions
-	correctAnswers := 0
-	incorrectAnswers := 0
-
-	for _, answer := range answers {
-		if answer.IsCorrect {
-			correctAnswers++
-		} else {
-			incorrectAnswers++
-		}
+		return "", err
 	}
 
-	skippedQuestions := totalQuestions - len(answers)
-	percentage := float64(correctAnswers) / float64(totalQuestions) * 100.0
+	// The result stored when the test finished, the same one the admins got
+	test := h.sessionTest(session)
+	questions := h.getSessionQuestions(session.QuestionIDs, session.Questions)
+	scoreResult := testScoring(&test).FromSession(session, questions, answers)
 
 	// Format result message
 	resultText := fmt.Sprintf(
-		"📊 Results of your last test:\n\n"+
+		"📊 Results of your last test <b>%s</b>:\n\n"+
+			"🏁 Outcome: %s\n"+
 			"Total Questions: %d\n"+
 			"✅ Correct Answers: %d\n"+
+			"%s"+
 			"❌ Incorrect Answers: %d\n"+
 			"⏭️  Skipped Questions: %d\n"+
-			"📈 Score: %d/%d (%.1f%%)",
-		totalQuestions,
-		correctAnswers,
-		incorrectAnswers,
-		skippedQuestions,
-		session.TotalScore,
-		totalQuestions,
-		percentage,
+			"📈 Score: %s",
+		html.EscapeString(test.Name),
+		formatOutcome(session.Status, session.TerminationReason),
+		len(questions),
+		scoreResult.Correct,
+		formatPartial(scoreResult),
+		scoreResult.Incorrect,
+		scoreResult.Skipped,
+		scoreResult.Summary(),
 	)
+	if verdict := scoreResult.Verdict(); verdict != "" {
+		resultText += "\n🏆 " + verdict
+	}
+
+	// Per-level breakdown and CEFR placement
+	placementResult := placement.Compute(questions, answers, h.levelCutScores)
+	level := session.Level
+	if level == "" {
+		// Sessions finished before placement was stored
+		level = placementResult.Level
+	}
+	if level != "" {
+		resultText += fmt.Sprintf("\n🎓 Your level: <b>%s</b>", level)
+		if len(placementResult.Levels) > 0 {
+			resultText += fmt.Sprintf("\n📚 By level: %s", placementResult.Breakdown())
+		}
+	}
 
-	h.sendMessageWithMenu(msg.Chat.ID, resultText)
+	return resultText, nil
 }
@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"sync"
+)
+
+// Dispatcher runs jobs on a fixed number of workers
+// Jobs of the same user always go to the same worker, so they run one at a time
+// and in submission order, while different users are processed in parallel
+type Dispatcher struct {
+	queues []chan func()
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex // Guards closed; held for reading while a job is queued
+	closed bool
+}
+
+// NewDispatcher starts workers goroutines, each with a queue of queueSize pending jobs
+func NewDispatcher(workers, queueSize int) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+
+	d := &Dispatcher{queues: make([]chan func(), workers)}
+	for i := range d.queues {
+		queue := make(chan func(), queueSize)
+		d.queues[i] = queue
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for job := range queue {
+				job()
+			}
+		}()
+	}
+	return d
+}
+
+// Submit queues job on the worker of userID
+// Blocks while that worker's queue is full, which slows down update polling instead of dropping updates
+// Returns false if the dispatcher is closed and the job was not queued
+func (d *Dispatcher) Submit(userID int64, job func()) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return false
+	}
+	d.queues[d.shard(userID)] <- job
+	return true
+}
+
+// Close stops accepting jobs and waits until every queued job has run
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, queue := range d.queues {
+			close(queue)
+		}
+	}
+	d.mu.Unlock()
+
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) shard(userID int64) int {
+	shard := userID % int64(len(d.queues))
+	if shard < 0 {
+		shard = -shard
+	}
+	return int(shard)
+}
@@ -0,0 +1,136 @@
+package bot
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcherKeepsUserOrder(t *testing.T) {
+	d := NewDispatcher(3, 2)
+	users := []int64{1, 2, 3, 4, -5}
+	const jobs = 50
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	got := make(map[int64][]int)
+	for i := 0; i < jobs; i++ {
+		for _, userID := range users {
+			wg.Add(1)
+			d.Submit(userID, func() {
+				defer wg.Done()
+				mu.Lock()
+				got[userID] = append(got[userID], i)
+				mu.Unlock()
+			})
+		}
+	}
+	wg.Wait()
+
+	for _, userID := range users {
+		if len(got[userID]) != jobs || !slices.IsSorted(got[userID]) {
+			t.Errorf("jobs of user %d ran in order %v, want 0..%d", userID, got[userID], jobs-1)
+		}
+	}
+}
+
+func TestDispatcherRunsUsersInParallel(t *testing.T) {
+	d := NewDispatcher(2, 1)
+	if d.shard(1) == d.shard(2) {
+		t.Fatal("users 1 and 2 share a worker")
+	}
+
+	// User 1's job waits for user 2's job, which only works when they run at the same time
+	release := make(chan struct{})
+	done := make(chan struct{})
+	d.Submit(1, func() {
+		<-release
+		close(done)
+	})
+	d.Submit(2, func() { close(release) })
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a blocked user held up another user's job")
+	}
+}
+
+func TestDispatcherShard(t *testing.T) {
+	d := NewDispatcher(0, 1) // Falls back to one worker
+	if len(d.queues) != 1 {
+		t.Fatalf("NewDispatcher(0, 1) has %d workers, want 1", len(d.queues))
+	}
+
+	d = NewDispatcher(4, 1)
+	for _, userID := range []int64{0, 1, 7, -7, 1 << 40, -1 << 62} {
+		shard := d.shard(userID)
+		if shard < 0 || shard >= 4 {
+			t.Errorf("shard(%d) = %d, want 0..3", userID, shard)
+		}
+		if again := d.shard(userID); again != shard {
+			t.Errorf("shard(%d) changed from %d to %d", userID, shard, again)
+		}
+	}
+}
+
+func TestDispatcherClose(t *testing.T) {
+	d := NewDispatcher(2, 10)
+
+	// Jobs queued behind a slow one still run before Close returns
+	var ran []int
+	release := make(chan struct{})
+	d.Submit(1, func() { <-release })
+	for i := 0; i < 5; i++ {
+		if !d.Submit(1, func() { ran = append(ran, i) }) {
+			t.Fatalf("Submit() of job %d = false before Close", i)
+		}
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		d.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close() returned while a job was still running")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() did not return after the jobs finished")
+	}
+	if !slices.Equal(ran, []int{0, 1, 2, 3, 4}) {
+		t.Errorf("queued jobs ran %v, want [0 1 2 3 4]", ran)
+	}
+
+	if d.Submit(1, func() { t.Error("job submitted after Close ran") }) {
+		t.Error("Submit() after Close = true, want false")
+	}
+	d.Close() // Closing twice is safe
+}
+
+func TestShutdownDrainsUpdates(t *testing.T) {
+	const telegramID = 42
+	h, repos := newTestHandler(t)
+	h.HandleUpdate(commandUpdate(telegramID, "/start_test"))
+
+	if err := h.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error: %v", err)
+	}
+	if h.getActiveSession(telegramID) == nil {
+		t.Fatal("update queued before Shutdown was not processed")
+	}
+
+	// Updates after shutdown are dropped
+	h.HandleUpdate(commandUpdate(telegramID+1, "/start_test"))
+	if user, _ := repos.Users.GetByTelegramID(telegramID + 1); user != nil {
+		t.Error("update received after Shutdown was processed")
+	}
+}
@@ -0,0 +1,477 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/andru_bot/tg-bot/database"
+	"github.com/andru_bot/tg-bot/models"
+	"github.com/andru_bot/tg-bot/scoring"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// newTestBot returns a bot whose Telegram API accepts every request and answers with a sent message
+func newTestBot(t *testing.T) *tgbotapi.BotAPI {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true,"result":{"message_id":5,"chat":{"id":42}}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("token", srv.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatalf("NewBotAPIWithAPIEndpoint() error: %v", err)
+	}
+	return bot
+}
+
+// commandUpdate returns the update of a user sending a bot command such as "/start_test"
+func commandUpdate(telegramID int64, command string) tgbotapi.Update {
+	return tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: telegramID, UserName: "alice"},
+		Chat:      &tgbotapi.Chat{ID: telegramID},
+		Text:      command,
+		Entities:  []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}},
+	}}
+}
+
+// callbackUpdate returns the update of a user pressing a button with the given data
+func callbackUpdate(telegramID int64, data string) tgbotapi.Update {
+	return tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "1",
+		From:    &tgbotapi.User{ID: telegramID},
+		Message: &tgbotapi.Message{MessageID: 5, Chat: &tgbotapi.Chat{ID: telegramID}},
+		Data:    data,
+	}}
+}
+
+// onWorker runs f on the user's worker and waits for it, after every update queued before
+func onWorker(h *BotHandler, telegramID int64, f func()) {
+	done := make(chan struct{})
+	h.dispatcher.Submit(telegramID, func() {
+		defer close(done)
+		f()
+	})
+	<-done
+}
+
+// handle processes the update on its user's worker and waits until it is done
+func handle(h *BotHandler, update tgbotapi.Update) {
+	h.HandleUpdate(update)
+	onWorker(h, update.SentFrom().ID, func() {})
+}
+
+// answerUpdate returns the update of a user pressing the button of answerID for their current question
+func answerUpdate(h *BotHandler, telegramID int64, answerID int) tgbotapi.Update {
+	var payload answerPayload
+	onWorker(h, telegramID, func() {
+		session := h.getActiveSession(telegramID)
+		questionID := session.QuestionIDs[session.CurrentIdx]
+		order := models.OptionOrder(session.OptionOrders, questionID, 3)
+		payload = answerPayload{
+			SessionID:   session.SessionID,
+			QuestionIdx: session.CurrentIdx,
+			Position:    slices.Index(order, answerID) + 1,
+		}
+	})
+	return callbackUpdate(telegramID, h.encodeAnswerPayload(payload))
+}
+
+// newTestHandler returns a handler on the memory backend with three 2-point questions whose answer 2 is correct
+func newTestHandler(t *testing.T) (*BotHandler, *database.Repositories) {
+	t.Helper()
+	repos := database.NewMemoryRepositories()
+	h := NewBotHandler(newTestBot(t), repos, t.TempDir()+"/results.csv")
+	for _, text := range []string{"She ___ to school.", "They ___ at home.", "I ___ a student."} {
+		question := &models.Question{Text: text, Options: []string{"go", "goes", "going"}, CorrectAnswerID: 2, Score: 2}
+		if err := repos.Questions.Create(question); err != nil {
+			t.Fatalf("Create() question error: %v", err)
+		}
+	}
+	return h, repos
+}
+
+// startTestSession starts a test for the user with /start_test and returns the stored session
+func startTestSession(t *testing.T, h *BotHandler, repos *database.Repositories, telegramID int64) *models.Session {
+	t.Helper()
+	handle(h, commandUpdate(telegramID, "/start_test"))
+	user, err := repos.Users.GetByTelegramID(telegramID)
+	if err != nil {
+		t.Fatalf("GetByTelegramID() error: %v", err)
+	}
+	session, err := repos.Sessions.GetActiveByUserID(user.ID)
+	if err != nil || session == nil {
+		t.Fatalf("GetActiveByUserID() = %v, %v, want the started session", session, err)
+	}
+	return session
+}
+
+func TestTestFlow(t *testing.T) {
+	const telegramID = 42
+
+	tests := []struct {
+		name      string
+		correct   []bool // Whether each question is answered right, in the order asked
+		wantScore float64
+	}{
+		{"all correct", []bool{true, true, true}, 6},
+		{"one wrong", []bool{true, false, true}, 4},
+		{"all wrong", []bool{false, false, false}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, repos := newTestHandler(t)
+			session := startTestSession(t, h, repos, telegramID)
+			if len(session.QuestionIDs) != len(tt.correct) {
+				t.Fatalf("session has %d questions, want %d", len(session.QuestionIDs), len(tt.correct))
+			}
+
+			for _, correct := range tt.correct {
+				answerID := 2
+				if !correct {
+					answerID = 3
+				}
+				handle(h, answerUpdate(h, telegramID, answerID))
+			}
+
+			h.notifications.Wait()
+
+			if h.getActiveSession(telegramID) != nil {
+				t.Error("session still active after the last answer")
+			}
+			stored, err := repos.Sessions.GetByID(session.ID)
+			if err != nil {
+				t.Fatalf("GetByID() error: %v", err)
+			}
+			if stored.Status != models.StatusCompleted || stored.FinishedAt == nil {
+				t.Errorf("session status = %q, finished at %v, want completed", stored.Status, stored.FinishedAt)
+			}
+			if stored.TotalScore != tt.wantScore {
+				t.Errorf("session score = %v, want %v", stored.TotalScore, tt.wantScore)
+			}
+
+			answers, err := repos.Answers.GetBySession(session.ID)
+			if err != nil {
+				t.Fatalf("GetBySession() error: %v", err)
+			}
+			if len(answers) != len(tt.correct) {
+				t.Fatalf("stored %d answers, want %d", len(answers), len(tt.correct))
+			}
+			for i, answer := range answers {
+				if answer.QuestionID != session.QuestionIDs[i] || answer.IsCorrect != tt.correct[i] {
+					t.Errorf("answer %d = question %v correct %v, want question %v correct %v",
+						i, answer.QuestionID, answer.IsCorrect, session.QuestionIDs[i], tt.correct[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStaleAnswerIgnored(t *testing.T) {
+	const telegramID = 42
+	h, repos := newTestHandler(t)
+	session := startTestSession(t, h, repos, telegramID)
+
+	first := answerUpdate(h, telegramID, 2)
+	handle(h, first)
+	// A second press on the first question's keyboard, and a forged bare position
+	handle(h, first)
+	handle(h, callbackUpdate(telegramID, "2"))
+
+	answers, err := repos.Answers.GetBySession(session.ID)
+	if err != nil {
+		t.Fatalf("GetBySession() error: %v", err)
+	}
+	if len(answers) != 1 {
+		t.Errorf("stored %d answers, want 1", len(answers))
+	}
+	onWorker(h, telegramID, func() {
+		if got := h.getActiveSession(telegramID).CurrentIdx; got != 1 {
+			t.Errorf("current question = %d, want 1", got)
+		}
+	})
+}
+
+func TestQuestionEditedDuringTest(t *testing.T) {
+	const telegramID = 42
+	h, repos := newTestHandler(t)
+	session := startTestSession(t, h, repos, telegramID)
+
+	// The bank changes the right answer while the test runs; the session grades the question as it was asked
+	for _, questionID := range session.QuestionIDs {
+		question, err := repos.Questions.GetByID(questionID)
+		if err != nil {
+			t.Fatalf("GetByID() error: %v", err)
+		}
+		question.CorrectAnswerID = 3
+		if err := repos.Questions.Update(question); err != nil {
+			t.Fatalf("Update() error: %v", err)
+		}
+	}
+	for range session.QuestionIDs {
+		handle(h, answerUpdate(h, telegramID, 2))
+	}
+	h.notifications.Wait()
+
+	stored, err := repos.Sessions.GetByID(session.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error: %v", err)
+	}
+	if stored.TotalScore != 6 {
+		t.Errorf("session score = %v, want 6 as graded by the snapshots", stored.TotalScore)
+	}
+	if len(stored.Questions) != 3 || stored.Questions[0].CorrectAnswerID != 2 {
+		t.Errorf("session snapshots = %+v, want the questions as asked", stored.Questions)
+	}
+}
+
+// textUpdate returns the update of a user sending a plain text message
+func textUpdate(telegramID int64, text string) tgbotapi.Update {
+	return tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: telegramID, UserName: "alice"},
+		Chat:      &tgbotapi.Chat{ID: telegramID},
+		Text:      text,
+	}}
+}
+
+func TestTypedAnswer(t *testing.T) {
+	const telegramID = 42
+
+	tests := []struct {
+		name        string
+		text        string
+		wantCorrect bool
+	}{
+		{"exact", "goes", true},
+		{"case and punctuation", " Goes! ", true},
+		{"other accepted answer", "walks", true},
+		{"typo within tolerance", "gose", true},
+		{"too many typos", "gxxs", false},
+		{"wrong", "go", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := database.NewMemoryRepositories()
+			h := NewBotHandler(newTestBot(t), repos, t.TempDir()+"/results.csv")
+			question := &models.Question{Text: "She ___ to school.", Type: models.QuestionTypeText, AcceptedAnswers: []string{"goes", "walks"}, Tolerance: 1, Score: 2}
+			if err := repos.Questions.Create(question); err != nil {
+				t.Fatalf("Create() question error: %v", err)
+			}
+			session := startTestSession(t, h, repos, telegramID)
+
+			// A blank message is no answer
+			handle(h, textUpdate(telegramID, " "))
+			handle(h, textUpdate(telegramID, tt.text))
+			h.notifications.Wait()
+
+			answers, err := repos.Answers.GetBySession(session.ID)
+			if err != nil {
+				t.Fatalf("GetBySession() error: %v", err)
+			}
+			if len(answers) != 1 {
+				t.Fatalf("stored %d answers, want 1", len(answers))
+			}
+			if answers[0].TextAnswer != tt.text || answers[0].IsCorrect != tt.wantCorrect {
+				t.Errorf("answer = %q correct %v, want %q correct %v", answers[0].TextAnswer, answers[0].IsCorrect, tt.text, tt.wantCorrect)
+			}
+			stored, err := repos.Sessions.GetByID(session.ID)
+			if err != nil {
+				t.Fatalf("GetByID() error: %v", err)
+			}
+			if stored.Status != models.StatusCompleted {
+				t.Errorf("session status = %q, want completed", stored.Status)
+			}
+		})
+	}
+}
+
+func TestTypedAnswerToChoiceQuestion(t *testing.T) {
+	const telegramID = 42
+	h, repos := newTestHandler(t)
+	session := startTestSession(t, h, repos, telegramID)
+
+	handle(h, textUpdate(telegramID, "goes"))
+
+	answers, err := repos.Answers.GetBySession(session.ID)
+	if err != nil {
+		t.Fatalf("GetBySession() error: %v", err)
+	}
+	if len(answers) != 0 {
+		t.Errorf("stored %d answers for a message to a choice question, want 0", len(answers))
+	}
+}
+
+// selectionUpdate returns the update of a user pressing the button of kind (toggle or submit) of their current
+// question, with the options of answerIDs selected
+func selectionUpdate(h *BotHandler, telegramID int64, kind string, answerIDs ...int) tgbotapi.Update {
+	var payload answerPayload
+	onWorker(h, telegramID, func() {
+		session := h.getActiveSession(telegramID)
+		questionID := session.QuestionIDs[session.CurrentIdx]
+		order := models.OptionOrder(session.OptionOrders, questionID, 4)
+		payload = answerPayload{Kind: kind, SessionID: session.SessionID, QuestionIdx: session.CurrentIdx}
+		for _, answerID := range answerIDs {
+			payload.Selection |= 1 << slices.Index(order, answerID)
+		}
+	})
+	return callbackUpdate(telegramID, h.encodeAnswerPayload(payload))
+}
+
+func TestMultipleChoice(t *testing.T) {
+	const telegramID = 42
+
+	tests := []struct {
+		name        string
+		scheme      string
+		selected    []int
+		wantCredit  float64
+		wantCorrect bool
+	}{
+		{"exact set", "", []int{1, 3}, 1, true},
+		{"one missing, all or nothing", "", []int{1}, 0, false},
+		{"one missing, right minus wrong", scoring.PartialCreditRightMinusWrong, []int{1}, 0.5, false},
+		{"one wrong, per option", scoring.PartialCreditPerOption, []int{1, 2, 3}, 0.75, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := database.NewMemoryRepositories()
+			h := NewBotHandler(newTestBot(t), repos, t.TempDir()+"/results.csv")
+			h.defaultTest.PartialCredit = tt.scheme
+			question := &models.Question{Text: "Which are verbs?", Type: models.QuestionTypeMultiple,
+				Options: []string{"run", "blue", "eat", "table"}, CorrectAnswerIDs: []int{1, 3}, Score: 4}
+			if err := repos.Questions.Create(question); err != nil {
+				t.Fatalf("Create() question error: %v", err)
+			}
+			session := startTestSession(t, h, repos, telegramID)
+
+			// Toggling options changes the keyboard only; submitting nothing is refused
+			handle(h, selectionUpdate(h, telegramID, togglePayloadKind, tt.selected[0]))
+			handle(h, selectionUpdate(h, telegramID, submitPayloadKind))
+			if answers, _ := repos.Answers.GetBySession(session.ID); len(answers) != 0 {
+				t.Fatalf("stored %d answers before the submit, want 0", len(answers))
+			}
+
+			handle(h, selectionUpdate(h, telegramID, submitPayloadKind, tt.selected...))
+			h.notifications.Wait()
+
+			answers, err := repos.Answers.GetBySession(session.ID)
+			if err != nil {
+				t.Fatalf("GetBySession() error: %v", err)
+			}
+			if len(answers) != 1 {
+				t.Fatalf("stored %d answers, want 1", len(answers))
+			}
+			answer := answers[0]
+			if !slices.Equal(answer.SelectedAnswerIDs, tt.selected) || answer.Credit != tt.wantCredit || answer.IsCorrect != tt.wantCorrect {
+				t.Errorf("answer = selected %v credit %v correct %v, want %v, %v, %v",
+					answer.SelectedAnswerIDs, answer.Credit, answer.IsCorrect, tt.selected, tt.wantCredit, tt.wantCorrect)
+			}
+			stored, err := repos.Sessions.GetByID(session.ID)
+			if err != nil {
+				t.Fatalf("GetByID() error: %v", err)
+			}
+			if want := 4 * tt.wantCredit; stored.TotalScore != want {
+				t.Errorf("session score = %v, want %v", stored.TotalScore, want)
+			}
+		})
+	}
+}
+
+// sequenceUpdate returns the update of a user pressing the button of kind (build or finish) of their current
+// order or match question, with the options of answerIDs picked in this order
+func sequenceUpdate(h *BotHandler, telegramID int64, kind string, answerIDs ...int) tgbotapi.Update {
+	var payload answerPayload
+	onWorker(h, telegramID, func() {
+		session := h.getActiveSession(telegramID)
+		questionID := session.QuestionIDs[session.CurrentIdx]
+		order := models.OptionOrder(session.OptionOrders, questionID, 4)
+		payload = answerPayload{Kind: kind, SessionID: session.SessionID, QuestionIdx: session.CurrentIdx, Sequence: []int{}}
+		for _, answerID := range answerIDs {
+			payload.Sequence = append(payload.Sequence, slices.Index(order, answerID)+1)
+		}
+	})
+	return callbackUpdate(telegramID, h.encodeAnswerPayload(payload))
+}
+
+func TestSequenceQuestions(t *testing.T) {
+	const telegramID = 42
+	order := &models.Question{Text: "Put the words in order.", Type: models.QuestionTypeOrder,
+		Options: []string{"she", "is", "at", "home"}, Score: 4}
+	match := &models.Question{Text: "Match the animals to their sounds.", Type: models.QuestionTypeMatch,
+		Options: []string{"cat", "dog", "cow", "duck"}, Matches: []string{"meow", "woof", "moo", "quack"}, Score: 4}
+
+	tests := []struct {
+		name        string
+		question    *models.Question
+		scheme      string
+		sequence    []int
+		wantCredit  float64
+		wantCorrect bool
+	}{
+		{"order right", order, "", []int{1, 2, 3, 4}, 1, true},
+		{"order wrong", order, "", []int{2, 1, 3, 4}, 0, false},
+		{"order half right", order, scoring.PartialCreditPerOption, []int{2, 1, 3, 4}, 0.5, false},
+		{"match right", match, "", []int{1, 2, 3, 4}, 1, true},
+		{"match one pair swapped", match, scoring.PartialCreditPerOption, []int{1, 2, 4, 3}, 0.5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := database.NewMemoryRepositories()
+			h := NewBotHandler(newTestBot(t), repos, t.TempDir()+"/results.csv")
+			h.defaultTest.PartialCredit = tt.scheme
+			question := *tt.question
+			if err := repos.Questions.Create(&question); err != nil {
+				t.Fatalf("Create() question error: %v", err)
+			}
+			session := startTestSession(t, h, repos, telegramID)
+
+			// Building the sequence only redraws the question; submitting it unfinished is refused
+			handle(h, sequenceUpdate(h, telegramID, buildPayloadKind, tt.sequence[:2]...))
+			handle(h, sequenceUpdate(h, telegramID, finishPayloadKind, tt.sequence[:3]...))
+			if answers, _ := repos.Answers.GetBySession(session.ID); len(answers) != 0 {
+				t.Fatalf("stored %d answers before the sequence was finished, want 0", len(answers))
+			}
+
+			handle(h, sequenceUpdate(h, telegramID, finishPayloadKind, tt.sequence...))
+			h.notifications.Wait()
+
+			answers, err := repos.Answers.GetBySession(session.ID)
+			if err != nil {
+				t.Fatalf("GetBySession() error: %v", err)
+			}
+			if len(answers) != 1 {
+				t.Fatalf("stored %d answers, want 1", len(answers))
+			}
+			answer := answers[0]
+			if !slices.Equal(answer.SelectedAnswerIDs, tt.sequence) || answer.Credit != tt.wantCredit || answer.IsCorrect != tt.wantCorrect {
+				t.Errorf("answer = sequence %v credit %v correct %v, want %v, %v, %v",
+					answer.SelectedAnswerIDs, answer.Credit, answer.IsCorrect, tt.sequence, tt.wantCredit, tt.wantCorrect)
+			}
+		})
+	}
+}
+
+func TestValidSequence(t *testing.T) {
+	tests := []struct {
+		sequence []int
+		want     bool
+	}{
+		{nil, true},
+		{[]int{3, 1}, true},
+		{[]int{1, 2, 3, 4}, true},
+		{[]int{1, 1}, false},
+		{[]int{0}, false},
+		{[]int{5}, false},
+		{[]int{1, 2, 3, 4, 1}, false},
+	}
+	for _, tt := range tests {
+		if got := validSequence(tt.sequence, 4); got != tt.want {
+			t.Errorf("validSequence(%v, 4) = %v, want %v", tt.sequence, got, tt.want)
+		}
+	}
+}
@@ -1,44 +1,137 @@
 package bot
 
 import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andru_bot/tg-bot/adaptive"
+	"github.com/andru_bot/tg-bot/bank"
 	"github.com/andru_bot/tg-bot/config"
 	"github.com/andru_bot/tg-bot/database"
+	"github.com/andru_bot/tg-bot/media"
 	"github.com/andru_bot/tg-bot/models"
+	"github.com/andru_bot/tg-bot/termination"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type BotHandler struct {
-	bot                  *tgbotapi.BotAPI
-	userRepo             *database.UserRepository
-	sessionRepo          *database.SessionRepository
-	questionRepo         *database.QuestionRepository
-	answerRepo           *database.AnswerRepository
-	activeSessions       map[int64]*ActiveSession
-	questions            []models.Question
-	resultsCSVPath       string
-	maxConsecutiveErrors int
+	bot                *tgbotapi.BotAPI
+	userRepo           database.UserRepository
+	sessionRepo        database.SessionRepository
+	questionRepo       database.QuestionRepository
+	answerRepo         database.AnswerRepository
+	testRepo           database.TestRepository
+	mediaRepo          database.MediaRepository
+	mediaDir           media.Dir // Audio and image files of the questions
+	bank               *bank.Service
+	dispatcher         *Dispatcher  // Runs all work of a user on one worker, see Dispatcher
+	sessionsMu         sync.RWMutex // Guards the activeSessions map; each session is only used by its user's worker
+	activeSessions     map[int64]*ActiveSession
+	notifications      sync.WaitGroup // Admin notifications in flight
+	notificationSlots  chan struct{}  // Bounds concurrent admin notifications
+	conversationsMu    sync.Mutex     // Guards the conversations map
+	conversations      map[int64]*adminConversation
+	questions          []models.Question
+	resultsCSVPath     string
+	defaultTest        models.Test // Test built from the environment, used by sessions started before the test catalog existed
+	levelCutScores     map[string]float64
+	adaptiveConfig     adaptive.Config
+	callbackKey        []byte        // HMAC key of answer button payloads
+	sessionIdleTimeout time.Duration // 0 means sessions never expire
+	sweeperStop        chan struct{} // Closed to stop the idle session sweeper
+	sweeperStopOnce    sync.Once     // Shutdown may run more than once
+	sweeperDone        chan struct{} // Closed when the sweeper has stopped, nil if it never started
 }
 
 type ActiveSession struct {
 	SessionID         primitive.ObjectID
 	UserID            primitive.ObjectID
+	Test              models.Test          // Settings of the test the session belongs to
+	policies          termination.Policies // Parsed Test.TerminationPolicy
 	QuestionIDs       []primitive.ObjectID
+	Questions         []models.Question // Snapshots of the questions as asked, see models.Session.Questions
 	CurrentIdx        int
-	Score             int
-	ConsecutiveErrors int // Track consecutive incorrect answers
+	Score             float64
+	Mode              string
+	TotalQuestions    int     // Number of questions (maximum length for adaptive tests)
+	Ability           float64 // Current ability estimate (adaptive tests)
+	AbilitySE         float64 // Standard error of the ability estimate (adaptive tests)
+	Seed              int64
+	OptionOrders      map[string][]int // Question ID (hex) -> answer IDs in displayed order
+	ChatID            int64
+	ExpiresAt         *time.Time  // End of the whole-test time limit
+	QuestionDeadline  *time.Time  // End of the time limit of the current question
+	QuestionMessageID int         // Message with the current question's buttons, 0 if unknown
+	timer             *time.Timer // Fires at the nearest deadline
+}
+
+// activeSessionFromDB builds the in-memory state of a session loaded from the database
+func (h *BotHandler) activeSessionFromDB(dbSession *models.Session) *ActiveSession {
+	session := &ActiveSession{
+		SessionID:         dbSession.ID,
+		UserID:            dbSession.UserID,
+		Test:              h.sessionTest(dbSession),
+		QuestionIDs:       dbSession.QuestionIDs,
+		Questions:         dbSession.Questions,
+		CurrentIdx:        dbSession.CurrentIdx,
+		Score:             dbSession.TotalScore,
+		Mode:              dbSession.Mode,
+		TotalQuestions:    dbSession.TotalQuestions,
+		Seed:              dbSession.Seed,
+		OptionOrders:      dbSession.OptionOrders,
+		ChatID:            dbSession.ChatID,
+		ExpiresAt:         dbSession.ExpiresAt,
+		QuestionDeadline:  dbSession.QuestionDeadline,
+		QuestionMessageID: dbSession.QuestionMessageID,
+	}
+	if session.Mode == "" {
+		// Sessions created before test modes existed
+		session.Mode = models.ModeLinear
+	}
+	session.policies = h.testPolicies(&session.Test)
+	if dbSession.Ability != nil && dbSession.AbilitySE != nil {
+		session.Ability, session.AbilitySE = *dbSession.Ability, *dbSession.AbilitySE
+	} else {
+		// Prior estimate before any answer
+		session.Ability, session.AbilitySE = adaptive.Estimate(nil)
+	}
+	return session
 }
 
-func NewBotHandler(bot *tgbotapi.BotAPI, resultsCSVPath string) *BotHandler {
+// updateQueueSize is the number of pending jobs per worker before update polling is slowed down
+const updateQueueSize = 64
+
+func NewBotHandler(bot *tgbotapi.BotAPI, repos *database.Repositories, resultsCSVPath string) *BotHandler {
 	return &BotHandler{
-		bot:                  bot,
-		userRepo:             database.NewUserRepository(),
-		sessionRepo:          database.NewSessionRepository(),
-		questionRepo:         database.NewQuestionRepository(),
-		answerRepo:           database.NewAnswerRepository(),
-		activeSessions:       make(map[int64]*ActiveSession),
-		resultsCSVPath:       resultsCSVPath,
-		maxConsecutiveErrors: config.GetMaxConsecutiveErrors(),
+		bot:               bot,
+		userRepo:          repos.Users,
+		sessionRepo:       repos.Sessions,
+		questionRepo:      repos.Questions,
+		answerRepo:        repos.Answers,
+		testRepo:          repos.Tests,
+		mediaRepo:         repos.Media,
+		mediaDir:          media.Dir(config.GetMediaDir()),
+		bank:              bank.NewService(repos.Questions, repos.Sessions),
+		dispatcher:        NewDispatcher(config.GetWorkerCount(), updateQueueSize),
+		activeSessions:    make(map[int64]*ActiveSession),
+		notificationSlots: make(chan struct{}, config.GetWorkerCount()),
+		conversations:     make(map[int64]*adminConversation),
+		resultsCSVPath:    resultsCSVPath,
+		defaultTest:       config.GetDefaultTest(),
+		levelCutScores:    config.GetLevelCutScores(),
+		adaptiveConfig: adaptive.Config{
+			MinQuestions: config.GetAdaptiveMinQuestions(),
+			MaxQuestions: config.GetAdaptiveMaxQuestions(),
+			TargetSE:     config.GetAdaptiveTargetSE(),
+		},
+		callbackKey:        deriveCallbackKey(config.GetCallbackSecret(), bot.Token),
+		sessionIdleTimeout: config.GetSessionIdleTimeout(),
+		sweeperStop:        make(chan struct{}),
 	}
 }
 
@@ -46,29 +139,225 @@ func (h *BotHandler) LoadQuestions(questions []models.Question) {
 	h.questions = questions
 }
 
+// LoadActiveSessions restores the deadline timers of in-progress sessions with time limits
+// Other sessions are loaded on-demand when the user interacts
 func (h *BotHandler) LoadActiveSessions() error {
-	_, err := h.sessionRepo.GetAllActive()
+	sessions, err := h.sessionRepo.GetAllActive()
 	if err != nil {
 		return err
 	}
-	// Sessions are loaded on-demand when user interacts
+
+	restored := 0
+	for i := range sessions {
+		dbSession := &sessions[i]
+		if dbSession.ExpiresAt == nil && dbSession.QuestionDeadline == nil {
+			continue
+		}
+		user, err := h.userRepo.GetByID(dbSession.UserID)
+		if err != nil {
+			log.Printf("Error getting user of session %s: %v", dbSession.ID.Hex(), err)
+			continue
+		}
+		h.loadActiveSession(user.TelegramID, dbSession)
+		restored++
+	}
+	if restored > 0 {
+		log.Printf("Restored deadline timers of %d active sessions", restored)
+	}
 	return nil
 }
 
+// resumeSession returns the user's active session, loading it from the database when it is not
+// in memory (e.g. after a restart). Returns nil if the user has no test in progress
+func (h *BotHandler) resumeSession(from *tgbotapi.User) (*ActiveSession, error) {
+	if session := h.getActiveSession(from.ID); session != nil {
+		return session, nil
+	}
+
+	user, err := h.userRepo.FindOrCreate(from.ID, from.UserName, from.FirstName, from.LastName)
+	if err != nil {
+		return nil, err
+	}
+	return h.loadStoredSession(from.ID, user)
+}
+
+// loadStoredSession loads the user's in-progress session from the database, nil if there is none
+func (h *BotHandler) loadStoredSession(userID int64, user *models.User) (*ActiveSession, error) {
+	dbSession, err := h.sessionRepo.GetActiveByUserID(user.ID)
+	if err != nil || dbSession == nil {
+		return nil, err
+	}
+	return h.loadActiveSession(userID, dbSession), nil
+}
+
+// loadActiveSession makes a session from the database the user's active session and arms its deadline timer
+// Progress that was not stored on the session is rebuilt from its answers, see restoreProgress
+func (h *BotHandler) loadActiveSession(userID int64, dbSession *models.Session) *ActiveSession {
+	session := h.activeSessionFromDB(dbSession)
+	if session.ChatID == 0 {
+		// Sessions created before the chat was stored; private chat IDs equal user IDs
+		session.ChatID = userID
+	}
+
+	answers, err := h.getSessionAnswers(session.SessionID)
+	if err != nil {
+		log.Printf("Error getting answers of session %s: %v", session.SessionID.Hex(), err)
+	} else if restoreProgress(session, answers) {
+		// The last answer was saved but the session was not moved on
+		err = h.sessionRepo.UpdateProgress(session.SessionID, session.CurrentIdx, session.Score)
+		if err != nil {
+			log.Printf("Error updating session progress: %v", err)
+		}
+		if session.Mode == models.ModeAdaptive {
+			h.updateAbility(session)
+		}
+	}
+
+	h.activateSession(userID, session)
+	return session
+}
+
+// activateSession makes session the user's active session and arms its deadline timer
+func (h *BotHandler) activateSession(userID int64, session *ActiveSession) {
+	h.removeActiveSession(userID)
+	h.sessionsMu.Lock()
+	h.activeSessions[userID] = session
+	h.sessionsMu.Unlock()
+	h.armTimer(userID, session)
+}
+
+// getSessionAnswers returns the answers of a session in the order they were given
+// Termination policies such as the consecutive error streak depend on this order
+func (h *BotHandler) getSessionAnswers(sessionID primitive.ObjectID) ([]models.Answer, error) {
+	answers, err := h.answerRepo.GetBySession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(answers, func(i, j int) bool {
+		return answers[i].AnsweredAt.Before(answers[j].AnsweredAt)
+	})
+	return answers, nil
+}
+
+// restoreProgress catches a loaded session up with its answers
+// If answers exist beyond the stored position (the process stopped between saving an answer and
+// moving on), the position and score are taken from the answers and true is returned
+func restoreProgress(session *ActiveSession, answers []models.Answer) bool {
+	answered := make(map[primitive.ObjectID]bool, len(answers))
+	score := 0.0
+	for _, answer := range answers {
+		answered[answer.QuestionID] = true
+		score += answer.Score
+	}
+
+	idx := 0
+	for idx < len(session.QuestionIDs) && answered[session.QuestionIDs[idx]] {
+		idx++
+	}
+	if idx <= session.CurrentIdx {
+		return false
+	}
+
+	session.CurrentIdx = idx
+	session.Score = score
+	session.QuestionDeadline = nil
+	session.QuestionMessageID = 0
+	return true
+}
+
+// ActiveSessionCount returns the number of sessions currently held in memory
+func (h *BotHandler) ActiveSessionCount() int {
+	h.sessionsMu.RLock()
+	defer h.sessionsMu.RUnlock()
+	return len(h.activeSessions)
+}
+
+// getActiveSession returns the user's active session held in memory, nil if there is none
+func (h *BotHandler) getActiveSession(userID int64) *ActiveSession {
+	h.sessionsMu.RLock()
+	defer h.sessionsMu.RUnlock()
+	return h.activeSessions[userID]
+}
+
+// removeActiveSession forgets the user's active session and stops its deadline timer
+func (h *BotHandler) removeActiveSession(userID int64) {
+	h.sessionsMu.Lock()
+	session := h.activeSessions[userID]
+	delete(h.activeSessions, userID)
+	h.sessionsMu.Unlock()
+
+	if session != nil && session.timer != nil {
+		session.timer.Stop()
+	}
+}
+
+// HandleUpdate queues an update on the worker of the user who sent it
+// Updates of one user are handled in order, updates of different users concurrently
 func (h *BotHandler) HandleUpdate(update tgbotapi.Update) {
+	var userID int64
+	if from := update.SentFrom(); from != nil {
+		userID = from.ID
+	}
+	queued := h.dispatcher.Submit(userID, func() {
+		h.handleUpdate(update)
+	})
+	if !queued {
+		log.Printf("Dropping update %d received during shutdown", update.UpdateID)
+	}
+}
+
+// Shutdown stops the idle session sweeper, stops taking new work and waits until queued updates
+// and admin notifications are done
+// Deadline timers are stopped afterwards; deadlines are stored with the sessions and restored on the next start
+// Returns ctx.Err() if ctx ends first
+func (h *BotHandler) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.stopSessionSweeper()
+		h.dispatcher.Close()
+
+		// No worker runs any more, so the sessions can be read safely
+		h.sessionsMu.RLock()
+		for _, session := range h.activeSessions {
+			if session.timer != nil {
+				session.timer.Stop()
+			}
+		}
+		h.sessionsMu.RUnlock()
+
+		h.notifications.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *BotHandler) handleUpdate(update tgbotapi.Update) {
 	// Handle callback queries first (button clicks)
 	if update.CallbackQuery != nil {
+		if strings.HasPrefix(update.CallbackQuery.Data, adminCallbackPrefix) {
+			h.handleAdminCallback(update.CallbackQuery)
+			return
+		}
+		if strings.HasPrefix(update.CallbackQuery.Data, catalogCallbackPrefix) {
+			h.handleCatalogCallback(update.CallbackQuery)
+			return
+		}
 		h.handleCallbackQuery(update.CallbackQuery)
 		return
 	}
 
 	// Handle regular messages
-	if update.Message == nil {
+	if update.Message == nil || update.Message.From == nil {
 		return
 	}
 
 	msg := update.Message
-	userID := msg.From.ID
 
 	// Handle commands
 	if msg.IsCommand() {
@@ -76,14 +365,23 @@ func (h *BotHandler) HandleUpdate(update tgbotapi.Update) {
 		return
 	}
 
+	// Question files uploaded by admins
+	if h.handleAdminDocument(msg) {
+		return
+	}
+
 	// Handle menu button clicks
 	if h.handleMenuButton(msg) {
 		return
 	}
 
+	// Answers of an admin adding or editing a question
+	if h.handleAdminInput(msg) {
+		return
+	}
+
 	// If user has active session, they might be trying to answer
-	if _, exists := h.activeSessions[userID]; exists {
-		h.sendMessage(msg.Chat.ID, "Please select an answer using the buttons below the question.")
+	if h.handleTextAnswer(msg) {
 		return
 	}
 
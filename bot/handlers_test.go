@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRestoreProgress(t *testing.T) {
+	ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+	start := time.Now()
+	answer := func(i int, correct, timedOut bool) models.Answer {
+		score := 0.0
+		if correct {
+			score = 2
+		}
+		return models.Answer{QuestionID: ids[i], IsCorrect: correct, TimedOut: timedOut, Score: score, AnsweredAt: start.Add(time.Duration(i) * time.Second)}
+	}
+
+	tests := []struct {
+		name       string
+		currentIdx int
+		answers    []models.Answer
+		wantMoved  bool
+		wantIdx    int
+		wantScore  float64
+	}{
+		{"no answers", 0, nil, false, 0, 0},
+		{"up to date", 2, []models.Answer{answer(0, true, false), answer(1, false, false)}, false, 2, 0},
+		{"timed out answer counts", 1, []models.Answer{answer(0, false, true)}, false, 1, 0},
+		{"answer saved, session not moved on", 1, []models.Answer{answer(0, true, false), answer(1, true, false)}, true, 2, 4},
+		{"all answered", 2, []models.Answer{answer(0, true, false), answer(1, false, true), answer(2, true, false), answer(3, false, false)}, true, 4, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deadline := start.Add(time.Minute)
+			session := &ActiveSession{QuestionIDs: ids, CurrentIdx: tt.currentIdx, QuestionDeadline: &deadline, QuestionMessageID: 5}
+
+			if moved := restoreProgress(session, tt.answers); moved != tt.wantMoved {
+				t.Errorf("restoreProgress() = %v, want %v", moved, tt.wantMoved)
+			}
+			if session.CurrentIdx != tt.wantIdx {
+				t.Errorf("restored position %d, want %d", session.CurrentIdx, tt.wantIdx)
+			}
+			if tt.wantMoved && (session.Score != tt.wantScore || session.QuestionDeadline != nil || session.QuestionMessageID != 0) {
+				t.Errorf("moved session = score %v, deadline %v, message %d, want score %v and the question reset",
+					session.Score, session.QuestionDeadline, session.QuestionMessageID, tt.wantScore)
+			}
+		})
+	}
+}
+
+func TestResumeAfterRestart(t *testing.T) {
+	const telegramID = 42
+	h, repos := newTestHandler(t)
+	session := startTestSession(t, h, repos, telegramID)
+	handle(h, answerUpdate(h, telegramID, 2))
+
+	// A new process knows only the database
+	restarted := NewBotHandler(h.bot, repos, t.TempDir()+"/results.csv")
+	if err := restarted.LoadActiveSessions(); err != nil {
+		t.Fatalf("LoadActiveSessions() error: %v", err)
+	}
+	if restarted.getActiveSession(telegramID) != nil {
+		t.Fatal("session without deadlines loaded before the user came back")
+	}
+
+	// The second question's button, still shown in the chat, resumes the session
+	stored, err := repos.Sessions.GetByID(session.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error: %v", err)
+	}
+	order := models.OptionOrder(stored.OptionOrders, stored.QuestionIDs[1], 3)
+	handle(restarted, callbackUpdate(telegramID, restarted.encodeAnswerPayload(answerPayload{
+		SessionID:   session.ID,
+		QuestionIdx: 1,
+		Position:    slices.Index(order, 2) + 1,
+	})))
+	for range session.QuestionIDs[2:] {
+		handle(restarted, answerUpdate(restarted, telegramID, 2))
+	}
+	restarted.notifications.Wait()
+
+	if stored, err = repos.Sessions.GetByID(session.ID); err != nil {
+		t.Fatalf("GetByID() error: %v", err)
+	}
+	if stored.Status != models.StatusCompleted || stored.TotalScore != 6 {
+		t.Errorf("resumed session = %s with score %v, want completed with 6", stored.Status, stored.TotalScore)
+	}
+}
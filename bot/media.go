@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"log"
+	"time"
+
+	"github.com/andru_bot/tg-bot/media"
+	"github.com/andru_bot/tg-bot/models"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// sendQuestionMedia sends the image and then the recording of a question, ahead of its text and buttons
+// A file that can't be sent is logged and the candidate is told; the question is still asked
+func (h *BotHandler) sendQuestionMedia(chatID int64, question *models.Question) {
+	for _, name := range []string{question.Image, question.Audio} {
+		if name == "" {
+			continue
+		}
+		if err := h.sendMedia(chatID, name); err != nil {
+			log.Printf("Error sending media file %s: %v", name, err)
+			h.sendMessage(chatID, "⚠️ A file of this question could not be sent. Please tell the administrator.")
+		}
+	}
+}
+
+// sendMedia sends a file of the media directory
+// The Telegram file ID of the first upload is cached, so the file is only uploaded again once it changed
+func (h *BotHandler) sendMedia(chatID int64, name string) error {
+	info, err := h.mediaDir.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	cached, err := h.mediaRepo.Get(name)
+	if err != nil {
+		log.Printf("Error reading cached media file ID: %v", err)
+	}
+	if cached != nil && cached.Size == info.Size() && cached.ModTime == info.ModTime().UnixNano() {
+		_, err := h.bot.Send(mediaMessage(chatID, name, tgbotapi.FileID(cached.FileID)))
+		if err == nil {
+			return nil
+		}
+		// File IDs only work for the bot that uploaded the file, e.g. not after changing the token
+		log.Printf("Error sending cached media file %s, uploading it again: %v", name, err)
+	}
+
+	path, err := h.mediaDir.Path(name)
+	if err != nil {
+		return err
+	}
+	sent, err := h.bot.Send(mediaMessage(chatID, name, tgbotapi.FilePath(path)))
+	if err != nil {
+		return err
+	}
+	if fileID := sentFileID(sent); fileID != "" {
+		err = h.mediaRepo.Save(&models.MediaFile{
+			Path:       name,
+			FileID:     fileID,
+			Size:       info.Size(),
+			ModTime:    info.ModTime().UnixNano(),
+			UploadedAt: time.Now(),
+		})
+		if err != nil {
+			log.Printf("Error caching media file ID: %v", err)
+		}
+	}
+	return nil
+}
+
+// mediaMessage builds the request sending a media file the way its kind is shown
+func mediaMessage(chatID int64, name string, file tgbotapi.RequestFileData) tgbotapi.Chattable {
+	switch media.Kind(name) {
+	case media.KindPhoto:
+		return tgbotapi.NewPhoto(chatID, file)
+	case media.KindVoice:
+		return tgbotapi.NewVoice(chatID, file)
+	}
+	return tgbotapi.NewAudio(chatID, file)
+}
+
+// sentFileID returns the file ID of the media in a sent message, the largest size for photos
+// Telegram sends files it can't play as a voice message as a document
+func sentFileID(msg tgbotapi.Message) string {
+	switch {
+	case msg.Audio != nil:
+		return msg.Audio.FileID
+	case msg.Voice != nil:
+		return msg.Voice.FileID
+	case len(msg.Photo) > 0:
+		return msg.Photo[len(msg.Photo)-1].FileID
+	case msg.Document != nil:
+		return msg.Document.FileID
+	}
+	return ""
+}
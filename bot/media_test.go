@@ -0,0 +1,104 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andru_bot/tg-bot/database"
+	"github.com/andru_bot/tg-bot/media"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// mediaServer is a Telegram API that records how audio files are sent
+type mediaServer struct {
+	mu        sync.Mutex
+	uploads   int      // Audio files sent as uploads
+	fileIDs   []string // File IDs audio was sent with
+	rejectIDs bool     // Answer requests with a file ID with an error
+}
+
+func (s *mediaServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if !strings.HasSuffix(r.URL.Path, "/sendAudio") {
+		w.Write([]byte(`{"ok":true,"result":{"message_id":5,"chat":{"id":42}}}`))
+		return
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		s.uploads++
+	} else {
+		r.ParseForm()
+		s.fileIDs = append(s.fileIDs, r.Form.Get("audio"))
+		if s.rejectIDs {
+			w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: wrong file identifier"}`))
+			return
+		}
+	}
+	w.Write([]byte(`{"ok":true,"result":{"message_id":5,"chat":{"id":42},"audio":{"file_id":"audio-id","file_unique_id":"u1"}}}`))
+}
+
+func TestSendMediaCachesFileID(t *testing.T) {
+	server := &mediaServer{}
+	srv := httptest.NewServer(server)
+	t.Cleanup(srv.Close)
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("token", srv.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatalf("NewBotAPIWithAPIEndpoint() error: %v", err)
+	}
+	repos := database.NewMemoryRepositories()
+	h := NewBotHandler(bot, repos, t.TempDir()+"/results.csv")
+	dir := t.TempDir()
+	h.mediaDir = media.Dir(dir)
+	path := filepath.Join(dir, "station.mp3")
+	if err := os.WriteFile(path, []byte("first"), 0644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	send := func() {
+		t.Helper()
+		if err := h.sendMedia(42, "station.mp3"); err != nil {
+			t.Fatalf("sendMedia() error: %v", err)
+		}
+	}
+	check := func(step string, wantUploads, wantFileIDs int) {
+		t.Helper()
+		if server.uploads != wantUploads || len(server.fileIDs) != wantFileIDs {
+			t.Errorf("%s: %d uploads and %d sends by file ID, want %d and %d", step, server.uploads, len(server.fileIDs), wantUploads, wantFileIDs)
+		}
+	}
+
+	send()
+	check("first send", 1, 0)
+	cached, err := repos.Media.Get("station.mp3")
+	if err != nil || cached == nil || cached.FileID != "audio-id" || cached.Size != 5 {
+		t.Fatalf("cached media file = %+v, %v, want the uploaded file's ID", cached, err)
+	}
+
+	send()
+	check("second send", 1, 1)
+	if server.fileIDs[0] != "audio-id" {
+		t.Errorf("sent with file ID %q, want the cached one", server.fileIDs[0])
+	}
+
+	// A changed file is uploaded again
+	if err := os.WriteFile(path, []byte("second take"), 0644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+	send()
+	check("changed file", 2, 1)
+
+	// A file ID Telegram no longer accepts falls back to an upload
+	server.rejectIDs = true
+	send()
+	check("rejected file ID", 3, 2)
+
+	if err := h.sendMedia(42, "missing.mp3"); err == nil {
+		t.Error("sendMedia() of a missing file succeeded")
+	}
+}
@@ -0,0 +1,34 @@
+package bot
+
+import (
+	"html"
+	"log"
+
+	"github.com/andru_bot/tg-bot/models"
+)
+
+// startsPassage reports whether the current question is the first of its passage in a row,
+// so the passage has to be sent before it
+func (h *BotHandler) startsPassage(session *ActiveSession, question *models.Question) bool {
+	if question.Passage == nil {
+		return false
+	}
+	if session.CurrentIdx == 0 {
+		return true
+	}
+	previous, err := h.getSessionQuestion(session, session.QuestionIDs[session.CurrentIdx-1])
+	if err != nil {
+		log.Printf("Error getting previous question: %v", err)
+		return true
+	}
+	return !models.SamePassage(previous, question)
+}
+
+// sendPassage sends the text of a reading passage, ahead of the first of its questions
+func (h *BotHandler) sendPassage(chatID int64, passage *models.Passage) {
+	title := "Read the text and answer the questions after it"
+	if passage.Title != "" {
+		title = html.EscapeString(passage.Title)
+	}
+	h.sendMessage(chatID, "📖 <b>"+title+"</b>\n\n"+passage.Text)
+}
@@ -0,0 +1,74 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andru_bot/tg-bot/database"
+	"github.com/andru_bot/tg-bot/models"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPassageShownOnce(t *testing.T) {
+	const telegramID = 42
+
+	var mu sync.Mutex
+	var passages []string // Passage messages sent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if text := r.Form.Get("text"); strings.HasSuffix(r.URL.Path, "/sendMessage") && strings.HasPrefix(text, "📖") {
+			mu.Lock()
+			passages = append(passages, text)
+			mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true,"result":{"message_id":5,"chat":{"id":42}}}`))
+	}))
+	t.Cleanup(srv.Close)
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("token", srv.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatalf("NewBotAPIWithAPIEndpoint() error: %v", err)
+	}
+
+	repos := database.NewMemoryRepositories()
+	h := NewBotHandler(bot, repos, t.TempDir()+"/results.csv")
+	passage := &models.Passage{Key: "p1", Title: "At the station", Text: "The train to Leeds leaves at nine."}
+	for i, text := range []string{"Where does the train go?", "She ___ to school.", "When does it leave?", "Who is waiting?"} {
+		question := &models.Question{ID: primitive.NewObjectID(), Text: text, Options: []string{"go", "goes", "going"}, CorrectAnswerID: 2, Score: 1}
+		if i != 1 {
+			question.Passage = passage
+		}
+		if err := repos.Questions.Create(question); err != nil {
+			t.Fatalf("Create() question error: %v", err)
+		}
+	}
+	session := startTestSession(t, h, repos, telegramID)
+
+	// The passage's questions are asked one after another, however the bank lists them
+	var asked strings.Builder // "P" for a question of the passage, "-" for the other one
+	for _, q := range session.Questions {
+		if q.Passage != nil {
+			asked.WriteByte('P')
+		} else {
+			asked.WriteByte('-')
+		}
+	}
+	if !strings.Contains(asked.String(), "PPP") {
+		t.Fatalf("questions asked as %s, want the passage's three questions together", asked.String())
+	}
+
+	for range session.QuestionIDs {
+		handle(h, answerUpdate(h, telegramID, 2))
+	}
+	h.notifications.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(passages) != 1 || !strings.Contains(passages[0], "At the station") || !strings.Contains(passages[0], passage.Text) {
+		t.Errorf("passage messages = %q, want the passage once", passages)
+	}
+}
@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// touchSession records activity on the session so that the idle sweeper leaves it alone
+func (h *BotHandler) touchSession(session *ActiveSession) {
+	err := h.sessionRepo.UpdateActivity(session.SessionID, time.Now())
+	if err != nil {
+		log.Printf("Error updating session activity: %v", err)
+	}
+}
+
+// StartSessionSweeper starts expiring sessions without activity for SESSION_IDLE_TIMEOUT
+// Sessions are checked right away and then every tenth of the timeout (between 10s and 5m)
+// Does nothing if the timeout is 0
+func (h *BotHandler) StartSessionSweeper() {
+	if h.sessionIdleTimeout <= 0 {
+		return
+	}
+
+	interval := min(max(h.sessionIdleTimeout/10, 10*time.Second), 5*time.Minute)
+	h.sweeperDone = make(chan struct{})
+	go func() {
+		defer close(h.sweeperDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			h.sweepIdleSessions()
+			select {
+			case <-h.sweeperStop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	log.Printf("Expiring sessions idle for %s", h.sessionIdleTimeout)
+}
+
+// stopSessionSweeper stops the sweeper and waits until its current sweep is done
+// Safe to call more than once and when the sweeper never started
+func (h *BotHandler) stopSessionSweeper() {
+	h.sweeperStopOnce.Do(func() {
+		if h.sweeperStop != nil {
+			close(h.sweeperStop)
+		}
+	})
+	if h.sweeperDone != nil {
+		<-h.sweeperDone
+	}
+}
+
+// sweepIdleSessions queues the expiry of every idle session on the worker of its user
+func (h *BotHandler) sweepIdleSessions() {
+	sessions, err := h.sessionRepo.GetIdle(time.Now().Add(-h.sessionIdleTimeout))
+	if err != nil {
+		log.Printf("Error getting idle sessions: %v", err)
+		return
+	}
+
+	for i := range sessions {
+		sessionID := sessions[i].ID
+		user, err := h.userRepo.GetByID(sessions[i].UserID)
+		if err != nil {
+			log.Printf("Error getting user of session %s: %v", sessionID.Hex(), err)
+			continue
+		}
+		userID := user.TelegramID
+		h.dispatcher.Submit(userID, func() {
+			h.expireSession(userID, sessionID)
+		})
+	}
+}
+
+// expireSession finishes the session as expired unless it was finished or used since it was found idle
+// Must run on the user's worker
+func (h *BotHandler) expireSession(userID int64, sessionID primitive.ObjectID) {
+	dbSession, err := h.sessionRepo.GetByID(sessionID)
+	if err != nil {
+		log.Printf("Error getting session %s to expire: %v", sessionID.Hex(), err)
+		return
+	}
+	if dbSession.Status != models.StatusInProgress || dbSession.LastActivity().After(time.Now().Add(-h.sessionIdleTimeout)) {
+		return
+	}
+
+	h.terminateSession(userID, dbSession, models.StatusExpired, fmt.Sprintf("no activity for %s", formatDuration(h.sessionIdleTimeout)))
+}
+
+// terminateSession finishes an in-progress session that the user did not end themselves
+// Must run on the user's worker
+func (h *BotHandler) terminateSession(userID int64, dbSession *models.Session, status string, reason string) {
+	session := h.getActiveSession(userID)
+	if session == nil || session.SessionID != dbSession.ID {
+		session = h.loadActiveSession(userID, dbSession)
+	}
+	h.removeQuestionKeyboard(session.ChatID, session)
+	h.finishTest(session.ChatID, userID, session, status, reason)
+}
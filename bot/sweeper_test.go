@@ -0,0 +1,120 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andru_bot/tg-bot/models"
+)
+
+func TestSweepIdleSessions(t *testing.T) {
+	const idleID, activeID, restartedID = 42, 43, 44
+	h, repos := newTestHandler(t)
+	h.sessionIdleTimeout = time.Minute
+
+	idle := startTestSession(t, h, repos, idleID)
+	active := startTestSession(t, h, repos, activeID)
+	restarted := startTestSession(t, h, repos, restartedID)
+	for _, session := range []*models.Session{idle, restarted} {
+		if err := repos.Sessions.UpdateActivity(session.ID, time.Now().Add(-time.Hour)); err != nil {
+			t.Fatalf("UpdateActivity() error: %v", err)
+		}
+	}
+	// The bot restarted since, the session is only in the database
+	onWorker(h, restartedID, func() { h.removeActiveSession(restartedID) })
+
+	h.sweepIdleSessions()
+	for _, userID := range []int64{idleID, activeID, restartedID} {
+		onWorker(h, userID, func() {})
+	}
+	h.notifications.Wait()
+
+	tests := []struct {
+		name       string
+		userID     int64
+		session    *models.Session
+		wantStatus string
+	}{
+		{"idle", idleID, idle, models.StatusExpired},
+		{"active", activeID, active, models.StatusInProgress},
+		{"idle, not in memory", restartedID, restarted, models.StatusExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored, err := repos.Sessions.GetByID(tt.session.ID)
+			if err != nil {
+				t.Fatalf("GetByID() error: %v", err)
+			}
+			if stored.Status != tt.wantStatus {
+				t.Errorf("session status = %q, want %q", stored.Status, tt.wantStatus)
+			}
+			expired := tt.wantStatus == models.StatusExpired
+			if expired && (stored.FinishedAt == nil || stored.TerminationReason == "") {
+				t.Errorf("expired session finished at %v with reason %q, want both set", stored.FinishedAt, stored.TerminationReason)
+			}
+			if inMemory := h.getActiveSession(tt.userID) != nil; inMemory == expired {
+				t.Errorf("session in memory = %v after the sweep", inMemory)
+			}
+		})
+	}
+}
+
+func TestExpireSessionRechecks(t *testing.T) {
+	const telegramID = 42
+	h, repos := newTestHandler(t)
+	h.sessionIdleTimeout = time.Minute
+	session := startTestSession(t, h, repos, telegramID)
+
+	// Found idle, but the candidate answered before the expiry ran
+	if err := repos.Sessions.UpdateActivity(session.ID, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("UpdateActivity() error: %v", err)
+	}
+	handle(h, answerUpdate(h, telegramID, 2))
+	onWorker(h, telegramID, func() { h.expireSession(telegramID, session.ID) })
+
+	stored, err := repos.Sessions.GetByID(session.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error: %v", err)
+	}
+	if stored.Status != models.StatusInProgress {
+		t.Errorf("session status = %q after an answer, want it kept in progress", stored.Status)
+	}
+
+	// Finished in the meantime: the final status stays
+	onWorker(h, telegramID, func() {
+		h.finishTest(telegramID, telegramID, h.getActiveSession(telegramID), models.StatusCancelledByUser, "")
+	})
+	if err := repos.Sessions.UpdateActivity(session.ID, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("UpdateActivity() error: %v", err)
+	}
+	onWorker(h, telegramID, func() { h.expireSession(telegramID, session.ID) })
+	h.notifications.Wait()
+
+	if stored, err = repos.Sessions.GetByID(session.ID); err != nil {
+		t.Fatalf("GetByID() error: %v", err)
+	}
+	if stored.Status != models.StatusCancelledByUser {
+		t.Errorf("session status = %q, want %q kept", stored.Status, models.StatusCancelledByUser)
+	}
+}
+
+func TestSessionSweeperStop(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.stopSessionSweeper() // Never started
+
+	h, _ = newTestHandler(t)
+	h.sessionIdleTimeout = time.Minute
+	h.StartSessionSweeper()
+
+	stopped := make(chan struct{})
+	go func() {
+		h.stopSessionSweeper()
+		h.stopSessionSweeper()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopSessionSweeper() did not return")
+	}
+}
@@ -2,57 +2,61 @@ package bot
 
 import (
 	"fmt"
+	"html"
 	"log"
-	"strconv"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/andru_bot/tg-bot/models"
+	"github.com/andru_bot/tg-bot/placement"
+	"github.com/andru_bot/tg-bot/scoring"
+	"github.com/andru_bot/tg-bot/termination"
+	"github.com/andru_bot/tg-bot/textmatch"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func (h *BotHandler) handleCallbackQuery(query *tgbotapi.CallbackQuery) {
 	userID := query.From.ID
-	session, exists := h.activeSessions[userID]
-
-	// If session not in memory, try to load from database
-	if !exists {
-		// Find or create user
-		user, err := h.userRepo.FindOrCreate(
-			int64(userID),
-			query.From.UserName,
-			query.From.FirstName,
-			query.From.LastName,
-		)
-		if err != nil {
-			log.Printf("Error finding/creating user: %v", err)
-			h.answerCallback(query.ID, "Error processing answer. Please try again.")
-			return
-		}
 
-		// Try to load active session from database
-		dbSession, err := h.sessionRepo.GetActiveByUserID(user.ID)
-		if err != nil || dbSession == nil {
-			h.answerCallback(query.ID, "Your test session has expired. Please start a new test with /test.")
-			return
-		}
-
-		// Load session into memory
-		h.activeSessions[userID] = &ActiveSession{
-			SessionID:         dbSession.ID,
-			UserID:            dbSession.UserID,
-			QuestionIDs:       dbSession.QuestionIDs,
-			CurrentIdx:        dbSession.CurrentIdx,
-			Score:             dbSession.TotalScore,
-			ConsecutiveErrors: 0, // Reset when loading from DB (we'll recalculate if needed)
-		}
-		session = h.activeSessions[userID]
+	// Verify the button before touching any session
+	payload, err := h.decodeAnswerPayload(query.Data)
+	if err != nil {
+		h.answerCallback(query.ID, "This button is no longer valid. Please answer the latest question.")
+		return
 	}
 
-	// Parse selected answer
-	selectedAnswerID, err := strconv.Atoi(query.Data)
+	// Load the session from the database if it is not in memory (e.g. after a restart)
+	session, err := h.resumeSession(query.From)
 	if err != nil {
-		h.answerCallback(query.ID, "Invalid answer. Please try again.")
+		log.Printf("Error loading active session: %v", err)
+		h.answerCallback(query.ID, "Error processing answer. Please try again.")
+		return
+	}
+	if session == nil {
+		h.answerCallback(query.ID, "Your test session has expired. Please start a new test with /test.")
+		return
+	}
+
+	// Reject presses on messages of another test or of a question that was already answered
+	if payload.SessionID != session.SessionID {
+		h.answerCallback(query.ID, "This question belongs to a test that is already finished.")
+		return
+	}
+	if payload.QuestionIdx != session.CurrentIdx {
+		h.answerCallback(query.ID, "This question was already answered. Please answer the latest question.")
+		return
+	}
+
+	// Time ran out before the timer got to it; enforce the deadline instead of grading
+	if now := time.Now(); session.deadlinePassed(now) {
+		if session.testExpired(now) {
+			h.answerCallback(query.ID, "⏰ Time is up! The test is over.")
+		} else {
+			h.answerCallback(query.ID, "⏰ Time is up for this question.")
+		}
+		h.handleDeadline(query.Message.Chat.ID, userID, session)
 		return
 	}
 
@@ -63,143 +67,474 @@ func (h *BotHandler) handleCallbackQuery(query *tgbotapi.CallbackQuery) {
 	}
 
 	questionID := session.QuestionIDs[session.CurrentIdx]
-	question, err := h.questionRepo.GetByID(questionID)
+	question, err := h.getSessionQuestion(session, questionID)
 	if err != nil {
 		log.Printf("Error getting question: %v", err)
 		h.answerCallback(query.ID, "Error processing answer. Please try again.")
 		return
 	}
 
-	// Validate selected answer ID is within range
+	// Validate the button against the question: a position within range, a selection of existing
+	// positions, or a sequence of distinct existing positions
 	maxAnswerID := question.GetAnswerCount()
-	if selectedAnswerID < 1 || selectedAnswerID > maxAnswerID {
+	if question.IsMultiple() {
+		if (payload.Kind != togglePayloadKind && payload.Kind != submitPayloadKind) || payload.Selection < 0 || payload.Selection >= 1<<maxAnswerID {
+			h.answerCallback(query.ID, "Invalid answer. Please try again.")
+			return
+		}
+		h.handleSelection(query, session, question, payload)
+		return
+	}
+	if question.IsSequence() {
+		if (payload.Kind != buildPayloadKind && payload.Kind != finishPayloadKind) || !validSequence(payload.Sequence, maxAnswerID) {
+			h.answerCallback(query.ID, "Invalid answer. Please try again.")
+			return
+		}
+		h.handleSequence(query, session, question, payload)
+		return
+	}
+	selectedPosition := payload.Position
+	if payload.Kind != answerPayloadKind || selectedPosition < 1 || selectedPosition > maxAnswerID {
 		h.answerCallback(query.ID, "Invalid answer. Please try again.")
 		return
 	}
 
-	// Check if answer is correct
-	isCorrect := selectedAnswerID == question.CorrectAnswerID
-	score := 0
-	if isCorrect {
-		score = question.Score
-		session.Score += score
-		// Reset consecutive errors on correct answer
-		session.ConsecutiveErrors = 0
-	} else {
-		// Increment consecutive errors on incorrect answer
-		session.ConsecutiveErrors++
+	// Map the displayed position back to the canonical answer ID
+	selectedAnswerID := models.OptionOrder(session.OptionOrders, questionID, maxAnswerID)[selectedPosition-1]
+
+	// Acknowledge callback (don't reveal if answer is correct)
+	h.answerCallback(query.ID, "")
+
+	h.submitAnswer(query.Message.Chat.ID, userID, session, question, &models.Answer{
+		SelectedAnswerID:  selectedAnswerID,
+		DisplayedPosition: selectedPosition,
+		IsCorrect:         selectedAnswerID == question.CorrectAnswerID,
+	})
+}
+
+// handleSelection handles the buttons of a multiple question: a toggle redraws the buttons with the
+// new selection, which lives only in the button data, and Submit grades the selection
+func (h *BotHandler) handleSelection(query *tgbotapi.CallbackQuery, session *ActiveSession, question *models.Question, payload answerPayload) {
+	chatID := query.Message.Chat.ID
+
+	if payload.Kind == togglePayloadKind {
+		h.answerCallback(query.ID, "")
+		h.touchSession(session)
+		edit := tgbotapi.NewEditMessageReplyMarkup(chatID, query.Message.MessageID,
+			tgbotapi.NewInlineKeyboardMarkup(h.questionKeyboard(session, question, payload.Selection)...))
+		if _, err := h.bot.Request(edit); err != nil {
+			log.Printf("Error updating selection: %v", err)
+		}
+		return
 	}
 
-	// Save answer
-	answer := &models.Answer{
-		ID:               primitive.NewObjectID(),
-		SessionID:        session.SessionID,
-		UserID:           session.UserID,
-		QuestionID:       questionID,
-		SelectedAnswerID: selectedAnswerID,
-		IsCorrect:        isCorrect,
-		Score:            score,
-		AnsweredAt:       time.Now(),
+	if payload.Selection == 0 {
+		h.answerCallback(query.ID, "Please select at least one option.")
+		return
 	}
 
-	err = h.answerRepo.Create(answer)
-	if err != nil {
-		log.Printf("Error saving answer: %v", err)
+	// Map the displayed positions back to the canonical answer IDs
+	order := models.OptionOrder(session.OptionOrders, question.ID, question.GetAnswerCount())
+	var selected []int
+	for _, position := range selectedPositions(payload.Selection) {
+		selected = append(selected, order[position-1])
+	}
+	slices.Sort(selected)
+	credit := testScoring(&session.Test).Credit(*question, selected)
+
+	// Acknowledge callback (don't reveal if answer is correct)
+	h.answerCallback(query.ID, "")
+
+	h.submitAnswer(chatID, query.From.ID, session, question, &models.Answer{
+		SelectedAnswerIDs: selected,
+		Credit:            credit,
+		IsCorrect:         credit == 1,
+	})
+}
+
+// handleSequence handles the buttons of an order or match question: picking an option or undoing the
+// last pick redraws the question with the new sequence, which lives only in the button data, and
+// Submit grades the sequence
+func (h *BotHandler) handleSequence(query *tgbotapi.CallbackQuery, session *ActiveSession, question *models.Question, payload answerPayload) {
+	chatID := query.Message.Chat.ID
+
+	if payload.Kind == buildPayloadKind {
+		h.answerCallback(query.ID, "")
+		h.touchSession(session)
+		edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, query.Message.MessageID,
+			h.questionText(session, question, payload.Sequence, time.Now()),
+			tgbotapi.NewInlineKeyboardMarkup(h.sequenceKeyboard(session, question, payload.Sequence)...))
+		edit.ParseMode = "HTML"
+		if _, err := h.bot.Request(edit); err != nil {
+			log.Printf("Error updating sequence: %v", err)
+		}
+		return
+	}
+
+	if len(payload.Sequence) < question.GetAnswerCount() {
+		if question.QuestionType() == models.QuestionTypeMatch {
+			h.answerCallback(query.ID, "Please match every item before submitting.")
+		} else {
+			h.answerCallback(query.ID, "Please use every word before submitting.")
+		}
+		return
 	}
 
+	// Map the displayed positions back to the canonical answer IDs
+	order := models.OptionOrder(session.OptionOrders, question.ID, question.GetAnswerCount())
+	sequence := make([]int, len(payload.Sequence))
+	for i, position := range payload.Sequence {
+		sequence[i] = order[position-1]
+	}
+	credit := testScoring(&session.Test).Credit(*question, sequence)
+
 	// Acknowledge callback (don't reveal if answer is correct)
 	h.answerCallback(query.ID, "")
 
-	// Check if max consecutive errors occurred
-	if session.ConsecutiveErrors >= h.maxConsecutiveErrors {
-		// Test failed due to consecutive errors
-		h.finishTestWithFailure(query.Message.Chat.ID, userID, session)
+	h.submitAnswer(chatID, query.From.ID, session, question, &models.Answer{
+		SelectedAnswerIDs: sequence,
+		Credit:            credit,
+		IsCorrect:         credit == 1,
+	})
+}
+
+// validSequence reports whether sequence holds distinct displayed positions between 1 and count
+func validSequence(sequence []int, count int) bool {
+	if len(sequence) > count {
+		return false
+	}
+	seen := make(map[int]bool, len(sequence))
+	for _, position := range sequence {
+		if position < 1 || position > count || seen[position] {
+			return false
+		}
+		seen[position] = true
+	}
+	return true
+}
+
+// questionKeyboard returns the answer buttons of a question in the session's display order, none for typed-answer questions
+// Button data carries the displayed position, mapped back to the answer ID when graded; the buttons of a
+// multiple question toggle their option in selection and are followed by a Submit button
+func (h *BotHandler) questionKeyboard(session *ActiveSession, question *models.Question, selection int) [][]tgbotapi.InlineKeyboardButton {
+	if question.IsSequence() {
+		return h.sequenceKeyboard(session, question, nil)
+	}
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	order := models.OptionOrder(session.OptionOrders, question.ID, question.GetAnswerCount())
+	for i, answerID := range order {
+		label := fmt.Sprintf("%d. %s", i+1, question.GetAnswer(answerID))
+		payload := answerPayload{SessionID: session.SessionID, QuestionIdx: session.CurrentIdx, Position: i + 1}
+		if question.IsMultiple() {
+			mark := "☐"
+			if selection&(1<<i) != 0 {
+				mark = "☑️"
+			}
+			label = mark + " " + label
+			payload.Kind = togglePayloadKind
+			payload.Selection = selection ^ (1 << i)
+		}
+		keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, h.encodeAnswerPayload(payload))))
+	}
+	if question.IsMultiple() {
+		submit := h.encodeAnswerPayload(answerPayload{Kind: submitPayloadKind, SessionID: session.SessionID, QuestionIdx: session.CurrentIdx, Selection: selection})
+		keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("📨 Submit", submit)))
+	}
+	return keyboard
+}
+
+// sequenceKeyboard returns the buttons of an order or match question with the given sequence built:
+// one button per option (order) or match (match) not picked yet, then Undo and Submit
+func (h *BotHandler) sequenceKeyboard(session *ActiveSession, question *models.Question, sequence []int) [][]tgbotapi.InlineKeyboardButton {
+	perRow := 3 // Words are short, matches are usually phrases
+	if question.QuestionType() == models.QuestionTypeMatch {
+		perRow = 1
+	}
+	build := func(kind string, sequence []int) string {
+		return h.encodeAnswerPayload(answerPayload{Kind: kind, SessionID: session.SessionID, QuestionIdx: session.CurrentIdx, Sequence: sequence})
+	}
+
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	order := models.OptionOrder(session.OptionOrders, question.ID, question.GetAnswerCount())
+	for i, answerID := range order {
+		if slices.Contains(sequence, i+1) {
+			continue
+		}
+		next := append(slices.Clone(sequence), i+1)
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(question.ButtonText(answerID), build(buildPayloadKind, next)))
+		if len(row) == perRow {
+			keyboard = append(keyboard, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		keyboard = append(keyboard, row)
+	}
+
+	var controls []tgbotapi.InlineKeyboardButton
+	if len(sequence) > 0 {
+		controls = append(controls, tgbotapi.NewInlineKeyboardButtonData("↩️ Undo", build(buildPayloadKind, sequence[:len(sequence)-1])))
+	}
+	controls = append(controls, tgbotapi.NewInlineKeyboardButtonData("📨 Submit", build(finishPayloadKind, sequence)))
+	return append(keyboard, controls)
+}
+
+// handleTextAnswer grades a message as the answer to the current question of a typed-answer question
+// Returns false if the user has no test in progress
+func (h *BotHandler) handleTextAnswer(msg *tgbotapi.Message) bool {
+	chatID, userID := msg.Chat.ID, msg.From.ID
+
+	// Load the session from the database if it is not in memory (e.g. after a restart)
+	session, err := h.resumeSession(msg.From)
+	if err != nil {
+		log.Printf("Error loading active session: %v", err)
+		h.sendMessage(chatID, "Error processing answer. Please try again.")
+		return true
+	}
+	if session == nil {
+		return false
+	}
+
+	// Time ran out before the timer got to it; enforce the deadline instead of grading
+	if session.deadlinePassed(time.Now()) {
+		h.handleDeadline(chatID, userID, session)
+		return true
+	}
+	if session.CurrentIdx >= len(session.QuestionIDs) {
+		h.sendMessage(chatID, "Test already completed.")
+		return true
+	}
+
+	questionID := session.QuestionIDs[session.CurrentIdx]
+	question, err := h.getSessionQuestion(session, questionID)
+	if err != nil {
+		log.Printf("Error getting question: %v", err)
+		h.sendMessage(chatID, "Error processing answer. Please try again.")
+		return true
+	}
+	if !question.IsTextAnswer() {
+		h.sendMessage(chatID, "Please select an answer using the buttons below the question.")
+		return true
+	}
+	if strings.TrimSpace(msg.Text) == "" {
+		h.sendMessage(chatID, "Please type your answer as a text message.")
+		return true
+	}
+
+	h.submitAnswer(chatID, userID, session, question, &models.Answer{
+		TextAnswer: msg.Text,
+		IsCorrect:  textmatch.Match(msg.Text, question.AcceptedAnswers, question.Tolerance),
+	})
+	return true
+}
+
+// submitAnswer scores and stores the graded answer to the current question, then moves the test on
+func (h *BotHandler) submitAnswer(chatID int64, userID int64, session *ActiveSession, question *models.Question, answer *models.Answer) {
+	answer.ID = primitive.NewObjectID()
+	answer.SessionID = session.SessionID
+	answer.UserID = session.UserID
+	answer.QuestionID = question.ID
+	if question.HasPartialCredit() {
+		answer.Score = testScoring(&session.Test).CreditScore(*question, answer.Credit)
+	} else {
+		answer.Score = testScoring(&session.Test).AnswerScore(*question, answer.IsCorrect)
+	}
+	answer.AnsweredAt = time.Now()
+	session.Score += answer.Score
+
+	err := h.answerRepo.Create(answer)
+	if err != nil {
+		log.Printf("Error saving answer: %v", err)
+	}
+	h.touchSession(session)
+
+	// Stop early if a termination policy says so (too many errors, ceiling reached, ...)
+	if h.checkTermination(chatID, userID, session) {
 		return
 	}
 
+	h.advance(chatID, userID, session)
+}
+
+// checkTermination finishes the test with the decision of the first termination policy that stops it
+// Returns true if the test was finished
+func (h *BotHandler) checkTermination(chatID int64, userID int64, session *ActiveSession) bool {
+	answers, err := h.getSessionAnswers(session.SessionID)
+	if err != nil {
+		log.Printf("Error getting answers for termination policies: %v", err)
+		return false
+	}
+
+	decision, stop := session.policies.Check(termination.Progress{
+		Questions: h.getSessionQuestions(session.QuestionIDs, session.Questions),
+		Answers:   answers,
+		ExpiresAt: session.ExpiresAt,
+		Now:       time.Now(),
+	})
+	if !stop {
+		return false
+	}
+	h.finishTest(chatID, userID, session, decision.Status, decision.Reason)
+	return true
+}
+
+// advance moves the session past the current question and sends the next one,
+// finishing the test when there is none left
+func (h *BotHandler) advance(chatID int64, userID int64, session *ActiveSession) {
 	// Move to next question
 	session.CurrentIdx++
+	session.QuestionDeadline = nil
+	session.QuestionMessageID = 0
 
 	// Update session progress in database
-	err = h.sessionRepo.UpdateProgress(session.SessionID, session.CurrentIdx, session.Score)
+	err := h.sessionRepo.UpdateProgress(session.SessionID, session.CurrentIdx, session.Score)
 	if err != nil {
 		log.Printf("Error updating session progress: %v", err)
 	}
 
+	// Re-estimate ability after every answer in adaptive mode
+	if session.Mode == models.ModeAdaptive {
+		h.updateAbility(session)
+	}
+
 	// Send next question or finish test
-	if session.CurrentIdx >= len(session.QuestionIDs) {
-		// Test completed naturally (all questions answered)
-		h.finishTest(query.Message.Chat.ID, userID, session, true)
+	if !h.prepareNextQuestion(session) {
+		// Test completed naturally (all questions answered or adaptive stopping rule met)
+		reason := "all questions answered"
+		if session.Mode == models.ModeAdaptive {
+			reason = "adaptive test finished"
+		}
+		h.finishTest(chatID, userID, session, models.StatusCompleted, reason)
 	} else {
 		// Send next question immediately
-		h.sendNextQuestion(query.Message.Chat.ID, userID)
+		h.sendNextQuestion(chatID, userID)
 	}
 }
 
 func (h *BotHandler) sendNextQuestion(chatID int64, userID int64) {
-	session := h.activeSessions[userID]
+	session := h.getActiveSession(userID)
 	if session == nil {
 		return
 	}
 
-	if session.CurrentIdx >= len(session.QuestionIDs) {
+	// A resumed session may have run out of time while nobody was watching
+	now := time.Now()
+	if session.deadlinePassed(now) {
+		h.handleDeadline(chatID, userID, session)
+		return
+	}
+
+	if !h.prepareNextQuestion(session) {
 		return
 	}
 
 	questionID := session.QuestionIDs[session.CurrentIdx]
-	question, err := h.questionRepo.GetByID(questionID)
+	question, err := h.getSessionQuestion(session, questionID)
 	if err != nil {
 		log.Printf("Error getting question: %v", err)
 		h.sendMessage(chatID, "Error loading question. Please try again.")
 		return
 	}
 
-	// Create inline keyboard with answer options (3 or 4 answers)
-	var keyboard [][]tgbotapi.InlineKeyboardButton
-	answers := []string{question.Answer1, question.Answer2, question.Answer3}
-	if question.Answer4 != "" {
-		answers = append(answers, question.Answer4)
+	// The passage comes before the first of its questions, then listening and picture questions send
+	// their files; the question's clock starts once they are sent
+	if h.startsPassage(session, question) {
+		h.sendPassage(chatID, question.Passage)
+		now = time.Now()
 	}
-
-	for i, answer := range answers {
-		row := []tgbotapi.InlineKeyboardButton{
-			tgbotapi.NewInlineKeyboardButtonData(
-				fmt.Sprintf("%d. %s", i+1, answer),
-				strconv.Itoa(i+1),
-			),
-		}
-		keyboard = append(keyboard, row)
+	if question.Audio != "" || question.Image != "" {
+		h.sendQuestionMedia(chatID, question)
+		now = time.Now()
 	}
 
-	questionNum := session.CurrentIdx + 1
-	totalQuestions := len(session.QuestionIDs)
+	keyboard := h.questionKeyboard(session, question, 0)
 
-	// Format question text with HTML - text already contains newlines from JSON
-	text := fmt.Sprintf("<b>Question %d/%d</b>\n\n%s", questionNum, totalQuestions, question.Text)
+	// Start the question's clock, the text shows the remaining time
+	h.startQuestionTimer(session, now)
+	h.touchSession(session)
+	text := h.questionText(session, question, nil, now)
 
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ParseMode = "HTML"
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	if len(keyboard) > 0 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	}
 
-	_, err = h.bot.Send(msg)
+	sent, err := h.bot.Send(msg)
 	if err != nil {
 		log.Printf("Error sending message: %v", err)
+	} else if len(keyboard) > 0 {
+		session.QuestionMessageID = sent.MessageID
+		err = h.sessionRepo.SetQuestionMessage(session.SessionID, sent.MessageID)
+		if err != nil {
+			log.Printf("Error saving question message: %v", err)
+		}
 	}
+
+	h.armTimer(userID, session)
 }
 
-func (h *BotHandler) finishTestWithFailure(chatID int64, userID int64, session *ActiveSession) {
-	// Finish session in database
-	err := h.sessionRepo.Finish(session.SessionID, session.Score, len(session.QuestionIDs))
-	if err != nil {
-		log.Printf("Error finishing session: %v", err)
+// questionText returns the HTML text of the current question, with the sequence built so far for
+// order and match questions
+func (h *BotHandler) questionText(session *ActiveSession, question *models.Question, sequence []int, now time.Time) string {
+	questionNum := session.CurrentIdx + 1
+
+	// Format question text with HTML - text already contains newlines from JSON
+	var header string
+	if session.Mode == models.ModeAdaptive {
+		// The length of an adaptive test is not known in advance
+		header = fmt.Sprintf("<b>Question %d</b> (at most %d)", questionNum, session.TotalQuestions)
+	} else {
+		header = fmt.Sprintf("<b>Question %d/%d</b>", questionNum, len(session.QuestionIDs))
+	}
+	if question.Passage != nil {
+		// Later questions of a passage may be far below it in the chat
+		reference := "Reading passage"
+		if question.Passage.Title != "" {
+			reference = html.EscapeString(question.Passage.Title)
+		}
+		header += "\n📖 <i>" + reference + "</i>"
+	}
+	text := header + "\n\n" + question.Text
+
+	order := models.OptionOrder(session.OptionOrders, question.ID, question.GetAnswerCount())
+	switch question.QuestionType() {
+	case models.QuestionTypeText:
+		text += "\n\n✍️ <i>Type your answer and send it as a message.</i>"
+	case models.QuestionTypeMultiple:
+		text += "\n\n☑️ <i>Select all that apply, then press Submit.</i>"
+	case models.QuestionTypeOrder:
+		words := make([]string, len(sequence))
+		for i, position := range sequence {
+			words[i] = html.EscapeString(question.GetAnswer(order[position-1]))
+		}
+		built := strings.Join(words, " ")
+		if built == "" {
+			built = "…"
+		}
+		text += "\n\n🧩 <i>Tap the words in the correct order, then press Submit.</i>\n\n<b>Your answer:</b> " + built
+	case models.QuestionTypeMatch:
+		text += "\n\n🔗 <i>Pick the match of each item in turn, then press Submit.</i>\n"
+		for i, option := range question.Options {
+			match := "…"
+			if i < len(sequence) {
+				match = html.EscapeString(question.GetMatch(order[sequence[i]-1]))
+			}
+			text += fmt.Sprintf("\n%d. <b>%s</b> → %s", i+1, html.EscapeString(option), match)
+		}
 	}
 
-	// Update user score
-	err = h.userRepo.UpdateScore(session.UserID, session.Score)
-	if err != nil {
-		log.Printf("Error updating user score: %v", err)
+	if timeLeft := formatTimeLeft(session, now); timeLeft != "" {
+		text += "\n" + timeLeft
 	}
+	return text
+}
 
+// finishTest ends the session with the given terminal status and reason, tells the candidate
+// and notifies admins
+// Only completed tests show the score to the candidate
+func (h *BotHandler) finishTest(chatID int64, userID int64, session *ActiveSession, status string, reason string) {
 	// Get all answers for this session
 	answers, err := h.answerRepo.GetBySession(session.SessionID)
 	if err != nil {
@@ -207,73 +542,141 @@ func (h *BotHandler) finishTestWithFailure(chatID int64, userID int64, session *
 	}
 
 	// Get questions for this session only
-	var questions []models.Question
-	for _, questionID := range session.QuestionIDs {
-		question, err := h.questionRepo.GetByID(questionID)
-		if err != nil {
-			log.Printf("Error getting question %s: %v", questionID.Hex(), err)
-			continue
-		}
-		questions = append(questions, *question)
-	}
+	questions := h.getSessionQuestions(session.QuestionIDs, session.Questions)
 
-	// Calculate statistics
-	correctAnswers := 0
-	incorrectAnswers := 0
-	for _, answer := range answers {
-		if answer.IsCorrect {
-			correctAnswers++
-		} else {
-			incorrectAnswers++
-		}
+	// Place the candidate on the CEFR scale
+	placementResult := placement.Compute(questions, answers, h.levelCutScores)
+
+	// Score against the maximum attainable score; the same result is stored, shown and reported
+	scoreResult := testScoring(&session.Test).Compute(questions, answers)
+
+	// Finish session in database
+	err = h.sessionRepo.Finish(session.SessionID, models.SessionOutcome{
+		Status:            status,
+		TerminationReason: reason,
+		TotalScore:        scoreResult.Score,
+		MaxScore:          scoreResult.MaxScore,
+		Percentage:        scoreResult.Percentage,
+		Passed:            scoreResult.Passed,
+		PassThreshold:     scoreResult.PassThreshold,
+		TotalQuestions:    len(session.QuestionIDs),
+		Level:             placementResult.Level,
+	})
+	if err != nil {
+		log.Printf("Error finishing session: %v", err)
 	}
-	totalQuestions := len(session.QuestionIDs)
 
-	// Send failure message to user
-	failureMessage := fmt.Sprintf("You made %d errors in row, test has been failed", h.maxConsecutiveErrors)
-	h.sendMessage(chatID, failureMessage)
+	// Update user score
+	err = h.userRepo.UpdateScore(session.UserID, scoreResult.Score)
+	if err != nil {
+		log.Printf("Error updating user score: %v", err)
+	}
 
-	// Show menu again after test failure
-	h.sendMessageWithMenu(chatID, "Test failed. Use menu to start a new test.")
+	// Send result to user and show menu again
+	var resultText, menuText string
+	switch status {
+	case models.StatusCompleted:
+		resultText = fmt.Sprintf("🎉 Test Completed!\n\nYour Score: %s\n", scoreResult.Summary())
+		if verdict := scoreResult.Verdict(); verdict != "" {
+			resultText += fmt.Sprintf("🏆 %s\n", verdict)
+		}
+		if placementResult.Level != "" {
+			resultText += fmt.Sprintf("🎓 Your level: <b>%s</b>\n", placementResult.Level)
+		}
+		resultText += "\nThank you for taking the test!"
+		menuText = "Test completed! Use menu to start a new test or view results."
+	case models.StatusFailedConsecutiveErrors, models.StatusFailed:
+		resultText = fmt.Sprintf("❌ Test has been failed: %s", reason)
+		menuText = "Test failed. Use menu to start a new test."
+	case models.StatusExpired:
+		resultText = "⌛ Your test was closed because there was no activity for too long."
+		menuText = "Use menu to start a new test."
+	case models.StatusCancelledByAdmin:
+		resultText = "🛑 Your test was cancelled by an administrator."
+		menuText = "Use menu to start a new test."
+	default:
+		// Hide detailed results when finished by the user
+		resultText = "✅ Test session finished.\n\n" +
+			"Thank you for taking the test!"
+		menuText = "Test completed! Use menu to start a new test or view results."
+	}
 
-	// Send notification to admin with skipped questions marked
-	// currentIdx is the question that was just answered (the last error)
-	// Mark questions from currentIdx+1 onwards as skip
-	h.sendAdminNotificationWithSkipped(userID, session.SessionID, correctAnswers, incorrectAnswers, totalQuestions, answers, questions, session.CurrentIdx+1, h.maxConsecutiveErrors)
+	h.sendMessage(chatID, resultText)
+	h.sendMessageWithMenu(chatID, menuText)
+
+	// Send notification to admin with the questions that were never reached marked as skipped
+	// After an early stop currentIdx is usually the question that was just answered
+	sessionID, skipFrom := session.SessionID, session.CurrentIdx
+	if skipFrom < len(session.QuestionIDs) {
+		if _, answered := models.FindAnswer(answers, session.QuestionIDs[skipFrom]); answered {
+			skipFrom++
+		}
+	}
+	h.notifyAdmins(func() {
+		h.sendAdminNotification(userID, sessionID, status, reason, scoreResult, answers, questions, placementResult, skipFrom)
+	})
 
 	// Delete results.csv file to save space
 	h.deleteResultsCSV()
 
 	// Log result to console
-	log.Printf("Test failed (%d consecutive errors) - UserID: %d, SessionID: %s, Score: %d/%d",
-		h.maxConsecutiveErrors, userID, session.SessionID.Hex(), session.Score, totalQuestions)
+	log.Printf("Test %s finished (%s: %s) - UserID: %d, SessionID: %s, Score: %s, Level: %s",
+		session.Test.Key, status, reason, userID, session.SessionID.Hex(), scoreResult.Summary(), placementResult.Level)
 
 	// Remove active session
-	delete(h.activeSessions, userID)
+	h.removeActiveSession(userID)
 }
 
-func (h *BotHandler) finishTest(chatID int64, userID int64, session *ActiveSession, showDetailedResults bool) {
-	// Finish session in database
-	err := h.sessionRepo.Finish(session.SessionID, session.Score, len(session.QuestionIDs))
-	if err != nil {
-		log.Printf("Error finishing session: %v", err)
+// formatOutcome describes how a finished session ended
+func formatOutcome(status, reason string) string {
+	var outcome string
+	switch status {
+	case models.StatusCompleted, "":
+		outcome = "Completed"
+	case models.StatusFailedConsecutiveErrors, models.StatusFailed:
+		outcome = "Failed"
+	case models.StatusCancelledByUser:
+		outcome = "Finished early"
+	case models.StatusExpired:
+		outcome = "Expired"
+	case models.StatusCancelledByAdmin:
+		outcome = "Cancelled by admin"
+	default:
+		outcome = status
+	}
+	if reason != "" {
+		outcome += " (" + reason + ")"
 	}
+	return outcome
+}
 
-	// Update user score
-	err = h.userRepo.UpdateScore(session.UserID, session.Score)
-	if err != nil {
-		log.Printf("Error updating user score: %v", err)
+// formatPartial returns the result line counting partially correct answers, empty when there are none
+func formatPartial(scoreResult scoring.Result) string {
+	if scoreResult.Partial == 0 {
+		return ""
 	}
+	return fmt.Sprintf("🟡 Partially Correct: %d\n", scoreResult.Partial)
+}
 
-	// Get all answers for this session
-	answers, err := h.answerRepo.GetBySession(session.SessionID)
-	if err != nil {
-		log.Printf("Error getting answers: %v", err)
+// getSessionQuestion returns a question of the session as it was asked
+func (h *BotHandler) getSessionQuestion(session *ActiveSession, questionID primitive.ObjectID) (*models.Question, error) {
+	if question, ok := models.FindQuestion(session.Questions, questionID); ok {
+		return question, nil
 	}
+	// Sessions started before snapshots were stored
+	return h.questionRepo.GetByID(questionID)
+}
 
-	// Get questions for this session only
+// getSessionQuestions returns the questions of a session in session order as they were asked
+// Questions without a snapshot (sessions started before snapshots were stored) are loaded
+// from the bank, skipping missing ones
+func (h *BotHandler) getSessionQuestions(questionIDs []primitive.ObjectID, snapshots []models.Question) []models.Question {
 	var questions []models.Question
-	for _, questionID := range session.QuestionIDs {
+	for _, questionID := range questionIDs {
+		if question, ok := models.FindQuestion(snapshots, questionID); ok {
+			questions = append(questions, *question)
+			continue
+		}
 		question, err := h.questionRepo.GetByID(questionID)
 		if err != nil {
 			log.Printf("Error getting question %s: %v", questionID.Hex(), err)
@@ -281,55 +684,5 @@ func (h *BotHandler) finishTest(chatID int64, userID int64, session *ActiveSessi
 		}
 		questions = append(questions, *question)
 	}
-
-	// Calculate statistics
-	correctAnswers := 0
-	incorrectAnswers := 0
-	for _, answer := range answers {
-		if answer.IsCorrect {
-			correctAnswers++
-		} else {
-			incorrectAnswers++
-		}
-	}
-	totalQuestions := len(session.QuestionIDs)
-
-	// Calculate percentage
-	percentage := float64(session.Score) / float64(totalQuestions) * 100.0
-
-	// Send result to user (hide detailed info if manually finished)
-	var resultText string
-	if showDetailedResults {
-		// Show detailed results (when test completes naturally)
-		resultText = fmt.Sprintf(
-			"🎉 Test Completed!\n\n"+
-				"Your Score: %d/%d (%.1f%%)\n\n"+
-				"Thank you for taking the test!",
-			session.Score,
-			totalQuestions,
-			percentage,
-		)
-	} else {
-		// Hide detailed results (when manually finished)
-		resultText = "✅ Test session finished.\n\n" +
-			"Thank you for taking the test!"
-	}
-
-	h.sendMessage(chatID, resultText)
-
-	// Show menu again after test completion
-	h.sendMessageWithMenu(chatID, "Test completed! Use menu to start a new test or view results.")
-
-	// Send notification to admin
-	h.sendAdminNotification(userID, session.SessionID, correctAnswers, incorrectAnswers, totalQuestions, answers, questions)
-
-	// Delete results.csv file to save space
-	h.deleteResultsCSV()
-
-	// Log result to console
-	log.Printf("Test completed - UserID: %d, SessionID: %s, Score: %d/%d (%.1f%%)",
-		userID, session.SessionID.Hex(), session.Score, totalQuestions, percentage)
-
-	// Remove active session
-	delete(h.activeSessions, userID)
+	return questions
 }
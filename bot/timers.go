@@ -0,0 +1,161 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/andru_bot/tg-bot/models"
+	"github.com/andru_bot/tg-bot/termination"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// nextDeadline returns the nearest of the question and test deadlines, nil if the session has none
+func (s *ActiveSession) nextDeadline() *time.Time {
+	deadline := s.ExpiresAt
+	if s.QuestionDeadline != nil && (deadline == nil || s.QuestionDeadline.Before(*deadline)) {
+		deadline = s.QuestionDeadline
+	}
+	return deadline
+}
+
+// deadlinePassed reports whether the question or test time limit of the session has run out
+func (s *ActiveSession) deadlinePassed(now time.Time) bool {
+	deadline := s.nextDeadline()
+	return deadline != nil && !now.Before(*deadline)
+}
+
+// testExpired reports whether the time limit of the whole test has run out
+func (s *ActiveSession) testExpired(now time.Time) bool {
+	return s.ExpiresAt != nil && !now.Before(*s.ExpiresAt)
+}
+
+// armTimer (re)starts the timer that enforces the nearest deadline of the session
+func (h *BotHandler) armTimer(userID int64, session *ActiveSession) {
+	if session.timer != nil {
+		session.timer.Stop()
+		session.timer = nil
+	}
+
+	deadline := session.nextDeadline()
+	if deadline == nil {
+		return
+	}
+
+	sessionID := session.SessionID
+	session.timer = time.AfterFunc(time.Until(*deadline), func() {
+		// Run on the user's worker so the deadline never races with the user's own updates
+		h.dispatcher.Submit(userID, func() {
+			// The session may have been finished or replaced while the timer was pending
+			current := h.getActiveSession(userID)
+			if current == nil || current.SessionID != sessionID {
+				return
+			}
+			h.handleDeadline(current.ChatID, userID, current)
+		})
+	})
+}
+
+// startQuestionTimer sets the deadline of the question about to be shown, unless it already has one
+// (a resumed question keeps its original deadline). The deadline never extends past the end of the test
+func (h *BotHandler) startQuestionTimer(session *ActiveSession, now time.Time) {
+	if session.Test.QuestionTimeLimit <= 0 || session.QuestionDeadline != nil {
+		return
+	}
+
+	deadline := now.Add(session.Test.QuestionTimeLimit)
+	if session.ExpiresAt != nil && session.ExpiresAt.Before(deadline) {
+		deadline = *session.ExpiresAt
+	}
+	session.QuestionDeadline = &deadline
+
+	err := h.sessionRepo.SetQuestionDeadline(session.SessionID, deadline)
+	if err != nil {
+		log.Printf("Error saving question deadline: %v", err)
+	}
+}
+
+// handleDeadline enforces the time limits of a session
+// An expired test is finished; an expired question is recorded as timed out and the test moves on
+func (h *BotHandler) handleDeadline(chatID int64, userID int64, session *ActiveSession) {
+	now := time.Now()
+	switch {
+	case session.testExpired(now):
+		decision, _ := termination.TimeExhausted{}.Check(termination.Progress{ExpiresAt: session.ExpiresAt, Now: now})
+		h.removeQuestionKeyboard(chatID, session)
+		h.sendMessage(chatID, "⏰ Time is up! The test is over.")
+		h.finishTest(chatID, userID, session, decision.Status, decision.Reason)
+	case session.QuestionDeadline != nil && !now.Before(*session.QuestionDeadline):
+		h.timeoutQuestion(chatID, userID, session)
+	default:
+		// Not due yet (e.g. the deadline moved while the timer was pending)
+		h.armTimer(userID, session)
+	}
+}
+
+// timeoutQuestion records the current question as unanswered and advances to the next one
+// A timeout is graded as incorrect but does not count towards consecutive errors
+func (h *BotHandler) timeoutQuestion(chatID int64, userID int64, session *ActiveSession) {
+	if session.CurrentIdx >= len(session.QuestionIDs) {
+		return
+	}
+
+	answer := &models.Answer{
+		ID:         primitive.NewObjectID(),
+		SessionID:  session.SessionID,
+		UserID:     session.UserID,
+		QuestionID: session.QuestionIDs[session.CurrentIdx],
+		TimedOut:   true,
+		AnsweredAt: time.Now(),
+	}
+	err := h.answerRepo.Create(answer)
+	if err != nil {
+		log.Printf("Error saving timed out answer: %v", err)
+	}
+
+	h.removeQuestionKeyboard(chatID, session)
+	h.sendMessage(chatID, "⏰ Time is up for this question.")
+
+	h.advance(chatID, userID, session)
+}
+
+// removeQuestionKeyboard removes the answer buttons from the current question message
+func (h *BotHandler) removeQuestionKeyboard(chatID int64, session *ActiveSession) {
+	if session.QuestionMessageID == 0 {
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageReplyMarkup(chatID, session.QuestionMessageID, tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{},
+	})
+	_, err := h.bot.Request(edit)
+	if err != nil {
+		log.Printf("Error removing question keyboard: %v", err)
+	}
+	session.QuestionMessageID = 0
+}
+
+// formatTimeLeft returns the remaining time lines shown under a question, empty without time limits
+func formatTimeLeft(session *ActiveSession, now time.Time) string {
+	var text string
+	if session.QuestionDeadline != nil {
+		text += fmt.Sprintf("\n⏱ Time to answer: %s", formatDuration(session.QuestionDeadline.Sub(now)))
+	}
+	if session.ExpiresAt != nil {
+		text += fmt.Sprintf("\n⌛ Test time left: %s", formatDuration(session.ExpiresAt.Sub(now)))
+	}
+	return text
+}
+
+// formatDuration formats a duration as m:ss (or h:mm:ss), rounded up to whole seconds
+func formatDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
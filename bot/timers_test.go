@@ -0,0 +1,143 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andru_bot/tg-bot/database"
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNextDeadline(t *testing.T) {
+	now := time.Now()
+	soon, later := now.Add(time.Minute), now.Add(time.Hour)
+	past := now.Add(-time.Second)
+
+	tests := []struct {
+		name        string
+		expiresAt   *time.Time
+		question    *time.Time
+		want        *time.Time
+		wantPassed  bool
+		wantExpired bool
+	}{
+		{"no limits", nil, nil, nil, false, false},
+		{"test limit only", &later, nil, &later, false, false},
+		{"question limit only", nil, &soon, &soon, false, false},
+		{"question ends first", &later, &soon, &soon, false, false},
+		{"test ends first", &soon, &later, &soon, false, false},
+		{"question deadline passed", &later, &past, &past, true, false},
+		{"test deadline passed", &past, &later, &past, true, true},
+		{"both deadlines passed", &past, &past, &past, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &ActiveSession{ExpiresAt: tt.expiresAt, QuestionDeadline: tt.question}
+			got := session.nextDeadline()
+			if (got == nil) != (tt.want == nil) || (got != nil && !got.Equal(*tt.want)) {
+				t.Errorf("nextDeadline() = %v, want %v", got, tt.want)
+			}
+			if passed := session.deadlinePassed(now); passed != tt.wantPassed {
+				t.Errorf("deadlinePassed() = %v, want %v", passed, tt.wantPassed)
+			}
+			if expired := session.testExpired(now); expired != tt.wantExpired {
+				t.Errorf("testExpired() = %v, want %v", expired, tt.wantExpired)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0:00"},
+		{-time.Second, "0:00"},
+		{time.Millisecond, "0:01"}, // Rounded up, the time is not over yet
+		{59 * time.Second, "0:59"},
+		{90 * time.Second, "1:30"},
+		{time.Hour + 2*time.Minute + 3*time.Second, "1:02:03"},
+	}
+	for _, tt := range tests {
+		if got := formatDuration(tt.d); got != tt.want {
+			t.Errorf("formatDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+// waitFinished waits until the deadline timers have finished the session and returns it
+func waitFinished(t *testing.T, repos *database.Repositories, sessionID primitive.ObjectID) *models.Session {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		session, err := repos.Sessions.GetByID(sessionID)
+		if err != nil {
+			t.Fatalf("GetByID() error: %v", err)
+		}
+		if session.Status != models.StatusInProgress {
+			return session
+		}
+	}
+	t.Fatal("session not finished by its time limit")
+	return nil
+}
+
+func TestTimeLimits(t *testing.T) {
+	const telegramID = 42
+
+	tests := []struct {
+		name              string
+		questionTimeLimit time.Duration
+		testTimeLimit     time.Duration
+		answered          int // Questions answered (correctly) before waiting for the timers
+		wantTimedOut      int
+	}{
+		{"every question times out", 20 * time.Millisecond, 0, 0, 3},
+		{"remaining questions time out", 20 * time.Millisecond, 0, 2, 1},
+		{"test time runs out", 0, 50 * time.Millisecond, 1, 0},
+		{"test time ends the question early", time.Hour, 50 * time.Millisecond, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, repos := newTestHandler(t)
+			h.defaultTest.QuestionTimeLimit = tt.questionTimeLimit
+			h.defaultTest.TimeLimit = tt.testTimeLimit
+
+			session := startTestSession(t, h, repos, telegramID)
+			if (session.ExpiresAt != nil) != (tt.testTimeLimit > 0) {
+				t.Errorf("session expires at %v with a test time limit of %v", session.ExpiresAt, tt.testTimeLimit)
+			}
+			for i := 0; i < tt.answered; i++ {
+				handle(h, answerUpdate(h, telegramID, 2))
+			}
+
+			finished := waitFinished(t, repos, session.ID)
+			if finished.Status != models.StatusCompleted {
+				t.Errorf("session status = %q, want completed", finished.Status)
+			}
+			answers, err := repos.Answers.GetBySession(session.ID)
+			if err != nil {
+				t.Fatalf("GetBySession() error: %v", err)
+			}
+			if len(answers) != tt.answered+tt.wantTimedOut {
+				t.Fatalf("stored %d answers, want %d", len(answers), tt.answered+tt.wantTimedOut)
+			}
+			for i, answer := range answers {
+				if timedOut := i >= tt.answered; answer.TimedOut != timedOut || answer.IsCorrect == timedOut {
+					t.Errorf("answer %d timed out %v correct %v, want timed out %v", i, answer.TimedOut, answer.IsCorrect, timedOut)
+				}
+			}
+			if want := float64(2 * tt.answered); finished.TotalScore != want {
+				t.Errorf("session score = %v, want %v", finished.TotalScore, want)
+			}
+
+			h.notifications.Wait()
+			// The stored session is finished before the in-memory one is dropped
+			onWorker(h, telegramID, func() {})
+			if h.getActiveSession(telegramID) != nil {
+				t.Error("session still active after its time limit")
+			}
+		})
+	}
+}
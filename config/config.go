@@ -6,6 +6,11 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/andru_bot/tg-bot/models"
+	"github.com/andru_bot/tg-bot/scoring"
+	"github.com/andru_bot/tg-bot/termination"
 )
 
 // GetMaxConsecutiveErrors returns the maximum allowed consecutive errors before test failure
@@ -30,6 +35,262 @@ func GetMaxConsecutiveErrors() int {
 	return maxErrors
 }
 
+// GetTerminationPolicies returns the rules that end a test early, see termination.Parse
+// Defaults to MAX_CONSECUTIVE_ERRORS consecutive errors plus the test time limit if TERMINATION_POLICY
+// is not set or invalid
+func GetTerminationPolicies() termination.Policies {
+	defaults := termination.Policies{
+		termination.ConsecutiveErrors{Max: GetMaxConsecutiveErrors()},
+		termination.TimeExhausted{},
+	}
+
+	spec := strings.TrimSpace(os.Getenv("TERMINATION_POLICY"))
+	if spec == "" {
+		return defaults
+	}
+
+	policies, err := termination.Parse(spec)
+	if err != nil {
+		log.Printf("Error parsing TERMINATION_POLICY: %v, using default %q", err, defaults)
+		return defaults
+	}
+	return policies
+}
+
+// GetScoringConfig returns the scoring scheme of tests
+// NEGATIVE_MARKING is the share of a question's weight deducted for a wrong answer (0 to 1, default 0)
+// PASS_THRESHOLD is the percentage of the maximum score needed to pass (0 to 100, default 0, no pass/fail)
+// PARTIAL_CREDIT is the partial credit scheme of multiple questions (none, right_minus_wrong or per_option, default none)
+func GetScoringConfig() scoring.Config {
+	return scoring.Config{
+		NegativeMarking: getFloatInRange("NEGATIVE_MARKING", 0, 1),
+		PassThreshold:   getFloatInRange("PASS_THRESHOLD", 0, 100),
+		PartialCredit:   GetPartialCredit(),
+	}
+}
+
+// GetPartialCredit returns the partial credit scheme of multiple questions
+// Defaults to none (all or nothing) if PARTIAL_CREDIT is not set or invalid
+func GetPartialCredit() string {
+	scheme, ok := scoring.NormalizePartialCredit(os.Getenv("PARTIAL_CREDIT"))
+	if !ok {
+		log.Printf("Unknown PARTIAL_CREDIT '%s', using default value %s", scheme, scoring.PartialCreditNone)
+		return ""
+	}
+	return scheme
+}
+
+// getFloatInRange parses a number environment variable between min and max, 0 if not set or invalid
+func getFloatInRange(name string, min, max float64) float64 {
+	valueStr := strings.TrimSpace(os.Getenv(name))
+	if valueStr == "" {
+		return 0
+	}
+
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil || value < min || value > max {
+		log.Printf("%s must be a number between %g and %g, using 0", name, min, max)
+		return 0
+	}
+
+	return value
+}
+
+// GetLevelCutScores returns the per-level cut scores used for CEFR placement
+// LEVEL_CUT_SCORES is a comma-separated list of LEVEL:PERCENT pairs, e.g. "A1:60,A2:60,B1:65"
+// Levels that are not listed (or invalid entries) use the default cut score
+func GetLevelCutScores() map[string]float64 {
+	cutScores := make(map[string]float64)
+
+	cutScoresStr := os.Getenv("LEVEL_CUT_SCORES")
+	if cutScoresStr == "" {
+		return cutScores
+	}
+
+	for _, part := range strings.Split(cutScoresStr, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		levelStr, percentStr, found := strings.Cut(part, ":")
+		if !found {
+			log.Printf("Error parsing LEVEL_CUT_SCORES entry '%s': expected LEVEL:PERCENT, skipping", part)
+			continue
+		}
+		level, ok := models.NormalizeLevel(levelStr)
+		if !ok {
+			log.Printf("Error parsing LEVEL_CUT_SCORES entry '%s': unknown CEFR level, skipping", part)
+			continue
+		}
+		percent, err := strconv.ParseFloat(strings.TrimSpace(percentStr), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			log.Printf("Error parsing LEVEL_CUT_SCORES entry '%s': percent must be between 0 and 100, skipping", part)
+			continue
+		}
+		cutScores[level] = percent
+	}
+
+	return cutScores
+}
+
+// GetTestMode returns the test mode, defaults to "linear"
+// "linear" asks every question in order, "adaptive" picks questions based on the candidate's answers
+func GetTestMode() string {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("TEST_MODE")))
+	switch mode {
+	case "", models.ModeLinear:
+		return models.ModeLinear
+	case models.ModeAdaptive:
+		return models.ModeAdaptive
+	default:
+		log.Printf("Unknown TEST_MODE '%s', using default value linear", mode)
+		return models.ModeLinear
+	}
+}
+
+// GetAdaptiveMinQuestions returns the minimum number of questions in an adaptive test
+// Defaults to 5 if ADAPTIVE_MIN_QUESTIONS is not set or invalid
+func GetAdaptiveMinQuestions() int {
+	return getPositiveInt("ADAPTIVE_MIN_QUESTIONS", 5)
+}
+
+// GetAdaptiveMaxQuestions returns the maximum number of questions in an adaptive test
+// Defaults to 30 if ADAPTIVE_MAX_QUESTIONS is not set or invalid
+func GetAdaptiveMaxQuestions() int {
+	return getPositiveInt("ADAPTIVE_MAX_QUESTIONS", 30)
+}
+
+// GetAdaptiveTargetSE returns the standard error of the ability estimate at which an adaptive test stops
+// Defaults to 0.4 if ADAPTIVE_TARGET_SE is not set or invalid
+func GetAdaptiveTargetSE() float64 {
+	targetStr := os.Getenv("ADAPTIVE_TARGET_SE")
+	if targetStr == "" {
+		return 0.4
+	}
+
+	target, err := strconv.ParseFloat(targetStr, 64)
+	if err != nil || target <= 0 {
+		log.Printf("ADAPTIVE_TARGET_SE must be a positive number, using default value 0.4")
+		return 0.4
+	}
+
+	return target
+}
+
+// getPositiveInt parses a positive integer environment variable, falling back to defaultValue
+func getPositiveInt(name string, defaultValue int) int {
+	valueStr := os.Getenv(name)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		log.Printf("Error parsing %s: %v, using default value %d", name, err, defaultValue)
+		return defaultValue
+	}
+
+	if value < 1 {
+		log.Printf("%s must be at least 1, using default value %d", name, defaultValue)
+		return defaultValue
+	}
+
+	return value
+}
+
+// GetShuffleQuestions reports whether question order is randomized per session (SHUFFLE_QUESTIONS, default false)
+func GetShuffleQuestions() bool {
+	return getBool("SHUFFLE_QUESTIONS", false)
+}
+
+// GetShuffleOptions reports whether answer option order is randomized per question (SHUFFLE_OPTIONS, default false)
+func GetShuffleOptions() bool {
+	return getBool("SHUFFLE_OPTIONS", false)
+}
+
+// GetQuestionsSync reports whether the question bank is synced with questions.json on startup (QUESTIONS_SYNC, default true)
+// When disabled the file is only imported into an empty bank
+func GetQuestionsSync() bool {
+	return getBool("QUESTIONS_SYNC", true)
+}
+
+// GetMediaDir returns the directory of the audio and image files referenced by questions, defaults to "media"
+func GetMediaDir() string {
+	dir := os.Getenv("MEDIA_DIR")
+	if dir == "" {
+		return "media"
+	}
+	return dir
+}
+
+// getBool parses a boolean environment variable, falling back to defaultValue
+func getBool(name string, defaultValue bool) bool {
+	valueStr := os.Getenv(name)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseBool(strings.TrimSpace(valueStr))
+	if err != nil {
+		log.Printf("Error parsing %s: %v, using default value %t", name, err, defaultValue)
+		return defaultValue
+	}
+
+	return value
+}
+
+// GetQuestionTimeLimit returns the time allowed for each question, 0 means no limit
+// QUESTION_TIME_LIMIT is a duration such as "45s" or "2m"; a plain number is read as seconds
+func GetQuestionTimeLimit() time.Duration {
+	return getDuration("QUESTION_TIME_LIMIT")
+}
+
+// GetTestTimeLimit returns the time allowed for the whole test, 0 means no limit
+// TEST_TIME_LIMIT is a duration such as "30m" or "1h"; a plain number is read as seconds
+func GetTestTimeLimit() time.Duration {
+	return getDuration("TEST_TIME_LIMIT")
+}
+
+// getDuration parses a non-negative duration environment variable, 0 if not set or invalid
+func getDuration(name string) time.Duration {
+	valueStr := strings.TrimSpace(os.Getenv(name))
+	if valueStr == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(valueStr); err == nil {
+		valueStr = fmt.Sprintf("%ds", seconds)
+	}
+
+	value, err := time.ParseDuration(valueStr)
+	if err != nil || value < 0 {
+		log.Printf("%s must be a non-negative duration like \"90s\" or \"30m\", time limit disabled", name)
+		return 0
+	}
+
+	return value
+}
+
+// GetDefaultTest returns the test defined by the environment settings
+// It is the only test of the catalog when tests.json defines none, and its settings are the
+// defaults of every setting a test in tests.json leaves out
+func GetDefaultTest() models.Test {
+	scoringConfig := GetScoringConfig()
+	return models.Test{
+		Key:               models.DefaultTestKey,
+		Name:              "English Level Test",
+		Mode:              GetTestMode(),
+		ShuffleQuestions:  GetShuffleQuestions(),
+		ShuffleOptions:    GetShuffleOptions(),
+		NegativeMarking:   scoringConfig.NegativeMarking,
+		PassThreshold:     scoringConfig.PassThreshold,
+		PartialCredit:     scoringConfig.PartialCredit,
+		TerminationPolicy: GetTerminationPolicies().String(),
+		QuestionTimeLimit: GetQuestionTimeLimit(),
+		TimeLimit:         GetTestTimeLimit(),
+	}
+}
+
 // GetTelegramBotToken returns the Telegram bot token from environment
 // Returns error if TELEGRAM_BOT_TOKEN is not set (required)
 func GetTelegramBotToken() (string, error) {
@@ -40,6 +301,127 @@ func GetTelegramBotToken() (string, error) {
 	return token, nil
 }
 
+// GetSessionIdleTimeout returns how long a session may go without activity before it expires
+// Defaults to 24h if SESSION_IDLE_TIMEOUT is not set, 0 disables expiry
+func GetSessionIdleTimeout() time.Duration {
+	if strings.TrimSpace(os.Getenv("SESSION_IDLE_TIMEOUT")) == "" {
+		return 24 * time.Hour
+	}
+	return getDuration("SESSION_IDLE_TIMEOUT")
+}
+
+// GetShutdownTimeout returns how long shutdown waits for queued updates and admin notifications
+// Defaults to 30s if SHUTDOWN_TIMEOUT is not set or invalid
+func GetShutdownTimeout() time.Duration {
+	timeout := getDuration("SHUTDOWN_TIMEOUT")
+	if timeout <= 0 {
+		return 30 * time.Second
+	}
+	return timeout
+}
+
+// GetWorkerCount returns the number of workers processing updates concurrently
+// Defaults to 16 if WORKER_COUNT is not set or invalid
+func GetWorkerCount() int {
+	return getPositiveInt("WORKER_COUNT", 16)
+}
+
+// GetCallbackSecret returns the key used to sign answer buttons, empty if CALLBACK_SECRET is not set
+// When empty the bot derives a key from its token
+func GetCallbackSecret() string {
+	return os.Getenv("CALLBACK_SECRET")
+}
+
+// Bot modes
+const (
+	BotModePolling = "polling"
+	BotModeWebhook = "webhook"
+)
+
+// GetBotMode returns how updates are received: "polling" (default) or "webhook"
+func GetBotMode() string {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("BOT_MODE")))
+	switch mode {
+	case "", BotModePolling:
+		return BotModePolling
+	case BotModeWebhook:
+		return BotModeWebhook
+	default:
+		log.Printf("Unknown BOT_MODE %q, using %s", mode, BotModePolling)
+		return BotModePolling
+	}
+}
+
+// GetWebhookURL returns the public HTTPS URL Telegram sends updates to in webhook mode
+// Returns error if WEBHOOK_URL is not set
+func GetWebhookURL() (string, error) {
+	webhookURL := os.Getenv("WEBHOOK_URL")
+	if webhookURL == "" {
+		return "", fmt.Errorf("WEBHOOK_URL environment variable is required in webhook mode")
+	}
+	return webhookURL, nil
+}
+
+// GetWebhookSecret returns the secret token Telegram sends with webhook requests, empty if WEBHOOK_SECRET is not set
+// When empty the bot derives a token from its bot token
+func GetWebhookSecret() string {
+	return os.Getenv("WEBHOOK_SECRET")
+}
+
+// GetListenAddr returns the address of the HTTP server (webhook, /healthz, /metrics)
+// Defaults to ":8080" in webhook mode; in polling mode the server only starts when LISTEN_ADDR is set
+func GetListenAddr() string {
+	addr := os.Getenv("LISTEN_ADDR")
+	if addr == "" && GetBotMode() == BotModeWebhook {
+		return ":8080"
+	}
+	return addr
+}
+
+// GetTLSCertFile returns the certificate file for serving HTTPS directly, empty for plain HTTP
+func GetTLSCertFile() string {
+	return os.Getenv("TLS_CERT_FILE")
+}
+
+// GetTLSKeyFile returns the private key file for serving HTTPS directly, empty for plain HTTP
+func GetTLSKeyFile() string {
+	return os.Getenv("TLS_KEY_FILE")
+}
+
+// GetTelegramAPIEndpoint returns the Bot API endpoint format, defaults to the public Telegram API
+// Set TELEGRAM_API_ENDPOINT (e.g. "http://localhost:8081/bot%s/%s") to use a local Bot API server or a fake
+func GetTelegramAPIEndpoint() string {
+	endpoint := os.Getenv("TELEGRAM_API_ENDPOINT")
+	if endpoint == "" {
+		return "https://api.telegram.org/bot%s/%s"
+	}
+	return endpoint
+}
+
+// GetTelegramFileEndpoint returns the file download endpoint format matching GetTelegramAPIEndpoint
+func GetTelegramFileEndpoint() string {
+	return strings.Replace(GetTelegramAPIEndpoint(), "/bot%s/", "/file/bot%s/", 1)
+}
+
+// GetStorageDriver returns the storage backend name, defaults to "mongo"
+// Supported values: "mongo", "bolt", "memory"
+func GetStorageDriver() string {
+	driver := strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_DRIVER")))
+	if driver == "" {
+		return "mongo"
+	}
+	return driver
+}
+
+// GetBoltPath returns the bolt database file path, defaults to "data/bot.db"
+func GetBoltPath() string {
+	path := os.Getenv("BOLT_PATH")
+	if path == "" {
+		return "data/bot.db"
+	}
+	return path
+}
+
 // GetMongoDBURI returns the MongoDB connection URI
 // If MONGODB_URI is set, returns it directly
 // Otherwise, builds URI from individual environment variables with defaults
@@ -3,91 +3,256 @@ package csv
 import (
 	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/andru_bot/tg-bot/models"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// legacyColumns maps column names to positions in the original 12-column format:
+// text, text_html, answer_1, answer_1_html, answer_2, answer_2_html, answer_3, answer_3_html, answer_4, answer_4_html, correct_answer_id, score
+var legacyColumns = map[string]int{
+	"text":              0,
+	"text_html":         1,
+	"answer_1":          2,
+	"answer_2":          4,
+	"answer_3":          6,
+	"answer_4":          8,
+	"correct_answer_id": 10,
+	"score":             11,
+}
+
+// requiredColumns must be present in the header (the *_html answer columns are accepted but ignored)
+var requiredColumns = []string{"text", "answer_1", "answer_2", "correct_answer_id", "score"}
+
+// answerColumn returns the name of the column holding the option with the given answer ID
+func answerColumn(answerID int) string {
+	return "answer_" + strconv.Itoa(answerID)
+}
+
+// matchColumn returns the name of the column holding the match of the option with the given answer ID
+func matchColumn(answerID int) string {
+	return "match_" + strconv.Itoa(answerID)
+}
+
+// columnIndex resolves column positions from the header row
+// Optional columns (id, text_html, answer_3 ... answer_10, match_1 ... match_10, type, audio, image, passage_id, passage_title,
+// passage_text, accepted_answers, tolerance, level, difficulty, tags) may be omitted;
+// files whose header does not name the required columns are read using the legacy positional format
+func columnIndex(header []string) map[string]int {
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range requiredColumns {
+		if _, ok := columns[name]; !ok {
+			return legacyColumns
+		}
+	}
+	return columns
+}
+
 // LoadQuestions loads questions from CSV file
-func LoadQuestions(filename string) ([]models.Question, error) {
+// Invalid rows are reported together as models.RowErrors, see ParseRecords
+func LoadQuestions(filename string, checks ...models.QuestionCheck) ([]models.Question, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open CSV file: %w", err)
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
+	return ReadQuestions(file, checks...)
+}
+
+// ReadQuestions reads questions in CSV format from r
+func ReadQuestions(r io.Reader, checks ...models.QuestionCheck) ([]models.Question, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // Optional trailing columns may be missing
 	records, err := reader.ReadAll()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read CSV file: %w", err)
 	}
 
+	return ParseRecords(records, checks...)
+}
+
+// ParseRecords converts rows of a question table (header first) into questions
+// Every invalid row is skipped and reported; if any row is invalid the valid questions
+// are returned together with a models.RowErrors error listing all invalid rows
+// Questions failing one of the checks count as invalid. The questions of a reading passage share its
+// passage_id; its title and text are given once, on the first of its rows
+func ParseRecords(records [][]string, checks ...models.QuestionCheck) ([]models.Question, error) {
 	if len(records) < 2 {
-		return nil, fmt.Errorf("CSV file must have at least a header and one question")
+		return nil, fmt.Errorf("file must have at least a header and one question")
+	}
+
+	columns := columnIndex(records[0])
+	minColumns := 0
+	for _, name := range requiredColumns {
+		if columns[name]+1 > minColumns {
+			minColumns = columns[name] + 1
+		}
 	}
 
 	var questions []models.Question
+	var rowErrors models.RowErrors
+	passages := make(map[string]*models.Passage)
 	for i, record := range records[1:] { // Skip header
-		// New CSV format: text, text_html, answer_1, answer_1_html, answer_2, answer_2_html, answer_3, answer_3_html, answer_4, answer_4_html, correct_answer_id, score
-		// We only need: text, answer_1, answer_2, answer_3, answer_4, correct_answer_id, score
-		if len(record) < 12 {
-			return nil, fmt.Errorf("invalid CSV format at line %d: expected at least 12 columns (text, text_html, answer_1, answer_1_html, answer_2, answer_2_html, answer_3, answer_3_html, answer_4, answer_4_html, correct_answer_id, score)", i+2)
+		if isBlank(record) {
+			continue
+		}
+		question, err := parseRecord(record, columns, minColumns, passages)
+		if err == nil {
+			err = models.RunChecks(question, checks)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, models.RowError{Row: i + 2, Message: err.Error()})
+			continue
 		}
+		questions = append(questions, *question)
+	}
 
-		// Extract fields from new format
-		text := record[0]                // text
-		textHTML := record[1]            // text_html
-		answer1 := record[2]             // answer_1
-		answer2 := record[4]             // answer_2 (skip answer_1_html at index 3)
-		answer3 := record[6]             // answer_3 (skip answer_2_html at index 5)
-		answer4 := record[8]             // answer_4 (skip answer_3_html at index 7)
-		correctAnswerIDStr := record[10] // correct_answer_id (skip answer_4_html at index 9)
-		scoreStr := record[11]           // score
+	if len(rowErrors) > 0 {
+		return questions, rowErrors
+	}
+	return questions, nil
+}
 
-		// Answer4 is optional - can be empty string for 3-answer questions
-		if answer4 == "" {
-			answer4 = ""
+// parseRecord converts one row into a validated question
+// passages holds the passages given on earlier rows, a passage given on this row is added
+func parseRecord(record []string, columns map[string]int, minColumns int, passages map[string]*models.Passage) (*models.Question, error) {
+	if len(record) < minColumns {
+		return nil, fmt.Errorf("expected at least %d columns (%s)", minColumns, strings.Join(requiredColumns, ", "))
+	}
+
+	// field returns the value of a column, or "" if the column is absent
+	field := func(name string) string {
+		idx, ok := columns[name]
+		if !ok || idx >= len(record) {
+			return ""
 		}
+		return record[idx]
+	}
 
-		// Parse correct answer ID
-		correctAnswerID, err := strconv.Atoi(correctAnswerIDStr)
-		if err != nil {
-			return nil, fmt.Errorf("invalid correct_answer_id at line %d: %w", i+2, err)
+	// Parse the optional passage first, so it is known to later rows even if this one is invalid
+	var passage *models.Passage
+	if key := strings.TrimSpace(field("passage_id")); key != "" {
+		passage = &models.Passage{Key: key, Title: strings.TrimSpace(field("passage_title")), Text: field("passage_text")}
+		if known, ok := passages[key]; ok {
+			if (passage.Text != "" && passage.Text != known.Text) || (passage.Title != "" && passage.Title != known.Title) {
+				return nil, fmt.Errorf("passage %q has a different title or text than on its first row", key)
+			}
+			passage = known
+		} else if strings.TrimSpace(passage.Text) != "" {
+			passages[key] = passage
 		}
+	}
+
+	// Parse optional question type
+	questionType, ok := models.NormalizeQuestionType(field("type"))
+	if !ok {
+		return nil, fmt.Errorf("invalid type %q: must be one of %s", field("type"), strings.Join(models.QuestionTypes, ", "))
+	}
+
+	// Collect the options, trailing empty answer columns are unused
+	answers := make([]string, models.MaxOptions)
+	matches := make([]string, models.MaxOptions)
+	for i := range answers {
+		answers[i] = field(answerColumn(i + 1))
+		matches[i] = field(matchColumn(i + 1))
+	}
 
-		// Validate correct answer ID is within range
-		maxAnswerID := 3
-		if answer4 != "" {
-			maxAnswerID = 4
+	// Parse correct answer ID, a list separated by "," or ";" for multiple questions, left empty for
+	// typed-answer, order and match questions
+	var correctAnswerID int
+	var correctAnswerIDs []int
+	var err error
+	correctStr := strings.TrimSpace(field("correct_answer_id"))
+	switch {
+	case questionType == models.QuestionTypeMultiple:
+		for _, idStr := range strings.FieldsFunc(correctStr, func(r rune) bool { return r == ',' || r == ';' }) {
+			id, err := strconv.Atoi(strings.TrimSpace(idStr))
+			if err != nil {
+				return nil, fmt.Errorf("invalid correct_answer_id %q", field("correct_answer_id"))
+			}
+			correctAnswerIDs = append(correctAnswerIDs, id)
 		}
-		if correctAnswerID < 1 || correctAnswerID > maxAnswerID {
-			return nil, fmt.Errorf("invalid correct_answer_id at line %d: must be between 1 and %d (question has %d answers)", i+2, maxAnswerID, maxAnswerID)
+	case correctStr != "" || questionType == models.QuestionTypeChoice:
+		if correctAnswerID, err = strconv.Atoi(correctStr); err != nil {
+			return nil, fmt.Errorf("invalid correct_answer_id %q", field("correct_answer_id"))
 		}
+	}
 
-		// Parse score
-		score, err := strconv.Atoi(scoreStr)
-		if err != nil {
-			return nil, fmt.Errorf("invalid score at line %d: %w", i+2, err)
+	// Parse optional typo tolerance of typed answers
+	var tolerance int
+	if toleranceStr := strings.TrimSpace(field("tolerance")); toleranceStr != "" {
+		if tolerance, err = strconv.Atoi(toleranceStr); err != nil {
+			return nil, fmt.Errorf("invalid tolerance %q", toleranceStr)
+		}
+	}
+
+	// Parse score
+	score, err := strconv.Atoi(strings.TrimSpace(field("score")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid score %q", field("score"))
+	}
+
+	// Parse optional CEFR level
+	level := field("level")
+	if strings.TrimSpace(level) != "" {
+		var ok bool
+		if level, ok = models.NormalizeLevel(level); !ok {
+			return nil, fmt.Errorf("invalid level %q: must be one of %s", field("level"), strings.Join(models.CEFRLevels, ", "))
 		}
+	}
 
-		question := models.Question{
-			ID:              primitive.NewObjectID(),
-			Text:            text,
-			TextHTML:        textHTML,
-			Answer1:         answer1,
-			Answer2:         answer2,
-			Answer3:         answer3,
-			Answer4:         answer4,
-			CorrectAnswerID: correctAnswerID,
-			Score:           score,
+	// Parse optional Rasch difficulty
+	var difficulty *float64
+	if difficultyStr := strings.TrimSpace(field("difficulty")); difficultyStr != "" {
+		value, err := strconv.ParseFloat(difficultyStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid difficulty %q", difficultyStr)
 		}
+		difficulty = &value
+	}
 
-		questions = append(questions, question)
+	question := &models.Question{
+		ID:               primitive.NewObjectID(),
+		ExternalID:       strings.TrimSpace(field("id")),
+		Text:             field("text"),
+		TextHTML:         field("text_html"),
+		Options:          models.OptionsFromAnswers(answers...),
+		CorrectAnswerID:  correctAnswerID,
+		CorrectAnswerIDs: correctAnswerIDs,
+		Matches:          models.OptionsFromAnswers(matches...),
+		Type:             questionType,
+		AcceptedAnswers:  models.ParseAcceptedAnswers(field("accepted_answers")), // Separated by "|"
+		Tolerance:        tolerance,
+		Audio:            strings.TrimSpace(field("audio")),
+		Image:            strings.TrimSpace(field("image")),
+		Passage:          passage,
+		Score:            score,
+		Level:            level,
+		Difficulty:       difficulty,
+		Tags:             models.ParseTags(field("tags")), // Optional, separated by "," or ";"
+	}
+	if err := question.Validate(); err != nil {
+		return nil, err
 	}
+	return question, nil
+}
 
-	return questions, nil
+// isBlank reports whether every cell of a row is empty
+func isBlank(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
 }
 
 // SaveResults saves test results to CSV file
@@ -110,7 +275,7 @@ func SaveResults(filename string, sessionID primitive.ObjectID, answers []models
 	// Write header if file is empty
 	stat, _ := file.Stat()
 	if stat.Size() == 0 {
-		header := []string{"session_id", "question_text", "answer_1", "answer_2", "answer_3", "answer_4", "correct_answer_id", "user_answer_id", "is_correct", "score"}
+		header := []string{"session_id", "question_text", "answer_1", "answer_2", "answer_3", "answer_4", "correct_answer_id", "user_answer_id", "is_correct", "score", "level", "user_answer_text", "options", "matches"}
 		if err := writer.Write(header); err != nil {
 			return fmt.Errorf("failed to write header: %w", err)
 		}
@@ -123,17 +288,29 @@ func SaveResults(filename string, sessionID primitive.ObjectID, answers []models
 			continue
 		}
 
+		// answer_1 ... answer_4 keep the columns of earlier files, options lists every option
+		// Order and match answers are sequences of answer IDs, see models.Answer.SelectedAnswerIDs
+		correctAnswerID := strconv.Itoa(question.CorrectAnswerID)
+		userAnswerID := strconv.Itoa(answer.SelectedAnswerID)
+		if question.HasPartialCredit() {
+			correctAnswerID = joinIDs(question.CorrectIDs())
+			userAnswerID = joinIDs(answer.SelectedAnswerIDs)
+		}
 		record := []string{
 			sessionID.Hex(),
 			question.Text,
-			question.Answer1,
-			question.Answer2,
-			question.Answer3,
-			question.Answer4,
-			strconv.Itoa(question.CorrectAnswerID),
-			strconv.Itoa(answer.SelectedAnswerID),
+			question.GetAnswer(1),
+			question.GetAnswer(2),
+			question.GetAnswer(3),
+			question.GetAnswer(4),
+			correctAnswerID,
+			userAnswerID,
 			strconv.FormatBool(answer.IsCorrect),
-			strconv.Itoa(answer.Score),
+			strconv.FormatFloat(answer.Score, 'f', -1, 64),
+			question.Level,
+			answer.TextAnswer,
+			strings.Join(question.Options, " | "),
+			strings.Join(question.Matches, " | "),
 		}
 
 		if err := writer.Write(record); err != nil {
@@ -143,3 +320,12 @@ func SaveResults(filename string, sessionID primitive.ObjectID, answers []models
 
 	return nil
 }
+
+// joinIDs formats answer IDs as a comma separated list
+func joinIDs(ids []int) string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = strconv.Itoa(id)
+	}
+	return strings.Join(values, ",")
+}
@@ -0,0 +1,196 @@
+package csv
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/andru_bot/tg-bot/models"
+)
+
+// rows returns the row numbers of a models.RowErrors error
+func rows(t *testing.T, err error) []int {
+	t.Helper()
+	var rowErrors models.RowErrors
+	if !errors.As(err, &rowErrors) {
+		t.Fatalf("error %v is no models.RowErrors", err)
+	}
+	var result []int
+	for _, e := range rowErrors {
+		result = append(result, e.Row)
+	}
+	return result
+}
+
+func TestReadQuestions(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		want     []string // Texts of the questions read
+		wantRows []int    // Rows reported as invalid
+	}{
+		{
+			name: "named columns in any order",
+			file: "score,correct_answer_id,text,answer_1,answer_2,answer_3,answer_4,level\n" +
+				"2,1,She ___ to school.,goes,go,going,,a2\n" +
+				"1,4,They ___ at home.,is,be,am,are,\n",
+			want: []string{"She ___ to school.", "They ___ at home."},
+		},
+		{
+			name: "legacy positional format",
+			file: "Question,HTML,A1,,A2,,A3,,A4,,Correct,Score\n" +
+				"She ___ to school.,,goes,,go,,going,,,,1,2\n",
+			want: []string{"She ___ to school."},
+		},
+		{
+			name: "invalid rows reported, valid rows kept",
+			file: "text,answer_1,answer_2,answer_3,answer_4,correct_answer_id,score,difficulty\n" +
+				"She ___ to school.,goes,go,going,,1,2,\n" +
+				"They ___ at home.,are,is,be,,x,1,\n" +
+				",,,,,,,\n" + // Blank rows are skipped
+				"I ___ a student.,am,is,are,,4,1,\n" +
+				"We ___ friends.,are,is,am,,1,1,hard\n" +
+				"short,row\n" +
+				"He ___ a doctor.,is,are,am,,1,1,0.5\n",
+			want:     []string{"She ___ to school.", "He ___ a doctor."},
+			wantRows: []int{3, 5, 6, 7},
+		},
+		{
+			name: "typed-answer questions",
+			file: "text,type,accepted_answers,tolerance,answer_1,answer_2,answer_3,answer_4,correct_answer_id,score\n" +
+				"She ___ to school.,text,goes | walks,1,,,,,,2\n" +
+				"They ___ at home.,text,,,,,,,,1\n" + // No accepted answers
+				"I ___ a student.,essay,am,,,,,,,1\n" +
+				"We ___ friends.,text,are,x,,,,,,1\n" +
+				"He ___ a doctor.,choice,,,is,are,am,,1,1\n",
+			want:     []string{"She ___ to school.", "He ___ a doctor."},
+			wantRows: []int{3, 4, 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			questions, err := ReadQuestions(strings.NewReader(tt.file))
+			if tt.wantRows == nil && err != nil {
+				t.Fatalf("ReadQuestions() error: %v", err)
+			}
+			if tt.wantRows != nil {
+				if got := rows(t, err); !slices.Equal(got, tt.wantRows) {
+					t.Errorf("ReadQuestions() invalid rows = %v, want %v", got, tt.wantRows)
+				}
+			}
+			var got []string
+			for _, q := range questions {
+				got = append(got, q.Text)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ReadQuestions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadQuestionsFields(t *testing.T) {
+	file := "id,text,text_html,answer_1,answer_2,answer_3,answer_4,correct_answer_id,score,level,difficulty\n" +
+		" q1 ,She ___ to school.,She <b>___</b> to school.,goes,go,going,gone,4,2,b1,-0.5\n"
+	questions, err := ReadQuestions(strings.NewReader(file))
+	if err != nil || len(questions) != 1 {
+		t.Fatalf("ReadQuestions() = %v, %v, want one question", questions, err)
+	}
+	q := questions[0]
+	if q.TextHTML != "She <b>___</b> to school." || q.GetAnswerCount() != 4 || q.CorrectAnswerID != 4 || q.Score != 2 {
+		t.Errorf("ReadQuestions() = %+v", q)
+	}
+	if q.ExternalID != "q1" {
+		t.Errorf("ReadQuestions() ID = %q, want q1", q.ExternalID)
+	}
+	if q.Level != "B1" || q.Difficulty == nil || *q.Difficulty != -0.5 {
+		t.Errorf("ReadQuestions() level %q difficulty %v, want B1 and -0.5", q.Level, q.Difficulty)
+	}
+}
+
+func TestReadQuestionsOptions(t *testing.T) {
+	file := "text,type,answer_1,answer_2,answer_3,answer_4,answer_5,correct_answer_id,score\n" +
+		"She ___ to school.,,goes,go,,,,1,1\n" +
+		"Which are verbs?,multiple,run,blue,eat,table,sing,\"1, 3;5\",3\n" +
+		"Which are nouns?,multiple,run,table,,,,,1\n" + // No correct answers
+		"Pick one.,multiple,a,b,,,,1;x,1\n"
+	questions, err := ReadQuestions(strings.NewReader(file))
+	if got := rows(t, err); !slices.Equal(got, []int{4, 5}) {
+		t.Errorf("ReadQuestions() invalid rows = %v, want [4 5]", got)
+	}
+	if len(questions) != 2 {
+		t.Fatalf("ReadQuestions() = %d questions, want 2", len(questions))
+	}
+	if q := questions[0]; !slices.Equal(q.Options, []string{"goes", "go"}) || q.CorrectAnswerID != 1 {
+		t.Errorf("question 1 = %+v, want two options", q)
+	}
+	q := questions[1]
+	if !q.IsMultiple() || len(q.Options) != 5 || !slices.Equal(q.CorrectIDs(), []int{1, 3, 5}) {
+		t.Errorf("question 2 = %+v, want a multiple question with five options", q)
+	}
+}
+
+func TestReadQuestionsSequences(t *testing.T) {
+	file := "text,type,answer_1,answer_2,answer_3,match_1,match_2,match_3,correct_answer_id,score\n" +
+		"Put the words in order.,order,she,is,home,,,,,3\n" +
+		"Match the sounds.,match,cat,dog,cow,meow,woof,moo,,3\n" +
+		"Match the colours.,match,sky,grass,,blue,,,,2\n" // A match missing
+	questions, err := ReadQuestions(strings.NewReader(file))
+	if got := rows(t, err); !slices.Equal(got, []int{4}) {
+		t.Errorf("ReadQuestions() invalid rows = %v, want [4]", got)
+	}
+	if len(questions) != 2 {
+		t.Fatalf("ReadQuestions() = %d questions, want 2", len(questions))
+	}
+	if q := questions[0]; q.QuestionType() != models.QuestionTypeOrder || len(q.Options) != 3 || q.Matches != nil {
+		t.Errorf("question 1 = %+v, want an order question", q)
+	}
+	if q := questions[1]; q.QuestionType() != models.QuestionTypeMatch || !slices.Equal(q.Matches, []string{"meow", "woof", "moo"}) {
+		t.Errorf("question 2 = %+v, want a match question", q)
+	}
+}
+
+func TestReadQuestionsPassages(t *testing.T) {
+	file := "text,answer_1,answer_2,correct_answer_id,score,passage_id,passage_title,passage_text\n" +
+		"Where does the train go?,Leeds,York,1,1,p1,At the station,The train to Leeds leaves at nine.\n" +
+		"When does it leave?,at nine,at ten,1,1,p1,,\n" +
+		"Who is waiting?,Tom,Ann,1,1,p1,,Another text.\n" + // Contradicts the first row
+		"She ___ to school.,goes,go,1,1,,,\n" +
+		"What is it about?,a cat,a dog,1,1,p2,,\n" // Passage without text
+	questions, err := ReadQuestions(strings.NewReader(file))
+	if got := rows(t, err); !slices.Equal(got, []int{4, 6}) {
+		t.Errorf("ReadQuestions() invalid rows = %v, want [4 6]", got)
+	}
+	if len(questions) != 3 {
+		t.Fatalf("ReadQuestions() = %d questions, want 3", len(questions))
+	}
+	first, second := questions[0].Passage, questions[1].Passage
+	if first == nil || second == nil || first.Key != "p1" || first.Title != "At the station" || second.Text != first.Text {
+		t.Errorf("passages = %+v and %+v, want p1 with its title and text on both", first, second)
+	}
+	if questions[2].Passage != nil {
+		t.Errorf("question without passage_id has passage %+v", questions[2].Passage)
+	}
+}
+
+func TestReadQuestionsTypedAnswer(t *testing.T) {
+	file := "text,type,accepted_answers,tolerance,answer_1,answer_2,answer_3,answer_4,correct_answer_id,score\n" +
+		"She ___ to school.,Text,goes | walks,1,,,,,,2\n"
+	questions, err := ReadQuestions(strings.NewReader(file))
+	if err != nil || len(questions) != 1 {
+		t.Fatalf("ReadQuestions() = %v, %v, want one question", questions, err)
+	}
+	q := questions[0]
+	if !q.IsTextAnswer() || !slices.Equal(q.AcceptedAnswers, []string{"goes", "walks"}) || q.Tolerance != 1 || q.GetAnswerCount() != 0 {
+		t.Errorf("ReadQuestions() = %+v, want a typed-answer question", q)
+	}
+}
+
+func TestReadQuestionsWithoutQuestions(t *testing.T) {
+	_, err := ReadQuestions(strings.NewReader("text,answer_1,answer_2,answer_3,answer_4,correct_answer_id,score\n"))
+	var rowErrors models.RowErrors
+	if err == nil || errors.As(err, &rowErrors) {
+		t.Errorf("ReadQuestions() of a header only = %v, want a file error", err)
+	}
+}
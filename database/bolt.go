@@ -0,0 +1,181 @@
+package database
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/andru_bot/tg-bot/models"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Bucket names used by the bolt storage backend
+var (
+	boltMetaBucket            = []byte("meta")
+	boltUsersBucket           = []byte("users")
+	boltUsersByTelegramBucket = []byte("users_by_telegram_id")
+	boltSessionsBucket        = []byte("sessions")
+	boltQuestionsBucket       = []byte("questions")
+	boltAnswersBucket         = []byte("answers")
+	boltTestsBucket           = []byte("tests")
+	boltMediaBucket           = []byte("media")
+
+	boltSchemaVersionKey = []byte("schema_version")
+)
+
+// boltMigration upgrades the bolt schema by one version
+type boltMigration struct {
+	description string
+	apply       func(tx *bolt.Tx) error
+}
+
+// boltMigrations are applied in order; the schema version stored in the meta
+// bucket is the number of migrations already applied. Never reorder or edit
+// released migrations, only append new ones.
+var boltMigrations = []boltMigration{
+	{
+		description: "create collections",
+		apply: func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{boltUsersBucket, boltSessionsBucket, boltQuestionsBucket, boltAnswersBucket} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		description: "index users by telegram_id",
+		apply: func(tx *bolt.Tx) error {
+			index, err := tx.CreateBucketIfNotExists(boltUsersByTelegramBucket)
+			if err != nil {
+				return err
+			}
+			return tx.Bucket(boltUsersBucket).ForEach(func(k, v []byte) error {
+				var user models.User
+				if err := bson.Unmarshal(v, &user); err != nil {
+					return err
+				}
+				return index.Put(telegramIDKey(user.TelegramID), k)
+			})
+		},
+	},
+	{
+		description: "create tests collection",
+		apply: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(boltTestsBucket)
+			return err
+		},
+	},
+	{
+		description: "move question answers to options",
+		apply: func(tx *bolt.Tx) error {
+			if err := migrateBoltDocs(tx.Bucket(boltQuestionsBucket), migrateQuestionOptions); err != nil {
+				return err
+			}
+			return migrateBoltDocs(tx.Bucket(boltSessionsBucket), migrateSessionOptions)
+		},
+	},
+	{
+		description: "create media collection",
+		apply: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(boltMediaBucket)
+			return err
+		},
+	},
+}
+
+// migrateBoltDocs rewrites the documents of a bucket that migrate changes
+func migrateBoltDocs(bucket *bolt.Bucket, migrate func(bson.D) (bson.D, bool)) error {
+	updates := make(map[string][]byte)
+	err := bucket.ForEach(func(k, v []byte) error {
+		var doc bson.D
+		if err := bson.Unmarshal(v, &doc); err != nil {
+			return err
+		}
+		migrated, changed := migrate(doc)
+		if !changed {
+			return nil
+		}
+		data, err := bson.Marshal(migrated)
+		if err != nil {
+			return err
+		}
+		updates[string(k)] = data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Not written inside ForEach, which must not modify the bucket
+	for k, data := range updates {
+		if err := bucket.Put([]byte(k), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenBolt opens (creating if needed) the bolt database file at path and migrates its schema
+func OpenBolt(path string) (*bolt.DB, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create bolt data directory: %w", err)
+		}
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %w", err)
+	}
+
+	if err := migrateBolt(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	log.Printf("Opened bolt database %s", path)
+	return db, nil
+}
+
+// migrateBolt applies every migration newer than the stored schema version
+func migrateBolt(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+
+		version := 0
+		if v := meta.Get(boltSchemaVersionKey); v != nil {
+			version = int(binary.BigEndian.Uint64(v))
+		}
+		if version > len(boltMigrations) {
+			return fmt.Errorf("bolt schema version %d is newer than supported version %d", version, len(boltMigrations))
+		}
+
+		for i := version; i < len(boltMigrations); i++ {
+			m := boltMigrations[i]
+			if err := m.apply(tx); err != nil {
+				return fmt.Errorf("bolt migration %d (%s) failed: %w", i+1, m.description, err)
+			}
+			log.Printf("Applied bolt migration %d: %s", i+1, m.description)
+		}
+
+		return meta.Put(boltSchemaVersionKey, uint64Key(uint64(len(boltMigrations))))
+	})
+}
+
+func uint64Key(v uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, v)
+	return key
+}
+
+func telegramIDKey(telegramID int64) []byte {
+	return uint64Key(uint64(telegramID))
+}
@@ -0,0 +1,578 @@
+package database
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/andru_bot/tg-bot/models"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	_ UserRepository     = (*BoltUserRepository)(nil)
+	_ SessionRepository  = (*BoltSessionRepository)(nil)
+	_ QuestionRepository = (*BoltQuestionRepository)(nil)
+	_ AnswerRepository   = (*BoltAnswerRepository)(nil)
+	_ TestRepository     = (*BoltTestRepository)(nil)
+	_ MediaRepository    = (*BoltMediaRepository)(nil)
+)
+
+// Documents are stored BSON-encoded and keyed by their ObjectID bytes, so
+// cursor order matches insertion order just like MongoDB natural order.
+
+func putDoc(bucket *bolt.Bucket, key []byte, doc interface{}) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
+
+func getDoc(bucket *bolt.Bucket, key []byte, doc interface{}) (bool, error) {
+	data := bucket.Get(key)
+	if data == nil {
+		return false, nil
+	}
+	return true, bson.Unmarshal(data, doc)
+}
+
+// BoltUserRepository stores users in a bolt database file
+type BoltUserRepository struct {
+	db *bolt.DB
+}
+
+func NewBoltUserRepository(db *bolt.DB) *BoltUserRepository {
+	return &BoltUserRepository{db: db}
+}
+
+func (r *BoltUserRepository) FindOrCreate(telegramID int64, username, firstName, lastName string) (*models.User, error) {
+	var user models.User
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltUsersBucket)
+		index := tx.Bucket(boltUsersByTelegramBucket)
+
+		if id := index.Get(telegramIDKey(telegramID)); id != nil {
+			_, err := getDoc(users, id, &user)
+			return err
+		}
+
+		// Create new user
+		user = models.User{
+			ID:         primitive.NewObjectID(),
+			TelegramID: telegramID,
+			Username:   username,
+			FirstName:  firstName,
+			LastName:   lastName,
+			TotalScore: 0,
+			TestsTaken: 0,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		}
+		if err := putDoc(users, user.ID[:], user); err != nil {
+			return err
+		}
+		return index.Put(telegramIDKey(telegramID), user.ID[:])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *BoltUserRepository) GetByID(userID primitive.ObjectID) (*models.User, error) {
+	var user models.User
+	var found bool
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = getDoc(tx.Bucket(boltUsersBucket), userID[:], &user)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r *BoltUserRepository) GetByTelegramID(telegramID int64) (*models.User, error) {
+	var user models.User
+	var found bool
+	err := r.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltUsersByTelegramBucket).Get(telegramIDKey(telegramID))
+		if id == nil {
+			return nil
+		}
+		var err error
+		found, err = getDoc(tx.Bucket(boltUsersBucket), id, &user)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r *BoltUserRepository) UpdateScore(userID primitive.ObjectID, score float64) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltUsersBucket)
+		var user models.User
+		found, err := getDoc(users, userID[:], &user)
+		if err != nil || !found {
+			return err
+		}
+		user.TotalScore += score
+		user.TestsTaken++
+		user.UpdatedAt = time.Now()
+		return putDoc(users, userID[:], user)
+	})
+}
+
+// BoltSessionRepository stores sessions in a bolt database file
+type BoltSessionRepository struct {
+	db *bolt.DB
+}
+
+func NewBoltSessionRepository(db *bolt.DB) *BoltSessionRepository {
+	return &BoltSessionRepository{db: db}
+}
+
+func (r *BoltSessionRepository) Create(session *models.Session) error {
+	initNewSession(session)
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return putDoc(tx.Bucket(boltSessionsBucket), session.ID[:], session)
+	})
+}
+
+func (r *BoltSessionRepository) Finish(sessionID primitive.ObjectID, outcome models.SessionOutcome) error {
+	return r.update(sessionID, func(s *models.Session) {
+		now := time.Now()
+		s.FinishedAt = &now
+		s.TotalScore = outcome.TotalScore
+		s.MaxScore = outcome.MaxScore
+		s.Percentage = outcome.Percentage
+		s.Passed = outcome.Passed
+		s.PassThreshold = outcome.PassThreshold
+		s.TotalQuestions = outcome.TotalQuestions
+		s.Status = finalStatus(outcome)
+		s.TerminationReason = outcome.TerminationReason
+		s.Level = outcome.Level
+	})
+}
+
+func (r *BoltSessionRepository) UpdateProgress(sessionID primitive.ObjectID, currentIdx int, score float64) error {
+	return r.update(sessionID, func(s *models.Session) {
+		s.CurrentIdx = currentIdx
+		s.TotalScore = score
+		s.QuestionDeadline = nil
+		s.QuestionMessageID = 0
+	})
+}
+
+func (r *BoltSessionRepository) SetQuestionDeadline(sessionID primitive.ObjectID, deadline time.Time) error {
+	return r.update(sessionID, func(s *models.Session) {
+		s.QuestionDeadline = &deadline
+	})
+}
+
+func (r *BoltSessionRepository) SetQuestionMessage(sessionID primitive.ObjectID, messageID int) error {
+	return r.update(sessionID, func(s *models.Session) {
+		s.QuestionMessageID = messageID
+	})
+}
+
+func (r *BoltSessionRepository) UpdateActivity(sessionID primitive.ObjectID, at time.Time) error {
+	return r.update(sessionID, func(s *models.Session) {
+		s.LastActivityAt = &at
+	})
+}
+
+func (r *BoltSessionRepository) AppendQuestion(sessionID primitive.ObjectID, question models.Question, optionOrder []int) error {
+	return r.update(sessionID, func(s *models.Session) {
+		s.QuestionIDs = append(s.QuestionIDs, question.ID)
+		s.Questions = append(s.Questions, question)
+		if optionOrder != nil {
+			if s.OptionOrders == nil {
+				s.OptionOrders = make(map[string][]int)
+			}
+			s.OptionOrders[question.ID.Hex()] = append([]int(nil), optionOrder...)
+		}
+	})
+}
+
+func (r *BoltSessionRepository) UpdateAbility(sessionID primitive.ObjectID, ability, abilitySE float64) error {
+	return r.update(sessionID, func(s *models.Session) {
+		s.Ability = &ability
+		s.AbilitySE = &abilitySE
+	})
+}
+
+func (r *BoltSessionRepository) update(sessionID primitive.ObjectID, apply func(*models.Session)) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		sessions := tx.Bucket(boltSessionsBucket)
+		var session models.Session
+		found, err := getDoc(sessions, sessionID[:], &session)
+		if err != nil || !found {
+			return err
+		}
+		apply(&session)
+		return putDoc(sessions, sessionID[:], session)
+	})
+}
+
+func (r *BoltSessionRepository) GetByID(sessionID primitive.ObjectID) (*models.Session, error) {
+	var session models.Session
+	var found bool
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = getDoc(tx.Bucket(boltSessionsBucket), sessionID[:], &session)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &session, nil
+}
+
+// scan decodes every session in insertion order until visit returns false
+func (r *BoltSessionRepository) scan(visit func(*models.Session) bool) error {
+	return r.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltSessionsBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var session models.Session
+			if err := bson.Unmarshal(v, &session); err != nil {
+				return err
+			}
+			if !visit(&session) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (r *BoltSessionRepository) GetActiveByUserID(userID primitive.ObjectID) (*models.Session, error) {
+	var active *models.Session
+	err := r.scan(func(s *models.Session) bool {
+		if s.UserID == userID && s.Status == models.StatusInProgress {
+			active = s
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return active, nil // nil when no active session found
+}
+
+func (r *BoltSessionRepository) GetAllActive() ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.scan(func(s *models.Session) bool {
+		if s.Status == models.StatusInProgress {
+			sessions = append(sessions, *s)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *BoltSessionRepository) GetIdle(before time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.scan(func(s *models.Session) bool {
+		if s.Status == models.StatusInProgress && s.LastActivity().Before(before) {
+			sessions = append(sessions, *s)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *BoltSessionRepository) GetFinishedByUserID(userID primitive.ObjectID) ([]models.Session, error) {
+	var finished []models.Session
+	err := r.scan(func(s *models.Session) bool {
+		if s.UserID == userID && s.Status != models.StatusInProgress {
+			finished = append(finished, *s)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortLatestFinished(finished)
+	return finished, nil
+}
+
+func (r *BoltSessionRepository) CountByQuestionID(questionID primitive.ObjectID) (int, error) {
+	count := 0
+	err := r.scan(func(s *models.Session) bool {
+		for _, id := range s.QuestionIDs {
+			if id == questionID {
+				count++
+				break
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// BoltQuestionRepository stores questions in a bolt database file
+type BoltQuestionRepository struct {
+	db *bolt.DB
+}
+
+func NewBoltQuestionRepository(db *bolt.DB) *BoltQuestionRepository {
+	return &BoltQuestionRepository{db: db}
+}
+
+func (r *BoltQuestionRepository) Create(question *models.Question) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		id := question.ID
+		if id.IsZero() {
+			// MongoDB generates _id for documents inserted without one
+			id = primitive.NewObjectID()
+		}
+		doc := *question
+		doc.ID = id
+		return putDoc(tx.Bucket(boltQuestionsBucket), id[:], doc)
+	})
+}
+
+func (r *BoltQuestionRepository) Update(question *models.Question) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		questions := tx.Bucket(boltQuestionsBucket)
+		if questions.Get(question.ID[:]) == nil {
+			return ErrNotFound
+		}
+		return putDoc(questions, question.ID[:], question)
+	})
+}
+
+func (r *BoltQuestionRepository) Delete(questionID primitive.ObjectID) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		questions := tx.Bucket(boltQuestionsBucket)
+		if questions.Get(questionID[:]) == nil {
+			return ErrNotFound
+		}
+		return questions.Delete(questionID[:])
+	})
+}
+
+func (r *BoltQuestionRepository) GetAll() ([]models.Question, error) {
+	return r.find(func(q *models.Question) bool { return true })
+}
+
+func (r *BoltQuestionRepository) GetActive() ([]models.Question, error) {
+	return r.find(func(q *models.Question) bool { return !q.Retired })
+}
+
+func (r *BoltQuestionRepository) find(match func(*models.Question) bool) ([]models.Question, error) {
+	var questions []models.Question
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltQuestionsBucket).ForEach(func(k, v []byte) error {
+			var question models.Question
+			if err := bson.Unmarshal(v, &question); err != nil {
+				return err
+			}
+			if match(&question) {
+				questions = append(questions, question)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return questions, nil
+}
+
+func (r *BoltQuestionRepository) GetByID(questionID primitive.ObjectID) (*models.Question, error) {
+	var question models.Question
+	var found bool
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = getDoc(tx.Bucket(boltQuestionsBucket), questionID[:], &question)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &question, nil
+}
+
+// BoltTestRepository stores tests in a bolt database file
+type BoltTestRepository struct {
+	db *bolt.DB
+}
+
+func NewBoltTestRepository(db *bolt.DB) *BoltTestRepository {
+	return &BoltTestRepository{db: db}
+}
+
+func (r *BoltTestRepository) Create(test *models.Test) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		id := test.ID
+		if id.IsZero() {
+			// MongoDB generates _id for documents inserted without one
+			id = primitive.NewObjectID()
+		}
+		doc := *test
+		doc.ID = id
+		return putDoc(tx.Bucket(boltTestsBucket), id[:], doc)
+	})
+}
+
+func (r *BoltTestRepository) Update(test *models.Test) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		tests := tx.Bucket(boltTestsBucket)
+		if tests.Get(test.ID[:]) == nil {
+			return ErrNotFound
+		}
+		return putDoc(tests, test.ID[:], test)
+	})
+}
+
+func (r *BoltTestRepository) GetAll() ([]models.Test, error) {
+	return r.find(func(t *models.Test) bool { return true })
+}
+
+func (r *BoltTestRepository) GetActive() ([]models.Test, error) {
+	return r.find(func(t *models.Test) bool { return !t.Retired })
+}
+
+func (r *BoltTestRepository) find(match func(*models.Test) bool) ([]models.Test, error) {
+	var tests []models.Test
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltTestsBucket).ForEach(func(k, v []byte) error {
+			var test models.Test
+			if err := bson.Unmarshal(v, &test); err != nil {
+				return err
+			}
+			if match(&test) {
+				tests = append(tests, test)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortTests(tests)
+	return tests, nil
+}
+
+func (r *BoltTestRepository) GetByID(testID primitive.ObjectID) (*models.Test, error) {
+	var test models.Test
+	var found bool
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = getDoc(tx.Bucket(boltTestsBucket), testID[:], &test)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &test, nil
+}
+
+// BoltAnswerRepository stores answers in a bolt database file
+// Answers are keyed by session ID followed by answer ID so that all answers
+// of a session are adjacent and can be read with a single prefix scan
+type BoltAnswerRepository struct {
+	db *bolt.DB
+}
+
+func NewBoltAnswerRepository(db *bolt.DB) *BoltAnswerRepository {
+	return &BoltAnswerRepository{db: db}
+}
+
+func answerKey(sessionID, answerID primitive.ObjectID) []byte {
+	key := make([]byte, 0, len(sessionID)+len(answerID))
+	key = append(key, sessionID[:]...)
+	return append(key, answerID[:]...)
+}
+
+func (r *BoltAnswerRepository) Create(answer *models.Answer) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		doc := *answer
+		if doc.ID.IsZero() {
+			// MongoDB generates _id for documents inserted without one
+			doc.ID = primitive.NewObjectID()
+		}
+		return putDoc(tx.Bucket(boltAnswersBucket), answerKey(doc.SessionID, doc.ID), doc)
+	})
+}
+
+func (r *BoltAnswerRepository) GetBySession(sessionID primitive.ObjectID) ([]models.Answer, error) {
+	var answers []models.Answer
+	err := r.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltAnswersBucket).Cursor()
+		prefix := sessionID[:]
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var answer models.Answer
+			if err := bson.Unmarshal(v, &answer); err != nil {
+				return err
+			}
+			answers = append(answers, answer)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return answers, nil
+}
+
+// BoltMediaRepository stores cached media file IDs in a bolt database file, keyed by path
+type BoltMediaRepository struct {
+	db *bolt.DB
+}
+
+func NewBoltMediaRepository(db *bolt.DB) *BoltMediaRepository {
+	return &BoltMediaRepository{db: db}
+}
+
+func (r *BoltMediaRepository) Get(path string) (*models.MediaFile, error) {
+	var file models.MediaFile
+	var found bool
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = getDoc(tx.Bucket(boltMediaBucket), []byte(path), &file)
+		return err
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (r *BoltMediaRepository) Save(file *models.MediaFile) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return putDoc(tx.Bucket(boltMediaBucket), []byte(file.Path), file)
+	})
+}
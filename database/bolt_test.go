@@ -0,0 +1,160 @@
+package database
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/andru_bot/tg-bot/models"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// openTestBolt opens a new bolt database in a temporary directory, migrated to the current schema
+func openTestBolt(t *testing.T) *bolt.DB {
+	t.Helper()
+	db, err := OpenBolt(filepath.Join(t.TempDir(), "data", "bot.db"))
+	if err != nil {
+		t.Fatalf("OpenBolt() error: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func schemaVersion(t *testing.T, db *bolt.DB) int {
+	t.Helper()
+	var version int
+	db.View(func(tx *bolt.Tx) error {
+		version = int(binary.BigEndian.Uint64(tx.Bucket(boltMetaBucket).Get(boltSchemaVersionKey)))
+		return nil
+	})
+	return version
+}
+
+func setSchemaVersion(t *testing.T, db *bolt.DB, version int) {
+	t.Helper()
+	err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMetaBucket).Put(boltSchemaVersionKey, uint64Key(uint64(version)))
+	})
+	if err != nil {
+		t.Fatalf("setting schema version: %v", err)
+	}
+}
+
+func TestOpenBoltMigratesNewDatabase(t *testing.T) {
+	db := openTestBolt(t)
+	if got := schemaVersion(t, db); got != len(boltMigrations) {
+		t.Errorf("schema version = %d, want %d", got, len(boltMigrations))
+	}
+	db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltUsersBucket, boltUsersByTelegramBucket, boltSessionsBucket, boltQuestionsBucket, boltAnswersBucket} {
+			if tx.Bucket(name) == nil {
+				t.Errorf("bucket %s missing", name)
+			}
+		}
+		return nil
+	})
+
+	// Migrating again changes nothing
+	if err := migrateBolt(db); err != nil {
+		t.Fatalf("migrateBolt() error: %v", err)
+	}
+	if got := schemaVersion(t, db); got != len(boltMigrations) {
+		t.Errorf("schema version after a second run = %d, want %d", got, len(boltMigrations))
+	}
+}
+
+func TestMigrateBoltIndexesExistingUsers(t *testing.T) {
+	db := openTestBolt(t)
+
+	// A database of schema version 1: users stored, no telegram_id index yet
+	user := models.User{ID: primitive.NewObjectID(), TelegramID: 42, Username: "alice"}
+	err := db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltUsersByTelegramBucket); err != nil {
+			return err
+		}
+		return putDoc(tx.Bucket(boltUsersBucket), user.ID[:], user)
+	})
+	if err != nil {
+		t.Fatalf("preparing version 1 database: %v", err)
+	}
+	setSchemaVersion(t, db, 1)
+
+	if err := migrateBolt(db); err != nil {
+		t.Fatalf("migrateBolt() error: %v", err)
+	}
+	found, err := NewBoltUserRepository(db).FindOrCreate(42, "other", "", "")
+	if err != nil {
+		t.Fatalf("FindOrCreate() error: %v", err)
+	}
+	if found.ID != user.ID || found.Username != "alice" {
+		t.Errorf("FindOrCreate() = %+v, want the user stored before the migration", found)
+	}
+}
+
+func TestMigrateBoltQuestionOptions(t *testing.T) {
+	db := openTestBolt(t)
+
+	// A database of schema version 3: answers stored as answer_1 ... answer_4
+	questionID, sessionID := primitive.NewObjectID(), primitive.NewObjectID()
+	session := bson.D{{Key: "_id", Value: sessionID}, {Key: "questions", Value: bson.A{legacyQuestionDoc(questionID, "gone")}}}
+	err := db.Update(func(tx *bolt.Tx) error {
+		if err := putDoc(tx.Bucket(boltQuestionsBucket), questionID[:], legacyQuestionDoc(questionID, "")); err != nil {
+			return err
+		}
+		return putDoc(tx.Bucket(boltSessionsBucket), sessionID[:], session)
+	})
+	if err != nil {
+		t.Fatalf("preparing version 3 database: %v", err)
+	}
+	setSchemaVersion(t, db, 3)
+
+	if err := migrateBolt(db); err != nil {
+		t.Fatalf("migrateBolt() error: %v", err)
+	}
+	question, err := NewBoltQuestionRepository(db).GetByID(questionID)
+	if err != nil {
+		t.Fatalf("GetByID() question error: %v", err)
+	}
+	if !slices.Equal(question.Options, []string{"go", "goes", "going"}) {
+		t.Errorf("migrated question options = %q", question.Options)
+	}
+	stored, err := NewBoltSessionRepository(db).GetByID(sessionID)
+	if err != nil {
+		t.Fatalf("GetByID() session error: %v", err)
+	}
+	if len(stored.Questions) != 1 || !slices.Equal(stored.Questions[0].Options, []string{"go", "goes", "going", "gone"}) {
+		t.Errorf("migrated session snapshots = %+v", stored.Questions)
+	}
+}
+
+func TestMigrateBoltRejectsNewerSchema(t *testing.T) {
+	db := openTestBolt(t)
+	setSchemaVersion(t, db, len(boltMigrations)+1)
+
+	err := migrateBolt(db)
+	if err == nil || !strings.Contains(err.Error(), "newer than supported") {
+		t.Errorf("migrateBolt() error = %v, want the schema to be rejected", err)
+	}
+}
+
+func TestBoltMediaRepository(t *testing.T) {
+	repo := NewBoltMediaRepository(openTestBolt(t))
+
+	got, err := repo.Get("station.mp3")
+	if err != nil || got != nil {
+		t.Fatalf("Get() of an unknown file = %+v, %v, want nil, nil", got, err)
+	}
+	for _, fileID := range []string{"first", "second"} {
+		if err := repo.Save(&models.MediaFile{Path: "station.mp3", FileID: fileID, Size: 5, ModTime: 1}); err != nil {
+			t.Fatalf("Save() error: %v", err)
+		}
+	}
+	got, err = repo.Get("station.mp3")
+	if err != nil || got == nil || got.FileID != "second" || got.Size != 5 || got.ModTime != 1 {
+		t.Errorf("Get() = %+v, %v, want the last saved entry", got, err)
+	}
+}
@@ -0,0 +1,592 @@
+package database
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// clone copies src into dst through a BSON round trip, so stored documents
+// never share memory with callers and behave exactly like decoded MongoDB documents
+func clone(src, dst interface{}) error {
+	data, err := bson.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, dst)
+}
+
+var (
+	_ UserRepository     = (*MemoryUserRepository)(nil)
+	_ SessionRepository  = (*MemorySessionRepository)(nil)
+	_ QuestionRepository = (*MemoryQuestionRepository)(nil)
+	_ AnswerRepository   = (*MemoryAnswerRepository)(nil)
+	_ TestRepository     = (*MemoryTestRepository)(nil)
+	_ MediaRepository    = (*MemoryMediaRepository)(nil)
+)
+
+// MemoryUserRepository stores users in process memory
+type MemoryUserRepository struct {
+	mu    sync.RWMutex
+	users []models.User
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{}
+}
+
+func (r *MemoryUserRepository) FindOrCreate(telegramID int64, username, firstName, lastName string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.TelegramID == telegramID {
+			var user models.User
+			if err := clone(u, &user); err != nil {
+				return nil, err
+			}
+			return &user, nil
+		}
+	}
+
+	// Create new user
+	user := models.User{
+		ID:         primitive.NewObjectID(),
+		TelegramID: telegramID,
+		Username:   username,
+		FirstName:  firstName,
+		LastName:   lastName,
+		TotalScore: 0,
+		TestsTaken: 0,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	var stored models.User
+	if err := clone(user, &stored); err != nil {
+		return nil, err
+	}
+	r.users = append(r.users, stored)
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) GetByID(userID primitive.ObjectID) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.ID == userID })
+}
+
+func (r *MemoryUserRepository) GetByTelegramID(telegramID int64) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.TelegramID == telegramID })
+}
+
+func (r *MemoryUserRepository) find(match func(*models.User) bool) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := range r.users {
+		if match(&r.users[i]) {
+			var user models.User
+			if err := clone(r.users[i], &user); err != nil {
+				return nil, err
+			}
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryUserRepository) UpdateScore(userID primitive.ObjectID, score float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].ID == userID {
+			r.users[i].TotalScore += score
+			r.users[i].TestsTaken++
+			r.users[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	// MongoDB UpdateOne does not report missing documents either
+	return nil
+}
+
+// MemorySessionRepository stores sessions in process memory
+type MemorySessionRepository struct {
+	mu       sync.RWMutex
+	sessions []models.Session
+}
+
+func NewMemorySessionRepository() *MemorySessionRepository {
+	return &MemorySessionRepository{}
+}
+
+func (r *MemorySessionRepository) Create(session *models.Session) error {
+	initNewSession(session)
+
+	var stored models.Session
+	if err := clone(session, &stored); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions = append(r.sessions, stored)
+
+	return nil
+}
+
+func (r *MemorySessionRepository) Finish(sessionID primitive.ObjectID, outcome models.SessionOutcome) error {
+	return r.update(sessionID, func(s *models.Session) {
+		now := time.Now()
+		s.FinishedAt = &now
+		s.TotalScore = outcome.TotalScore
+		s.MaxScore = outcome.MaxScore
+		s.Percentage = outcome.Percentage
+		s.Passed = outcome.Passed
+		s.PassThreshold = outcome.PassThreshold
+		s.TotalQuestions = outcome.TotalQuestions
+		s.Status = finalStatus(outcome)
+		s.TerminationReason = outcome.TerminationReason
+		s.Level = outcome.Level
+	})
+}
+
+func (r *MemorySessionRepository) UpdateProgress(sessionID primitive.ObjectID, currentIdx int, score float64) error {
+	return r.update(sessionID, func(s *models.Session) {
+		s.CurrentIdx = currentIdx
+		s.TotalScore = score
+		s.QuestionDeadline = nil
+		s.QuestionMessageID = 0
+	})
+}
+
+func (r *MemorySessionRepository) SetQuestionDeadline(sessionID primitive.ObjectID, deadline time.Time) error {
+	return r.update(sessionID, func(s *models.Session) {
+		s.QuestionDeadline = &deadline
+	})
+}
+
+func (r *MemorySessionRepository) SetQuestionMessage(sessionID primitive.ObjectID, messageID int) error {
+	return r.update(sessionID, func(s *models.Session) {
+		s.QuestionMessageID = messageID
+	})
+}
+
+func (r *MemorySessionRepository) UpdateActivity(sessionID primitive.ObjectID, at time.Time) error {
+	return r.update(sessionID, func(s *models.Session) {
+		s.LastActivityAt = &at
+	})
+}
+
+func (r *MemorySessionRepository) AppendQuestion(sessionID primitive.ObjectID, question models.Question, optionOrder []int) error {
+	return r.update(sessionID, func(s *models.Session) {
+		s.QuestionIDs = append(s.QuestionIDs, question.ID)
+		s.Questions = append(s.Questions, question)
+		if optionOrder != nil {
+			if s.OptionOrders == nil {
+				s.OptionOrders = make(map[string][]int)
+			}
+			s.OptionOrders[question.ID.Hex()] = append([]int(nil), optionOrder...)
+		}
+	})
+}
+
+func (r *MemorySessionRepository) UpdateAbility(sessionID primitive.ObjectID, ability, abilitySE float64) error {
+	return r.update(sessionID, func(s *models.Session) {
+		s.Ability = &ability
+		s.AbilitySE = &abilitySE
+	})
+}
+
+func (r *MemorySessionRepository) update(sessionID primitive.ObjectID, apply func(*models.Session)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.sessions {
+		if r.sessions[i].ID == sessionID {
+			apply(&r.sessions[i])
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *MemorySessionRepository) GetByID(sessionID primitive.ObjectID) (*models.Session, error) {
+	session, err := r.findFirst(func(s *models.Session) bool { return s.ID == sessionID })
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, ErrNotFound
+	}
+	return session, nil
+}
+
+func (r *MemorySessionRepository) GetActiveByUserID(userID primitive.ObjectID) (*models.Session, error) {
+	return r.findFirst(func(s *models.Session) bool {
+		return s.UserID == userID && s.Status == models.StatusInProgress
+	})
+}
+
+func (r *MemorySessionRepository) findFirst(match func(*models.Session) bool) (*models.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := range r.sessions {
+		if match(&r.sessions[i]) {
+			var session models.Session
+			if err := clone(r.sessions[i], &session); err != nil {
+				return nil, err
+			}
+			return &session, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *MemorySessionRepository) GetAllActive() ([]models.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var sessions []models.Session
+	for _, s := range r.sessions {
+		if s.Status != models.StatusInProgress {
+			continue
+		}
+		var session models.Session
+		if err := clone(s, &session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (r *MemorySessionRepository) GetIdle(before time.Time) ([]models.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var sessions []models.Session
+	for _, s := range r.sessions {
+		if s.Status != models.StatusInProgress || !s.LastActivity().Before(before) {
+			continue
+		}
+		var session models.Session
+		if err := clone(s, &session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (r *MemorySessionRepository) GetFinishedByUserID(userID primitive.ObjectID) ([]models.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var finished []models.Session
+	for _, s := range r.sessions {
+		if s.UserID != userID || s.Status == models.StatusInProgress {
+			continue
+		}
+		var session models.Session
+		if err := clone(s, &session); err != nil {
+			return nil, err
+		}
+		finished = append(finished, session)
+	}
+
+	// Same ordering as the MongoDB query: latest finished_at first
+	sortLatestFinished(finished)
+	return finished, nil
+}
+
+// sortLatestFinished orders sessions by finished_at, latest first
+func sortLatestFinished(sessions []models.Session) {
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return finishedAt(sessions[i]).After(finishedAt(sessions[j]))
+	})
+}
+
+func finishedAt(s models.Session) time.Time {
+	if s.FinishedAt == nil {
+		return time.Time{}
+	}
+	return *s.FinishedAt
+}
+
+func (r *MemorySessionRepository) CountByQuestionID(questionID primitive.ObjectID) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := 0
+	for _, s := range r.sessions {
+		for _, id := range s.QuestionIDs {
+			if id == questionID {
+				count++
+				break
+			}
+		}
+	}
+	return count, nil
+}
+
+// MemoryQuestionRepository stores questions in process memory
+type MemoryQuestionRepository struct {
+	mu        sync.RWMutex
+	questions []models.Question
+}
+
+func NewMemoryQuestionRepository() *MemoryQuestionRepository {
+	return &MemoryQuestionRepository{}
+}
+
+func (r *MemoryQuestionRepository) Create(question *models.Question) error {
+	var stored models.Question
+	if err := clone(question, &stored); err != nil {
+		return err
+	}
+	if stored.ID.IsZero() {
+		// MongoDB generates _id for documents inserted without one
+		stored.ID = primitive.NewObjectID()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.questions = append(r.questions, stored)
+	return nil
+}
+
+func (r *MemoryQuestionRepository) Update(question *models.Question) error {
+	var stored models.Question
+	if err := clone(question, &stored); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.questions {
+		if r.questions[i].ID == question.ID {
+			r.questions[i] = stored
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryQuestionRepository) Delete(questionID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.questions {
+		if r.questions[i].ID == questionID {
+			r.questions = append(r.questions[:i], r.questions[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryQuestionRepository) GetAll() ([]models.Question, error) {
+	return r.find(func(q *models.Question) bool { return true })
+}
+
+func (r *MemoryQuestionRepository) GetActive() ([]models.Question, error) {
+	return r.find(func(q *models.Question) bool { return !q.Retired })
+}
+
+func (r *MemoryQuestionRepository) find(match func(*models.Question) bool) ([]models.Question, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var questions []models.Question
+	for i := range r.questions {
+		q := &r.questions[i]
+		if !match(q) {
+			continue
+		}
+		var question models.Question
+		if err := clone(q, &question); err != nil {
+			return nil, err
+		}
+		questions = append(questions, question)
+	}
+	return questions, nil
+}
+
+func (r *MemoryQuestionRepository) GetByID(questionID primitive.ObjectID) (*models.Question, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, q := range r.questions {
+		if q.ID == questionID {
+			var question models.Question
+			if err := clone(q, &question); err != nil {
+				return nil, err
+			}
+			return &question, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// MemoryTestRepository stores tests in process memory
+type MemoryTestRepository struct {
+	mu    sync.RWMutex
+	tests []models.Test
+}
+
+func NewMemoryTestRepository() *MemoryTestRepository {
+	return &MemoryTestRepository{}
+}
+
+func (r *MemoryTestRepository) Create(test *models.Test) error {
+	var stored models.Test
+	if err := clone(test, &stored); err != nil {
+		return err
+	}
+	if stored.ID.IsZero() {
+		// MongoDB generates _id for documents inserted without one
+		stored.ID = primitive.NewObjectID()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tests = append(r.tests, stored)
+	return nil
+}
+
+func (r *MemoryTestRepository) Update(test *models.Test) error {
+	var stored models.Test
+	if err := clone(test, &stored); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.tests {
+		if r.tests[i].ID == test.ID {
+			r.tests[i] = stored
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryTestRepository) GetAll() ([]models.Test, error) {
+	return r.find(func(t *models.Test) bool { return true })
+}
+
+func (r *MemoryTestRepository) GetActive() ([]models.Test, error) {
+	return r.find(func(t *models.Test) bool { return !t.Retired })
+}
+
+func (r *MemoryTestRepository) find(match func(*models.Test) bool) ([]models.Test, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var tests []models.Test
+	for i := range r.tests {
+		t := &r.tests[i]
+		if !match(t) {
+			continue
+		}
+		var test models.Test
+		if err := clone(t, &test); err != nil {
+			return nil, err
+		}
+		tests = append(tests, test)
+	}
+	sortTests(tests)
+	return tests, nil
+}
+
+func (r *MemoryTestRepository) GetByID(testID primitive.ObjectID) (*models.Test, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, t := range r.tests {
+		if t.ID == testID {
+			var test models.Test
+			if err := clone(t, &test); err != nil {
+				return nil, err
+			}
+			return &test, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// MemoryAnswerRepository stores answers in process memory
+type MemoryAnswerRepository struct {
+	mu      sync.RWMutex
+	answers []models.Answer
+}
+
+func NewMemoryAnswerRepository() *MemoryAnswerRepository {
+	return &MemoryAnswerRepository{}
+}
+
+func (r *MemoryAnswerRepository) Create(answer *models.Answer) error {
+	var stored models.Answer
+	if err := clone(answer, &stored); err != nil {
+		return err
+	}
+	if stored.ID.IsZero() {
+		// MongoDB generates _id for documents inserted without one
+		stored.ID = primitive.NewObjectID()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.answers = append(r.answers, stored)
+	return nil
+}
+
+func (r *MemoryAnswerRepository) GetBySession(sessionID primitive.ObjectID) ([]models.Answer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var answers []models.Answer
+	for _, a := range r.answers {
+		if a.SessionID != sessionID {
+			continue
+		}
+		var answer models.Answer
+		if err := clone(a, &answer); err != nil {
+			return nil, err
+		}
+		answers = append(answers, answer)
+	}
+	return answers, nil
+}
+
+// MemoryMediaRepository stores cached media file IDs in process memory
+type MemoryMediaRepository struct {
+	mu    sync.RWMutex
+	files map[string]models.MediaFile
+}
+
+func NewMemoryMediaRepository() *MemoryMediaRepository {
+	return &MemoryMediaRepository{files: make(map[string]models.MediaFile)}
+}
+
+func (r *MemoryMediaRepository) Get(path string) (*models.MediaFile, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	file, ok := r.files[path]
+	if !ok {
+		return nil, nil
+	}
+	return &file, nil
+}
+
+func (r *MemoryMediaRepository) Save(file *models.MediaFile) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files[file.Path] = *file
+	return nil
+}
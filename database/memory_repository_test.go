@@ -0,0 +1,190 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMemoryUserRepositoryFindOrCreate(t *testing.T) {
+	repo := NewMemoryUserRepository()
+	first, err := repo.FindOrCreate(42, "alice", "Alice", "Smith")
+	if err != nil {
+		t.Fatalf("FindOrCreate() error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		telegramID int64
+		username   string
+		wantSame   bool
+		wantName   string // Stored username, the first one given for the Telegram ID
+	}{
+		{"same telegram ID", 42, "alice", true, "alice"},
+		{"same telegram ID, changed profile", 42, "alice2", true, "alice"},
+		{"other telegram ID", 43, "bob", false, "bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := repo.FindOrCreate(tt.telegramID, tt.username, "", "")
+			if err != nil {
+				t.Fatalf("FindOrCreate() error: %v", err)
+			}
+			if (user.ID == first.ID) != tt.wantSame {
+				t.Errorf("FindOrCreate() ID = %v, first user %v, want same %v", user.ID, first.ID, tt.wantSame)
+			}
+			if user.TelegramID != tt.telegramID || user.Username != tt.wantName {
+				t.Errorf("FindOrCreate() = %+v, want telegram ID %d and username %q", user, tt.telegramID, tt.wantName)
+			}
+		})
+	}
+
+	// A returned user is a copy, changing it must not change the stored one
+	first.Username = "changed"
+	stored, err := repo.GetByTelegramID(42)
+	if err != nil {
+		t.Fatalf("GetByTelegramID() error: %v", err)
+	}
+	if stored.Username != "alice" {
+		t.Errorf("stored username = %q, want %q", stored.Username, "alice")
+	}
+}
+
+func TestMemorySessionRepositoryGetActiveByUserID(t *testing.T) {
+	userID, otherID := primitive.NewObjectID(), primitive.NewObjectID()
+
+	tests := []struct {
+		name     string
+		sessions []primitive.ObjectID // Owners of the sessions created, in order
+		finish   []int                // Indexes of the sessions to finish
+		user     primitive.ObjectID
+		want     int // Index of the expected session, -1 for none
+	}{
+		{"no sessions", nil, nil, userID, -1},
+		{"in progress", []primitive.ObjectID{userID}, nil, userID, 0},
+		{"other user's session", []primitive.ObjectID{otherID}, nil, userID, -1},
+		{"finished", []primitive.ObjectID{userID}, []int{0}, userID, -1},
+		{"finished then restarted", []primitive.ObjectID{userID, userID}, []int{0}, userID, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := NewMemorySessionRepository()
+			var ids []primitive.ObjectID
+			for _, owner := range tt.sessions {
+				session := &models.Session{UserID: owner}
+				if err := repo.Create(session); err != nil {
+					t.Fatalf("Create() error: %v", err)
+				}
+				ids = append(ids, session.ID)
+			}
+			for _, i := range tt.finish {
+				if err := repo.Finish(ids[i], models.SessionOutcome{}); err != nil {
+					t.Fatalf("Finish() error: %v", err)
+				}
+			}
+
+			got, err := repo.GetActiveByUserID(tt.user)
+			if err != nil {
+				t.Fatalf("GetActiveByUserID() error: %v", err)
+			}
+			switch {
+			case tt.want < 0 && got != nil:
+				t.Errorf("GetActiveByUserID() = %v, want nil", got.ID)
+			case tt.want >= 0 && got == nil:
+				t.Errorf("GetActiveByUserID() = nil, want %v", ids[tt.want])
+			case tt.want >= 0 && (got.ID != ids[tt.want] || got.Status != models.StatusInProgress):
+				t.Errorf("GetActiveByUserID() = %v (%s), want %v in progress", got.ID, got.Status, ids[tt.want])
+			}
+		})
+	}
+}
+
+func TestMemorySessionRepositoryGetFinishedByUserID(t *testing.T) {
+	userID, otherID := primitive.NewObjectID(), primitive.NewObjectID()
+
+	tests := []struct {
+		name     string
+		sessions []primitive.ObjectID // Owners of the sessions created, in order
+		finish   []int                // Indexes of the sessions to finish, in this order
+		status   string               // Outcome status of every finished session
+		want     []int                // Indexes of the expected sessions, in order
+	}{
+		{"no sessions", nil, nil, "", nil},
+		{"in progress only", []primitive.ObjectID{userID}, nil, "", nil},
+		{"latest finished first", []primitive.ObjectID{userID, userID, userID}, []int{1, 0, 2}, "", []int{2, 0, 1}},
+		{"finish order, not start order", []primitive.ObjectID{userID, userID}, []int{1, 0}, "", []int{0, 1}},
+		{"in progress left out", []primitive.ObjectID{userID, userID}, []int{0}, "", []int{0}},
+		{"other users left out", []primitive.ObjectID{otherID, userID, otherID}, []int{0, 1, 2}, "", []int{1}},
+		{"failed sessions are finished too", []primitive.ObjectID{userID}, []int{0}, models.StatusFailed, []int{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := NewMemorySessionRepository()
+			var ids []primitive.ObjectID
+			for _, owner := range tt.sessions {
+				session := &models.Session{UserID: owner}
+				if err := repo.Create(session); err != nil {
+					t.Fatalf("Create() error: %v", err)
+				}
+				ids = append(ids, session.ID)
+			}
+			for _, i := range tt.finish {
+				// Stored times keep milliseconds only, keep finish times apart
+				time.Sleep(2 * time.Millisecond)
+				if err := repo.Finish(ids[i], models.SessionOutcome{Status: tt.status}); err != nil {
+					t.Fatalf("Finish() error: %v", err)
+				}
+			}
+
+			got, err := repo.GetFinishedByUserID(userID)
+			if err != nil {
+				t.Fatalf("GetFinishedByUserID() error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("GetFinishedByUserID() returned %d sessions, want %d", len(got), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if got[i].ID != ids[want] {
+					t.Errorf("session %d = %v, want %v", i, got[i].ID, ids[want])
+				}
+				if got[i].FinishedAt == nil || got[i].Status == models.StatusInProgress {
+					t.Errorf("session %d is not finished: %+v", i, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMemorySessionRepositoryGetIdle(t *testing.T) {
+	repo := NewMemorySessionRepository()
+	userID := primitive.NewObjectID()
+	now := time.Now()
+
+	create := func() primitive.ObjectID {
+		session := &models.Session{UserID: userID}
+		if err := repo.Create(session); err != nil {
+			t.Fatalf("Create() error: %v", err)
+		}
+		return session.ID
+	}
+	idle, active, finished := create(), create(), create()
+	if err := repo.UpdateActivity(idle, now.Add(-time.Hour)); err != nil {
+		t.Fatalf("UpdateActivity() error: %v", err)
+	}
+	if err := repo.UpdateActivity(finished, now.Add(-time.Hour)); err != nil {
+		t.Fatalf("UpdateActivity() error: %v", err)
+	}
+	if err := repo.Finish(finished, models.SessionOutcome{}); err != nil {
+		t.Fatalf("Finish() error: %v", err)
+	}
+
+	got, err := repo.GetIdle(now.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("GetIdle() error: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != idle {
+		t.Errorf("GetIdle() = %d sessions, want only the idle one %v (active %v)", len(got), idle, active)
+	}
+}
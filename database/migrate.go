@@ -0,0 +1,68 @@
+package database
+
+import (
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// legacyAnswerFields held the options of a question before they were a list
+var legacyAnswerFields = []string{"answer_1", "answer_2", "answer_3", "answer_4"}
+
+// migrateQuestionOptions moves answer_1 ... answer_4 of a question document into the options list,
+// which takes the place of answer_1; reports whether the document changed
+func migrateQuestionOptions(doc bson.D) (bson.D, bool) {
+	answers := make([]string, len(legacyAnswerFields))
+	found := false
+	for _, e := range doc {
+		for i, name := range legacyAnswerFields {
+			if e.Key == name {
+				answers[i], _ = e.Value.(string)
+				found = true
+			}
+		}
+	}
+	if !found {
+		return doc, false
+	}
+
+	migrated := make(bson.D, 0, len(doc))
+	for _, e := range doc {
+		switch e.Key {
+		case "answer_1":
+			if options := models.OptionsFromAnswers(answers...); options != nil {
+				migrated = append(migrated, bson.E{Key: "options", Value: options})
+			}
+		case "answer_2", "answer_3", "answer_4":
+		default:
+			migrated = append(migrated, e)
+		}
+	}
+	return migrated, true
+}
+
+// migrateSessionOptions applies migrateQuestionOptions to the question snapshots of a session document
+// Reports whether the document changed
+func migrateSessionOptions(doc bson.D) (bson.D, bool) {
+	changed := false
+	for i, e := range doc {
+		if e.Key != "questions" {
+			continue
+		}
+		questions, ok := e.Value.(bson.A)
+		if !ok {
+			continue
+		}
+		for j, q := range questions {
+			question, ok := q.(bson.D)
+			if !ok {
+				continue
+			}
+			if migrated, ok := migrateQuestionOptions(question); ok {
+				questions[j] = migrated
+				changed = true
+			}
+		}
+		doc[i].Value = questions
+	}
+	return doc, changed
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// legacyQuestionDoc returns a question document as stored before options were a list
+func legacyQuestionDoc(id primitive.ObjectID, answer4 string) bson.D {
+	return bson.D{
+		{Key: "_id", Value: id},
+		{Key: "text", Value: "She ___ to school."},
+		{Key: "answer_1", Value: "go"},
+		{Key: "answer_2", Value: "goes"},
+		{Key: "answer_3", Value: "going"},
+		{Key: "answer_4", Value: answer4},
+		{Key: "correct_answer_id", Value: int32(2)},
+		{Key: "score", Value: int32(1)},
+	}
+}
+
+// decodeQuestion decodes a question document the way the repositories do
+func decodeQuestion(t *testing.T, doc bson.D) models.Question {
+	t.Helper()
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal() error: %v", err)
+	}
+	var q models.Question
+	if err := bson.Unmarshal(data, &q); err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+	return q
+}
+
+func TestMigrateQuestionOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer4 string
+		want    []string
+	}{
+		{"three answers", "", []string{"go", "goes", "going"}},
+		{"four answers", "gone", []string{"go", "goes", "going", "gone"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := primitive.NewObjectID()
+			migrated, changed := migrateQuestionOptions(legacyQuestionDoc(id, tt.answer4))
+			if !changed {
+				t.Fatal("migrateQuestionOptions() reported no change")
+			}
+			for _, e := range migrated {
+				if slices.Contains(legacyAnswerFields, e.Key) {
+					t.Errorf("migrated document still has %s", e.Key)
+				}
+			}
+			q := decodeQuestion(t, migrated)
+			if q.ID != id || !slices.Equal(q.Options, tt.want) || q.CorrectAnswerID != 2 || q.Text != "She ___ to school." {
+				t.Errorf("migrated question = %+v, want options %q", q, tt.want)
+			}
+			if err := q.Validate(); err != nil {
+				t.Errorf("migrated question is invalid: %v", err)
+			}
+
+			// Migrating again changes nothing
+			if again, changed := migrateQuestionOptions(migrated); changed || len(again) != len(migrated) {
+				t.Errorf("second migrateQuestionOptions() = %v, %v, want no change", again, changed)
+			}
+		})
+	}
+}
+
+func TestMigrateSessionOptions(t *testing.T) {
+	migratedAlready := bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "options", Value: bson.A{"is", "are"}}}
+	doc := bson.D{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "questions", Value: bson.A{legacyQuestionDoc(primitive.NewObjectID(), ""), migratedAlready}},
+		{Key: "status", Value: models.StatusCompleted},
+	}
+
+	migrated, changed := migrateSessionOptions(doc)
+	if !changed {
+		t.Fatal("migrateSessionOptions() reported no change")
+	}
+	data, err := bson.Marshal(migrated)
+	if err != nil {
+		t.Fatalf("Marshal() error: %v", err)
+	}
+	var session models.Session
+	if err := bson.Unmarshal(data, &session); err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+	if len(session.Questions) != 2 || !slices.Equal(session.Questions[0].Options, []string{"go", "goes", "going"}) ||
+		!slices.Equal(session.Questions[1].Options, []string{"is", "are"}) || session.Status != models.StatusCompleted {
+		t.Errorf("migrated session = %+v", session)
+	}
+
+	if _, changed := migrateSessionOptions(migrated); changed {
+		t.Error("second migrateSessionOptions() reported a change")
+	}
+	if _, changed := migrateSessionOptions(bson.D{{Key: "status", Value: models.StatusInProgress}}); changed {
+		t.Error("migrateSessionOptions() of a session without snapshots reported a change")
+	}
+}
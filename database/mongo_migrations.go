@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoSchemaVersionID is the _id of the document in the meta collection holding the schema version
+const mongoSchemaVersionID = "schema_version"
+
+// mongoMigration upgrades the MongoDB schema by one version
+type mongoMigration struct {
+	description string
+	apply       func(ctx context.Context, db *mongo.Database) error
+}
+
+// mongoMigrations are applied in order; the schema version stored in the meta
+// collection is the number of migrations already applied. Never reorder or edit
+// released migrations, only append new ones.
+var mongoMigrations = []mongoMigration{
+	{
+		description: "move question answers to options",
+		apply: func(ctx context.Context, db *mongo.Database) error {
+			err := migrateMongoDocs(ctx, db.Collection("questions"), bson.M{"answer_1": bson.M{"$exists": true}}, migrateQuestionOptions)
+			if err != nil {
+				return err
+			}
+			return migrateMongoDocs(ctx, db.Collection("sessions"), bson.M{"questions.answer_1": bson.M{"$exists": true}}, migrateSessionOptions)
+		},
+	},
+}
+
+// MigrateMongo applies every migration newer than the stored schema version to DB
+func MigrateMongo() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	meta := DB.Collection("meta")
+	var stored struct {
+		Version int `bson:"version"`
+	}
+	err := meta.FindOne(ctx, bson.M{"_id": mongoSchemaVersionID}).Decode(&stored)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to read MongoDB schema version: %w", err)
+	}
+	if stored.Version > len(mongoMigrations) {
+		return fmt.Errorf("MongoDB schema version %d is newer than supported version %d", stored.Version, len(mongoMigrations))
+	}
+
+	// Each migration is stored as soon as it is applied, so a failed run resumes where it stopped
+	for i := stored.Version; i < len(mongoMigrations); i++ {
+		m := mongoMigrations[i]
+		if err := m.apply(ctx, DB); err != nil {
+			return fmt.Errorf("MongoDB migration %d (%s) failed: %w", i+1, m.description, err)
+		}
+		_, err := meta.UpdateOne(ctx, bson.M{"_id": mongoSchemaVersionID},
+			bson.M{"$set": bson.M{"version": i + 1}}, options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to store MongoDB schema version: %w", err)
+		}
+		log.Printf("Applied MongoDB migration %d: %s", i+1, m.description)
+	}
+	return nil
+}
+
+// migrateMongoDocs rewrites the documents of a collection matching filter that migrate changes
+func migrateMongoDocs(ctx context.Context, collection *mongo.Collection, filter bson.M, migrate func(bson.D) (bson.D, bool)) error {
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.D
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		migrated, changed := migrate(doc)
+		if !changed {
+			continue
+		}
+		var id interface{}
+		for _, e := range migrated {
+			if e.Key == "_id" {
+				id = e.Value
+			}
+		}
+		if _, err := collection.ReplaceOne(ctx, bson.M{"_id": id}, migrated); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
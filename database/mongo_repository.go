@@ -0,0 +1,601 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	_ UserRepository     = (*MongoUserRepository)(nil)
+	_ SessionRepository  = (*MongoSessionRepository)(nil)
+	_ QuestionRepository = (*MongoQuestionRepository)(nil)
+	_ AnswerRepository   = (*MongoAnswerRepository)(nil)
+	_ TestRepository     = (*MongoTestRepository)(nil)
+	_ MediaRepository    = (*MongoMediaRepository)(nil)
+)
+
+// MongoUserRepository stores users in MongoDB
+type MongoUserRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoUserRepository() *MongoUserRepository {
+	return &MongoUserRepository{
+		collection: DB.Collection("users"),
+	}
+}
+
+func (r *MongoUserRepository) FindOrCreate(telegramID int64, username, firstName, lastName string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := r.collection.FindOne(ctx, bson.M{"telegram_id": telegramID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		// Create new user
+		user = models.User{
+			ID:         primitive.NewObjectID(),
+			TelegramID: telegramID,
+			Username:   username,
+			FirstName:  firstName,
+			LastName:   lastName,
+			TotalScore: 0,
+			TestsTaken: 0,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		}
+		_, err = r.collection.InsertOne(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *MongoUserRepository) GetByID(userID primitive.ObjectID) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := r.collection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *MongoUserRepository) GetByTelegramID(telegramID int64) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := r.collection.FindOne(ctx, bson.M{"telegram_id": telegramID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *MongoUserRepository) UpdateScore(userID primitive.ObjectID, score float64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": userID},
+		bson.M{
+			"$inc": bson.M{
+				"total_score": score,
+				"tests_taken": 1,
+			},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// MongoSessionRepository stores sessions in MongoDB
+type MongoSessionRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoSessionRepository() *MongoSessionRepository {
+	return &MongoSessionRepository{
+		collection: DB.Collection("sessions"),
+	}
+}
+
+func (r *MongoSessionRepository) Create(session *models.Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	initNewSession(session)
+	_, err := r.collection.InsertOne(ctx, session)
+	return err
+}
+
+func (r *MongoSessionRepository) AppendQuestion(sessionID primitive.ObjectID, question models.Question, optionOrder []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$push": bson.M{"question_ids": question.ID, "questions": question}}
+	if optionOrder != nil {
+		update["$set"] = bson.M{"option_orders." + question.ID.Hex(): optionOrder}
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": sessionID}, update)
+	return err
+}
+
+func (r *MongoSessionRepository) UpdateAbility(sessionID primitive.ObjectID, ability, abilitySE float64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": sessionID},
+		bson.M{
+			"$set": bson.M{
+				"ability":    ability,
+				"ability_se": abilitySE,
+			},
+		},
+	)
+	return err
+}
+
+func (r *MongoSessionRepository) Finish(sessionID primitive.ObjectID, outcome models.SessionOutcome) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": sessionID},
+		bson.M{
+			"$set": bson.M{
+				"finished_at":        now,
+				"total_score":        outcome.TotalScore,
+				"max_score":          outcome.MaxScore,
+				"percentage":         outcome.Percentage,
+				"passed":             outcome.Passed,
+				"pass_threshold":     outcome.PassThreshold,
+				"total_questions":    outcome.TotalQuestions,
+				"status":             finalStatus(outcome),
+				"termination_reason": outcome.TerminationReason,
+				"level":              outcome.Level,
+			},
+		},
+	)
+	return err
+}
+
+func (r *MongoSessionRepository) GetByID(sessionID primitive.ObjectID) (*models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var session models.Session
+	err := r.collection.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *MongoSessionRepository) GetActiveByUserID(userID primitive.ObjectID) (*models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var session models.Session
+	err := r.collection.FindOne(
+		ctx,
+		bson.M{
+			"user_id": userID,
+			"status":  models.StatusInProgress,
+		},
+	).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil // No active session found
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *MongoSessionRepository) UpdateProgress(sessionID primitive.ObjectID, currentIdx int, score float64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": sessionID},
+		bson.M{
+			"$set": bson.M{
+				"current_idx": currentIdx,
+				"total_score": score,
+			},
+			"$unset": bson.M{
+				"question_deadline":   "",
+				"question_message_id": "",
+			},
+		},
+	)
+	return err
+}
+
+func (r *MongoSessionRepository) SetQuestionDeadline(sessionID primitive.ObjectID, deadline time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": sessionID},
+		bson.M{
+			"$set": bson.M{
+				"question_deadline": deadline,
+			},
+		},
+	)
+	return err
+}
+
+func (r *MongoSessionRepository) SetQuestionMessage(sessionID primitive.ObjectID, messageID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": sessionID},
+		bson.M{
+			"$set": bson.M{
+				"question_message_id": messageID,
+			},
+		},
+	)
+	return err
+}
+
+func (r *MongoSessionRepository) GetAllActive() ([]models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, bson.M{"status": models.StatusInProgress})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var sessions []models.Session
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (r *MongoSessionRepository) UpdateActivity(sessionID primitive.ObjectID, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": sessionID},
+		bson.M{
+			"$set": bson.M{
+				"last_activity_at": at,
+			},
+		},
+	)
+	return err
+}
+
+func (r *MongoSessionRepository) GetIdle(before time.Time) ([]models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, bson.M{
+		"status": models.StatusInProgress,
+		"$or": bson.A{
+			bson.M{"last_activity_at": bson.M{"$lt": before}},
+			// Sessions created before activity was tracked
+			bson.M{"last_activity_at": bson.M{"$exists": false}, "started_at": bson.M{"$lt": before}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var sessions []models.Session
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (r *MongoSessionRepository) GetFinishedByUserID(userID primitive.ObjectID) ([]models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := r.collection.Find(
+		ctx,
+		bson.M{
+			"user_id": userID,
+			"status":  bson.M{"$ne": models.StatusInProgress},
+		},
+		options.Find().SetSort(bson.M{"finished_at": -1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var sessions []models.Session
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (r *MongoSessionRepository) CountByQuestionID(questionID primitive.ObjectID) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	count, err := r.collection.CountDocuments(ctx, bson.M{"question_ids": questionID})
+	return int(count), err
+}
+
+// MongoQuestionRepository stores questions in MongoDB
+type MongoQuestionRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoQuestionRepository() *MongoQuestionRepository {
+	return &MongoQuestionRepository{
+		collection: DB.Collection("questions"),
+	}
+}
+
+func (r *MongoQuestionRepository) Create(question *models.Question) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.collection.InsertOne(ctx, question)
+	return err
+}
+
+func (r *MongoQuestionRepository) Update(question *models.Question) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := r.collection.ReplaceOne(ctx, bson.M{"_id": question.ID}, question)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoQuestionRepository) Delete(questionID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": questionID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoQuestionRepository) GetAll() ([]models.Question, error) {
+	return r.find(bson.M{})
+}
+
+func (r *MongoQuestionRepository) GetActive() ([]models.Question, error) {
+	return r.find(bson.M{"retired": bson.M{"$ne": true}})
+}
+
+func (r *MongoQuestionRepository) find(filter bson.M) ([]models.Question, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var questions []models.Question
+	if err = cursor.All(ctx, &questions); err != nil {
+		return nil, err
+	}
+
+	return questions, nil
+}
+
+func (r *MongoQuestionRepository) GetByID(questionID primitive.ObjectID) (*models.Question, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var question models.Question
+	err := r.collection.FindOne(ctx, bson.M{"_id": questionID}).Decode(&question)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &question, nil
+}
+
+// MongoTestRepository stores tests in MongoDB
+type MongoTestRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoTestRepository() *MongoTestRepository {
+	return &MongoTestRepository{
+		collection: DB.Collection("tests"),
+	}
+}
+
+func (r *MongoTestRepository) Create(test *models.Test) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.collection.InsertOne(ctx, test)
+	return err
+}
+
+func (r *MongoTestRepository) Update(test *models.Test) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := r.collection.ReplaceOne(ctx, bson.M{"_id": test.ID}, test)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoTestRepository) GetAll() ([]models.Test, error) {
+	return r.find(bson.M{})
+}
+
+func (r *MongoTestRepository) GetActive() ([]models.Test, error) {
+	return r.find(bson.M{"retired": bson.M{"$ne": true}})
+}
+
+func (r *MongoTestRepository) find(filter bson.M) ([]models.Test, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"position": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var tests []models.Test
+	if err = cursor.All(ctx, &tests); err != nil {
+		return nil, err
+	}
+
+	return tests, nil
+}
+
+func (r *MongoTestRepository) GetByID(testID primitive.ObjectID) (*models.Test, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var test models.Test
+	err := r.collection.FindOne(ctx, bson.M{"_id": testID}).Decode(&test)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &test, nil
+}
+
+// MongoAnswerRepository stores answers in MongoDB
+type MongoAnswerRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoAnswerRepository() *MongoAnswerRepository {
+	return &MongoAnswerRepository{
+		collection: DB.Collection("answers"),
+	}
+}
+
+func (r *MongoAnswerRepository) Create(answer *models.Answer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.collection.InsertOne(ctx, answer)
+	return err
+}
+
+func (r *MongoAnswerRepository) GetBySession(sessionID primitive.ObjectID) ([]models.Answer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, bson.M{"session_id": sessionID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var answers []models.Answer
+	if err = cursor.All(ctx, &answers); err != nil {
+		return nil, err
+	}
+
+	return answers, nil
+}
+
+// MongoMediaRepository stores cached media file IDs in MongoDB, one document per path
+type MongoMediaRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoMediaRepository() *MongoMediaRepository {
+	return &MongoMediaRepository{
+		collection: DB.Collection("media"),
+	}
+}
+
+func (r *MongoMediaRepository) Get(path string) (*models.MediaFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var file models.MediaFile
+	err := r.collection.FindOne(ctx, bson.M{"_id": path}).Decode(&file)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (r *MongoMediaRepository) Save(file *models.MediaFile) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.collection.ReplaceOne(ctx, bson.M{"_id": file.Path}, file, options.Replace().SetUpsert(true))
+	return err
+}
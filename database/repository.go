@@ -1,326 +1,198 @@
 package database
 
 import (
-	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"time"
 
+	"github.com/andru_bot/tg-bot/config"
 	"github.com/andru_bot/tg-bot/models"
-	"go.mongodb.org/mongo-driver/bson"
+	bolt "go.etcd.io/bbolt"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// UserRepository handles user operations
-type UserRepository struct {
-	collection *mongo.Collection
-}
-
-func NewUserRepository() *UserRepository {
-	return &UserRepository{
-		collection: DB.Collection("users"),
-	}
-}
-
-func (r *UserRepository) FindOrCreate(telegramID int64, username, firstName, lastName string) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var user models.User
-	err := r.collection.FindOne(ctx, bson.M{"telegram_id": telegramID}).Decode(&user)
-	if err == mongo.ErrNoDocuments {
-		// Create new user
-		user = models.User{
-			ID:         primitive.NewObjectID(),
-			TelegramID: telegramID,
-			Username:   username,
-			FirstName:  firstName,
-			LastName:   lastName,
-			TotalScore: 0,
-			TestsTaken: 0,
-			CreatedAt:  time.Now(),
-			UpdatedAt:  time.Now(),
-		}
-		_, err = r.collection.InsertOne(ctx, user)
-		if err != nil {
-			return nil, err
-		}
-		return &user, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
-}
-
-func (r *UserRepository) GetByID(userID primitive.ObjectID) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var user models.User
-	err := r.collection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
-}
-
-func (r *UserRepository) GetByTelegramID(telegramID int64) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// ErrNotFound is returned by lookups by ID when the document does not exist
+var ErrNotFound = errors.New("not found")
 
-	var user models.User
-	err := r.collection.FindOne(ctx, bson.M{"telegram_id": telegramID}).Decode(&user)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
-}
-
-func (r *UserRepository) UpdateScore(userID primitive.ObjectID, score int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := r.collection.UpdateOne(
-		ctx,
-		bson.M{"_id": userID},
-		bson.M{
-			"$inc": bson.M{
-				"total_score": score,
-				"tests_taken": 1,
-			},
-			"$set": bson.M{"updated_at": time.Now()},
-		},
-	)
-	return err
+// UserRepository handles user operations
+type UserRepository interface {
+	FindOrCreate(telegramID int64, username, firstName, lastName string) (*models.User, error)
+	GetByID(userID primitive.ObjectID) (*models.User, error)
+	GetByTelegramID(telegramID int64) (*models.User, error)
+	UpdateScore(userID primitive.ObjectID, score float64) error
 }
 
 // SessionRepository handles session operations
-type SessionRepository struct {
-	collection *mongo.Collection
+// GetActiveByUserID returns nil, nil when nothing matches
+// GetFinishedByUserID returns the user's finished sessions, latest finished first
+// UpdateProgress clears the question deadline and message, the next question gets its own
+type SessionRepository interface {
+	Create(session *models.Session) error
+	AppendQuestion(sessionID primitive.ObjectID, question models.Question, optionOrder []int) error
+	UpdateAbility(sessionID primitive.ObjectID, ability, abilitySE float64) error
+	Finish(sessionID primitive.ObjectID, outcome models.SessionOutcome) error
+	GetByID(sessionID primitive.ObjectID) (*models.Session, error)
+	GetActiveByUserID(userID primitive.ObjectID) (*models.Session, error)
+	UpdateProgress(sessionID primitive.ObjectID, currentIdx int, score float64) error
+	SetQuestionDeadline(sessionID primitive.ObjectID, deadline time.Time) error
+	SetQuestionMessage(sessionID primitive.ObjectID, messageID int) error
+	UpdateActivity(sessionID primitive.ObjectID, at time.Time) error
+	GetAllActive() ([]models.Session, error)
+	GetIdle(before time.Time) ([]models.Session, error) // In-progress sessions without activity since before
+	GetFinishedByUserID(userID primitive.ObjectID) ([]models.Session, error)
+	CountByQuestionID(questionID primitive.ObjectID) (int, error)
 }
 
-func NewSessionRepository() *SessionRepository {
-	return &SessionRepository{
-		collection: DB.Collection("sessions"),
-	}
+// QuestionRepository handles question operations
+// GetAll includes retired questions, GetActive only the ones new tests may use
+// Update and Delete return ErrNotFound when the question does not exist
+type QuestionRepository interface {
+	Create(question *models.Question) error
+	Update(question *models.Question) error
+	Delete(questionID primitive.ObjectID) error
+	GetAll() ([]models.Question, error)
+	GetActive() ([]models.Question, error)
+	GetByID(questionID primitive.ObjectID) (*models.Question, error)
+}
+
+// TestRepository handles the tests of the catalog
+// GetAll includes retired tests, GetActive only the ones offered in the catalog; both are ordered by position
+// Update returns ErrNotFound when the test does not exist
+type TestRepository interface {
+	Create(test *models.Test) error
+	Update(test *models.Test) error
+	GetAll() ([]models.Test, error)
+	GetActive() ([]models.Test, error)
+	GetByID(testID primitive.ObjectID) (*models.Test, error)
 }
 
-func (r *SessionRepository) Create(userID primitive.ObjectID, questionIDs []primitive.ObjectID) (*models.Session, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	session := models.Session{
-		ID:             primitive.NewObjectID(),
-		UserID:         userID,
-		StartedAt:      time.Now(),
-		TotalScore:     0,
-		TotalQuestions: len(questionIDs),
-		Status:         "in_progress",
-		CurrentIdx:     0,
-		QuestionIDs:    questionIDs,
-	}
-
-	_, err := r.collection.InsertOne(ctx, session)
-	if err != nil {
-		return nil, err
-	}
-
-	return &session, nil
+// AnswerRepository handles answer operations
+type AnswerRepository interface {
+	Create(answer *models.Answer) error
+	GetBySession(sessionID primitive.ObjectID) ([]models.Answer, error)
 }
 
-func (r *SessionRepository) Finish(sessionID primitive.ObjectID, totalScore, totalQuestions int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	now := time.Now()
-	_, err := r.collection.UpdateOne(
-		ctx,
-		bson.M{"_id": sessionID},
-		bson.M{
-			"$set": bson.M{
-				"finished_at":     now,
-				"total_score":     totalScore,
-				"total_questions": totalQuestions,
-				"status":          "completed",
-			},
-		},
-	)
-	return err
+// MediaRepository caches the Telegram file IDs of uploaded media files by path
+// Get returns nil, nil when the file has no cached file ID; Save replaces the entry of the file's path
+type MediaRepository interface {
+	Get(path string) (*models.MediaFile, error)
+	Save(file *models.MediaFile) error
 }
 
-func (r *SessionRepository) GetByID(sessionID primitive.ObjectID) (*models.Session, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var session models.Session
-	err := r.collection.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&session)
-	if err != nil {
-		return nil, err
+// finalStatus returns the status stored by Finish
+func finalStatus(outcome models.SessionOutcome) string {
+	if outcome.Status == "" {
+		return models.StatusCompleted
 	}
-	return &session, nil
+	return outcome.Status
 }
 
-func (r *SessionRepository) GetActiveByUserID(userID primitive.ObjectID) (*models.Session, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var session models.Session
-	err := r.collection.FindOne(
-		ctx,
-		bson.M{
-			"user_id": userID,
-			"status":  "in_progress",
-		},
-	).Decode(&session)
-	if err == mongo.ErrNoDocuments {
-		return nil, nil // No active session found
-	}
-	if err != nil {
-		return nil, err
-	}
-	return &session, nil
+// sortTests orders tests by their catalog position
+func sortTests(tests []models.Test) {
+	sort.SliceStable(tests, func(i, j int) bool {
+		return tests[i].Position < tests[j].Position
+	})
 }
 
-func (r *SessionRepository) UpdateProgress(sessionID primitive.ObjectID, currentIdx int, score int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := r.collection.UpdateOne(
-		ctx,
-		bson.M{"_id": sessionID},
-		bson.M{
-			"$set": bson.M{
-				"current_idx": currentIdx,
-				"total_score": score,
-			},
-		},
-	)
-	return err
-}
-
-func (r *SessionRepository) GetAllActive() ([]models.Session, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	cursor, err := r.collection.Find(ctx, bson.M{"status": "in_progress"})
-	if err != nil {
-		return nil, err
+// initNewSession fills the fields every backend sets when a session is created
+// The caller provides UserID, TestID, Test, ChatID, QuestionIDs, Questions, TotalQuestions, Mode, Seed, OptionOrders and ExpiresAt
+func initNewSession(session *models.Session) {
+	if session.ID.IsZero() {
+		session.ID = primitive.NewObjectID()
 	}
-	defer cursor.Close(ctx)
-
-	var sessions []models.Session
-	if err = cursor.All(ctx, &sessions); err != nil {
-		return nil, err
+	if session.Mode == "" {
+		session.Mode = models.ModeLinear
 	}
-
-	return sessions, nil
+	session.StartedAt = time.Now()
+	session.LastActivityAt = &session.StartedAt
+	session.TotalScore = 0
+	session.Status = models.StatusInProgress
+	session.CurrentIdx = 0
 }
 
-func (r *SessionRepository) GetLastCompletedByUserID(userID primitive.ObjectID) (*models.Session, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var session models.Session
-	err := r.collection.FindOne(
-		ctx,
-		bson.M{
-			"user_id": userID,
-			"status":  "completed",
-		},
-		options.FindOne().SetSort(bson.M{"finished_at": -1}),
-	).Decode(&session)
-	if err == mongo.ErrNoDocuments {
-		return nil, nil // No completed session found
-	}
-	if err != nil {
-		return nil, err
-	}
-	return &session, nil
-}
+// Repositories groups the repositories of a single storage backend
+type Repositories struct {
+	Users     UserRepository
+	Sessions  SessionRepository
+	Questions QuestionRepository
+	Answers   AnswerRepository
+	Tests     TestRepository
+	Media     MediaRepository
 
-// QuestionRepository handles question operations
-type QuestionRepository struct {
-	collection *mongo.Collection
+	close func() error
 }
 
-func NewQuestionRepository() *QuestionRepository {
-	return &QuestionRepository{
-		collection: DB.Collection("questions"),
+// Close releases the resources held by the storage backend
+func (r *Repositories) Close() error {
+	if r.close == nil {
+		return nil
 	}
+	return r.close()
 }
 
-func (r *QuestionRepository) Create(question *models.Question) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := r.collection.InsertOne(ctx, question)
-	return err
-}
-
-func (r *QuestionRepository) GetAll() ([]models.Question, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	cursor, err := r.collection.Find(ctx, bson.M{})
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
-
-	var questions []models.Question
-	if err = cursor.All(ctx, &questions); err != nil {
-		return nil, err
+// NewMongoRepositories returns repositories backed by the connected MongoDB database
+// Connect must be called first
+func NewMongoRepositories() *Repositories {
+	return &Repositories{
+		Users:     NewMongoUserRepository(),
+		Sessions:  NewMongoSessionRepository(),
+		Questions: NewMongoQuestionRepository(),
+		Answers:   NewMongoAnswerRepository(),
+		Tests:     NewMongoTestRepository(),
+		Media:     NewMongoMediaRepository(),
+		close:     Disconnect,
 	}
-
-	return questions, nil
 }
 
-func (r *QuestionRepository) GetByID(questionID primitive.ObjectID) (*models.Question, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var question models.Question
-	err := r.collection.FindOne(ctx, bson.M{"_id": questionID}).Decode(&question)
-	if err != nil {
-		return nil, err
+// NewMemoryRepositories returns repositories that keep all data in process memory
+// Data is lost when the process exits
+func NewMemoryRepositories() *Repositories {
+	return &Repositories{
+		Users:     NewMemoryUserRepository(),
+		Sessions:  NewMemorySessionRepository(),
+		Questions: NewMemoryQuestionRepository(),
+		Answers:   NewMemoryAnswerRepository(),
+		Tests:     NewMemoryTestRepository(),
+		Media:     NewMemoryMediaRepository(),
 	}
-	return &question, nil
 }
 
-// AnswerRepository handles answer operations
-type AnswerRepository struct {
-	collection *mongo.Collection
-}
-
-func NewAnswerRepository() *AnswerRepository {
-	return &AnswerRepository{
-		collection: DB.Collection("answers"),
+// NewBoltRepositories returns repositories stored in the given bolt database
+// Closing the repositories closes the database
+func NewBoltRepositories(db *bolt.DB) *Repositories {
+	return &Repositories{
+		Users:     NewBoltUserRepository(db),
+		Sessions:  NewBoltSessionRepository(db),
+		Questions: NewBoltQuestionRepository(db),
+		Answers:   NewBoltAnswerRepository(db),
+		Tests:     NewBoltTestRepository(db),
+		Media:     NewBoltMediaRepository(db),
+		close:     db.Close,
 	}
 }
 
-func (r *AnswerRepository) Create(answer *models.Answer) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := r.collection.InsertOne(ctx, answer)
-	return err
-}
-
-func (r *AnswerRepository) GetBySession(sessionID primitive.ObjectID) ([]models.Answer, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	cursor, err := r.collection.Find(ctx, bson.M{"session_id": sessionID})
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
-
-	var answers []models.Answer
-	if err = cursor.All(ctx, &answers); err != nil {
-		return nil, err
+// Open initializes the storage backend selected by driver ("mongo", "bolt" or "memory")
+func Open(driver string) (*Repositories, error) {
+	switch driver {
+	case "mongo", "mongodb":
+		if err := Connect(); err != nil {
+			return nil, err
+		}
+		if err := MigrateMongo(); err != nil {
+			Disconnect()
+			return nil, err
+		}
+		return NewMongoRepositories(), nil
+	case "bolt", "bbolt":
+		db, err := OpenBolt(config.GetBoltPath())
+		if err != nil {
+			return nil, err
+		}
+		return NewBoltRepositories(db), nil
+	case "memory":
+		return NewMemoryRepositories(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", driver)
 	}
-
-	return answers, nil
 }
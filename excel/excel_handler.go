@@ -2,22 +2,41 @@ package excel
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/andru_bot/tg-bot/csv"
 	"github.com/andru_bot/tg-bot/models"
+	"github.com/andru_bot/tg-bot/placement"
+	"github.com/andru_bot/tg-bot/scoring"
 	"github.com/xuri/excelize/v2"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // CreateResultsExcel creates an Excel file with test results
-func CreateResultsExcel(sessionID primitive.ObjectID, answers []models.Answer, questions []models.Question) (string, error) {
-	// Create a map of question IDs to questions for quick lookup
-	questionMap := make(map[primitive.ObjectID]models.Question)
-	for _, q := range questions {
-		questionMap[q.ID] = q
-	}
+// scoreResult is the score of the session as graded with its test's scoring, see scoring.Config.FromSession
+func CreateResultsExcel(session *models.Session, answers []models.Answer, questions []models.Question, scoreResult scoring.Result, placementResult placement.Result) (string, error) {
+	// No question is marked as skipped
+	return createResultsExcel(session, answers, questions, scoreResult, placementResult, len(questions))
+}
 
+// CreateResultsExcelWithSkipped creates an Excel file with test results, marking unanswered questions as "skip"
+func CreateResultsExcelWithSkipped(session *models.Session, answers []models.Answer, questions []models.Question, scoreResult scoring.Result, placementResult placement.Result, currentIdx int) (string, error) {
+	return createResultsExcel(session, answers, questions, scoreResult, placementResult, currentIdx)
+}
+
+// createResultsExcel writes the results sheet (and the level summary sheet when a placement is available)
+// Unanswered questions at index skipFrom or later are marked as "skip"
+// Answer options are listed in canonical order, one column per option of the longest question
+// (with its match for match questions); "Shown Order" lists the answer numbers in the order the
+// candidate saw them (the matches for match questions) and "User Choice" the button position pressed
+// Order answers are shown as the sentence built, match answers as the pairs made
+// When the session has reading passages, "Passage" names the passage of each question and a
+// Passages sheet holds their texts
+func createResultsExcel(session *models.Session, answers []models.Answer, questions []models.Question, scoreResult scoring.Result, placementResult placement.Result, skipFrom int) (string, error) {
 	// Create a map of question IDs to answers for quick lookup
 	answerMap := make(map[primitive.ObjectID]models.Answer)
 	for _, a := range answers {
@@ -44,71 +63,98 @@ func CreateResultsExcel(sessionID primitive.ObjectID, answers []models.Answer, q
 	f.DeleteSheet("Sheet1")
 
 	// Set headers
-	headers := []string{"Question", "Answer 1", "Answer 2", "Answer 3", "Answer 4", "Correct Answer", "User Answer", "Result"}
-	for i, header := range headers {
-		cell := fmt.Sprintf("%c1", 'A'+i)
-		f.SetCellValue(sheetName, cell, header)
+	optionColumns := 0
+	var passages []*models.Passage
+	seenPassages := make(map[string]bool)
+	for i := range questions {
+		optionColumns = max(optionColumns, questions[i].GetAnswerCount())
+		if key := questions[i].PassageKey(); key != "" && !seenPassages[key] {
+			seenPassages[key] = true
+			passages = append(passages, questions[i].Passage)
+		}
 	}
-
-	// Style headers
-	headerStyle, err := f.NewStyle(&excelize.Style{
-		Font: &excelize.Font{Bold: true},
-		Fill: excelize.Fill{Type: "pattern", Color: []string{"#E0E0E0"}, Pattern: 1},
-	})
-	if err == nil {
-		f.SetCellStyle(sheetName, "A1", fmt.Sprintf("%c1", 'A'+len(headers)-1), headerStyle)
+	headers := []string{"Question", "Level"}
+	if len(passages) > 0 {
+		headers = append(headers, "Passage")
 	}
+	for i := 1; i <= optionColumns; i++ {
+		headers = append(headers, fmt.Sprintf("Answer %d", i))
+	}
+	headers = append(headers, "Correct Answer", "Shown Order", "User Choice", "User Answer", "Result", "Points")
+	writeHeaders(f, sheetName, headers)
 
 	// Write data rows
 	row := 2
-	for _, question := range questions {
+	for i, question := range questions {
 		answer, answered := answerMap[question.ID]
 
-		// Question text
-		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), question.Text)
-
-		// Answer options
-		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), question.Answer1)
-		f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), question.Answer2)
-		f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), question.Answer3)
-		// Answer4 can be empty for 3-answer questions
-		if question.Answer4 != "" {
-			f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), question.Answer4)
-		} else {
-			f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), "")
+		values := []interface{}{question.Text, question.Level}
+		if len(passages) > 0 {
+			passage := ""
+			if question.Passage != nil {
+				passage = question.Passage.Name()
+			}
+			values = append(values, passage)
 		}
+		for id := 1; id <= optionColumns; id++ {
+			option := question.GetAnswer(id) // Empty for questions with fewer options
+			if match := question.GetMatch(id); match != "" {
+				option += " → " + match
+			}
+			values = append(values, option)
+		}
+		values = append(values,
+			question.CorrectAnswerText(),
+			formatOrder(session.OptionOrder(question.ID, question.GetAnswerCount())),
+		)
 
-		// Correct answer
-		correctAnswerText := question.GetAnswer(question.CorrectAnswerID)
-		f.SetCellValue(sheetName, fmt.Sprintf("F%d", row), correctAnswerText)
-
-		// User answer
-		if answered {
-			userAnswerText := question.GetAnswer(answer.SelectedAnswerID)
-			f.SetCellValue(sheetName, fmt.Sprintf("G%d", row), userAnswerText)
-
-			// Result (+ or -)
+		// User answer and result
+		if answered && answer.TimedOut {
+			values = append(values, "", "Time expired", "-", 0)
+		} else if answered {
+			result := "-"
 			if answer.IsCorrect {
-				f.SetCellValue(sheetName, fmt.Sprintf("H%d", row), "+")
-			} else {
-				f.SetCellValue(sheetName, fmt.Sprintf("H%d", row), "-")
+				result = "+"
+			} else if answer.Credit > 0 {
+				result = fmt.Sprintf("%.0f%%", answer.Credit*100) // Partial credit
+			}
+			choice := ""
+			if answer.DisplayedPosition > 0 {
+				choice = strconv.Itoa(answer.DisplayedPosition)
 			}
+			values = append(values, choice, question.AnswerText(&answer), result, answer.Score)
+		} else if i >= skipFrom {
+			// Not answered due to early test termination
+			values = append(values, "", "Not answered", "skip", 0)
 		} else {
-			f.SetCellValue(sheetName, fmt.Sprintf("G%d", row), "Not answered")
-			f.SetCellValue(sheetName, fmt.Sprintf("H%d", row), "-")
+			values = append(values, "", "Not answered", "-", 0)
 		}
 
+		writeRow(f, sheetName, row, values)
 		row++
 	}
 
 	// Auto-fit columns
-	for i := 0; i < len(headers); i++ {
-		col := string(rune('A' + i))
-		f.SetColWidth(sheetName, col, col, 20)
+	setColumnWidths(f, sheetName, len(headers), 20)
+
+	if err := writeScoreSummary(f, session, scoreResult); err != nil {
+		return "", err
+	}
+
+	if len(passages) > 0 {
+		if err := writePassages(f, passages); err != nil {
+			return "", err
+		}
+	}
+
+	if placementResult.Level != "" {
+		if err := writeLevelSummary(f, placementResult); err != nil {
+			return "", err
+		}
 	}
 
 	// Save file
-	filename := fmt.Sprintf("results_%s.xlsx", sessionID.Hex())
+	filename := fmt.Sprintf("results_%s.xlsx", session.ID.Hex())
 	filepath := filepath.Join(os.TempDir(), filename)
 	if err := f.SaveAs(filepath); err != nil {
 		return "", fmt.Errorf("failed to save Excel file: %w", err)
@@ -117,39 +163,98 @@ func CreateResultsExcel(sessionID primitive.ObjectID, answers []models.Answer, q
 	return filepath, nil
 }
 
-// CreateResultsExcelWithSkipped creates an Excel file with test results, marking unanswered questions as "skip"
-func CreateResultsExcelWithSkipped(sessionID primitive.ObjectID, answers []models.Answer, questions []models.Question, currentIdx int) (string, error) {
-	// Create a map of question IDs to answers for quick lookup
-	answerMap := make(map[primitive.ObjectID]models.Answer)
-	for _, a := range answers {
-		answerMap[a.QuestionID] = a
+// writeScoreSummary adds a sheet with the test, the outcome and the score of the session
+func writeScoreSummary(f *excelize.File, session *models.Session, scoreResult scoring.Result) error {
+	sheetName := "Summary"
+	if _, err := f.NewSheet(sheetName); err != nil {
+		return fmt.Errorf("failed to create sheet: %w", err)
 	}
 
-	// Create new Excel file
-	f := excelize.NewFile()
-	defer func() {
-		if err := f.Close(); err != nil {
-			fmt.Printf("Error closing Excel file: %v\n", err)
-		}
-	}()
+	testName := ""
+	if session.Test != nil {
+		testName = session.Test.Name
+	}
 
-	// Set sheet name
-	sheetName := "Results"
-	index, err := f.NewSheet(sheetName)
-	if err != nil {
-		return "", fmt.Errorf("failed to create sheet: %w", err)
+	rows := [][]interface{}{
+		{"Test", testName},
+		{"Status", session.Status},
+		{"Reason", session.TerminationReason},
+		{"Correct", scoreResult.Correct},
+		{"Partially Correct", scoreResult.Partial},
+		{"Incorrect", scoreResult.Incorrect},
+		{"Skipped", scoreResult.Skipped},
+		{"Score", scoreResult.Score},
+		{"Max Score", scoreResult.MaxScore},
+		{"Percentage", fmt.Sprintf("%.1f%%", scoreResult.Percentage)},
+	}
+	if scoreResult.Passed != nil {
+		rows = append(rows, []interface{}{"Result", scoreResult.Verdict()})
+	}
+	for i, values := range rows {
+		writeRow(f, sheetName, i+1, values)
 	}
-	f.SetActiveSheet(index)
 
-	// Delete default sheet
-	f.DeleteSheet("Sheet1")
+	setColumnWidths(f, sheetName, 2, 20)
+	return nil
+}
 
-	// Set headers
-	headers := []string{"Question", "Answer 1", "Answer 2", "Answer 3", "Answer 4", "Correct Answer", "User Answer", "Result"}
+// writeLevelSummary adds a sheet with the CEFR placement and per-level scores
+func writeLevelSummary(f *excelize.File, placementResult placement.Result) error {
+	sheetName := "Levels"
+	if _, err := f.NewSheet(sheetName); err != nil {
+		return fmt.Errorf("failed to create sheet: %w", err)
+	}
+
+	headers := []string{"Level", "Correct", "Total", "Percentage"}
+	writeHeaders(f, sheetName, headers)
+
+	row := 2
+	for _, s := range placementResult.Levels {
+		writeRow(f, sheetName, row, []interface{}{s.Level, s.Correct, s.Total, fmt.Sprintf("%.1f%%", s.Percentage())})
+		row++
+	}
+
+	row++
+	writeRow(f, sheetName, row, []interface{}{"Placement", placementResult.Level})
+
+	setColumnWidths(f, sheetName, len(headers), 15)
+	return nil
+}
+
+// writePassages adds a sheet with the reading passages of the session in the order they were shown
+func writePassages(f *excelize.File, passages []*models.Passage) error {
+	sheetName := "Passages"
+	if _, err := f.NewSheet(sheetName); err != nil {
+		return fmt.Errorf("failed to create sheet: %w", err)
+	}
+
+	headers := []string{"Passage", "ID", "Text"}
+	writeHeaders(f, sheetName, headers)
+
+	for i, passage := range passages {
+		writeRow(f, sheetName, i+2, []interface{}{passage.Name(), passage.Key, passage.Text})
+	}
+
+	setColumnWidths(f, sheetName, 2, 20)
+	f.SetColWidth(sheetName, "C", "C", 80)
+	return nil
+}
+
+// formatOrder formats an option order as "3,1,4,2"
+func formatOrder(order []int) string {
+	parts := make([]string, len(order))
+	for i, id := range order {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+func writeHeaders(f *excelize.File, sheetName string, headers []string) {
+	values := make([]interface{}, len(headers))
 	for i, header := range headers {
-		cell := fmt.Sprintf("%c1", 'A'+i)
-		f.SetCellValue(sheetName, cell, header)
+		values[i] = header
 	}
+	writeRow(f, sheetName, 1, values)
 
 	// Style headers
 	headerStyle, err := f.NewStyle(&excelize.Style{
@@ -157,70 +262,53 @@ func CreateResultsExcelWithSkipped(sessionID primitive.ObjectID, answers []model
 		Fill: excelize.Fill{Type: "pattern", Color: []string{"#E0E0E0"}, Pattern: 1},
 	})
 	if err == nil {
-		f.SetCellStyle(sheetName, "A1", fmt.Sprintf("%c1", 'A'+len(headers)-1), headerStyle)
+		lastCell, _ := excelize.CoordinatesToCellName(len(headers), 1)
+		f.SetCellStyle(sheetName, "A1", lastCell, headerStyle)
 	}
+}
 
-	// Write data rows
-	row := 2
-	for i, question := range questions {
-		answer, answered := answerMap[question.ID]
-
-		// Question text
-		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), question.Text)
-
-		// Answer options
-		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), question.Answer1)
-		f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), question.Answer2)
-		f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), question.Answer3)
-		// Answer4 can be empty for 3-answer questions
-		if question.Answer4 != "" {
-			f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), question.Answer4)
-		} else {
-			f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), "")
-		}
+func writeRow(f *excelize.File, sheetName string, row int, values []interface{}) {
+	for i, value := range values {
+		cell, _ := excelize.CoordinatesToCellName(i+1, row)
+		f.SetCellValue(sheetName, cell, value)
+	}
+}
 
-		// Correct answer
-		correctAnswerText := question.GetAnswer(question.CorrectAnswerID)
-		f.SetCellValue(sheetName, fmt.Sprintf("F%d", row), correctAnswerText)
+func setColumnWidths(f *excelize.File, sheetName string, columns int, width float64) {
+	for i := 1; i <= columns; i++ {
+		col, _ := excelize.ColumnNumberToName(i)
+		f.SetColWidth(sheetName, col, col, width)
+	}
+}
 
-		// User answer and result
-		if answered {
-			userAnswerText := question.GetAnswer(answer.SelectedAnswerID)
-			f.SetCellValue(sheetName, fmt.Sprintf("G%d", row), userAnswerText)
+// LoadQuestions loads questions from the first sheet of an Excel file
+// The sheet uses the same columns as the CSV format; invalid rows are reported as models.RowErrors
+func LoadQuestions(filename string, checks ...models.QuestionCheck) ([]models.Question, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Excel file: %w", err)
+	}
+	defer file.Close()
 
-			// Result (+ or -)
-			if answer.IsCorrect {
-				f.SetCellValue(sheetName, fmt.Sprintf("H%d", row), "+")
-			} else {
-				f.SetCellValue(sheetName, fmt.Sprintf("H%d", row), "-")
-			}
-		} else {
-			// Check if this question was skipped (not answered due to early test failure)
-			// currentIdx is the first unanswered question index (after the last answered question)
-			if i >= currentIdx {
-				f.SetCellValue(sheetName, fmt.Sprintf("G%d", row), "Not answered")
-				f.SetCellValue(sheetName, fmt.Sprintf("H%d", row), "skip")
-			} else {
-				f.SetCellValue(sheetName, fmt.Sprintf("G%d", row), "Not answered")
-				f.SetCellValue(sheetName, fmt.Sprintf("H%d", row), "-")
-			}
-		}
+	return ReadQuestions(file, checks...)
+}
 
-		row++
+// ReadQuestions reads questions from the first sheet of an Excel workbook in r
+func ReadQuestions(r io.Reader, checks ...models.QuestionCheck) ([]models.Question, error) {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Excel file: %w", err)
 	}
+	defer f.Close()
 
-	// Auto-fit columns
-	for i := 0; i < len(headers); i++ {
-		col := string(rune('A' + i))
-		f.SetColWidth(sheetName, col, col, 20)
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, fmt.Errorf("Excel file has no sheets")
 	}
-
-	// Save file
-	filename := fmt.Sprintf("results_%s.xlsx", sessionID.Hex())
-	filepath := filepath.Join(os.TempDir(), filename)
-	if err := f.SaveAs(filepath); err != nil {
-		return "", fmt.Errorf("failed to save Excel file: %w", err)
+	rows, err := f.GetRows(sheets[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Excel sheet %q: %w", sheets[0], err)
 	}
 
-	return filepath, nil
+	return csv.ParseRecords(rows, checks...)
 }
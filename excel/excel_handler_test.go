@@ -0,0 +1,106 @@
+package excel
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/andru_bot/tg-bot/models"
+	"github.com/andru_bot/tg-bot/placement"
+	"github.com/andru_bot/tg-bot/scoring"
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// workbook returns an Excel file whose first sheet holds rows
+func workbook(t *testing.T, rows [][]interface{}) *bytes.Buffer {
+	t.Helper()
+	f := excelize.NewFile()
+	defer f.Close()
+	for i, row := range rows {
+		cell, err := excelize.CoordinatesToCellName(1, i+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.SetSheetRow("Sheet1", cell, &row); err != nil {
+			t.Fatalf("SetSheetRow() error: %v", err)
+		}
+	}
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatalf("WriteToBuffer() error: %v", err)
+	}
+	return buf
+}
+
+func TestReadQuestions(t *testing.T) {
+	file := workbook(t, [][]interface{}{
+		{"text", "answer_1", "answer_2", "answer_3", "answer_4", "correct_answer_id", "score", "level"},
+		{"She ___ to school.", "goes", "go", "going", "", 1, 2, "A2"},
+		{"They ___ at home.", "are", "is", "be", "", 4, 1, ""},
+		{},
+		{"He ___ a doctor.", "is", "are", "am", "be", 4, 1, "B1"},
+		{"We ___ friends.", "are", "is", "am", "", "one", 1, ""},
+	})
+
+	questions, err := ReadQuestions(file)
+	var rowErrors models.RowErrors
+	if !errors.As(err, &rowErrors) {
+		t.Fatalf("ReadQuestions() error = %v, want models.RowErrors", err)
+	}
+	// Rows are numbered as in the sheet, the header is row 1
+	if len(rowErrors) != 2 || rowErrors[0].Row != 3 || rowErrors[1].Row != 6 {
+		t.Errorf("ReadQuestions() invalid rows = %+v, want rows 3 and 6", rowErrors)
+	}
+	if len(questions) != 2 || questions[0].Text != "She ___ to school." || questions[1].CorrectAnswerID != 4 {
+		t.Errorf("ReadQuestions() = %+v, want rows 2 and 5", questions)
+	}
+}
+
+func TestReadQuestionsNotExcel(t *testing.T) {
+	if _, err := ReadQuestions(bytes.NewBufferString("text,answer_1\n")); err == nil {
+		t.Error("ReadQuestions() of a CSV file error = nil")
+	}
+}
+
+func TestCreateResultsExcelScoreSummary(t *testing.T) {
+	q1 := models.Question{ID: primitive.NewObjectID(), Text: "She ___ to school.", Options: []string{"go", "goes"}, CorrectAnswerID: 2, Score: 2}
+	q2 := models.Question{ID: primitive.NewObjectID(), Text: "They ___ at home.", Options: []string{"is", "are"}, CorrectAnswerID: 2, Score: 2}
+	session := &models.Session{ID: primitive.NewObjectID(), Status: models.StatusCompleted, Questions: []models.Question{q1, q2}}
+	answers := []models.Answer{
+		{QuestionID: q1.ID, SelectedAnswerID: 2, IsCorrect: true, Score: 2},
+		{QuestionID: q2.ID, SelectedAnswerID: 1, Score: -0.5},
+	}
+	// Graded with negative marking and a pass mark, which the report must show as given
+	passed := false
+	scoreResult := scoring.Result{Score: 1.5, MaxScore: 4, Percentage: 37.5, Correct: 1, Incorrect: 1, Passed: &passed, PassThreshold: 50}
+
+	path, err := CreateResultsExcel(session, answers, session.Questions, scoreResult, placement.Result{})
+	if err != nil {
+		t.Fatalf("CreateResultsExcel() error: %v", err)
+	}
+	defer os.Remove(path)
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile() error: %v", err)
+	}
+	defer f.Close()
+	rows, err := f.GetRows("Summary")
+	if err != nil {
+		t.Fatalf("GetRows() error: %v", err)
+	}
+
+	summary := make(map[string]string)
+	for _, row := range rows {
+		if len(row) == 2 {
+			summary[row[0]] = row[1]
+		}
+	}
+	want := map[string]string{"Score": "1.5", "Max Score": "4", "Percentage": "37.5%", "Result": scoreResult.Verdict()}
+	for name, value := range want {
+		if summary[name] != value {
+			t.Errorf("summary %s = %q, want %q", name, summary[name], value)
+		}
+	}
+}
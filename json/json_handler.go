@@ -3,7 +3,9 @@ package json
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/andru_bot/tg-bot/models"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,67 +14,164 @@ import (
 // QuestionData represents the structure of the JSON file
 type QuestionData struct {
 	Questions []QuestionJSON `json:"questions"`
+	Passages  []PassageJSON  `json:"passages"` // Reading passages with the questions about them
+}
+
+// PassageJSON represents a reading passage in the JSON file, its questions are nested under it
+type PassageJSON struct {
+	ID        string         `json:"id"` // Required, identifies the passage's group of questions
+	Title     string         `json:"title"`
+	Text      string         `json:"text"`
+	Questions []QuestionJSON `json:"questions"`
 }
 
 // QuestionJSON represents a question in the JSON file
 type QuestionJSON struct {
-	Text            string `json:"text"`
-	TextHTML        string `json:"text_html"`
-	Answer1         string `json:"answer_1"`
-	Answer1HTML     string `json:"answer_1_html"`
-	Answer2         string `json:"answer_2"`
-	Answer2HTML     string `json:"answer_2_html"`
-	Answer3         string `json:"answer_3"`
-	Answer3HTML     string `json:"answer_3_html"`
-	Answer4         string `json:"answer_4"`
-	Answer4HTML     string `json:"answer_4_html"`
-	CorrectAnswerID int    `json:"correct_answer_id"`
-	Score           int    `json:"score"`
+	ID               string   `json:"id"` // Optional stable key, see models.Question.Key
+	Text             string   `json:"text"`
+	TextHTML         string   `json:"text_html"`
+	Options          []string `json:"options"`            // MinOptions to MaxOptions answers
+	CorrectAnswerIDs []int    `json:"correct_answer_ids"` // Every correct option of "multiple" questions
+	Matches          []string `json:"matches"`            // The match of each option of "match" questions, in option order
+	Answer1          string   `json:"answer_1"`           // answer_1 ... answer_4: options in the format before options were a list
+	Answer1HTML      string   `json:"answer_1_html"`
+	Answer2          string   `json:"answer_2"`
+	Answer2HTML      string   `json:"answer_2_html"`
+	Answer3          string   `json:"answer_3"`
+	Answer3HTML      string   `json:"answer_3_html"`
+	Answer4          string   `json:"answer_4"`
+	Answer4HTML      string   `json:"answer_4_html"`
+	CorrectAnswerID  int      `json:"correct_answer_id"`
+	Type             string   `json:"type"`             // Optional, "choice" (default), "multiple", "order", "match" or "text"
+	AcceptedAnswers  []string `json:"accepted_answers"` // Answers graded as correct for "text" questions
+	Tolerance        int      `json:"tolerance"`        // Typos allowed in "text" answers
+	Audio            string   `json:"audio"`            // Optional recording in the media directory, e.g. "listening/station.mp3"
+	Image            string   `json:"image"`            // Optional picture in the media directory
+	Score            int      `json:"score"`
+	Level            string   `json:"level"`      // Optional CEFR level (A1-C2)
+	Difficulty       *float64 `json:"difficulty"` // Optional Rasch difficulty in logits for adaptive tests
+	Tags             []string `json:"tags"`       // Optional categories such as "grammar"
 }
 
 // LoadQuestions loads questions from JSON file
-func LoadQuestions(filename string) ([]models.Question, error) {
+// Invalid questions are reported together as models.RowErrors, see ReadQuestions
+func LoadQuestions(filename string, checks ...models.QuestionCheck) ([]models.Question, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open JSON file: %w", err)
 	}
 	defer file.Close()
 
+	return ReadQuestions(file, checks...)
+}
+
+// ReadQuestions reads questions in JSON format from r
+// Every invalid question is skipped and reported; if any question is invalid the valid ones
+// are returned together with a models.RowErrors error numbering questions from 1, the questions
+// of passages after the top-level ones. Questions failing one of the checks count as invalid
+func ReadQuestions(r io.Reader, checks ...models.QuestionCheck) ([]models.Question, error) {
 	var data QuestionData
-	decoder := json.NewDecoder(file)
+	decoder := json.NewDecoder(r)
 	if err := decoder.Decode(&data); err != nil {
 		return nil, fmt.Errorf("failed to decode JSON file: %w", err)
 	}
 
-	if len(data.Questions) == 0 {
+	count := len(data.Questions)
+	for _, pJSON := range data.Passages {
+		count += len(pJSON.Questions)
+	}
+	if count == 0 {
 		return nil, fmt.Errorf("JSON file must contain at least one question")
 	}
 
 	var questions []models.Question
-	for i, qJSON := range data.Questions {
-		// Validate correct answer ID is within range
-		maxAnswerID := 3
-		if qJSON.Answer4 != "" {
-			maxAnswerID = 4
+	var rowErrors models.RowErrors
+	row := 0
+	read := func(qJSON *QuestionJSON, passage *models.Passage, passageErr error) {
+		row++
+		question, err := qJSON.toQuestion(passage)
+		if passageErr != nil {
+			err = passageErr
 		}
-		if qJSON.CorrectAnswerID < 1 || qJSON.CorrectAnswerID > maxAnswerID {
-			return nil, fmt.Errorf("invalid correct_answer_id at question %d: must be between 1 and %d (question has %d answers)", i+1, maxAnswerID, maxAnswerID)
+		if err == nil {
+			err = models.RunChecks(question, checks)
 		}
-
-		question := models.Question{
-			ID:              primitive.NewObjectID(),
-			Text:            qJSON.Text,
-			TextHTML:        qJSON.TextHTML,
-			Answer1:         qJSON.Answer1,
-			Answer2:         qJSON.Answer2,
-			Answer3:         qJSON.Answer3,
-			Answer4:         qJSON.Answer4,
-			CorrectAnswerID: qJSON.CorrectAnswerID,
-			Score:           qJSON.Score,
+		if err != nil {
+			rowErrors = append(rowErrors, models.RowError{Row: row, Message: err.Error()})
+			return
 		}
+		questions = append(questions, *question)
+	}
+	for i := range data.Questions {
+		read(&data.Questions[i], nil, nil)
+	}
 
-		questions = append(questions, question)
+	passageIDs := make(map[string]bool, len(data.Passages))
+	for _, pJSON := range data.Passages {
+		// Every question of an invalid passage is reported, each would be missing from the bank
+		passage := &models.Passage{Key: strings.TrimSpace(pJSON.ID), Title: strings.TrimSpace(pJSON.Title), Text: pJSON.Text}
+		err := passage.Validate()
+		if err == nil && passageIDs[passage.Key] {
+			err = fmt.Errorf("passage id %q is used twice", passage.Key)
+		}
+		passageIDs[passage.Key] = true
+		for i := range pJSON.Questions {
+			read(&pJSON.Questions[i], passage, err)
+		}
 	}
 
+	if len(rowErrors) > 0 {
+		return questions, rowErrors
+	}
 	return questions, nil
 }
+
+// toQuestion converts a question of the file into a validated question of the passage, if it has one
+func (qJSON *QuestionJSON) toQuestion(passage *models.Passage) (*models.Question, error) {
+	// Validate optional CEFR level
+	level := qJSON.Level
+	if strings.TrimSpace(level) != "" {
+		var ok bool
+		if level, ok = models.NormalizeLevel(level); !ok {
+			return nil, fmt.Errorf("invalid level %q: must be one of %s", qJSON.Level, strings.Join(models.CEFRLevels, ", "))
+		}
+	}
+
+	questionType, ok := models.NormalizeQuestionType(qJSON.Type)
+	if !ok {
+		return nil, fmt.Errorf("invalid type %q: must be one of %s", qJSON.Type, strings.Join(models.QuestionTypes, ", "))
+	}
+
+	options := qJSON.Options
+	if legacy := models.OptionsFromAnswers(qJSON.Answer1, qJSON.Answer2, qJSON.Answer3, qJSON.Answer4); legacy != nil {
+		if options != nil {
+			return nil, fmt.Errorf("options and answer_1 ... answer_4 can't be combined")
+		}
+		options = legacy
+	}
+
+	question := &models.Question{
+		ID:               primitive.NewObjectID(),
+		ExternalID:       strings.TrimSpace(qJSON.ID),
+		Text:             qJSON.Text,
+		TextHTML:         qJSON.TextHTML,
+		Options:          options,
+		CorrectAnswerID:  qJSON.CorrectAnswerID,
+		CorrectAnswerIDs: qJSON.CorrectAnswerIDs,
+		Matches:          qJSON.Matches,
+		Type:             questionType,
+		AcceptedAnswers:  qJSON.AcceptedAnswers,
+		Tolerance:        qJSON.Tolerance,
+		Audio:            strings.TrimSpace(qJSON.Audio),
+		Image:            strings.TrimSpace(qJSON.Image),
+		Passage:          passage,
+		Score:            qJSON.Score,
+		Level:            level,
+		Difficulty:       qJSON.Difficulty,
+		Tags:             models.NormalizeTags(qJSON.Tags),
+	}
+	if err := question.Validate(); err != nil {
+		return nil, err
+	}
+	return question, nil
+}
@@ -0,0 +1,132 @@
+package json
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/andru_bot/tg-bot/models"
+)
+
+func TestReadQuestions(t *testing.T) {
+	file := `{"questions": [
+		{"text": "She ___ to school.", "answer_1": "goes", "answer_2": "go", "answer_3": "going", "correct_answer_id": 1, "score": 2, "level": "a2"},
+		{"text": "They ___ at home.", "answer_1": "are", "answer_2": "is", "answer_3": "be", "correct_answer_id": 4, "score": 1},
+		{"text": "", "answer_1": "am", "answer_2": "is", "answer_3": "are", "correct_answer_id": 1, "score": 1},
+		{"text": "We ___ friends.", "answer_1": "are", "answer_2": "is", "answer_3": "am", "correct_answer_id": 1, "score": 1, "level": "D1"},
+		{"text": "He ___ a doctor.", "answer_1": "is", "answer_2": "are", "answer_3": "am", "answer_4": "be", "correct_answer_id": 4, "score": 1, "difficulty": 0.5}
+	]}`
+
+	questions, err := ReadQuestions(strings.NewReader(file))
+	var rowErrors models.RowErrors
+	if !errors.As(err, &rowErrors) {
+		t.Fatalf("ReadQuestions() error = %v, want models.RowErrors", err)
+	}
+	var rows []int
+	for _, e := range rowErrors {
+		rows = append(rows, e.Row)
+	}
+	if !slices.Equal(rows, []int{2, 3, 4}) {
+		t.Errorf("ReadQuestions() invalid questions = %v, want [2 3 4]", rows)
+	}
+
+	if len(questions) != 2 {
+		t.Fatalf("ReadQuestions() = %d questions, want 2", len(questions))
+	}
+	if q := questions[0]; q.Text != "She ___ to school." || q.Level != "A2" || q.Score != 2 {
+		t.Errorf("question 1 = %+v", q)
+	}
+	if q := questions[1]; q.CorrectAnswerID != 4 || q.Difficulty == nil || *q.Difficulty != 0.5 {
+		t.Errorf("question 5 = %+v", q)
+	}
+}
+
+func TestReadQuestionsOptions(t *testing.T) {
+	file := `{"questions": [
+		{"text": "Which are verbs?", "type": "multiple", "options": ["run", "blue", "eat", "table", "sing"], "correct_answer_ids": [5, 1, 3], "score": 3},
+		{"text": "She ___ to school.", "options": ["goes", "go"], "correct_answer_id": 1, "score": 1},
+		{"text": "They ___ at home.", "options": ["are", "is"], "answer_1": "are", "answer_2": "is", "correct_answer_id": 1, "score": 1},
+		{"text": "Which are nouns?", "type": "multiple", "options": ["run", "table"], "score": 1}
+	]}`
+
+	questions, err := ReadQuestions(strings.NewReader(file))
+	var rowErrors models.RowErrors
+	if !errors.As(err, &rowErrors) || len(rowErrors) != 2 || rowErrors[0].Row != 3 || rowErrors[1].Row != 4 {
+		t.Fatalf("ReadQuestions() error = %v, want questions 3 and 4 invalid", err)
+	}
+	if len(questions) != 2 {
+		t.Fatalf("ReadQuestions() = %d questions, want 2", len(questions))
+	}
+	if q := questions[0]; !q.IsMultiple() || len(q.Options) != 5 || !slices.Equal(q.CorrectIDs(), []int{1, 3, 5}) {
+		t.Errorf("question 1 = %+v, want a multiple question with five options", q)
+	}
+	if q := questions[1]; !slices.Equal(q.Options, []string{"goes", "go"}) {
+		t.Errorf("question 2 = %+v, want two options", q)
+	}
+}
+
+func TestReadQuestionsPassages(t *testing.T) {
+	file := `{
+		"questions": [
+			{"text": "She ___ to school.", "options": ["goes", "go"], "correct_answer_id": 1, "score": 1}
+		],
+		"passages": [
+			{"id": "p1", "title": "At the station", "text": "The train to Leeds leaves at nine.", "questions": [
+				{"text": "Where does the train go?", "options": ["Leeds", "York"], "correct_answer_id": 1, "score": 1},
+				{"text": "When does it leave?", "options": ["at nine", "at ten"], "correct_answer_id": 1, "score": 1}
+			]},
+			{"id": "p2", "text": "", "questions": [
+				{"text": "What is it about?", "options": ["a cat", "a dog"], "correct_answer_id": 1, "score": 1}
+			]},
+			{"id": "p1", "text": "Another text.", "questions": [
+				{"text": "Who is waiting?", "options": ["Tom", "Ann"], "correct_answer_id": 1, "score": 1}
+			]}
+		]
+	}`
+
+	questions, err := ReadQuestions(strings.NewReader(file))
+	var rowErrors models.RowErrors
+	if !errors.As(err, &rowErrors) || len(rowErrors) != 2 || rowErrors[0].Row != 4 || rowErrors[1].Row != 5 {
+		t.Fatalf("ReadQuestions() error = %v, want questions 4 and 5 invalid", err)
+	}
+	if len(questions) != 3 {
+		t.Fatalf("ReadQuestions() = %d questions, want 3", len(questions))
+	}
+	if questions[0].Passage != nil {
+		t.Errorf("top-level question has passage %+v", questions[0].Passage)
+	}
+	for _, q := range questions[1:] {
+		if q.Passage == nil || q.Passage.Key != "p1" || q.Passage.Title != "At the station" {
+			t.Errorf("question %q has passage %+v, want p1", q.Text, q.Passage)
+		}
+	}
+}
+
+func TestReadQuestionsTypedAnswer(t *testing.T) {
+	file := `{"questions": [
+		{"text": "She ___ to school.", "type": "text", "accepted_answers": ["goes", "walks"], "tolerance": 1, "score": 2},
+		{"text": "They ___ at home.", "type": "text", "score": 1},
+		{"text": "I ___ a student.", "type": "essay", "accepted_answers": ["am"], "score": 1}
+	]}`
+
+	questions, err := ReadQuestions(strings.NewReader(file))
+	var rowErrors models.RowErrors
+	if !errors.As(err, &rowErrors) || len(rowErrors) != 2 {
+		t.Fatalf("ReadQuestions() error = %v, want 2 invalid questions", err)
+	}
+	if len(questions) != 1 {
+		t.Fatalf("ReadQuestions() = %d questions, want 1", len(questions))
+	}
+	if q := questions[0]; !q.IsTextAnswer() || !slices.Equal(q.AcceptedAnswers, []string{"goes", "walks"}) || q.Tolerance != 1 {
+		t.Errorf("question 1 = %+v, want a typed-answer question", q)
+	}
+}
+
+func TestReadQuestionsBadFile(t *testing.T) {
+	for _, file := range []string{`{"questions": []}`, `{"questions": [`, `not json`} {
+		if _, err := ReadQuestions(strings.NewReader(file)); err == nil {
+			t.Errorf("ReadQuestions(%q) error = nil", file)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package json
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andru_bot/tg-bot/models"
+	"github.com/andru_bot/tg-bot/scoring"
+	"github.com/andru_bot/tg-bot/termination"
+)
+
+// TestData represents the structure of the tests file
+type TestData struct {
+	Tests []TestJSON `json:"tests"`
+}
+
+// TestJSON represents a test in the tests file
+// Settings left out take their value from the default test, see config.GetDefaultTest
+type TestJSON struct {
+	ID                string          `json:"id"` // Stable key of the test
+	Name              string          `json:"name"`
+	Description       string          `json:"description"`
+	Questions         []string        `json:"questions"`      // Question keys (the "id" of questions.json), empty for the whole bank
+	Levels            []string        `json:"levels"`         // CEFR levels of the questions, empty for every level
+	QuestionCount     int             `json:"question_count"` // Questions drawn at random from the selection, 0 for all
+	Blueprint         []BlueprintJSON `json:"blueprint"`      // Pools the questions are drawn from, instead of question_count
+	Mode              string          `json:"mode"`
+	ShuffleQuestions  *bool           `json:"shuffle_questions"`
+	ShuffleOptions    *bool           `json:"shuffle_options"`
+	NegativeMarking   *float64        `json:"negative_marking"`
+	PassThreshold     *float64        `json:"pass_threshold"`
+	PartialCredit     string          `json:"partial_credit"` // Partial credit scheme of multiple questions
+	TerminationPolicy string          `json:"termination_policy"`
+	QuestionTimeLimit *string         `json:"question_time_limit"` // Duration such as "45s", "0" for no limit
+	TimeLimit         *string         `json:"time_limit"`          // Duration such as "30m", "0" for no limit
+}
+
+// BlueprintJSON represents a blueprint rule such as {"count": 5, "level": "A1", "tags": ["grammar"]}
+type BlueprintJSON struct {
+	Count int      `json:"count"`
+	Level string   `json:"level"`
+	Tags  []string `json:"tags"`
+}
+
+// LoadTests loads the test catalog from a JSON file
+// Returns os.ErrNotExist (wrapped) when the file does not exist
+// Invalid tests are reported together as models.RowErrors, see ReadTests
+func LoadTests(filename string, defaults models.Test) ([]models.Test, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open JSON file: %w", err)
+	}
+	defer file.Close()
+
+	return ReadTests(file, defaults)
+}
+
+// ReadTests reads tests in JSON format from r, filling left out settings from defaults
+// Every invalid test is skipped and reported; if any test is invalid the valid ones
+// are returned together with a models.RowErrors error numbering tests from 1
+func ReadTests(r io.Reader, defaults models.Test) ([]models.Test, error) {
+	var data TestData
+	decoder := json.NewDecoder(r)
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON file: %w", err)
+	}
+
+	var tests []models.Test
+	var rowErrors models.RowErrors
+	seen := make(map[string]bool)
+	for i, tJSON := range data.Tests {
+		test, err := tJSON.toTest(defaults)
+		if err == nil && seen[test.Key] {
+			err = fmt.Errorf("id %q is used by another test", test.Key)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, models.RowError{Row: i + 1, Message: err.Error()})
+			continue
+		}
+		seen[test.Key] = true
+		test.Position = i
+		tests = append(tests, *test)
+	}
+
+	if len(rowErrors) > 0 {
+		return tests, rowErrors
+	}
+	return tests, nil
+}
+
+// toTest converts a test of the file into a validated test
+func (tJSON *TestJSON) toTest(defaults models.Test) (*models.Test, error) {
+	test := &models.Test{
+		Key:               strings.TrimSpace(tJSON.ID),
+		Name:              strings.TrimSpace(tJSON.Name),
+		Description:       strings.TrimSpace(tJSON.Description),
+		QuestionCount:     tJSON.QuestionCount,
+		Mode:              defaults.Mode,
+		ShuffleQuestions:  defaults.ShuffleQuestions,
+		ShuffleOptions:    defaults.ShuffleOptions,
+		NegativeMarking:   defaults.NegativeMarking,
+		PassThreshold:     defaults.PassThreshold,
+		PartialCredit:     defaults.PartialCredit,
+		TerminationPolicy: defaults.TerminationPolicy,
+		QuestionTimeLimit: defaults.QuestionTimeLimit,
+		TimeLimit:         defaults.TimeLimit,
+	}
+	if test.Key == "" {
+		return nil, fmt.Errorf("id is required")
+	}
+	if test.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	for _, key := range tJSON.Questions {
+		if key = strings.TrimSpace(key); key != "" {
+			test.QuestionKeys = append(test.QuestionKeys, key)
+		}
+	}
+	for _, levelStr := range tJSON.Levels {
+		level, ok := models.NormalizeLevel(levelStr)
+		if !ok {
+			return nil, fmt.Errorf("invalid level %q: must be one of %s", levelStr, strings.Join(models.CEFRLevels, ", "))
+		}
+		test.Levels = append(test.Levels, level)
+	}
+	if test.QuestionCount < 0 {
+		return nil, fmt.Errorf("question_count must not be negative")
+	}
+	for i, rJSON := range tJSON.Blueprint {
+		rule := models.BlueprintRule{Count: rJSON.Count, Tags: models.NormalizeTags(rJSON.Tags)}
+		if rule.Count < 1 {
+			return nil, fmt.Errorf("blueprint rule %d: count must be at least 1", i+1)
+		}
+		if strings.TrimSpace(rJSON.Level) != "" {
+			var ok bool
+			if rule.Level, ok = models.NormalizeLevel(rJSON.Level); !ok {
+				return nil, fmt.Errorf("blueprint rule %d: invalid level %q: must be one of %s", i+1, rJSON.Level, strings.Join(models.CEFRLevels, ", "))
+			}
+		}
+		test.Blueprint = append(test.Blueprint, rule)
+	}
+	if len(test.Blueprint) > 0 && test.QuestionCount > 0 {
+		return nil, fmt.Errorf("blueprint and question_count can't be combined")
+	}
+
+	if mode := strings.ToLower(strings.TrimSpace(tJSON.Mode)); mode != "" {
+		if mode != models.ModeLinear && mode != models.ModeAdaptive {
+			return nil, fmt.Errorf("mode must be %s or %s", models.ModeLinear, models.ModeAdaptive)
+		}
+		test.Mode = mode
+	}
+	if len(test.Blueprint) > 0 && test.Mode == models.ModeAdaptive {
+		return nil, fmt.Errorf("blueprint can't be used in %s mode", models.ModeAdaptive)
+	}
+	if tJSON.ShuffleQuestions != nil {
+		test.ShuffleQuestions = *tJSON.ShuffleQuestions
+	}
+	if tJSON.ShuffleOptions != nil {
+		test.ShuffleOptions = *tJSON.ShuffleOptions
+	}
+
+	if tJSON.NegativeMarking != nil {
+		if *tJSON.NegativeMarking < 0 || *tJSON.NegativeMarking > 1 {
+			return nil, fmt.Errorf("negative_marking must be between 0 and 1")
+		}
+		test.NegativeMarking = *tJSON.NegativeMarking
+	}
+	if tJSON.PassThreshold != nil {
+		if *tJSON.PassThreshold < 0 || *tJSON.PassThreshold > 100 {
+			return nil, fmt.Errorf("pass_threshold must be between 0 and 100")
+		}
+		test.PassThreshold = *tJSON.PassThreshold
+	}
+	if strings.TrimSpace(tJSON.PartialCredit) != "" {
+		scheme, ok := scoring.NormalizePartialCredit(tJSON.PartialCredit)
+		if !ok {
+			return nil, fmt.Errorf("partial_credit must be one of %s", strings.Join(scoring.PartialCreditSchemes, ", "))
+		}
+		test.PartialCredit = scheme
+	}
+
+	if spec := strings.TrimSpace(tJSON.TerminationPolicy); spec != "" {
+		policies, err := termination.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid termination_policy: %w", err)
+		}
+		test.TerminationPolicy = policies.String()
+	}
+
+	var err error
+	if tJSON.QuestionTimeLimit != nil {
+		if test.QuestionTimeLimit, err = parseDuration(*tJSON.QuestionTimeLimit); err != nil {
+			return nil, fmt.Errorf("invalid question_time_limit: %w", err)
+		}
+	}
+	if tJSON.TimeLimit != nil {
+		if test.TimeLimit, err = parseDuration(*tJSON.TimeLimit); err != nil {
+			return nil, fmt.Errorf("invalid time_limit: %w", err)
+		}
+	}
+
+	return test, nil
+}
+
+// parseDuration parses a non-negative duration such as "90s" or "30m"; a plain number is read as seconds
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		value = fmt.Sprintf("%ds", seconds)
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, errors.New(`must be a non-negative duration like "90s" or "30m"`)
+	}
+	return d, nil
+}
@@ -0,0 +1,108 @@
+package json
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andru_bot/tg-bot/models"
+)
+
+func TestReadTests(t *testing.T) {
+	defaults := models.Test{
+		Mode:              models.ModeLinear,
+		ShuffleOptions:    true,
+		PassThreshold:     60,
+		TerminationPolicy: "consecutive_errors:5",
+		QuestionTimeLimit: time.Minute,
+	}
+	file := `{"tests": [
+		{"id": "placement", "name": "Placement", "mode": "ADAPTIVE", "levels": ["a2", "b1"], "time_limit": "30m"},
+		{"id": "quick", "name": "Quick", "questions": ["q1", " q2 ", ""], "question_count": 2,
+		 "shuffle_options": false, "pass_threshold": 0, "question_time_limit": "0", "termination_policy": "total_errors:3, time"},
+		{"id": "", "name": "No ID"},
+		{"id": "quick", "name": "Same ID"},
+		{"id": "bad", "name": "Bad", "levels": ["D1"]},
+		{"id": "bad2", "name": "Bad", "termination_policy": "sometimes"},
+		{"id": "bad3", "name": "Bad", "time_limit": "-5m"},
+		{"id": "bad4", "name": "Bad", "negative_marking": 2}
+	]}`
+
+	tests, err := ReadTests(strings.NewReader(file), defaults)
+	var rowErrors models.RowErrors
+	if !errors.As(err, &rowErrors) {
+		t.Fatalf("ReadTests() error = %v, want models.RowErrors", err)
+	}
+	var rows []int
+	for _, e := range rowErrors {
+		rows = append(rows, e.Row)
+	}
+	if len(rows) != 6 || rows[0] != 3 || rows[5] != 8 {
+		t.Errorf("ReadTests() invalid tests = %v, want 3 to 8", rows)
+	}
+	if len(tests) != 2 {
+		t.Fatalf("ReadTests() = %d tests, want 2", len(tests))
+	}
+
+	placement := tests[0]
+	if placement.Mode != models.ModeAdaptive || len(placement.Levels) != 2 || placement.Levels[1] != "B1" || placement.TimeLimit != 30*time.Minute {
+		t.Errorf("placement test = %+v", placement)
+	}
+	// Left out settings come from the defaults
+	if !placement.ShuffleOptions || placement.PassThreshold != 60 || placement.QuestionTimeLimit != time.Minute || placement.TerminationPolicy != "consecutive_errors:5" {
+		t.Errorf("placement test defaults = %+v", placement)
+	}
+
+	quick := tests[1]
+	if quick.Position != 1 || len(quick.QuestionKeys) != 2 || quick.QuestionKeys[1] != "q2" || quick.QuestionCount != 2 {
+		t.Errorf("quick test = %+v", quick)
+	}
+	if quick.ShuffleOptions || quick.PassThreshold != 0 || quick.QuestionTimeLimit != 0 || quick.TerminationPolicy != "total_errors:3,time" {
+		t.Errorf("quick test settings = %+v, want the defaults overridden", quick)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"90", 90 * time.Second, false},
+		{" 45s ", 45 * time.Second, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"0", 0, false},
+		{"-1s", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseDuration(%q) = %v, %v, want %v (error %v)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestReadTestsBlueprint(t *testing.T) {
+	file := `{"tests": [
+		{"id": "form", "name": "Form", "blueprint": [{"count": 2, "level": "a1", "tags": ["Grammar", " grammar"]}, {"count": 1}]},
+		{"id": "zero", "name": "Bad", "blueprint": [{"count": 0}]},
+		{"id": "level", "name": "Bad", "blueprint": [{"count": 1, "level": "Z9"}]},
+		{"id": "count", "name": "Bad", "question_count": 3, "blueprint": [{"count": 1}]},
+		{"id": "adaptive", "name": "Bad", "mode": "adaptive", "blueprint": [{"count": 1}]}
+	]}`
+
+	tests, err := ReadTests(strings.NewReader(file), models.Test{Mode: models.ModeLinear})
+	var rowErrors models.RowErrors
+	if !errors.As(err, &rowErrors) || len(rowErrors) != 4 {
+		t.Fatalf("ReadTests() error = %v, want 4 invalid tests", err)
+	}
+	if len(tests) != 1 {
+		t.Fatalf("ReadTests() = %d tests, want 1", len(tests))
+	}
+	rules := tests[0].Blueprint
+	if len(rules) != 2 || rules[0].String() != "2 A1 grammar" || rules[1].String() != "1 any" {
+		t.Errorf("blueprint = %+v, want [2 A1 grammar] [1 any]", rules)
+	}
+}
@@ -1,17 +1,39 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/andru_bot/tg-bot/bank"
+	"github.com/andru_bot/tg-bot/blueprint"
 	"github.com/andru_bot/tg-bot/bot"
 	"github.com/andru_bot/tg-bot/config"
 	"github.com/andru_bot/tg-bot/database"
 	"github.com/andru_bot/tg-bot/json"
+	"github.com/andru_bot/tg-bot/media"
+	"github.com/andru_bot/tg-bot/models"
+	"github.com/andru_bot/tg-bot/server"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run starts the bot and blocks until it was shut down
+// Errors are returned instead of exiting, so the storage backend is closed (releasing e.g. the
+// bolt file lock) on every path except a shutdown that timed out with workers still using it
+func run() error {
 	// Load environment variables from the appropriate file based on ENV variable
 	envFile := config.GetEnvFile()
 	log.Printf("Loading environment from %s", envFile)
@@ -24,47 +46,69 @@ func main() {
 	// Get bot token from config
 	botToken, err := config.GetTelegramBotToken()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	// Connect to MongoDB
-	err = database.Connect()
+	// Open the configured storage backend
+	storageDriver := config.GetStorageDriver()
+	repos, err := database.Open(storageDriver)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		return fmt.Errorf("failed to open %s storage: %w", storageDriver, err)
 	}
-	defer database.Disconnect()
+	workersRunning := false // Set when the shutdown timed out; the storage must stay open for the workers
+	defer func() {
+		if workersRunning {
+			log.Println("Leaving storage open, workers are still running")
+			return
+		}
+		if err := repos.Close(); err != nil {
+			log.Printf("Error closing %s storage: %v", storageDriver, err)
+		}
+	}()
 
-	// Load questions from JSON
-	questions, err := json.LoadQuestions("questions.json")
-	if err != nil {
-		log.Fatalf("Failed to load questions: %v", err)
+	// Load questions from JSON, checking that their audio and image files exist
+	questions, err := json.LoadQuestions("questions.json", media.Dir(config.GetMediaDir()).Check)
+	var rowErrors models.RowErrors
+	if errors.As(err, &rowErrors) {
+		// Start with the valid questions
+		for _, rowErr := range rowErrors {
+			log.Printf("Skipping invalid question in questions.json: %v", rowErr)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to load questions: %w", err)
 	}
 
 	// Store questions in database
-	questionRepo := database.NewQuestionRepository()
-	existingQuestions, err := questionRepo.GetAll()
-	if err != nil {
-		log.Printf("Error checking existing questions: %v", err)
+	if config.GetQuestionsSync() {
+		syncQuestions(repos, questions, len(rowErrors) > 0)
+	} else {
+		importQuestions(repos, questions)
 	}
 
-	// Only insert questions if database is empty
-	if len(existingQuestions) == 0 {
-		log.Println("Loading questions into database...")
-		for _, q := range questions {
-			err := questionRepo.Create(&q)
-			if err != nil {
-				log.Printf("Error inserting question: %v", err)
-			}
+	// Load the test catalog; without tests.json the catalog is the test defined by the environment
+	defaultTest := config.GetDefaultTest()
+	tests, err := json.LoadTests("tests.json", defaultTest)
+	var testErrors models.RowErrors
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		tests = nil
+	case errors.As(err, &testErrors):
+		for _, rowErr := range testErrors {
+			log.Printf("Skipping invalid test in tests.json: %v", rowErr)
 		}
-		log.Printf("Loaded %d questions into database", len(questions))
-	} else {
-		log.Printf("Database already contains %d questions, skipping import", len(existingQuestions))
+	case err != nil:
+		return fmt.Errorf("failed to load tests: %w", err)
+	}
+	if len(tests) == 0 && len(testErrors) == 0 {
+		tests = []models.Test{defaultTest}
 	}
+	syncTests(repos, tests, len(testErrors) > 0)
+	checkBlueprints(repos, tests)
 
 	// Initialize Telegram bot
-	telegramBot, err := tgbotapi.NewBotAPI(botToken)
+	telegramBot, err := tgbotapi.NewBotAPIWithAPIEndpoint(botToken, config.GetTelegramAPIEndpoint())
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	log.Printf("Authorized on account %s", telegramBot.Self.UserName)
@@ -86,8 +130,66 @@ func main() {
 
 	// Create bot handler
 	resultsCSVPath := "results.csv"
-	botHandler := bot.NewBotHandler(telegramBot, resultsCSVPath)
+	botHandler := bot.NewBotHandler(telegramBot, repos, resultsCSVPath)
 	botHandler.LoadQuestions(questions)
+	botHandler.SetAdminCommands(commands)
+
+	// Restore time limits of tests that were in progress before the restart
+	if err := botHandler.LoadActiveSessions(); err != nil {
+		log.Printf("Error loading active sessions: %v", err)
+	}
+
+	// Expire tests abandoned for longer than SESSION_IDLE_TIMEOUT
+	botHandler.StartSessionSweeper()
+
+	// Stop on Ctrl+C and on SIGTERM from Docker
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Also stop when the bot can't go on, e.g. the HTTP server failed; the cause is returned after shutdown
+	ctx, fail := context.WithCancelCause(ctx)
+	defer fail(nil)
+
+	// HTTP server for the webhook, health checks and metrics
+	var httpServer *server.Server
+	if addr := config.GetListenAddr(); addr != "" {
+		httpServer = server.New(addr)
+		httpServer.RegisterGauge("tgbot_active_sessions", "Test sessions currently held in memory.", func() float64 {
+			return float64(botHandler.ActiveSessionCount())
+		})
+	}
+
+	if config.GetBotMode() == config.BotModeWebhook {
+		if err := runWebhook(ctx, fail, telegramBot, botHandler, httpServer, botToken); err != nil {
+			fail(err)
+		}
+	} else {
+		runPolling(ctx, fail, telegramBot, botHandler, httpServer)
+	}
+
+	// Updates are no longer received; finish what was already accepted
+	// A second Ctrl+C kills the process right away
+	stop()
+	if err := shutdown(botHandler, httpServer); err != nil {
+		workersRunning = true
+		return err
+	}
+	if err := context.Cause(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// runPolling receives updates with long polling until ctx is cancelled
+func runPolling(ctx context.Context, fail context.CancelCauseFunc, telegramBot *tgbotapi.BotAPI, botHandler *bot.BotHandler, httpServer *server.Server) {
+	if httpServer != nil {
+		go serveHTTP(httpServer, fail)
+	}
+
+	// getUpdates is refused while a webhook is set, e.g. after switching back from webhook mode
+	_, err := telegramBot.Request(tgbotapi.DeleteWebhookConfig{})
+	if err != nil {
+		log.Printf("Warning: Failed to delete webhook: %v", err)
+	}
 
 	// Set up update config
 	u := tgbotapi.NewUpdate(0)
@@ -95,10 +197,209 @@ func main() {
 
 	updates := telegramBot.GetUpdatesChan(u)
 
-	log.Println("Bot is running. Press Ctrl+C to stop.")
+	log.Println("Bot is running in polling mode. Press Ctrl+C to stop.")
 
-	// Handle updates
-	for update := range updates {
+	handle := func(update tgbotapi.Update) {
+		if httpServer != nil {
+			httpServer.CountUpdate()
+		}
 		botHandler.HandleUpdate(update)
 	}
+
+	// Handle updates
+	for {
+		select {
+		case update := <-updates:
+			handle(update)
+		case <-ctx.Done():
+			log.Println("Shutting down: stopped receiving updates")
+			telegramBot.StopReceivingUpdates()
+
+			// Updates already fetched are confirmed to Telegram and would be lost, handle them too
+			for {
+				select {
+				case update, ok := <-updates:
+					if !ok {
+						return
+					}
+					handle(update)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// runWebhook registers the webhook with Telegram and serves updates until ctx is cancelled
+func runWebhook(ctx context.Context, fail context.CancelCauseFunc, telegramBot *tgbotapi.BotAPI, botHandler *bot.BotHandler, httpServer *server.Server, botToken string) error {
+	webhookURL, err := config.GetWebhookURL()
+	if err != nil {
+		return err
+	}
+	webhookPath, err := server.WebhookPath(webhookURL)
+	if err != nil {
+		return err
+	}
+
+	secretToken := config.GetWebhookSecret()
+	if secretToken == "" {
+		secretToken = server.DeriveSecretToken(botToken)
+	}
+
+	httpServer.HandleWebhook(webhookPath, secretToken, botHandler.HandleUpdate)
+
+	err = server.RegisterWebhook(telegramBot, webhookURL, secretToken)
+	if err != nil {
+		return err
+	}
+
+	go serveHTTP(httpServer, fail)
+
+	log.Printf("Bot is running in webhook mode at %s. Press Ctrl+C to stop.", webhookURL)
+
+	<-ctx.Done()
+	log.Println("Shutting down: stopped receiving updates")
+	return nil
+}
+
+// serveHTTP runs the HTTP server, stopping the bot through fail if it can't serve
+func serveHTTP(httpServer *server.Server, fail context.CancelCauseFunc) {
+	err := httpServer.ListenAndServe(config.GetTLSCertFile(), config.GetTLSKeyFile())
+	if err != nil && err != http.ErrServerClosed {
+		fail(fmt.Errorf("HTTP server error: %w", err))
+	}
+}
+
+// shutdown waits for in-flight webhook requests, queued updates and admin notifications,
+// giving up after SHUTDOWN_TIMEOUT; run closes the storage backend afterwards unless this fails,
+// since workers that did not finish may still write to it
+func shutdown(botHandler *bot.BotHandler, httpServer *server.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.GetShutdownTimeout())
+	defer cancel()
+
+	if httpServer != nil {
+		// Webhook requests in flight finish queueing their updates before the handler is drained
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down HTTP server: %v", err)
+		}
+	}
+
+	if err := botHandler.Shutdown(ctx); err != nil {
+		return fmt.Errorf("shutdown timed out, some work was not finished: %w", err)
+	}
+	log.Println("Shutdown complete")
+	return nil
+}
+
+// syncQuestions makes the question bank match questions.json: new questions are added, changed ones updated
+// and missing ones retired. When the file has invalid questions nothing is retired, since the invalid
+// questions would otherwise look removed
+func syncQuestions(repos *database.Repositories, questions []models.Question, hasInvalid bool) {
+	questionBank := bank.NewService(repos.Questions, repos.Sessions)
+	changes, err := questionBank.Diff(questions)
+	if err != nil {
+		log.Printf("Error comparing questions.json with the question bank: %v", err)
+		return
+	}
+	if hasInvalid && len(changes.Removed) > 0 {
+		log.Printf("questions.json has invalid questions, not retiring %d questions missing from it", len(changes.Removed))
+		changes.Removed = nil
+	}
+	for _, q := range changes.Duplicates {
+		log.Printf("Skipping repeated question %s in questions.json", q.Key())
+	}
+
+	if err := questionBank.Apply(changes); err != nil {
+		log.Printf("Error syncing questions: %v", err)
+		return
+	}
+	log.Printf("Synced questions.json: %d added, %d updated, %d retired, %d unchanged",
+		len(changes.Added), len(changes.Changed), len(changes.Removed), changes.Unchanged)
+}
+
+// syncTests makes the stored catalog match tests: tests are matched by key, new ones are added,
+// existing ones updated and missing ones retired. When the file has invalid tests nothing is retired,
+// since the invalid tests would otherwise look removed
+func syncTests(repos *database.Repositories, tests []models.Test, hasInvalid bool) {
+	stored, err := repos.Tests.GetAll()
+	if err != nil {
+		log.Printf("Error getting stored tests: %v", err)
+		return
+	}
+	storedByKey := make(map[string]models.Test, len(stored))
+	for _, test := range stored {
+		storedByKey[test.Key] = test
+	}
+
+	added, retired := 0, 0
+	for _, test := range tests {
+		if existing, ok := storedByKey[test.Key]; ok {
+			delete(storedByKey, test.Key)
+			test.ID = existing.ID
+			err = repos.Tests.Update(&test)
+		} else {
+			test.ID = primitive.NewObjectID()
+			err = repos.Tests.Create(&test)
+			added++
+		}
+		if err != nil {
+			log.Printf("Error storing test %s: %v", test.Key, err)
+		}
+	}
+
+	if !hasInvalid {
+		for _, test := range storedByKey {
+			if test.Retired {
+				continue
+			}
+			test.Retired = true
+			if err := repos.Tests.Update(&test); err != nil {
+				log.Printf("Error retiring test %s: %v", test.Key, err)
+				continue
+			}
+			retired++
+		}
+	}
+	log.Printf("Synced test catalog: %d tests, %d added, %d retired", len(tests), added, retired)
+}
+
+// checkBlueprints warns about tests whose blueprint can't be assembled from the question bank
+func checkBlueprints(repos *database.Repositories, tests []models.Test) {
+	bank, err := repos.Questions.GetActive()
+	if err != nil {
+		log.Printf("Error getting questions: %v", err)
+		return
+	}
+	for _, test := range tests {
+		if len(test.Blueprint) == 0 {
+			continue
+		}
+		if err := blueprint.Check(test.Blueprint, test.SelectQuestions(bank)); err != nil {
+			log.Printf("Warning: test %s can't be started: %v", test.Key, err)
+		}
+	}
+}
+
+// importQuestions stores the questions only if the bank is empty
+func importQuestions(repos *database.Repositories, questions []models.Question) {
+	existingQuestions, err := repos.Questions.GetAll()
+	if err != nil {
+		log.Printf("Error checking existing questions: %v", err)
+	}
+
+	if len(existingQuestions) > 0 {
+		log.Printf("Database already contains %d questions, skipping import", len(existingQuestions))
+		return
+	}
+
+	log.Println("Loading questions into database...")
+	for _, q := range questions {
+		q.ExternalID = q.Key()
+		err := repos.Questions.Create(&q)
+		if err != nil {
+			log.Printf("Error inserting question: %v", err)
+		}
+	}
+	log.Printf("Loaded %d questions into database", len(questions))
 }
@@ -0,0 +1,101 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andru_bot/tg-bot/models"
+)
+
+// Kinds of media files, chosen by file extension
+const (
+	KindAudio = "audio" // .mp3, .m4a: sent with sendAudio as a music file with a player
+	KindVoice = "voice" // .ogg, .oga, .opus (OGG/Opus): sent with sendVoice as a voice message
+	KindPhoto = "photo" // .jpg, .jpeg, .png: sent with sendPhoto
+)
+
+// Upload limits of the Telegram Bot API
+const (
+	MaxAudioSize = 50 << 20 // Bytes, audio and voice files
+	MaxPhotoSize = 10 << 20 // Bytes
+)
+
+var kindsByExtension = map[string]string{
+	".mp3":  KindAudio,
+	".m4a":  KindAudio,
+	".ogg":  KindVoice,
+	".oga":  KindVoice,
+	".opus": KindVoice,
+	".jpg":  KindPhoto,
+	".jpeg": KindPhoto,
+	".png":  KindPhoto,
+}
+
+// Kind returns the kind of a media file by its extension, "" if it is not supported
+func Kind(name string) string {
+	return kindsByExtension[strings.ToLower(filepath.Ext(name))]
+}
+
+// Dir is the directory holding the media files referenced by questions
+type Dir string
+
+// Path returns the file system path of a media file referenced by a question
+// References are relative paths inside the directory with forward slashes, e.g. "listening/a2-station.mp3"
+func (d Dir) Path(name string) (string, error) {
+	local := filepath.FromSlash(name)
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("media file %q must be a path inside the media directory", name)
+	}
+	return filepath.Join(string(d), local), nil
+}
+
+// Stat returns the file info of a media file after checking that it exists and can be sent
+func (d Dir) Stat(name string) (os.FileInfo, error) {
+	path, err := d.Path(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("media file %q not found in %s", name, string(d))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("media file %q is not a file", name)
+	}
+
+	maxSize := int64(MaxAudioSize)
+	if Kind(name) == KindPhoto {
+		maxSize = MaxPhotoSize
+	}
+	if info.Size() > maxSize {
+		return nil, fmt.Errorf("media file %q is larger than %d MB", name, maxSize>>20)
+	}
+	return info, nil
+}
+
+// Check reports the first media file of a question that can't be sent, see models.QuestionCheck
+func (d Dir) Check(q *models.Question) error {
+	if q.Audio != "" {
+		if kind := Kind(q.Audio); kind != KindAudio && kind != KindVoice {
+			return fmt.Errorf("audio %q must be an .mp3, .m4a, .ogg, .oga or .opus file", q.Audio)
+		}
+		if _, err := d.Stat(q.Audio); err != nil {
+			return err
+		}
+	}
+	if q.Image != "" {
+		if Kind(q.Image) != KindPhoto {
+			return fmt.Errorf("image %q must be a .jpg, .jpeg or .png file", q.Image)
+		}
+		if _, err := d.Stat(q.Image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andru_bot/tg-bot/models"
+)
+
+func TestKind(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"listening/station.mp3", KindAudio},
+		{"interview.M4A", KindAudio},
+		{"note.opus", KindVoice},
+		{"pictures/kitchen.JPEG", KindPhoto},
+		{"map.png", KindPhoto},
+		{"slides.pdf", ""},
+		{"noextension", ""},
+	}
+	for _, tt := range tests {
+		if got := Kind(tt.name); got != tt.want {
+			t.Errorf("Kind(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDirPath(t *testing.T) {
+	d := Dir("media")
+	if got, err := d.Path("listening/station.mp3"); err != nil || got != filepath.Join("media", "listening", "station.mp3") {
+		t.Errorf("Path() = %q, %v", got, err)
+	}
+	for _, name := range []string{"../secret.mp3", "/etc/passwd", "listening/../../secret.mp3", ""} {
+		if got, err := d.Path(name); err == nil {
+			t.Errorf("Path(%q) = %q, want an error for a path outside the directory", name, got)
+		}
+	}
+}
+
+func TestDirCheck(t *testing.T) {
+	dir := t.TempDir()
+	for name, size := range map[string]int{"station.mp3": 10, "kitchen.jpg": 10, "huge.png": MaxPhotoSize + 1} {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644); err != nil {
+			t.Fatalf("WriteFile() error: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "folder.mp3"), 0755); err != nil {
+		t.Fatalf("Mkdir() error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		audio   string
+		image   string
+		wantErr bool
+	}{
+		{"no media", "", "", false},
+		{"audio and image", "station.mp3", "kitchen.jpg", false},
+		{"missing audio", "missing.mp3", "", true},
+		{"image as audio", "kitchen.jpg", "", true},
+		{"audio as image", "", "station.mp3", true},
+		{"directory", "folder.mp3", "", true},
+		{"image too large", "", "huge.png", true},
+		{"outside the directory", "../station.mp3", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Dir(dir).Check(&models.Question{Audio: tt.audio, Image: tt.image})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -8,12 +8,27 @@ import (
 
 // Answer represents a user's answer to a question
 type Answer struct {
-	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	SessionID        primitive.ObjectID `bson:"session_id" json:"session_id"`
-	UserID           primitive.ObjectID `bson:"user_id" json:"user_id"`
-	QuestionID       primitive.ObjectID `bson:"question_id" json:"question_id"`
-	SelectedAnswerID int                `bson:"selected_answer_id" json:"selected_answer_id"`
-	IsCorrect        bool               `bson:"is_correct" json:"is_correct"`
-	Score            int                `bson:"score" json:"score"`
-	AnsweredAt       time.Time          `bson:"answered_at" json:"answered_at"`
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SessionID         primitive.ObjectID `bson:"session_id" json:"session_id"`
+	UserID            primitive.ObjectID `bson:"user_id" json:"user_id"`
+	QuestionID        primitive.ObjectID `bson:"question_id" json:"question_id"`
+	SelectedAnswerID  int                `bson:"selected_answer_id" json:"selected_answer_id"`                       // Canonical answer ID (starting at 1), 0 for typed answers and multiple questions
+	SelectedAnswerIDs []int              `bson:"selected_answer_ids,omitempty" json:"selected_answer_ids,omitempty"` // Multiple questions: canonical answer IDs of the submitted options, ascending; order questions: the options in the order given; match questions: the match picked for each option
+	DisplayedPosition int                `bson:"displayed_position,omitempty" json:"displayed_position,omitempty"`   // Button position the user pressed (starting at 1)
+	TextAnswer        string             `bson:"text_answer,omitempty" json:"text_answer,omitempty"`                 // Text typed for a typed-answer question, as sent
+	IsCorrect         bool               `bson:"is_correct" json:"is_correct"`
+	Credit            float64            `bson:"credit,omitempty" json:"credit,omitempty"`       // Multiple questions: share of the question earned (0-1), see scoring.Config.PartialCredit
+	TimedOut          bool               `bson:"timed_out,omitempty" json:"timed_out,omitempty"` // Time ran out before the user answered
+	Score             float64            `bson:"score" json:"score"`                             // Points for the answer, negative for a wrong answer with negative marking
+	AnsweredAt        time.Time          `bson:"answered_at" json:"answered_at"`
+}
+
+// FindAnswer looks up the answer to a question
+func FindAnswer(answers []Answer, questionID primitive.ObjectID) (*Answer, bool) {
+	for i := range answers {
+		if answers[i].QuestionID == questionID {
+			return &answers[i], true
+		}
+	}
+	return nil, false
 }
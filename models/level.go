@@ -0,0 +1,26 @@
+package models
+
+import "strings"
+
+// CEFRLevels lists the CEFR levels in ascending order
+var CEFRLevels = []string{"A1", "A2", "B1", "B2", "C1", "C2"}
+
+// LevelBelowA1 is the placement given when the A1 cut score is not reached
+const LevelBelowA1 = "Pre-A1"
+
+// LevelIndex returns the position of a CEFR level in CEFRLevels, or -1 if unknown
+func LevelIndex(level string) int {
+	for i, l := range CEFRLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// NormalizeLevel trims and upper-cases a CEFR level ("b1 " -> "B1")
+// Returns false if the result is not a valid CEFR level
+func NormalizeLevel(level string) (string, bool) {
+	level = strings.ToUpper(strings.TrimSpace(level))
+	return level, LevelIndex(level) >= 0
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// MediaFile caches the Telegram file ID of a file of the media directory once it was uploaded
+// The file ID is only reused while the file keeps its size and modification time
+type MediaFile struct {
+	Path       string    `bson:"_id" json:"path"` // Path in the media directory, as referenced by questions
+	FileID     string    `bson:"file_id" json:"file_id"`
+	Size       int64     `bson:"size" json:"size"`
+	ModTime    int64     `bson:"mod_time" json:"mod_time"` // Unix nanoseconds; BSON dates only keep milliseconds
+	UploadedAt time.Time `bson:"uploaded_at" json:"uploaded_at"`
+}
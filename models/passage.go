@@ -0,0 +1,67 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxPassageLength is the longest passage text in characters, so the passage fits in one Telegram message
+const MaxPassageLength = 3500
+
+// Passage is a reading text shared by a group of questions
+// Questions with the same passage key form a group: the passage is shown once before the first
+// of them, and the group stays together when questions are drawn or shuffled
+type Passage struct {
+	Key   string `bson:"key" json:"key"`                         // The passage's id in the question file, identifies the group
+	Title string `bson:"title,omitempty" json:"title,omitempty"` // Shown above the text and on its questions, optional
+	Text  string `bson:"text" json:"text"`                       // HTML formatted like question texts
+}
+
+// Name returns the title of the passage, its key when it has none
+func (p *Passage) Name() string {
+	if p.Title != "" {
+		return p.Title
+	}
+	return p.Key
+}
+
+// Validate checks the passage of a question file
+func (p *Passage) Validate() error {
+	if strings.TrimSpace(p.Key) == "" {
+		return fmt.Errorf("passage id is required")
+	}
+	if strings.TrimSpace(p.Text) == "" {
+		return fmt.Errorf("passage %q has no text", p.Key)
+	}
+	if n := utf8.RuneCountInString(p.Text); n > MaxPassageLength {
+		return fmt.Errorf("passage %q is %d characters long, at most %d fit in a message", p.Key, n, MaxPassageLength)
+	}
+	return nil
+}
+
+// PassageKey returns the key of the question's passage, "" if it has none
+func (q *Question) PassageKey() string {
+	if q.Passage == nil {
+		return ""
+	}
+	return q.Passage.Key
+}
+
+// SamePassage reports whether both questions belong to the same passage
+func SamePassage(a, b *Question) bool {
+	return a.Passage != nil && b.Passage != nil && a.Passage.Key == b.Passage.Key
+}
+
+// PassageGroups maps the IDs of the questions with a passage to its key, the groups kept together by shuffle
+func PassageGroups(questions []Question) map[primitive.ObjectID]string {
+	groups := make(map[primitive.ObjectID]string)
+	for _, q := range questions {
+		if key := q.PassageKey(); key != "" {
+			groups[q.ID] = key
+		}
+	}
+	return groups
+}
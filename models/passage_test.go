@@ -0,0 +1,54 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPassageValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		passage Passage
+		wantErr bool
+	}{
+		{"valid", Passage{Key: "p1", Text: "The train leaves at nine."}, false},
+		{"no key", Passage{Key: " ", Text: "The train leaves at nine."}, true},
+		{"no text", Passage{Key: "p1", Text: " "}, true},
+		{"longest text", Passage{Key: "p1", Text: strings.Repeat("é", MaxPassageLength)}, false},
+		{"text too long", Passage{Key: "p1", Text: strings.Repeat("é", MaxPassageLength+1)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.passage.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPassageGroups(t *testing.T) {
+	p1, p2 := &Passage{Key: "p1"}, &Passage{Key: "p2"}
+	questions := []Question{
+		{ID: primitive.NewObjectID(), Passage: p1},
+		{ID: primitive.NewObjectID()},
+		{ID: primitive.NewObjectID(), Passage: &Passage{Key: "p1"}},
+		{ID: primitive.NewObjectID(), Passage: p2},
+	}
+	groups := PassageGroups(questions)
+	if len(groups) != 3 || groups[questions[0].ID] != "p1" || groups[questions[2].ID] != "p1" || groups[questions[3].ID] != "p2" {
+		t.Errorf("PassageGroups() = %v", groups)
+	}
+	if !SamePassage(&questions[0], &questions[2]) || SamePassage(&questions[0], &questions[3]) || SamePassage(&questions[1], &questions[1]) {
+		t.Error("SamePassage() compares passages wrongly")
+	}
+}
+
+func TestQuestionKeyWithPassage(t *testing.T) {
+	a := Question{Text: "What is the text about?", Passage: &Passage{Key: "p1"}}
+	b := Question{Text: "What is the text about?", Passage: &Passage{Key: "p2"}}
+	if a.Key() == b.Key() || a.Key() == TextKey(a.Text) {
+		t.Error("Key() does not tell the same question of different passages apart")
+	}
+}
@@ -1,42 +1,409 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Question types
+const (
+	QuestionTypeChoice   = "choice"   // One correct option, chosen with a button
+	QuestionTypeMultiple = "multiple" // Select all that apply: options are toggled with buttons, then submitted
+	QuestionTypeOrder    = "order"    // Put the options (words or phrases, listed in the correct order) in order
+	QuestionTypeMatch    = "match"    // Match each option to its entry in Matches
+	QuestionTypeText     = "text"     // Answer typed as a message and graded against the accepted answers
+)
+
+// QuestionTypes lists the question types accepted in question files
+var QuestionTypes = []string{QuestionTypeChoice, QuestionTypeMultiple, QuestionTypeOrder, QuestionTypeMatch, QuestionTypeText}
+
+// Limits of the question fields
+const (
+	MinOptions   = 2
+	MaxOptions   = 10
+	MaxTolerance = 3 // Typos a typed-answer question may allow
+)
+
 // Question represents a question from CSV
 type Question struct {
-	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Text            string             `bson:"text" json:"text"`
-	TextHTML        string             `bson:"text_html,omitempty" json:"text_html,omitempty"` // HTML formatted text for Telegram
-	Answer1         string             `bson:"answer_1" json:"answer_1"`
-	Answer2         string             `bson:"answer_2" json:"answer_2"`
-	Answer3         string             `bson:"answer_3" json:"answer_3"`
-	Answer4         string             `bson:"answer_4" json:"answer_4"` // Can be empty for 3-answer questions
-	CorrectAnswerID int                `bson:"correct_answer_id" json:"correct_answer_id"`
-	Score           int                `bson:"score" json:"score"`
-}
-
-// GetAnswerCount returns the number of available answers (3 or 4)
+	ID               primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ExternalID       string              `bson:"external_id,omitempty" json:"external_id,omitempty"` // Stable key from the question file, see Key
+	Text             string              `bson:"text" json:"text"`
+	TextHTML         string              `bson:"text_html,omitempty" json:"text_html,omitempty"`                   // HTML formatted text for Telegram
+	Options          []string            `bson:"options,omitempty" json:"options,omitempty"`                       // MinOptions to MaxOptions answers, none for typed-answer questions
+	CorrectAnswerID  int                 `bson:"correct_answer_id" json:"correct_answer_id"`                       // Choice questions: the correct option, starting at 1
+	CorrectAnswerIDs []int               `bson:"correct_answer_ids,omitempty" json:"correct_answer_ids,omitempty"` // Multiple questions: every correct option, starting at 1
+	Matches          []string            `bson:"matches,omitempty" json:"matches,omitempty"`                       // Match questions: the entry matching each option, in option order
+	Type             string              `bson:"type,omitempty" json:"type,omitempty"`                             // One of the QuestionType constants, empty for QuestionTypeChoice
+	AcceptedAnswers  []string            `bson:"accepted_answers,omitempty" json:"accepted_answers,omitempty"`     // Typed-answer questions: answers graded as correct
+	Tolerance        int                 `bson:"tolerance,omitempty" json:"tolerance,omitempty"`                   // Typed-answer questions: typos allowed, see textmatch.Distance
+	Audio            string              `bson:"audio,omitempty" json:"audio,omitempty"`                           // Recording sent before the question, path in the media directory, optional
+	Image            string              `bson:"image,omitempty" json:"image,omitempty"`                           // Picture sent before the question, path in the media directory, optional
+	Passage          *Passage            `bson:"passage,omitempty" json:"passage,omitempty"`                       // Reading text the question is about, shared by its group, optional
+	Score            int                 `bson:"score" json:"score"`
+	Level            string              `bson:"level,omitempty" json:"level,omitempty"`             // CEFR level (A1-C2), optional
+	Difficulty       *float64            `bson:"difficulty,omitempty" json:"difficulty,omitempty"`   // Rasch difficulty in logits for adaptive tests, optional
+	Tags             []string            `bson:"tags,omitempty" json:"tags,omitempty"`               // Categories such as "grammar" or "reading", see NormalizeTags
+	Retired          bool                `bson:"retired,omitempty" json:"retired,omitempty"`         // Excluded from new tests, kept for past sessions
+	ReplacedBy       *primitive.ObjectID `bson:"replaced_by,omitempty" json:"replaced_by,omitempty"` // Edited copy that took over from this question
+}
+
+// QuestionType returns the type of the question, one of the QuestionType constants
+func (q *Question) QuestionType() string {
+	if q.Type == "" {
+		return QuestionTypeChoice
+	}
+	return q.Type
+}
+
+// IsTextAnswer reports whether the answer to the question is typed instead of chosen with a button
+func (q *Question) IsTextAnswer() bool {
+	return q.QuestionType() == QuestionTypeText
+}
+
+// NormalizeQuestionType returns the question type in canonical form ("" for QuestionTypeChoice) and whether it is known
+func NormalizeQuestionType(questionType string) (string, bool) {
+	switch questionType = strings.ToLower(strings.TrimSpace(questionType)); questionType {
+	case "", QuestionTypeChoice:
+		return "", true
+	case QuestionTypeMultiple, QuestionTypeOrder, QuestionTypeMatch, QuestionTypeText:
+		return questionType, true
+	}
+	return questionType, false
+}
+
+// IsMultiple reports whether the question asks for every option that applies
+func (q *Question) IsMultiple() bool {
+	return q.QuestionType() == QuestionTypeMultiple
+}
+
+// IsSequence reports whether the answer is built by picking buttons one after another:
+// the options in order for order questions, the match of each option in turn for match questions
+func (q *Question) IsSequence() bool {
+	return q.QuestionType() == QuestionTypeOrder || q.QuestionType() == QuestionTypeMatch
+}
+
+// HasPartialCredit reports whether an answer can earn part of the question's score, see scoring.Config.Credit
+func (q *Question) HasPartialCredit() bool {
+	return q.IsMultiple() || q.IsSequence()
+}
+
+// GetAnswerCount returns the number of options, 0 for typed-answer questions
 func (q *Question) GetAnswerCount() int {
-	if q.Answer4 == "" {
-		return 3
+	if q.IsTextAnswer() {
+		return 0
 	}
-	return 4
+	return len(q.Options)
 }
 
-// GetAnswer returns the answer text for the given answer ID (1-4)
+// GetAnswer returns the text of the option with the given answer ID (starting at 1), "" if there is none
 func (q *Question) GetAnswer(answerID int) string {
-	switch answerID {
-	case 1:
-		return q.Answer1
-	case 2:
-		return q.Answer2
-	case 3:
-		return q.Answer3
-	case 4:
-		return q.Answer4
-	default:
+	if answerID < 1 || answerID > len(q.Options) {
 		return ""
 	}
+	return q.Options[answerID-1]
+}
+
+// GetMatch returns the text of the match with the given ID (starting at 1), "" if there is none
+func (q *Question) GetMatch(matchID int) string {
+	if matchID < 1 || matchID > len(q.Matches) {
+		return ""
+	}
+	return q.Matches[matchID-1]
+}
+
+// ButtonText returns the text shown on the button with the given ID: the match for match questions, otherwise the option
+func (q *Question) ButtonText(id int) string {
+	if q.QuestionType() == QuestionTypeMatch {
+		return q.GetMatch(id)
+	}
+	return q.GetAnswer(id)
+}
+
+// CorrectIDs returns the answer IDs of the correct options in ascending order, none for typed-answer questions
+// For order and match questions it is the key sequence 1, 2, ... n, see Answer.SelectedAnswerIDs
+func (q *Question) CorrectIDs() []int {
+	switch q.QuestionType() {
+	case QuestionTypeChoice:
+		return []int{q.CorrectAnswerID}
+	case QuestionTypeMultiple:
+		ids := append([]int(nil), q.CorrectAnswerIDs...)
+		slices.Sort(ids)
+		return ids
+	case QuestionTypeOrder, QuestionTypeMatch:
+		ids := make([]int, len(q.Options))
+		for i := range ids {
+			ids[i] = i + 1
+		}
+		return ids
+	}
+	return nil
+}
+
+// IsCorrectOption reports whether the option with the given answer ID is correct
+func (q *Question) IsCorrectOption(answerID int) bool {
+	return slices.Contains(q.CorrectIDs(), answerID)
+}
+
+// CorrectAnswerText returns the correct answer as shown in reports, the accepted answers of typed-answer questions
+func (q *Question) CorrectAnswerText() string {
+	if q.IsTextAnswer() {
+		return strings.Join(q.AcceptedAnswers, " | ")
+	}
+	return q.sequenceText(q.CorrectIDs())
+}
+
+// AnswerText returns the answer the candidate gave as shown in reports
+func (q *Question) AnswerText(a *Answer) string {
+	switch q.QuestionType() {
+	case QuestionTypeText:
+		return a.TextAnswer
+	case QuestionTypeChoice:
+		return q.GetAnswer(a.SelectedAnswerID)
+	}
+	return q.sequenceText(a.SelectedAnswerIDs)
+}
+
+// sequenceText formats answer IDs as shown in reports: the options joined into a sentence for
+// order questions, "option → match" pairs for match questions, a list of options otherwise
+func (q *Question) sequenceText(ids []int) string {
+	switch q.QuestionType() {
+	case QuestionTypeOrder:
+		texts := make([]string, len(ids))
+		for i, id := range ids {
+			texts[i] = q.GetAnswer(id)
+		}
+		return strings.Join(texts, " ")
+	case QuestionTypeMatch:
+		pairs := make([]string, len(ids))
+		for i, id := range ids {
+			pairs[i] = q.GetAnswer(i+1) + " → " + q.GetMatch(id)
+		}
+		return strings.Join(pairs, "; ")
+	}
+	return q.optionsText(ids)
+}
+
+// optionsText joins the texts of the options with the given answer IDs
+func (q *Question) optionsText(answerIDs []int) string {
+	texts := make([]string, len(answerIDs))
+	for i, id := range answerIDs {
+		texts[i] = q.GetAnswer(id)
+	}
+	return strings.Join(texts, "; ")
+}
+
+// OptionsFromAnswers returns the options of a question given as answer_1 ... answer_4,
+// the format used before options were a list; trailing empty answers are dropped
+func OptionsFromAnswers(answers ...string) []string {
+	for len(answers) > 0 && strings.TrimSpace(answers[len(answers)-1]) == "" {
+		answers = answers[:len(answers)-1]
+	}
+	if len(answers) == 0 {
+		return nil
+	}
+	return append([]string(nil), answers...)
+}
+
+// Key identifies the question across imports of the question file
+// It is the external ID when the file sets one, otherwise a hash of the question text, together with
+// the passage key for questions of a passage, since e.g. "What is the text about?" fits many passages
+func (q *Question) Key() string {
+	if q.ExternalID != "" {
+		return q.ExternalID
+	}
+	if q.Passage != nil {
+		return TextKey(q.Passage.Key + " " + q.Text)
+	}
+	return TextKey(q.Text)
+}
+
+// TextKey returns the key of a question without external ID, a hash of its whitespace-normalized text
+func TextKey(text string) string {
+	sum := sha256.Sum256([]byte(strings.Join(strings.Fields(text), " ")))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// Snapshot returns a copy of the question's content for a session, without the bank's bookkeeping
+func (q *Question) Snapshot() Question {
+	snapshot := *q
+	snapshot.Retired = false
+	snapshot.ReplacedBy = nil
+	if q.Difficulty != nil {
+		difficulty := *q.Difficulty
+		snapshot.Difficulty = &difficulty
+	}
+	if q.Tags != nil {
+		snapshot.Tags = append([]string(nil), q.Tags...)
+	}
+	if q.AcceptedAnswers != nil {
+		snapshot.AcceptedAnswers = append([]string(nil), q.AcceptedAnswers...)
+	}
+	if q.Options != nil {
+		snapshot.Options = append([]string(nil), q.Options...)
+	}
+	if q.CorrectAnswerIDs != nil {
+		snapshot.CorrectAnswerIDs = append([]int(nil), q.CorrectAnswerIDs...)
+	}
+	if q.Matches != nil {
+		snapshot.Matches = append([]string(nil), q.Matches...)
+	}
+	if q.Passage != nil {
+		passage := *q.Passage
+		snapshot.Passage = &passage
+	}
+	return snapshot
+}
+
+// HasTags reports whether the question carries every one of the tags
+func (q *Question) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		if !contains(q.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTags splits a comma or semicolon separated list of tags, see NormalizeTags
+func ParseTags(value string) []string {
+	return NormalizeTags(strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }))
+}
+
+// ParseAcceptedAnswers splits a list of accepted answers separated by "|" or new lines
+func ParseAcceptedAnswers(value string) []string {
+	var answers []string
+	for _, answer := range strings.FieldsFunc(value, func(r rune) bool { return r == '|' || r == '\n' }) {
+		if answer = strings.TrimSpace(answer); answer != "" {
+			answers = append(answers, answer)
+		}
+	}
+	return answers
+}
+
+// NormalizeTags lowercases and trims tags, dropping empty and repeated ones
+// Returns nil if no tag is left
+func NormalizeTags(values []string) []string {
+	var tags []string
+	for _, value := range values {
+		tag := strings.ToLower(strings.TrimSpace(value))
+		if tag != "" && !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Validate checks that a question can be shown and graded
+func (q *Question) Validate() error {
+	if strings.TrimSpace(q.Text) == "" {
+		return fmt.Errorf("question text is required")
+	}
+	switch q.QuestionType() {
+	case QuestionTypeChoice, QuestionTypeMultiple, QuestionTypeOrder, QuestionTypeMatch:
+		if len(q.Options) < MinOptions || len(q.Options) > MaxOptions {
+			return fmt.Errorf("a question needs %d to %d answers, this one has %d", MinOptions, MaxOptions, len(q.Options))
+		}
+		for i, option := range q.Options {
+			if strings.TrimSpace(option) == "" {
+				return fmt.Errorf("answer %d is empty", i+1)
+			}
+		}
+		if q.QuestionType() == QuestionTypeOrder {
+			break
+		}
+		if q.QuestionType() == QuestionTypeMatch {
+			if len(q.Matches) != len(q.Options) {
+				return fmt.Errorf("a match question needs one match per answer, this one has %d answers and %d matches", len(q.Options), len(q.Matches))
+			}
+			for i, match := range q.Matches {
+				if strings.TrimSpace(match) == "" {
+					return fmt.Errorf("match %d is empty", i+1)
+				}
+			}
+			break
+		}
+		maxAnswerID := len(q.Options)
+		if !q.IsMultiple() {
+			if q.CorrectAnswerID < 1 || q.CorrectAnswerID > maxAnswerID {
+				return fmt.Errorf("correct_answer_id must be between 1 and %d (question has %d answers)", maxAnswerID, maxAnswerID)
+			}
+			break
+		}
+		if len(q.CorrectAnswerIDs) == 0 {
+			return fmt.Errorf("correct_answer_ids are required for %s questions", QuestionTypeMultiple)
+		}
+		for i, id := range q.CorrectAnswerIDs {
+			if id < 1 || id > maxAnswerID {
+				return fmt.Errorf("correct_answer_ids must be between 1 and %d (question has %d answers)", maxAnswerID, maxAnswerID)
+			}
+			if slices.Contains(q.CorrectAnswerIDs[:i], id) {
+				return fmt.Errorf("correct answer %d is listed twice", id)
+			}
+		}
+	case QuestionTypeText:
+		if len(q.AcceptedAnswers) == 0 {
+			return fmt.Errorf("accepted_answers are required for %s questions", QuestionTypeText)
+		}
+		for _, answer := range q.AcceptedAnswers {
+			if strings.TrimSpace(answer) == "" {
+				return fmt.Errorf("accepted_answers must not be empty")
+			}
+		}
+		if q.Tolerance < 0 || q.Tolerance > MaxTolerance {
+			return fmt.Errorf("tolerance must be between 0 and %d", MaxTolerance)
+		}
+	default:
+		return fmt.Errorf("type must be one of %s", strings.Join(QuestionTypes, ", "))
+	}
+	if q.Score < 0 {
+		return fmt.Errorf("score must not be negative")
+	}
+	if q.Level != "" && LevelIndex(q.Level) < 0 {
+		return fmt.Errorf("level must be one of %s", strings.Join(CEFRLevels, ", "))
+	}
+	if q.Passage != nil {
+		return q.Passage.Validate()
+	}
+	return nil
+}
+
+// RowError is a problem with one question of an imported file
+// Row is the line (CSV), sheet row (Excel) or question number (JSON), starting at 1
+type RowError struct {
+	Row     int
+	Message string
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Message)
+}
+
+// QuestionCheck validates a loaded question beyond Validate, e.g. that its media files exist
+type QuestionCheck func(q *Question) error
+
+// RunChecks returns the first error reported by the checks
+func RunChecks(q *Question, checks []QuestionCheck) error {
+	for _, check := range checks {
+		if err := check(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RowErrors is returned by the question loaders when some questions are invalid
+// The loaders still return every valid question alongside it
+type RowErrors []RowError
+
+func (e RowErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d invalid questions, first: %s", len(e), e[0].Error())
 }
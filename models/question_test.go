@@ -0,0 +1,135 @@
+package models
+
+import (
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestQuestionValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(*Question)
+		wantErr bool
+	}{
+		{"valid", func(q *Question) {}, false},
+		{"four answers", func(q *Question) { q.Options = append(q.Options, "gone"); q.CorrectAnswerID = 4 }, false},
+		{"two answers", func(q *Question) { q.Options = q.Options[:2] }, false},
+		{"one answer", func(q *Question) { q.Options = q.Options[:1]; q.CorrectAnswerID = 1 }, true},
+		{"too many answers", func(q *Question) { q.Options = make([]string, MaxOptions+1); q.CorrectAnswerID = 1 }, true},
+		{"no text", func(q *Question) { q.Text = " " }, true},
+		{"empty answer", func(q *Question) { q.Options[2] = " " }, true},
+		{"correct answer out of range", func(q *Question) { q.CorrectAnswerID = 4 }, true},
+		{"no correct answer", func(q *Question) { q.CorrectAnswerID = 0 }, true},
+		{"negative score", func(q *Question) { q.Score = -1 }, true},
+		{"known level", func(q *Question) { q.Level = "B2" }, false},
+		{"unknown level", func(q *Question) { q.Level = "D1" }, true},
+		{"typed answer", func(q *Question) { q.Type = QuestionTypeText; q.AcceptedAnswers = []string{"goes"}; q.Tolerance = 1 }, false},
+		{"typed answer without answers", func(q *Question) { q.Type = QuestionTypeText }, true},
+		{"typed answer with empty answer", func(q *Question) { q.Type = QuestionTypeText; q.AcceptedAnswers = []string{"goes", " "} }, true},
+		{"typed answer tolerance too high", func(q *Question) {
+			q.Type = QuestionTypeText
+			q.AcceptedAnswers = []string{"goes"}
+			q.Tolerance = MaxTolerance + 1
+		}, true},
+		{"order", func(q *Question) { q.Type = QuestionTypeOrder; q.CorrectAnswerID = 0 }, false},
+		{"match", func(q *Question) { q.Type = QuestionTypeMatch; q.Matches = []string{"a", "b", "c"} }, false},
+		{"match without every match", func(q *Question) { q.Type = QuestionTypeMatch; q.Matches = []string{"a", "b"} }, true},
+		{"match with empty match", func(q *Question) { q.Type = QuestionTypeMatch; q.Matches = []string{"a", " ", "c"} }, true},
+		{"unknown type", func(q *Question) { q.Type = "essay" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &Question{Text: "She ___ to school.", Options: []string{"go", "goes", "going"}, CorrectAnswerID: 2, Score: 1}
+			tt.change(q)
+			if err := q.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalizeQuestionType(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   string
+		wantOK bool
+	}{
+		{"", "", true},
+		{" Choice ", "", true},
+		{"TEXT", QuestionTypeText, true},
+		{"essay", "essay", false},
+	}
+	for _, tt := range tests {
+		if got, ok := NormalizeQuestionType(tt.in); got != tt.want || ok != tt.wantOK {
+			t.Errorf("NormalizeQuestionType(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestParseAcceptedAnswers(t *testing.T) {
+	if got, want := ParseAcceptedAnswers(" goes | is going||\ngoes on\n"), []string{"goes", "is going", "goes on"}; !slices.Equal(got, want) {
+		t.Errorf("ParseAcceptedAnswers() = %q, want %q", got, want)
+	}
+	if got := ParseAcceptedAnswers(" "); got != nil {
+		t.Errorf("ParseAcceptedAnswers() of a blank value = %q, want nil", got)
+	}
+}
+
+func TestRowErrors(t *testing.T) {
+	one := RowErrors{{Row: 3, Message: "invalid score \"x\""}}
+	if got, want := one.Error(), `row 3: invalid score "x"`; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	two := append(one, RowError{Row: 7, Message: "question text is required"})
+	if got, want := two.Error(), `2 invalid questions, first: row 3: invalid score "x"`; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestQuestionKey(t *testing.T) {
+	q := Question{Text: "She ___  to school. "}
+	if got, want := q.Key(), TextKey("She ___ to school."); got != want {
+		t.Errorf("Key() = %q, want the key of the normalized text %q", got, want)
+	}
+	if TextKey("She ___ to school.") == TextKey("He ___ to school.") {
+		t.Error("TextKey() is the same for different texts")
+	}
+	q.ExternalID = "q1"
+	if got := q.Key(); got != "q1" {
+		t.Errorf("Key() = %q, want the external ID", got)
+	}
+}
+
+func TestQuestionSnapshot(t *testing.T) {
+	difficulty := 0.5
+	replacement := primitive.NewObjectID()
+	q := Question{ID: primitive.NewObjectID(), Text: "She ___ to school.", Difficulty: &difficulty, Retired: true, ReplacedBy: &replacement}
+
+	snapshot := q.Snapshot()
+	if snapshot.ID != q.ID || snapshot.Text != q.Text || snapshot.Retired || snapshot.ReplacedBy != nil {
+		t.Errorf("Snapshot() = %+v, want the content without the bank's bookkeeping", snapshot)
+	}
+	difficulty = 2
+	if *snapshot.Difficulty != 0.5 {
+		t.Errorf("Snapshot() difficulty changed with the question to %v", *snapshot.Difficulty)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{" , ;", nil},
+		{"grammar", []string{"grammar"}},
+		{"Grammar; reading, grammar ,Tenses", []string{"grammar", "reading", "tenses"}},
+	}
+	for _, tt := range tests {
+		if got := ParseTags(tt.value); !slices.Equal(got, tt.want) {
+			t.Errorf("ParseTags(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -8,13 +8,105 @@ import (
 
 // Session represents a test session
 type Session struct {
-	ID             primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
-	UserID         primitive.ObjectID   `bson:"user_id" json:"user_id"`
-	StartedAt      time.Time            `bson:"started_at" json:"started_at"`
-	FinishedAt     *time.Time           `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
-	TotalScore     int                  `bson:"total_score" json:"total_score"`
-	TotalQuestions int                  `bson:"total_questions" json:"total_questions"`
-	Status         string               `bson:"status" json:"status"`             // "in_progress", "completed"
-	CurrentIdx     int                  `bson:"current_idx" json:"current_idx"`   // Current question index
-	QuestionIDs    []primitive.ObjectID `bson:"question_ids" json:"question_ids"` // List of question IDs in order
+	ID                primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	UserID            primitive.ObjectID   `bson:"user_id" json:"user_id"`
+	TestID            primitive.ObjectID   `bson:"test_id,omitempty" json:"test_id,omitempty"` // Catalog test the session belongs to
+	Test              *Test                `bson:"test,omitempty" json:"test,omitempty"`       // Snapshot of the test settings when the session started
+	StartedAt         time.Time            `bson:"started_at" json:"started_at"`
+	FinishedAt        *time.Time           `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+	TotalScore        float64              `bson:"total_score" json:"total_score"`
+	MaxScore          float64              `bson:"max_score,omitempty" json:"max_score,omitempty"`           // Maximum attainable score, set when finished
+	Percentage        float64              `bson:"percentage,omitempty" json:"percentage,omitempty"`         // Score against the maximum, set when finished
+	Passed            *bool                `bson:"passed,omitempty" json:"passed,omitempty"`                 // Pass/fail result, nil without a pass threshold
+	PassThreshold     float64              `bson:"pass_threshold,omitempty" json:"pass_threshold,omitempty"` // Percentage needed to pass
+	TotalQuestions    int                  `bson:"total_questions" json:"total_questions"`
+	Status            string               `bson:"status" json:"status"`                                               // One of the Status constants
+	TerminationReason string               `bson:"termination_reason,omitempty" json:"termination_reason,omitempty"`   // Why the session ended, set when finished
+	CurrentIdx        int                  `bson:"current_idx" json:"current_idx"`                                     // Current question index
+	QuestionIDs       []primitive.ObjectID `bson:"question_ids" json:"question_ids"`                                   // List of question IDs in order
+	Level             string               `bson:"level,omitempty" json:"level,omitempty"`                             // CEFR placement level, set when finished
+	Mode              string               `bson:"mode,omitempty" json:"mode,omitempty"`                               // "linear" (default) or "adaptive"
+	Ability           *float64             `bson:"ability,omitempty" json:"ability,omitempty"`                         // Adaptive ability estimate (logits)
+	AbilitySE         *float64             `bson:"ability_se,omitempty" json:"ability_se,omitempty"`                   // Standard error of the ability estimate
+	Seed              int64                `bson:"seed,omitempty" json:"seed,omitempty"`                               // Seed of all random choices made for this session
+	OptionOrders      map[string][]int     `bson:"option_orders,omitempty" json:"option_orders,omitempty"`             // Question ID (hex) -> answer IDs in displayed order
+	ChatID            int64                `bson:"chat_id,omitempty" json:"chat_id,omitempty"`                         // Chat the test is taken in
+	ExpiresAt         *time.Time           `bson:"expires_at,omitempty" json:"expires_at,omitempty"`                   // End of the whole-test time limit
+	QuestionDeadline  *time.Time           `bson:"question_deadline,omitempty" json:"question_deadline,omitempty"`     // End of the time limit of the current question
+	QuestionMessageID int                  `bson:"question_message_id,omitempty" json:"question_message_id,omitempty"` // Message with the current question's buttons
+	Questions         []Question           `bson:"questions,omitempty" json:"questions,omitempty"`                     // Snapshot of each question as it was asked, in QuestionIDs order
+	LastActivityAt    *time.Time           `bson:"last_activity_at,omitempty" json:"last_activity_at,omitempty"`       // Last answer, button press or question shown
+}
+
+// Session statuses; every status except StatusInProgress is final
+const (
+	StatusInProgress              = "in_progress"
+	StatusCompleted               = "completed"                 // All questions answered, adaptive stopping rule met or test time limit reached
+	StatusFailedConsecutiveErrors = "failed_consecutive_errors" // Too many wrong answers in a row
+	StatusFailed                  = "failed"                    // Stopped by another error-based termination policy
+	StatusCancelledByUser         = "cancelled_by_user"         // Finished early with /finish_test
+	StatusExpired                 = "expired"                   // Abandoned, closed by the idle session sweeper
+	StatusCancelledByAdmin        = "cancelled_by_admin"
+)
+
+// LastActivity returns when the candidate last did something in the session
+func (s *Session) LastActivity() time.Time {
+	if s.LastActivityAt != nil {
+		return *s.LastActivityAt
+	}
+	// Sessions created before activity was tracked
+	return s.StartedAt
+}
+
+// QuestionSnapshot returns the question as it was when it was added to the session
+// Returns false for sessions started before snapshots were stored
+func (s *Session) QuestionSnapshot(questionID primitive.ObjectID) (*Question, bool) {
+	return FindQuestion(s.Questions, questionID)
+}
+
+// FindQuestion looks up a question by ID
+func FindQuestion(questions []Question, questionID primitive.ObjectID) (*Question, bool) {
+	for i := range questions {
+		if questions[i].ID == questionID {
+			return &questions[i], true
+		}
+	}
+	return nil, false
+}
+
+// OptionOrder returns the canonical answer IDs of a question in the order they were displayed
+func (s *Session) OptionOrder(questionID primitive.ObjectID, answerCount int) []int {
+	return OptionOrder(s.OptionOrders, questionID, answerCount)
+}
+
+// OptionOrder looks up the displayed option order of a question
+// Questions without a stored order (or whose answer count changed) are shown as 1..answerCount
+func OptionOrder(orders map[string][]int, questionID primitive.ObjectID, answerCount int) []int {
+	if order, ok := orders[questionID.Hex()]; ok && len(order) == answerCount {
+		return order
+	}
+	order := make([]int, answerCount)
+	for i := range order {
+		order[i] = i + 1
+	}
+	return order
+}
+
+// Test modes
+const (
+	ModeLinear   = "linear"   // All questions in bank order
+	ModeAdaptive = "adaptive" // Questions chosen one by one from the candidate's ability estimate
+)
+
+// SessionOutcome holds the results stored on a session when it is finished
+type SessionOutcome struct {
+	Status            string // Final status, StatusCompleted if empty
+	TerminationReason string // Why the session ended, shown in results and reports
+	TotalScore        float64
+	MaxScore          float64
+	Percentage        float64
+	Passed            *bool   // nil without a pass threshold
+	PassThreshold     float64 // Percentage needed to pass, 0 without a pass threshold
+	TotalQuestions    int
+	Level             string // CEFR placement level, empty if questions carry no levels
 }
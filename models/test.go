@@ -0,0 +1,100 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultTestKey is the key of the test built from the environment settings when tests.json defines none
+const DefaultTestKey = "default"
+
+// Test is a named test of the catalog with its own question selection, scoring, termination and time settings
+type Test struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Key               string             `bson:"key" json:"key"` // Stable key from tests.json
+	Name              string             `bson:"name" json:"name"`
+	Description       string             `bson:"description,omitempty" json:"description,omitempty"`
+	Position          int                `bson:"position" json:"position"`                                 // Place in the catalog
+	QuestionKeys      []string           `bson:"question_keys,omitempty" json:"question_keys,omitempty"`   // Questions of the test by Question.Key, empty for the whole bank
+	Levels            []string           `bson:"levels,omitempty" json:"levels,omitempty"`                 // CEFR levels of the questions, empty for every level
+	QuestionCount     int                `bson:"question_count,omitempty" json:"question_count,omitempty"` // Questions drawn at random from the selection, 0 for all
+	Blueprint         []BlueprintRule    `bson:"blueprint,omitempty" json:"blueprint,omitempty"`           // Pools the questions are drawn from, empty to use the selection as is
+	Mode              string             `bson:"mode" json:"mode"`                                         // ModeLinear or ModeAdaptive
+	ShuffleQuestions  bool               `bson:"shuffle_questions,omitempty" json:"shuffle_questions,omitempty"`
+	ShuffleOptions    bool               `bson:"shuffle_options,omitempty" json:"shuffle_options,omitempty"`
+	NegativeMarking   float64            `bson:"negative_marking,omitempty" json:"negative_marking,omitempty"`       // Share of a question's score deducted for a wrong answer
+	PassThreshold     float64            `bson:"pass_threshold,omitempty" json:"pass_threshold,omitempty"`           // Percentage needed to pass, 0 for no pass/fail
+	PartialCredit     string             `bson:"partial_credit,omitempty" json:"partial_credit,omitempty"`           // Partial credit scheme of multiple, order and match questions, see scoring.Config
+	TerminationPolicy string             `bson:"termination_policy" json:"termination_policy"`                       // Rules that end the test early, in TERMINATION_POLICY format
+	QuestionTimeLimit time.Duration      `bson:"question_time_limit,omitempty" json:"question_time_limit,omitempty"` // 0 means no per-question limit
+	TimeLimit         time.Duration      `bson:"time_limit,omitempty" json:"time_limit,omitempty"`                   // 0 means no whole-test limit
+	Retired           bool               `bson:"retired,omitempty" json:"retired,omitempty"`                         // Removed from tests.json, kept for past sessions
+}
+
+// BlueprintRule asks for Count questions drawn from the pool of questions with the level and all the tags
+type BlueprintRule struct {
+	Count int      `bson:"count" json:"count"`
+	Level string   `bson:"level,omitempty" json:"level,omitempty"` // Empty for every level
+	Tags  []string `bson:"tags,omitempty" json:"tags,omitempty"`   // Empty for every question
+}
+
+// Matches reports whether the question belongs to the pool of the rule
+func (r *BlueprintRule) Matches(q *Question) bool {
+	return (r.Level == "" || r.Level == q.Level) && q.HasTags(r.Tags)
+}
+
+// String formats the rule as "5 A1 grammar"
+func (r BlueprintRule) String() string {
+	parts := []string{strconv.Itoa(r.Count)}
+	if r.Level != "" {
+		parts = append(parts, r.Level)
+	}
+	parts = append(parts, r.Tags...)
+	if len(parts) == 1 {
+		parts = append(parts, "any")
+	}
+	return strings.Join(parts, " ")
+}
+
+// BlueprintSize returns the number of questions a blueprint asks for
+func BlueprintSize(rules []BlueprintRule) int {
+	size := 0
+	for _, rule := range rules {
+		size += rule.Count
+	}
+	return size
+}
+
+// Selects reports whether the question belongs to the test
+func (t *Test) Selects(q *Question) bool {
+	if len(t.Levels) > 0 && !contains(t.Levels, q.Level) {
+		return false
+	}
+	if len(t.QuestionKeys) > 0 && !contains(t.QuestionKeys, q.Key()) {
+		return false
+	}
+	return true
+}
+
+// SelectQuestions returns the questions of the bank that belong to the test, in bank order
+func (t *Test) SelectQuestions(bank []Question) []Question {
+	var selected []Question
+	for i := range bank {
+		if t.Selects(&bank[i]) {
+			selected = append(selected, bank[i])
+		}
+	}
+	return selected
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
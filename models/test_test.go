@@ -0,0 +1,64 @@
+package models
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTestSelectQuestions(t *testing.T) {
+	bank := []Question{
+		{Text: "a", ExternalID: "q1", Level: "A1"},
+		{Text: "b", ExternalID: "q2", Level: "B1"},
+		{Text: "c", Level: "B1"},
+		{Text: "d", ExternalID: "q4"},
+	}
+
+	tests := []struct {
+		name string
+		test Test
+		want []string
+	}{
+		{"whole bank", Test{}, []string{"a", "b", "c", "d"}},
+		{"by level", Test{Levels: []string{"B1"}}, []string{"b", "c"}},
+		{"by key", Test{QuestionKeys: []string{"q4", "q1", TextKey("c")}}, []string{"a", "c", "d"}},
+		{"by key and level", Test{QuestionKeys: []string{"q1", "q2"}, Levels: []string{"B1", "C1"}}, []string{"b"}},
+		{"nothing selected", Test{Levels: []string{"C2"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, q := range tt.test.SelectQuestions(bank) {
+				got = append(got, q.Text)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("SelectQuestions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlueprintRule(t *testing.T) {
+	q := Question{Level: "A2", Tags: []string{"grammar", "tenses"}}
+	tests := []struct {
+		rule        BlueprintRule
+		wantString  string
+		wantMatches bool
+	}{
+		{BlueprintRule{Count: 5}, "5 any", true},
+		{BlueprintRule{Count: 2, Level: "A2"}, "2 A2", true},
+		{BlueprintRule{Count: 2, Level: "B1"}, "2 B1", false},
+		{BlueprintRule{Count: 3, Level: "A2", Tags: []string{"tenses", "grammar"}}, "3 A2 tenses grammar", true},
+		{BlueprintRule{Count: 1, Tags: []string{"grammar", "reading"}}, "1 grammar reading", false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.String(); got != tt.wantString {
+			t.Errorf("String() = %q, want %q", got, tt.wantString)
+		}
+		if got := tt.rule.Matches(&q); got != tt.wantMatches {
+			t.Errorf("rule %q Matches() = %v, want %v", tt.wantString, got, tt.wantMatches)
+		}
+	}
+	if got := BlueprintSize([]BlueprintRule{{Count: 5}, {Count: 3}}); got != 8 {
+		t.Errorf("BlueprintSize() = %d, want 8", got)
+	}
+}
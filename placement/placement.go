@@ -0,0 +1,94 @@
+package placement
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultCutScore is the percentage required to pass a level without an explicit cut score
+const DefaultCutScore = 60.0
+
+// LevelScore holds the results for the questions of a single CEFR level
+type LevelScore struct {
+	Level   string
+	Correct int
+	Total   int
+}
+
+// Percentage returns the share of correctly answered questions of the level
+func (s LevelScore) Percentage() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.Correct) / float64(s.Total) * 100.0
+}
+
+// Result is the placement computed for a finished test
+type Result struct {
+	Level  string       // Placement level, empty if no question carries a CEFR level
+	Levels []LevelScore // Per-level scores in ascending CEFR order, only levels present in the test
+}
+
+// Breakdown formats per-level scores as "A1: 5/6, A2: 3/6"
+func (r Result) Breakdown() string {
+	parts := make([]string, 0, len(r.Levels))
+	for _, s := range r.Levels {
+		parts = append(parts, fmt.Sprintf("%s: %d/%d", s.Level, s.Correct, s.Total))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Compute scores every CEFR level present in questions and places the candidate
+// at the highest level such that the cut score (percentage of correct answers)
+// of that level and of every lower level in the test was reached.
+// Unanswered questions count as incorrect. Levels missing from cutScores
+// default to DefaultCutScore.
+func Compute(questions []models.Question, answers []models.Answer, cutScores map[string]float64) Result {
+	correct := make(map[primitive.ObjectID]bool)
+	for _, a := range answers {
+		if a.IsCorrect {
+			correct[a.QuestionID] = true
+		}
+	}
+
+	scores := make([]LevelScore, len(models.CEFRLevels))
+	for i, level := range models.CEFRLevels {
+		scores[i].Level = level
+	}
+	for _, q := range questions {
+		idx := models.LevelIndex(q.Level)
+		if idx < 0 {
+			continue
+		}
+		scores[idx].Total++
+		if correct[q.ID] {
+			scores[idx].Correct++
+		}
+	}
+
+	var result Result
+	for _, s := range scores {
+		if s.Total > 0 {
+			result.Levels = append(result.Levels, s)
+		}
+	}
+	if len(result.Levels) == 0 {
+		return result
+	}
+
+	result.Level = models.LevelBelowA1
+	for _, s := range result.Levels {
+		cut, ok := cutScores[s.Level]
+		if !ok {
+			cut = DefaultCutScore
+		}
+		if s.Percentage() < cut {
+			break
+		}
+		result.Level = s.Level
+	}
+	return result
+}
@@ -0,0 +1,53 @@
+package placement
+
+import (
+	"testing"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCompute(t *testing.T) {
+	// Two questions per level from A1 to B1, and one without a level
+	var questions []models.Question
+	for _, level := range []string{"A1", "A1", "A2", "A2", "B1", "B1", ""} {
+		questions = append(questions, models.Question{ID: primitive.NewObjectID(), Level: level})
+	}
+	answered := func(correct ...int) []models.Answer {
+		var answers []models.Answer
+		for _, i := range correct {
+			answers = append(answers, models.Answer{QuestionID: questions[i].ID, IsCorrect: true})
+		}
+		return answers
+	}
+
+	tests := []struct {
+		name          string
+		answers       []models.Answer
+		cutScores     map[string]float64
+		wantLevel     string
+		wantBreakdown string
+	}{
+		{"nothing right", nil, nil, models.LevelBelowA1, "A1: 0/2, A2: 0/2, B1: 0/2"},
+		{"every level passed", answered(0, 1, 2, 3, 4, 5), nil, "B1", "A1: 2/2, A2: 2/2, B1: 2/2"},
+		{"stops at the first level missed", answered(0, 1, 4, 5), nil, "A1", "A1: 2/2, A2: 0/2, B1: 2/2"},
+		{"default cut score is 60%", answered(0, 2, 3), nil, models.LevelBelowA1, "A1: 1/2, A2: 2/2, B1: 0/2"},
+		{"custom cut score", answered(0, 2, 3), map[string]float64{"A1": 50}, "A2", "A1: 1/2, A2: 2/2, B1: 0/2"},
+		{"questions without a level are left out", answered(6), nil, models.LevelBelowA1, "A1: 0/2, A2: 0/2, B1: 0/2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Compute(questions, tt.answers, tt.cutScores)
+			if got.Level != tt.wantLevel {
+				t.Errorf("Level = %q, want %q", got.Level, tt.wantLevel)
+			}
+			if got.Breakdown() != tt.wantBreakdown {
+				t.Errorf("Breakdown() = %q, want %q", got.Breakdown(), tt.wantBreakdown)
+			}
+		})
+	}
+
+	if got := Compute(questions[6:], answered(6), nil); got.Level != "" || len(got.Levels) != 0 {
+		t.Errorf("Compute() without levels = %+v, want no placement", got)
+	}
+}
@@ -0,0 +1,209 @@
+package scoring
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Partial credit schemes of multiple questions; order and match questions earn the share of
+// positions right under every scheme but none
+const (
+	PartialCreditNone            = "none"              // All or nothing: only the exact set of correct options earns points
+	PartialCreditRightMinusWrong = "right_minus_wrong" // Share of correct options selected minus share of wrong options selected
+	PartialCreditPerOption       = "per_option"        // Share of options selected or left out correctly
+)
+
+// PartialCreditSchemes lists the partial credit schemes
+var PartialCreditSchemes = []string{PartialCreditNone, PartialCreditRightMinusWrong, PartialCreditPerOption}
+
+// NormalizePartialCredit returns the scheme in canonical form ("" for PartialCreditNone) and whether it is known
+func NormalizePartialCredit(scheme string) (string, bool) {
+	switch scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme {
+	case "", PartialCreditNone:
+		return "", true
+	case PartialCreditRightMinusWrong, PartialCreditPerOption:
+		return scheme, true
+	}
+	return scheme, false
+}
+
+// Config is the scoring scheme of a test
+type Config struct {
+	NegativeMarking float64 // Share of a question's weight deducted for a wrong answer, 0 disables negative marking
+	PassThreshold   float64 // Percentage of the maximum score needed to pass, 0 means tests are not passed or failed
+	PartialCredit   string  // Partial credit scheme of multiple, order and match questions, one of the PartialCredit constants ("" for PartialCreditNone)
+}
+
+// Result is the score of a test
+type Result struct {
+	Score         float64 // Sum of the answer scores, negative marking can make it negative
+	MaxScore      float64 // Sum of the weights of all questions of the test
+	Percentage    float64 // Score against MaxScore, never below 0
+	Correct       int
+	Partial       int // Earned part of the question's score, see Credit
+	Incorrect     int
+	Skipped       int     // Not answered, timed out or never reached
+	Passed        *bool   // nil without a pass threshold
+	PassThreshold float64 // Percentage needed to pass, 0 without a pass threshold
+}
+
+// Weight returns the points a correct answer to the question is worth
+func Weight(q models.Question) float64 {
+	return float64(q.Score)
+}
+
+// AnswerScore returns the points for an answer to the question: its weight if correct,
+// minus NegativeMarking times its weight if wrong
+// Skipped and timed out questions score 0 and are never graded with AnswerScore
+func (c Config) AnswerScore(q models.Question, correct bool) float64 {
+	if correct {
+		return c.CreditScore(q, 1)
+	}
+	return c.CreditScore(q, 0)
+}
+
+// Credit returns the share of a multiple question earned by submitting the selected answer IDs,
+// 1 for exactly the correct options and otherwise as given by the PartialCredit scheme
+// For order and match questions selected is the answer sequence (see models.Answer.SelectedAnswerIDs)
+// and every scheme other than none gives the share of positions right
+func (c Config) Credit(q models.Question, selected []int) float64 {
+	if q.IsSequence() {
+		return c.sequenceCredit(q, selected)
+	}
+	correct := q.CorrectIDs()
+	var right, wrong int // Correct and wrong options selected
+	for _, id := range selected {
+		if slices.Contains(correct, id) {
+			right++
+		} else {
+			wrong++
+		}
+	}
+	if right == len(correct) && wrong == 0 {
+		return 1
+	}
+
+	switch c.PartialCredit {
+	case PartialCreditRightMinusWrong:
+		credit := float64(right) / float64(len(correct))
+		if wrongOptions := q.GetAnswerCount() - len(correct); wrongOptions > 0 {
+			credit -= float64(wrong) / float64(wrongOptions)
+		}
+		return max(credit, 0)
+	case PartialCreditPerOption:
+		missed := len(correct) - right
+		return float64(q.GetAnswerCount()-missed-wrong) / float64(q.GetAnswerCount())
+	}
+	return 0
+}
+
+// sequenceCredit grades an order or match sequence position by position
+// Positions are compared by text, so repeated words or matches are interchangeable
+func (c Config) sequenceCredit(q models.Question, sequence []int) float64 {
+	right := 0
+	for i, id := range sequence {
+		if i < q.GetAnswerCount() && q.ButtonText(id) == q.ButtonText(i+1) {
+			right++
+		}
+	}
+	if right == q.GetAnswerCount() && len(sequence) == right {
+		return 1
+	}
+	if c.PartialCredit == "" || q.GetAnswerCount() == 0 {
+		return 0
+	}
+	return float64(right) / float64(q.GetAnswerCount())
+}
+
+// CreditScore returns the points for an answer earning the given share of the question:
+// that share of its weight, or minus NegativeMarking times its weight if it earned nothing
+func (c Config) CreditScore(q models.Question, credit float64) float64 {
+	if credit > 0 {
+		return credit * Weight(q)
+	}
+	if c.NegativeMarking == 0 {
+		return 0 // Not -0
+	}
+	return -c.NegativeMarking * Weight(q)
+}
+
+// Compute scores a test from the points stored with its answers, so that changing the
+// scheme later does not change results of past answers
+// questions are all questions of the test, including the ones never reached
+func (c Config) Compute(questions []models.Question, answers []models.Answer) Result {
+	answerMap := make(map[primitive.ObjectID]models.Answer, len(answers))
+	for _, a := range answers {
+		answerMap[a.QuestionID] = a
+	}
+
+	var r Result
+	for _, q := range questions {
+		r.MaxScore += Weight(q)
+		a, answered := answerMap[q.ID]
+		switch {
+		case !answered || a.TimedOut:
+			r.Skipped++
+			continue
+		case a.IsCorrect:
+			r.Correct++
+		case a.Credit > 0:
+			r.Partial++
+		default:
+			r.Incorrect++
+		}
+		r.Score += a.Score
+	}
+
+	if r.MaxScore > 0 {
+		r.Percentage = max(r.Score, 0) / r.MaxScore * 100.0
+	}
+	if c.PassThreshold > 0 {
+		passed := r.Percentage >= c.PassThreshold
+		r.Passed = &passed
+		r.PassThreshold = c.PassThreshold
+	}
+	return r
+}
+
+// FromSession returns the result stored with a finished session, counting correct, partially
+// correct, incorrect and skipped questions from its questions and answers
+// Sessions finished before results were stored are computed with c
+func (c Config) FromSession(session *models.Session, questions []models.Question, answers []models.Answer) Result {
+	r := c.Compute(questions, answers)
+	if session.MaxScore > 0 {
+		r.Score = session.TotalScore
+		r.MaxScore = session.MaxScore
+		r.Percentage = session.Percentage
+		r.Passed = session.Passed
+		r.PassThreshold = session.PassThreshold
+	}
+	return r
+}
+
+// FormatScore formats a score rounded to two decimals as "3" or "2.75"
+func FormatScore(score float64) string {
+	return strconv.FormatFloat(math.Round(score*100)/100, 'f', -1, 64)
+}
+
+// Summary formats the score as "7.5/10 (75.0%)"
+func (r Result) Summary() string {
+	return fmt.Sprintf("%s/%s (%.1f%%)", FormatScore(r.Score), FormatScore(r.MaxScore), r.Percentage)
+}
+
+// Verdict formats the pass/fail result as "Passed (pass mark 60%)", empty without a pass threshold
+func (r Result) Verdict() string {
+	if r.Passed == nil {
+		return ""
+	}
+	verdict := "Not passed"
+	if *r.Passed {
+		verdict = "Passed"
+	}
+	return fmt.Sprintf("%s (pass mark %s%%)", verdict, FormatScore(r.PassThreshold))
+}
@@ -0,0 +1,245 @@
+package scoring
+
+import (
+	"math"
+	"testing"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newQuestion(score int) models.Question {
+	return models.Question{
+		ID:              primitive.NewObjectID(),
+		Text:            "question",
+		Options:         []string{"a", "b", "c", "d"},
+		CorrectAnswerID: 1,
+		Score:           score,
+	}
+}
+
+func TestCompute(t *testing.T) {
+	q1, q2, q3, q4 := newQuestion(1), newQuestion(2), newQuestion(3), newQuestion(4)
+	questions := []models.Question{q1, q2, q3, q4}
+
+	tests := []struct {
+		name     string
+		config   Config
+		answers  []models.Answer
+		want     Result
+		wantPass *bool
+		wantPct  float64
+	}{
+		{
+			name:    "nothing answered",
+			answers: nil,
+			want:    Result{MaxScore: 10, Skipped: 4},
+		},
+		{
+			name: "correct, incorrect, timed out and unreached",
+			answers: []models.Answer{
+				{QuestionID: q1.ID, IsCorrect: true, Score: 1},
+				{QuestionID: q2.ID, Score: 0},
+				{QuestionID: q3.ID, TimedOut: true},
+			},
+			want:    Result{MaxScore: 10, Score: 1, Correct: 1, Incorrect: 1, Skipped: 2},
+			wantPct: 10,
+		},
+		{
+			name: "partial credit is counted on its own",
+			answers: []models.Answer{
+				{QuestionID: q1.ID, IsCorrect: true, Score: 1},
+				{QuestionID: q2.ID, Credit: 0.5, Score: 1},
+				{QuestionID: q3.ID, Score: 0},
+			},
+			want:    Result{MaxScore: 10, Score: 2, Correct: 1, Partial: 1, Incorrect: 1, Skipped: 1},
+			wantPct: 20,
+		},
+		{
+			name: "negative score keeps the percentage at 0",
+			answers: []models.Answer{
+				{QuestionID: q1.ID, Score: -0.25},
+				{QuestionID: q2.ID, Score: -0.5},
+			},
+			want: Result{MaxScore: 10, Score: -0.75, Incorrect: 2, Skipped: 2},
+		},
+		{
+			name:   "pass threshold reached",
+			config: Config{PassThreshold: 60},
+			answers: []models.Answer{
+				{QuestionID: q3.ID, IsCorrect: true, Score: 3},
+				{QuestionID: q4.ID, IsCorrect: true, Score: 4},
+			},
+			want:     Result{MaxScore: 10, Score: 7, Correct: 2, Skipped: 2, PassThreshold: 60},
+			wantPass: boolPtr(true),
+			wantPct:  70,
+		},
+		{
+			name:   "pass threshold missed",
+			config: Config{PassThreshold: 60},
+			answers: []models.Answer{
+				{QuestionID: q4.ID, IsCorrect: true, Score: 4},
+			},
+			want:     Result{MaxScore: 10, Score: 4, Correct: 1, Skipped: 3, PassThreshold: 60},
+			wantPass: boolPtr(false),
+			wantPct:  40,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Compute(questions, tt.answers)
+			if got.Score != tt.want.Score || got.MaxScore != tt.want.MaxScore ||
+				got.Correct != tt.want.Correct || got.Partial != tt.want.Partial ||
+				got.Incorrect != tt.want.Incorrect || got.Skipped != tt.want.Skipped ||
+				got.PassThreshold != tt.want.PassThreshold {
+				t.Errorf("Compute() = %+v, want %+v", got, tt.want)
+			}
+			if math.Abs(got.Percentage-tt.wantPct) > 1e-9 {
+				t.Errorf("Percentage = %v, want %v", got.Percentage, tt.wantPct)
+			}
+			switch {
+			case tt.wantPass == nil && got.Passed != nil:
+				t.Errorf("Passed = %v, want nil", *got.Passed)
+			case tt.wantPass != nil && (got.Passed == nil || *got.Passed != *tt.wantPass):
+				t.Errorf("Passed = %v, want %v", got.Passed, *tt.wantPass)
+			}
+		})
+	}
+}
+
+func TestAnswerScore(t *testing.T) {
+	q := newQuestion(2)
+	tests := []struct {
+		name    string
+		config  Config
+		correct bool
+		want    float64
+	}{
+		{"correct", Config{}, true, 2},
+		{"wrong without negative marking", Config{}, false, 0},
+		{"correct with negative marking", Config{NegativeMarking: 0.25}, true, 2},
+		{"wrong with negative marking", Config{NegativeMarking: 0.25}, false, -0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.AnswerScore(q, tt.correct)
+			if got != tt.want || math.Signbit(got) != math.Signbit(tt.want) {
+				t.Errorf("AnswerScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromSession(t *testing.T) {
+	q1, q2 := newQuestion(1), newQuestion(3)
+	questions := []models.Question{q1, q2}
+	answers := []models.Answer{{QuestionID: q1.ID, IsCorrect: true, Score: 1}, {QuestionID: q2.ID, Score: -0.75}}
+	config := Config{NegativeMarking: 0.25, PassThreshold: 50}
+
+	// Stored results win over the current scheme, the counts come from the answers
+	stored := &models.Session{TotalScore: 1, MaxScore: 4, Percentage: 25, Passed: boolPtr(true), PassThreshold: 20}
+	got := config.FromSession(stored, questions, answers)
+	if got.Score != 1 || got.MaxScore != 4 || got.Percentage != 25 || got.Passed == nil || !*got.Passed || got.PassThreshold != 20 {
+		t.Errorf("FromSession() = %+v, want the stored result", got)
+	}
+	if got.Correct != 1 || got.Incorrect != 1 {
+		t.Errorf("FromSession() counted %d correct and %d incorrect, want 1 and 1", got.Correct, got.Incorrect)
+	}
+
+	// Sessions finished before results were stored
+	got = config.FromSession(&models.Session{TotalScore: 1}, questions, answers)
+	if got.Score != 0.25 || got.MaxScore != 4 || got.Passed == nil || *got.Passed {
+		t.Errorf("FromSession() of an old session = %+v, want it computed", got)
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestCredit(t *testing.T) {
+	multiple := models.Question{
+		Type:             models.QuestionTypeMultiple,
+		Options:          []string{"a", "b", "c", "d"},
+		CorrectAnswerIDs: []int{1, 2},
+		Score:            1,
+	}
+	order := models.Question{
+		Type:    models.QuestionTypeOrder,
+		Options: []string{"she", "is", "here", "now"},
+		Score:   1,
+	}
+	match := models.Question{
+		Type:    models.QuestionTypeMatch,
+		Options: []string{"cat", "dog", "cow"},
+		Matches: []string{"meow", "woof", "meow"},
+		Score:   1,
+	}
+	tests := []struct {
+		name     string
+		scheme   string
+		question models.Question
+		selected []int
+		want     float64
+	}{
+		{"exact set", "", multiple, []int{1, 2}, 1},
+		{"one missing, all or nothing", "", multiple, []int{1}, 0},
+		{"one missing, right minus wrong", PartialCreditRightMinusWrong, multiple, []int{1}, 0.5},
+		{"one right one wrong, right minus wrong", PartialCreditRightMinusWrong, multiple, []int{1, 3}, 0},
+		{"one missing, per option", PartialCreditPerOption, multiple, []int{1}, 0.75},
+		{"right sequence", "", order, []int{1, 2, 3, 4}, 1},
+		{"half the positions, all or nothing", "", order, []int{1, 2, 4, 3}, 0},
+		{"half the positions", PartialCreditPerOption, order, []int{1, 2, 4, 3}, 0.5},
+		{"sequence not finished", PartialCreditPerOption, order, []int{1, 2}, 0.5},
+		{"right matches", "", match, []int{1, 2, 3}, 1},
+		{"repeated matches are interchangeable", "", match, []int{3, 2, 1}, 1},
+		{"one match right", PartialCreditRightMinusWrong, match, []int{2, 1, 3}, 1.0 / 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Config{PartialCredit: tt.scheme}.Credit(tt.question, tt.selected)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Credit(%v) = %v, want %v", tt.selected, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreditScore(t *testing.T) {
+	q := newQuestion(4)
+	tests := []struct {
+		config Config
+		credit float64
+		want   float64
+	}{
+		{Config{}, 1, 4},
+		{Config{}, 0.5, 2},
+		{Config{}, 0, 0},
+		{Config{NegativeMarking: 0.25}, 0.5, 2},
+		{Config{NegativeMarking: 0.25}, 0, -1},
+	}
+	for _, tt := range tests {
+		if got := tt.config.CreditScore(q, tt.credit); got != tt.want {
+			t.Errorf("CreditScore(%v) with negative marking %v = %v, want %v", tt.credit, tt.config.NegativeMarking, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizePartialCredit(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   string
+		wantOK bool
+	}{
+		{"", "", true},
+		{"None", "", true},
+		{" per_option ", PartialCreditPerOption, true},
+		{"half", "half", false},
+	}
+	for _, tt := range tests {
+		if got, ok := NormalizePartialCredit(tt.in); got != tt.want || ok != tt.wantOK {
+			t.Errorf("NormalizePartialCredit(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// SecretTokenHeader is the header Telegram sends the webhook secret token in
+const SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Server is the bot's HTTP server: Telegram webhook, health check and metrics
+type Server struct {
+	httpServer *http.Server
+	mux        *http.ServeMux
+
+	updatesReceived atomic.Int64
+	updatesRejected atomic.Int64
+
+	gaugesMu sync.Mutex
+	gauges   map[string]gauge
+}
+
+type gauge struct {
+	help  string
+	value func() float64
+}
+
+// New creates a server listening on addr with /healthz and /metrics registered
+func New(addr string) *Server {
+	s := &Server{
+		mux:    http.NewServeMux(),
+		gauges: make(map[string]gauge),
+	}
+	s.httpServer = &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	s.mux.HandleFunc("/healthz", s.handleHealth)
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
+	return s
+}
+
+// HandleWebhook registers the Telegram webhook endpoint at path
+// Requests without the expected secret token or with an invalid body are rejected
+// and valid updates are passed to handle
+func (s *Server) HandleWebhook(path, secretToken string, handle func(tgbotapi.Update)) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := r.Header.Get(SecretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secretToken)) != 1 {
+			s.updatesRejected.Add(1)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		var update tgbotapi.Update
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			s.updatesRejected.Add(1)
+			log.Printf("Error decoding webhook update: %v", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		s.updatesReceived.Add(1)
+		handle(update)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// RegisterGauge exposes the current value of fn on /metrics under name
+func (s *Server) RegisterGauge(name, help string, fn func() float64) {
+	s.gaugesMu.Lock()
+	defer s.gaugesMu.Unlock()
+	s.gauges[name] = gauge{help: help, value: fn}
+}
+
+// CountUpdate records an update received outside the webhook (long polling)
+func (s *Server) CountUpdate() {
+	s.updatesReceived.Add(1)
+}
+
+// ListenAndServe serves plain HTTP, or HTTPS when certFile and keyFile are set
+// Returns http.ErrServerClosed after Shutdown
+func (s *Server) ListenAndServe(certFile, keyFile string) error {
+	log.Printf("HTTP server listening on %s", s.httpServer.Addr)
+	if certFile != "" && keyFile != "" {
+		return s.httpServer.ListenAndServeTLS(certFile, keyFile)
+	}
+	return s.httpServer.ListenAndServe()
+}
+
+// Shutdown stops accepting requests and waits for in-flight requests to finish
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleMetrics writes the metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMetric(w, "tgbot_updates_received_total", "Updates received from Telegram.", "counter", float64(s.updatesReceived.Load()))
+	writeMetric(w, "tgbot_webhook_updates_rejected_total", "Webhook requests rejected for a bad secret token or body.", "counter", float64(s.updatesRejected.Load()))
+
+	s.gaugesMu.Lock()
+	names := make([]string, 0, len(s.gauges))
+	for name := range s.gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	gauges := make([]gauge, len(names))
+	for i, name := range names {
+		gauges[i] = s.gauges[name]
+	}
+	s.gaugesMu.Unlock()
+
+	for i, name := range names {
+		writeMetric(w, name, gauges[i].help, "gauge", gauges[i].value())
+	}
+}
+
+func writeMetric(w http.ResponseWriter, name, help, kind string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, kind, name, value)
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// DeriveSecretToken returns a webhook secret token derived from the bot token
+// Telegram allows 1-256 characters from A-Z, a-z, 0-9, _ and -, hex digits qualify
+func DeriveSecretToken(botToken string) string {
+	sum := sha256.Sum256([]byte("tg-english-bot webhook:" + botToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// WebhookPath returns the path of the public webhook URL that the server has to handle, "/" if it has none
+func WebhookPath(webhookURL string) (string, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", fmt.Errorf("invalid webhook URL %q: scheme must be https", webhookURL)
+	}
+	if u.Path == "" {
+		return "/", nil
+	}
+	return u.Path, nil
+}
+
+// RegisterWebhook points the bot's webhook at webhookURL with the given secret token
+// tgbotapi's WebhookConfig has no secret_token field, so the request is built by hand
+func RegisterWebhook(bot *tgbotapi.BotAPI, webhookURL, secretToken string) error {
+	params := tgbotapi.Params{}
+	params["url"] = webhookURL
+	params["secret_token"] = secretToken
+
+	resp, err := bot.MakeRequest("setWebhook", params)
+	if err != nil {
+		return fmt.Errorf("failed to set webhook: %w", err)
+	}
+	if !resp.Ok {
+		return fmt.Errorf("failed to set webhook: %s", resp.Description)
+	}
+	return nil
+}
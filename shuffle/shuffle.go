@@ -0,0 +1,130 @@
+package shuffle
+
+import (
+	"math/rand"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewSeed returns a fresh seed for a session
+func NewSeed() int64 {
+	return time.Now().UnixNano()
+}
+
+// Rand returns the random source for one step of a session
+// The same seed and step always produce the same sequence, which makes every
+// permutation of a session reproducible from its stored seed
+func Rand(seed int64, step int) *rand.Rand {
+	// Mix the step into the seed so neighbouring steps get unrelated sequences
+	return rand.New(rand.NewSource(seed ^ (int64(step)+1)*0x5DEECE66D))
+}
+
+// units splits question IDs into the units that are drawn and shuffled as a whole
+// The questions of a group (e.g. the questions of a reading passage) form one unit in their
+// original order, at the place of the group's first question; every other question is a unit
+// of its own. groups maps question IDs to their group, questions missing from it have none
+func units(questionIDs []primitive.ObjectID, groups map[primitive.ObjectID]string) [][]primitive.ObjectID {
+	result := make([][]primitive.ObjectID, 0, len(questionIDs))
+	unitOf := make(map[string]int)
+	for _, id := range questionIDs {
+		group := groups[id]
+		if i, ok := unitOf[group]; ok && group != "" {
+			result[i] = append(result[i], id)
+			continue
+		}
+		if group != "" {
+			unitOf[group] = len(result)
+		}
+		result = append(result, []primitive.ObjectID{id})
+	}
+	return result
+}
+
+// flatten lists the question IDs of units in order
+func flatten(units [][]primitive.ObjectID) []primitive.ObjectID {
+	var questionIDs []primitive.ObjectID
+	for _, unit := range units {
+		questionIDs = append(questionIDs, unit...)
+	}
+	return questionIDs
+}
+
+// Group returns the question IDs with the questions of each group moved up to its first question,
+// otherwise in their order; see units
+func Group(questionIDs []primitive.ObjectID, groups map[primitive.ObjectID]string) []primitive.ObjectID {
+	return flatten(units(questionIDs, groups))
+}
+
+// Questions returns the question IDs in the order shown to the candidate
+// The questions of a group stay together in their order, see units
+func Questions(seed int64, questionIDs []primitive.ObjectID, groups map[primitive.ObjectID]string) []primitive.ObjectID {
+	shuffled := units(questionIDs, groups)
+	r := Rand(seed, -1)
+	r.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return flatten(shuffled)
+}
+
+// Draw returns count of the question IDs picked at random, keeping their order
+// All question IDs are returned when there are no more than count. Groups are drawn as a whole
+// (see units): a group that no longer fits is passed over, so fewer than count may be returned,
+// except that the first unit drawn is always taken, so a test never ends up without questions
+func Draw(seed int64, questionIDs []primitive.ObjectID, count int, groups map[primitive.ObjectID]string) []primitive.ObjectID {
+	all := units(questionIDs, groups)
+	if count >= len(questionIDs) {
+		return flatten(all)
+	}
+
+	picked := make(map[int]bool, count)
+	left := count
+	for _, i := range Rand(seed, -2).Perm(len(all)) {
+		if left <= 0 {
+			break
+		}
+		if len(all[i]) <= left || left == count {
+			picked[i] = true
+			left -= len(all[i])
+		}
+	}
+	var drawn [][]primitive.ObjectID
+	for i, unit := range all {
+		if picked[i] {
+			drawn = append(drawn, unit)
+		}
+	}
+	return flatten(drawn)
+}
+
+// Options returns the canonical answer IDs (1-based) in the order they are shown
+// for the question at position questionIdx of the session
+func Options(seed int64, questionIdx int, answerCount int) []int {
+	order := Identity(answerCount)
+	r := Rand(seed, questionIdx)
+	r.Shuffle(len(order), func(i, j int) {
+		order[i], order[j] = order[j], order[i]
+	})
+	return order
+}
+
+// Identity returns the unshuffled option order 1..answerCount
+func Identity(answerCount int) []int {
+	order := make([]int, answerCount)
+	for i := range order {
+		order[i] = i + 1
+	}
+	return order
+}
+
+// Scramble is Options for questions whose canonical order is the key (order and match
+// questions): with two or more options it never returns the canonical order
+func Scramble(seed int64, questionIdx int, answerCount int) []int {
+	order := Options(seed, questionIdx, answerCount)
+	if answerCount > 1 && slices.Equal(order, Identity(answerCount)) {
+		// Rotate by one instead of reshuffling, which keeps the result reproducible
+		order = append(order[1:], order[0])
+	}
+	return order
+}
@@ -0,0 +1,211 @@
+package shuffle
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newIDs returns n question IDs and, for every group name, puts the IDs at the given indexes in that group
+func newIDs(n int, groups map[string][]int) ([]primitive.ObjectID, map[primitive.ObjectID]string) {
+	ids := make([]primitive.ObjectID, n)
+	for i := range ids {
+		ids[i] = primitive.NewObjectID()
+	}
+	groupOf := make(map[primitive.ObjectID]string)
+	for group, indexes := range groups {
+		for _, i := range indexes {
+			groupOf[ids[i]] = group
+		}
+	}
+	return ids, groupOf
+}
+
+// positions returns the index in ids of every ID of got
+func positions(ids, got []primitive.ObjectID) []int {
+	result := make([]int, len(got))
+	for i, id := range got {
+		result[i] = slices.Index(ids, id)
+	}
+	return result
+}
+
+// checkGroups fails unless every group of got is contiguous and in its original order
+func checkGroups(t *testing.T, ids, got []primitive.ObjectID, groups map[primitive.ObjectID]string) {
+	t.Helper()
+	seen := make(map[string]bool)
+	for i, id := range got {
+		group := groups[id]
+		if group == "" {
+			continue
+		}
+		if i > 0 && groups[got[i-1]] == group {
+			if slices.Index(ids, got[i-1]) > slices.Index(ids, id) {
+				t.Errorf("group %q out of order: %v", group, positions(ids, got))
+			}
+			continue
+		}
+		if seen[group] {
+			t.Errorf("group %q split: %v", group, positions(ids, got))
+		}
+		seen[group] = true
+	}
+}
+
+func TestQuestions(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      int
+		groups map[string][]int
+	}{
+		{"no groups", 8, nil},
+		{"one passage", 8, map[string][]int{"p1": {2, 3, 4}}},
+		{"two passages", 10, map[string][]int{"p1": {0, 1}, "p2": {5, 6, 7, 8}}},
+		{"passage listed apart", 8, map[string][]int{"p1": {1, 6}}},
+		{"everything in one passage", 4, map[string][]int{"p1": {0, 1, 2, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, groups := newIDs(tt.n, tt.groups)
+			for seed := int64(0); seed < 20; seed++ {
+				got := Questions(seed, ids, groups)
+				sorted := slices.Clone(positions(ids, got))
+				slices.Sort(sorted)
+				if !slices.Equal(sorted, positions(ids, ids)) {
+					t.Fatalf("Questions() is no permutation: %v", positions(ids, got))
+				}
+				checkGroups(t, ids, got, groups)
+				if again := Questions(seed, ids, groups); !slices.Equal(again, got) {
+					t.Fatalf("Questions() not reproducible for seed %d", seed)
+				}
+			}
+		})
+	}
+}
+
+func TestQuestionsWithoutGroupsShufflesLikeBefore(t *testing.T) {
+	// Sessions started before passages existed must get the order their seed always gave
+	ids, _ := newIDs(8, nil)
+	for seed := int64(0); seed < 10; seed++ {
+		want := slices.Clone(ids)
+		Rand(seed, -1).Shuffle(len(want), func(i, j int) {
+			want[i], want[j] = want[j], want[i]
+		})
+		if got := Questions(seed, ids, nil); !slices.Equal(got, want) {
+			t.Errorf("seed %d: Questions() = %v, want %v", seed, positions(ids, got), positions(ids, want))
+		}
+	}
+}
+
+func TestQuestionsDependsOnSeed(t *testing.T) {
+	ids, _ := newIDs(8, nil)
+	orders := make(map[string]bool)
+	for seed := int64(0); seed < 20; seed++ {
+		orders[fmt.Sprint(positions(ids, Questions(seed, ids, nil)))] = true
+	}
+	if len(orders) < 10 {
+		t.Errorf("20 seeds gave only %d question orders", len(orders))
+	}
+}
+
+func TestOptions(t *testing.T) {
+	for _, answerCount := range []int{3, 4, 6} {
+		for seed := int64(0); seed < 20; seed++ {
+			for questionIdx := 0; questionIdx < 5; questionIdx++ {
+				order := Options(seed, questionIdx, answerCount)
+				sorted := slices.Clone(order)
+				slices.Sort(sorted)
+				if !slices.Equal(sorted, Identity(answerCount)) {
+					t.Fatalf("Options(%d, %d, %d) = %v is no permutation", seed, questionIdx, answerCount, order)
+				}
+				if again := Options(seed, questionIdx, answerCount); !slices.Equal(again, order) {
+					t.Fatalf("Options(%d, %d, %d) not reproducible", seed, questionIdx, answerCount)
+				}
+			}
+		}
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	if got := Identity(4); !slices.Equal(got, []int{1, 2, 3, 4}) {
+		t.Errorf("Identity(4) = %v, want [1 2 3 4]", got)
+	}
+}
+
+func TestDraw(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		groups  map[string][]int
+		count   int
+		wantMin int // Fewest questions expected, groups that no longer fit are passed over
+		wantMax int
+	}{
+		{"no groups", 10, nil, 4, 4, 4},
+		{"count above the questions", 5, nil, 8, 5, 5},
+		{"groups fit", 10, map[string][]int{"p1": {0, 1}, "p2": {5, 6}}, 4, 4, 4},
+		{"group may not fit", 10, map[string][]int{"p1": {2, 3, 4}}, 5, 3, 5},
+		{"first group drawn is always taken", 6, map[string][]int{"p1": {0, 1, 2}, "p2": {3, 4, 5}}, 2, 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, groups := newIDs(tt.n, tt.groups)
+			for seed := int64(0); seed < 20; seed++ {
+				got := Draw(seed, ids, tt.count, groups)
+				if len(got) < tt.wantMin || len(got) > tt.wantMax {
+					t.Fatalf("seed %d: Draw() returned %d questions, want %d to %d", seed, len(got), tt.wantMin, tt.wantMax)
+				}
+				if p := positions(ids, got); !slices.IsSorted(p) || slices.Contains(p, -1) {
+					t.Fatalf("seed %d: Draw() = %v, want questions in their order", seed, p)
+				}
+				for _, id := range got {
+					group := groups[id]
+					if group == "" {
+						continue
+					}
+					for member, g := range groups {
+						if g == group && !slices.Contains(got, member) {
+							t.Fatalf("seed %d: Draw() = %v, group %q drawn partly", seed, positions(ids, got), group)
+						}
+					}
+				}
+			}
+		})
+	}
+
+	ids, _ := newIDs(10, nil)
+	draws := make(map[string]bool)
+	for seed := int64(0); seed < 20; seed++ {
+		draws[fmt.Sprint(positions(ids, Draw(seed, ids, 3, nil)))] = true
+	}
+	if len(draws) < 10 {
+		t.Errorf("20 seeds drew only %d different sets", len(draws))
+	}
+}
+
+func TestGroup(t *testing.T) {
+	ids, groups := newIDs(6, map[string][]int{"p1": {1, 4}, "p2": {2, 5}})
+	got := positions(ids, Group(ids, groups))
+	want := []int{0, 1, 4, 2, 5, 3}
+	if !slices.Equal(got, want) {
+		t.Errorf("Group() = %v, want %v", got, want)
+	}
+}
+
+func TestScramble(t *testing.T) {
+	for n := 2; n <= 10; n++ {
+		for seed := int64(0); seed < 50; seed++ {
+			order := Scramble(seed, 0, n)
+			if slices.Equal(order, Identity(n)) {
+				t.Fatalf("Scramble(%d, 0, %d) kept the canonical order", seed, n)
+			}
+			sorted := slices.Clone(order)
+			slices.Sort(sorted)
+			if !slices.Equal(sorted, Identity(n)) {
+				t.Fatalf("Scramble(%d, 0, %d) = %v is no permutation", seed, n, order)
+			}
+		}
+	}
+}
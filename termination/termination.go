@@ -0,0 +1,329 @@
+package termination
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Defaults of the optional policy arguments
+const (
+	DefaultLevelMinAnswers = 5
+	DefaultCeilingLevel    = "C2"
+)
+
+// Progress is the state of a running test a policy decides on
+// Timed out answers are never counted as errors
+type Progress struct {
+	Questions []models.Question // Questions asked so far, used to look up the level of each answer
+	Answers   []models.Answer   // Answers in the order they were given
+	ExpiresAt *time.Time        // End of the whole-test time limit, nil without one
+	Now       time.Time
+}
+
+// Decision tells how and why a test ends
+type Decision struct {
+	Status string // Session status, one of the models.Status constants
+	Reason string // Shown to the candidate and admins and stored on the session
+}
+
+// Policy stops a test early when its condition is met
+type Policy interface {
+	// Check returns the decision to end the test, false to go on
+	Check(p Progress) (Decision, bool)
+	// String returns the policy in the TERMINATION_POLICY format
+	String() string
+}
+
+// Policies are checked in order, the first one that stops the test decides
+type Policies []Policy
+
+// Check returns the decision of the first policy that stops the test, false to go on
+func (ps Policies) Check(p Progress) (Decision, bool) {
+	for _, policy := range ps {
+		if decision, stop := policy.Check(p); stop {
+			return decision, true
+		}
+	}
+	return Decision{}, false
+}
+
+// String returns the policies in the TERMINATION_POLICY format
+func (ps Policies) String() string {
+	if len(ps) == 0 {
+		return "none"
+	}
+	parts := make([]string, len(ps))
+	for i, policy := range ps {
+		parts[i] = policy.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// ConsecutiveErrors fails the test after Max incorrect answers in a row
+type ConsecutiveErrors struct {
+	Max int
+}
+
+func (c ConsecutiveErrors) Check(p Progress) (Decision, bool) {
+	streak := Streak(p.Answers)
+	if streak < c.Max {
+		return Decision{}, false
+	}
+	return Decision{
+		Status: models.StatusFailedConsecutiveErrors,
+		Reason: fmt.Sprintf("%d consecutive errors", streak),
+	}, true
+}
+
+func (c ConsecutiveErrors) String() string {
+	return fmt.Sprintf("consecutive_errors:%d", c.Max)
+}
+
+// TotalErrors fails the test after Max incorrect answers in total
+type TotalErrors struct {
+	Max int
+}
+
+func (t TotalErrors) Check(p Progress) (Decision, bool) {
+	errors := 0
+	for _, a := range p.Answers {
+		if isError(a) {
+			errors++
+		}
+	}
+	if errors < t.Max {
+		return Decision{}, false
+	}
+	return Decision{
+		Status: models.StatusFailed,
+		Reason: fmt.Sprintf("%d errors in total", errors),
+	}, true
+}
+
+func (t TotalErrors) String() string {
+	return fmt.Sprintf("total_errors:%d", t.Max)
+}
+
+// LevelErrorRate fails the test when at least Percent of the answers at the level of the
+// last answered question are incorrect, once MinAnswers questions of that level were answered
+type LevelErrorRate struct {
+	Percent    float64
+	MinAnswers int
+}
+
+func (l LevelErrorRate) Check(p Progress) (Decision, bool) {
+	if len(p.Answers) == 0 {
+		return Decision{}, false
+	}
+	levels := questionLevels(p.Questions)
+	level := levels[p.Answers[len(p.Answers)-1].QuestionID]
+	if level == "" {
+		return Decision{}, false
+	}
+
+	answered, errors := 0, 0
+	for _, a := range p.Answers {
+		if levels[a.QuestionID] != level {
+			continue
+		}
+		answered++
+		if isError(a) {
+			errors++
+		}
+	}
+	if answered < l.MinAnswers || float64(errors)/float64(answered)*100.0 < l.Percent {
+		return Decision{}, false
+	}
+	return Decision{
+		Status: models.StatusFailed,
+		Reason: fmt.Sprintf("%d of %d answers at %s incorrect", errors, answered, level),
+	}, true
+}
+
+func (l LevelErrorRate) String() string {
+	return fmt.Sprintf("level_error_rate:%s:%d", strconv.FormatFloat(l.Percent, 'f', -1, 64), l.MinAnswers)
+}
+
+// Ceiling completes the test once Correct questions of Level were answered correctly,
+// the candidate has nothing left to prove
+type Ceiling struct {
+	Correct int
+	Level   string
+}
+
+func (c Ceiling) Check(p Progress) (Decision, bool) {
+	levels := questionLevels(p.Questions)
+	correct := 0
+	for _, a := range p.Answers {
+		if a.IsCorrect && levels[a.QuestionID] == c.Level {
+			correct++
+		}
+	}
+	if correct < c.Correct {
+		return Decision{}, false
+	}
+	return Decision{
+		Status: models.StatusCompleted,
+		Reason: fmt.Sprintf("ceiling reached, %d correct answers at %s", correct, c.Level),
+	}, true
+}
+
+func (c Ceiling) String() string {
+	return fmt.Sprintf("ceiling:%d:%s", c.Correct, c.Level)
+}
+
+// TimeExhausted completes the test when the whole-test time limit runs out
+// The time limit is enforced by the bot's timers whether or not the policy is listed; listing it
+// also checks the limit after each answer, in order with the other policies
+type TimeExhausted struct{}
+
+func (TimeExhausted) Check(p Progress) (Decision, bool) {
+	if p.ExpiresAt == nil || p.Now.Before(*p.ExpiresAt) {
+		return Decision{}, false
+	}
+	return Decision{
+		Status: models.StatusCompleted,
+		Reason: "test time limit reached",
+	}, true
+}
+
+func (TimeExhausted) String() string {
+	return "time"
+}
+
+// Streak returns the number of incorrect answers in a row at the end of answers
+// Timed out answers neither count nor break the streak
+func Streak(answers []models.Answer) int {
+	streak := 0
+	for _, a := range answers {
+		switch {
+		case a.IsCorrect:
+			streak = 0
+		case a.TimedOut:
+		default:
+			streak++
+		}
+	}
+	return streak
+}
+
+// Parse reads a comma-separated list of policies:
+// consecutive_errors:N, total_errors:N, level_error_rate:PERCENT[:MIN_ANSWERS],
+// ceiling:N[:LEVEL], time, or none for no policy at all
+func Parse(spec string) (Policies, error) {
+	if strings.EqualFold(strings.TrimSpace(spec), "none") {
+		return Policies{}, nil
+	}
+
+	var policies Policies
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		args := strings.Split(part, ":")
+		name := strings.ToLower(strings.TrimSpace(args[0]))
+		args = args[1:]
+
+		var policy Policy
+		var err error
+		switch name {
+		case "none":
+			err = fmt.Errorf("can't be combined with other policies")
+		case "consecutive_errors":
+			var max int
+			max, err = parseCount(args)
+			policy = ConsecutiveErrors{Max: max}
+		case "total_errors":
+			var max int
+			max, err = parseCount(args)
+			policy = TotalErrors{Max: max}
+		case "level_error_rate":
+			policy, err = parseLevelErrorRate(args)
+		case "ceiling":
+			policy, err = parseCeiling(args)
+		case "time":
+			if len(args) > 0 {
+				err = fmt.Errorf("takes no arguments")
+			}
+			policy = TimeExhausted{}
+		default:
+			err = fmt.Errorf("unknown policy")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", part, err)
+		}
+		policies = append(policies, policy)
+	}
+	if policies == nil {
+		return nil, fmt.Errorf("no policy given")
+	}
+	return policies, nil
+}
+
+// parseCount parses the single positive count argument of a policy
+func parseCount(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("expected a count")
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(args[0]))
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("count must be a whole number of at least 1")
+	}
+	return n, nil
+}
+
+func parseLevelErrorRate(args []string) (Policy, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("expected PERCENT[:MIN_ANSWERS]")
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSpace(args[0]), 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return nil, fmt.Errorf("percent must be above 0 and at most 100")
+	}
+	policy := LevelErrorRate{Percent: percent, MinAnswers: DefaultLevelMinAnswers}
+	if len(args) == 2 {
+		if policy.MinAnswers, err = parseCount(args[1:]); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+func parseCeiling(args []string) (Policy, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("expected N[:LEVEL]")
+	}
+	correct, err := parseCount(args[:1])
+	if err != nil {
+		return nil, err
+	}
+	policy := Ceiling{Correct: correct, Level: DefaultCeilingLevel}
+	if len(args) == 2 {
+		level, ok := models.NormalizeLevel(args[1])
+		if !ok {
+			return nil, fmt.Errorf("unknown CEFR level %q", args[1])
+		}
+		policy.Level = level
+	}
+	return policy, nil
+}
+
+// isError reports whether an answer counts as an error, timeouts do not
+func isError(a models.Answer) bool {
+	return !a.IsCorrect && !a.TimedOut
+}
+
+// questionLevels maps question IDs to their CEFR level
+func questionLevels(questions []models.Question) map[primitive.ObjectID]string {
+	levels := make(map[primitive.ObjectID]string, len(questions))
+	for _, q := range questions {
+		levels[q.ID] = q.Level
+	}
+	return levels
+}
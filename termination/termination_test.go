@@ -0,0 +1,112 @@
+package termination
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andru_bot/tg-bot/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string // Policies.String() of the result
+		wantErr bool
+	}{
+		{spec: "none", want: "none"},
+		{spec: " NONE ", want: "none"},
+		{spec: "consecutive_errors:5", want: "consecutive_errors:5"},
+		{spec: "consecutive_errors:5, time", want: "consecutive_errors:5,time"},
+		{spec: "total_errors:10,ceiling:4:b2", want: "total_errors:10,ceiling:4:B2"},
+		{spec: "ceiling:3", want: "ceiling:3:C2"},
+		{spec: "level_error_rate:70", want: "level_error_rate:70:5"},
+		{spec: "level_error_rate:62.5:6", want: "level_error_rate:62.5:6"},
+		{spec: "", wantErr: true},
+		{spec: ",", wantErr: true},
+		{spec: "none,time", wantErr: true},
+		{spec: "consecutive_errors", wantErr: true},
+		{spec: "consecutive_errors:0", wantErr: true},
+		{spec: "total_errors:x", wantErr: true},
+		{spec: "level_error_rate:0", wantErr: true},
+		{spec: "level_error_rate:101", wantErr: true},
+		{spec: "ceiling:2:D1", wantErr: true},
+		{spec: "time:5", wantErr: true},
+		{spec: "unknown", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			policies, err := Parse(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %q, want an error", tt.spec, policies)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.spec, err)
+			}
+			if got := policies.String(); got != tt.want {
+				t.Errorf("Parse(%q) = %q, want %q", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	a1 := models.Question{ID: primitive.NewObjectID(), Level: "A1"}
+	a1b := models.Question{ID: primitive.NewObjectID(), Level: "A1"}
+	c2 := models.Question{ID: primitive.NewObjectID(), Level: "C2"}
+	c2b := models.Question{ID: primitive.NewObjectID(), Level: "C2"}
+	questions := []models.Question{a1, a1b, c2, c2b}
+
+	right := func(q models.Question) models.Answer { return models.Answer{QuestionID: q.ID, IsCorrect: true} }
+	wrong := func(q models.Question) models.Answer { return models.Answer{QuestionID: q.ID} }
+	timedOut := func(q models.Question) models.Answer { return models.Answer{QuestionID: q.ID, TimedOut: true} }
+
+	now := time.Now()
+	past := now.Add(-time.Second)
+	future := now.Add(time.Minute)
+
+	tests := []struct {
+		name       string
+		spec       string
+		answers    []models.Answer
+		expiresAt  *time.Time
+		wantStop   bool
+		wantStatus string
+	}{
+		{"streak below the maximum", "consecutive_errors:2", []models.Answer{wrong(a1), right(a1b), wrong(c2)}, nil, false, ""},
+		{"streak reached", "consecutive_errors:2", []models.Answer{right(a1), wrong(a1b), wrong(c2)}, nil, true, models.StatusFailedConsecutiveErrors},
+		{"timeouts do not break or extend a streak", "consecutive_errors:2", []models.Answer{wrong(a1), timedOut(a1b), wrong(c2)}, nil, true, models.StatusFailedConsecutiveErrors},
+		{"timeouts alone are no errors", "consecutive_errors:2", []models.Answer{timedOut(a1), timedOut(a1b)}, nil, false, ""},
+		{"total errors reached", "total_errors:2", []models.Answer{wrong(a1), right(a1b), wrong(c2)}, nil, true, models.StatusFailed},
+		{"level error rate reached", "level_error_rate:50:2", []models.Answer{wrong(a1), right(c2), wrong(a1b)}, nil, true, models.StatusFailed},
+		{"level error rate needs enough answers", "level_error_rate:50:3", []models.Answer{wrong(a1), wrong(a1b)}, nil, false, ""},
+		{"ceiling reached", "ceiling:2:C2", []models.Answer{right(c2), wrong(a1), right(c2b)}, nil, true, models.StatusCompleted},
+		{"ceiling not reached", "ceiling:2:C2", []models.Answer{right(c2), right(a1)}, nil, false, ""},
+		{"time left", "time", nil, &future, false, ""},
+		{"time exhausted", "time", nil, &past, true, models.StatusCompleted},
+		{"no time limit", "time", nil, nil, false, ""},
+		{"first policy that stops decides", "ceiling:1:A1,consecutive_errors:1", []models.Answer{right(a1), wrong(c2)}, nil, true, models.StatusCompleted},
+		{"none never stops", "none", []models.Answer{wrong(a1), wrong(a1b), wrong(c2), wrong(c2b)}, &past, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policies, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.spec, err)
+			}
+			decision, stop := policies.Check(Progress{Questions: questions, Answers: tt.answers, ExpiresAt: tt.expiresAt, Now: now})
+			if stop != tt.wantStop {
+				t.Fatalf("Check() stop = %v (%+v), want %v", stop, decision, tt.wantStop)
+			}
+			if stop && decision.Status != tt.wantStatus {
+				t.Errorf("Check() status = %q, want %q", decision.Status, tt.wantStatus)
+			}
+			if stop && decision.Reason == "" {
+				t.Error("Check() returned no reason")
+			}
+		})
+	}
+}
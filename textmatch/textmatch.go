@@ -0,0 +1,145 @@
+package textmatch
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxVariants caps the spellings tried for an answer with many ambiguous contractions
+const maxVariants = 64
+
+// apostrophes are typed instead of ' by phone keyboards and word processors
+var apostrophes = strings.NewReplacer("’", "'", "‘", "'", "`", "'", "´", "'", "ʼ", "'")
+
+// irregularContractions are contractions whose expansion does not follow from their suffix
+var irregularContractions = map[string]string{
+	"won't":  "will not",
+	"can't":  "can not",
+	"cannot": "can not",
+	"shan't": "shall not",
+	"ain't":  "is not",
+	"let's":  "let us",
+}
+
+// contractionSuffixes expand contractions by their suffix; 's and 'd are ambiguous
+// ("she's gone" is "she has gone", "she's here" is "she is here"), so every reading is tried
+var contractionSuffixes = []struct {
+	suffix     string
+	expansions []string
+}{
+	{"n't", []string{" not"}},
+	{"'re", []string{" are"}},
+	{"'m", []string{" am"}},
+	{"'ve", []string{" have"}},
+	{"'ll", []string{" will"}},
+	{"'d", []string{" would", " had"}},
+	{"'s", []string{" is", " has", "'s"}}, // Also a possessive
+}
+
+// Normalize lowercases an answer, unifies apostrophes, collapses whitespace and
+// drops punctuation around words, keeping apostrophes and hyphens inside them
+func Normalize(answer string) string {
+	answer = apostrophes.Replace(strings.ToLower(answer))
+	var words []string
+	for _, word := range strings.Fields(answer) {
+		word = strings.TrimFunc(word, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Variants returns the normalized answer with its contractions expanded in every possible way
+// The first variant is Normalize(answer) itself
+func Variants(answer string) []string {
+	variants := []string{""}
+	for i, word := range strings.Fields(Normalize(answer)) {
+		expansions := expand(word)
+		var next []string
+		for _, variant := range variants {
+			for _, expansion := range expansions {
+				if i > 0 {
+					expansion = " " + expansion
+				}
+				next = append(next, variant+expansion)
+				if len(next) == maxVariants {
+					break
+				}
+			}
+			if len(next) == maxVariants {
+				break
+			}
+		}
+		variants = next
+	}
+	return variants
+}
+
+// expand returns the ways a word can be written without contraction, the word itself first
+func expand(word string) []string {
+	if expansion, ok := irregularContractions[word]; ok {
+		return []string{word, expansion}
+	}
+	for _, c := range contractionSuffixes {
+		if stem, ok := strings.CutSuffix(word, c.suffix); ok && stem != "" {
+			expansions := []string{word}
+			for _, e := range c.expansions {
+				if expanded := stem + e; expanded != word {
+					expansions = append(expansions, expanded)
+				}
+			}
+			return expansions
+		}
+	}
+	return []string{word}
+}
+
+// Match reports whether a typed answer matches one of the accepted answers
+// Answers are compared after normalization with contractions expanded, allowing up to
+// tolerance edits, see Distance
+func Match(typed string, accepted []string, tolerance int) bool {
+	typedVariants := Variants(typed)
+	if typedVariants[0] == "" {
+		return false
+	}
+	for _, answer := range accepted {
+		for _, a := range Variants(answer) {
+			for _, t := range typedVariants {
+				if a == t || (tolerance > 0 && Distance(a, t) <= tolerance) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Distance returns the edit distance between two strings, counted in characters
+// Insertions, deletions, substitutions and swaps of neighbouring characters are one edit each
+func Distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
@@ -0,0 +1,103 @@
+package textmatch
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Hello", "hello"},
+		{"  She   has  gone. ", "she has gone"},
+		{"\"Well-known,\" he said!", "well-known he said"},
+		{"It’s", "it's"},
+		{"don`t", "don't"},
+		{"...", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.in); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestVariants(t *testing.T) {
+	tests := []struct {
+		in      string
+		first   string
+		include []string
+	}{
+		{"I'm here", "i'm here", []string{"i am here"}},
+		{"She's gone", "she's gone", []string{"she is gone", "she has gone"}},
+		{"We won't", "we won't", []string{"we will not"}},
+		{"They'd left", "they'd left", []string{"they would left", "they had left"}},
+		{"plain words", "plain words", nil},
+	}
+	for _, tt := range tests {
+		got := Variants(tt.in)
+		if len(got) == 0 || got[0] != tt.first {
+			t.Errorf("Variants(%q) = %q, want %q first", tt.in, got, tt.first)
+			continue
+		}
+		for _, want := range tt.include {
+			if !slices.Contains(got, want) {
+				t.Errorf("Variants(%q) = %q, missing %q", tt.in, got, want)
+			}
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "abc", 0},
+		{"", "abc", 3},
+		{"cat", "cut", 1},
+		{"cat", "cats", 1},
+		{"cats", "cat", 1},
+		{"form", "from", 1}, // Swap of neighbouring characters
+		{"kitten", "sitting", 3},
+		{"naïve", "naive", 1}, // Counted in characters, not bytes
+	}
+	for _, tt := range tests {
+		if got := Distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("Distance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		typed     string
+		accepted  []string
+		tolerance int
+		want      bool
+	}{
+		{"exact", "went", []string{"went"}, 0, true},
+		{"case and punctuation", "  Went! ", []string{"went"}, 0, true},
+		{"second accepted answer", "has gone", []string{"went", "has gone"}, 0, true},
+		{"contraction typed", "she's gone", []string{"she has gone"}, 0, true},
+		{"contraction accepted", "I am here", []string{"I'm here"}, 0, true},
+		{"curly apostrophe", "she’s gone", []string{"she has gone"}, 0, true},
+		{"typo without tolerance", "wnet", []string{"went"}, 0, false},
+		{"typo within tolerance", "wnet", []string{"went"}, 1, true},
+		{"too many typos", "wint home", []string{"went house"}, 1, false},
+		{"wrong answer", "go", []string{"went"}, 0, false},
+		{"empty answer", "", []string{"went"}, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Match(tt.typed, tt.accepted, tt.tolerance); got != tt.want {
+				t.Errorf("Match(%q, %q, %d) = %v, want %v", tt.typed, tt.accepted, tt.tolerance, got, tt.want)
+			}
+		})
+	}
+}